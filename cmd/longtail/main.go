@@ -1,16 +1,21 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"runtime"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/DanEngelbrecht/golongtail/commands"
 	"github.com/DanEngelbrecht/golongtail/longtaillib"
+	"github.com/DanEngelbrecht/golongtail/longtailstorelib"
 	"github.com/DanEngelbrecht/golongtail/longtailutils"
+	"github.com/DanEngelbrecht/golongtail/remotestore"
 	"github.com/alecthomas/kong"
 	"github.com/sirupsen/logrus"
 )
@@ -21,7 +26,12 @@ func runCommand() error {
 	executionStartTime := time.Now()
 	initStartTime := executionStartTime
 
+	// Cancel all blob store operations on ctrl-c so we exit cleanly
+	runCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	context := &commands.Context{}
+	mirrorStats := longtailstorelib.NewMirrorStatsCollector()
 
 	defer func() {
 		context.TimeStats = append(context.TimeStats, longtailutils.TimeStat{"Execution", time.Since(executionStartTime)})
@@ -29,6 +39,9 @@ func runCommand() error {
 		for _, s := range context.StoreStats {
 			longtailutils.PrintStats(s.Name, s.Stats, commands.Cli.ShowStoreStats)
 		}
+		for i, s := range mirrorStats.Stores() {
+			longtailutils.PrintMirrorStats(fmt.Sprintf("Mirror %d", i), s.Stats(), commands.Cli.ShowStoreStats)
+		}
 
 		maxLen := 0
 		for _, s := range context.TimeStats {
@@ -97,6 +110,49 @@ func runCommand() error {
 	}
 
 	context.NumRemoteWorkerCount = commands.Cli.RemoteWorkerCount
+	context.Ctx = longtailstorelib.WithOperationTimeout(runCtx, commands.Cli.BlobOperationTimeout)
+
+	retryPolicy := longtailstorelib.DefaultRetryPolicy()
+	retryPolicy.MaxRetries = commands.Cli.BlobMaxRetries
+	retryPolicy.MaxDelay = commands.Cli.BlobRetryMaxDelay
+	context.Ctx = longtailstorelib.WithRetryPolicy(context.Ctx, retryPolicy)
+	context.Ctx = longtailstorelib.WithBlobStoreOptions(context.Ctx, longtailstorelib.WithMirrorStatsCollector(mirrorStats))
+	if commands.Cli.VerifyBlockContent {
+		context.Ctx = longtailstorelib.WithBlobStoreOptions(context.Ctx, remotestore.WithBlockContentVerification())
+	}
+
+	if commands.Cli.EncryptionKeyFile != "" || commands.Cli.EncryptionKeyEnv != "" {
+		var keyRing *longtailstorelib.EncryptionKeyRing
+		if commands.Cli.EncryptionKeyFile != "" {
+			keyRing, err = longtailstorelib.ReadEncryptionKeyFile(commands.Cli.EncryptionKeyFile)
+		} else {
+			keyRing, err = longtailstorelib.ReadEncryptionKeyEnv(commands.Cli.EncryptionKeyEnv)
+		}
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		context.Ctx = longtailstorelib.WithBlobStoreOptions(context.Ctx, longtailstorelib.WithEncryptionKeyRing(keyRing))
+	}
+
+	bandwidthLimits, err := createBandwidthLimits()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if bandwidthLimits != nil {
+		context.Ctx = longtailstorelib.WithBlobStoreOptions(context.Ctx, longtailstorelib.WithBandwidthLimits(bandwidthLimits))
+		if commands.Cli.RateControlFile != "" {
+			err = bandwidthLimits.ReadBandwidthControlFile(commands.Cli.RateControlFile)
+			if err != nil && !longtaillib.IsNotExist(err) {
+				logrus.Fatal(err)
+			}
+			reload := make(chan os.Signal, 1)
+			signal.Notify(reload, syscall.SIGHUP)
+			defer signal.Stop(reload)
+			go bandwidthLimits.WatchBandwidthControlFile(context.Ctx, commands.Cli.RateControlFile, time.Second, reload, func(err error) {
+				logrus.WithError(err).Warn("Failed to update rate limits")
+			})
+		}
+	}
 
 	if commands.Cli.MemTrace || commands.Cli.MemTraceDetailed || commands.Cli.MemTraceCSV != "" {
 		longtaillib.EnableMemtrace()
@@ -130,6 +186,26 @@ func runCommand() error {
 	return err
 }
 
+// createBandwidthLimits returns nil if no rate limits are requested on the command line
+func createBandwidthLimits() (*longtailstorelib.BandwidthLimits, error) {
+	totalRate, err := longtailstorelib.ParseByteRate(commands.Cli.MaxTransferRate)
+	if err != nil {
+		return nil, err
+	}
+	downloadRate, err := longtailstorelib.ParseByteRate(commands.Cli.MaxDownloadRate)
+	if err != nil {
+		return nil, err
+	}
+	uploadRate, err := longtailstorelib.ParseByteRate(commands.Cli.MaxUploadRate)
+	if err != nil {
+		return nil, err
+	}
+	if totalRate == 0 && downloadRate == 0 && uploadRate == 0 && commands.Cli.RateControlFile == "" {
+		return nil, nil
+	}
+	return longtailstorelib.NewBandwidthLimits(totalRate, downloadRate, uploadRate), nil
+}
+
 func main() {
 	err := runCommand()
 	if err != nil {
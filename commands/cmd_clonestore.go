@@ -3,7 +3,7 @@ package commands
 import (
 	"archive/zip"
 	"bufio"
-	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -19,6 +19,7 @@ import (
 )
 
 func validateOneVersion(
+	ctx context.Context,
 	targetStore longtaillib.Longtail_BlockStoreAPI,
 	targetEndpointResolverURI string,
 	targetFilePath string,
@@ -31,7 +32,7 @@ func validateOneVersion(
 		"skipValidate":              skipValidate,
 	})
 	log.Info(fname)
-	tbuffer, err := longtailutils.ReadFromURI(targetFilePath, longtailutils.WithS3EndpointResolverURI(targetEndpointResolverURI))
+	tbuffer, err := longtailutils.ReadFromURI(ctx, targetFilePath, longtailutils.WithS3EndpointResolverURI(targetEndpointResolverURI))
 	if err != nil {
 		return errors.Wrap(err, fname)
 	}
@@ -89,7 +90,7 @@ func cloneVersionIndex(v longtaillib.Longtail_VersionIndex) longtaillib.Longtail
 	return copy
 }
 
-func downloadFromZip(targetPath string, sourceFileZipPath string, sourceEndpointResolverURI string) error {
+func downloadFromZip(ctx context.Context, targetPath string, sourceFileZipPath string, sourceEndpointResolverURI string) error {
 	const fname = "downloadFromZip"
 	log := logrus.WithFields(logrus.Fields{
 		"targetPath":                targetPath,
@@ -101,14 +102,26 @@ func downloadFromZip(targetPath string, sourceFileZipPath string, sourceEndpoint
 		return errors.Wrap(err, fname)
 	}
 	log.Infof("falling back to reading ZIP source from `%s`", sourceFileZipPath)
-	zipBytes, err := longtailutils.ReadFromURI(sourceFileZipPath, longtailutils.WithS3EndpointResolverURI(sourceEndpointResolverURI))
+	zipStream, err := longtailutils.OpenReadFromURI(ctx, sourceFileZipPath, longtailutils.WithS3EndpointResolverURI(sourceEndpointResolverURI))
 	if err != nil {
 		return errors.Wrap(err, fname)
 	}
+	defer zipStream.Close()
 
-	zipReader := bytes.NewReader(zipBytes)
+	// zip needs random access, spool it to a temp file instead of holding it in memory
+	zipFile, err := os.CreateTemp("", "longtail-clone-*.zip")
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	defer os.Remove(zipFile.Name())
+	defer zipFile.Close()
 
-	r, err := zip.NewReader(zipReader, int64(len(zipBytes)))
+	zipSize, err := io.Copy(zipFile, zipStream)
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+
+	r, err := zip.NewReader(zipFile, zipSize)
 	if err != nil {
 		return errors.Wrap(err, fname)
 	}
@@ -185,6 +198,7 @@ func downloadFromZip(targetPath string, sourceFileZipPath string, sourceEndpoint
 }
 
 func updateCurrentVersionFromLongtail(
+	ctx context.Context,
 	targetPath string,
 	targetEndpointResolverURI string,
 	targetPathVersionIndex longtaillib.Longtail_VersionIndex,
@@ -205,7 +219,7 @@ func updateCurrentVersionFromLongtail(
 
 	var hash longtaillib.Longtail_HashAPI
 
-	vbuffer, err := longtailutils.ReadFromURI(sourceFilePath, longtailutils.WithS3EndpointResolverURI(sourceEndpointResolverURI))
+	vbuffer, err := longtailutils.ReadFromURI(ctx, sourceFilePath, longtailutils.WithS3EndpointResolverURI(sourceEndpointResolverURI))
 	if err != nil {
 		err := errors.Wrap(err, "longtailutils.ReadFromURI() failed")
 		return cloneVersionIndex(targetPathVersionIndex), hash, errors.Wrap(err, fname)
@@ -322,7 +336,7 @@ func updateCurrentVersionFromLongtail(
 		return cloneVersionIndex(sourceVersionIndex), hash, nil
 	}
 
-	err = downloadFromZip(targetPath, sourceFileZipPath, sourceEndpointResolverURI)
+	err = downloadFromZip(ctx, targetPath, sourceFileZipPath, sourceEndpointResolverURI)
 	if err != nil {
 		return longtaillib.Longtail_VersionIndex{}, hash, errors.Wrap(err, fname)
 	}
@@ -351,6 +365,7 @@ func updateCurrentVersionFromLongtail(
 }
 
 func cloneOneVersion(
+	ctx context.Context,
 	targetPath string,
 	jobs longtaillib.Longtail_JobAPI,
 	hashRegistry longtaillib.Longtail_HashRegistryAPI,
@@ -395,7 +410,7 @@ func cloneOneVersion(
 	})
 	log.Info(fname)
 
-	err := validateOneVersion(targetStore, targetFilePath, targetEndpointResolverURI, skipValidate)
+	err := validateOneVersion(ctx, targetStore, targetFilePath, targetEndpointResolverURI, skipValidate)
 	if err == nil {
 		return cloneVersionIndex(currentVersionIndex), nil
 	}
@@ -407,6 +422,7 @@ func cloneOneVersion(
 	log.Infof("`%s` -> `%s`", sourceFilePath, targetFilePath)
 
 	targetVersionIndex, hash, err := updateCurrentVersionFromLongtail(
+		ctx,
 		targetPath,
 		targetEndpointResolverURI,
 		currentVersionIndex,
@@ -481,7 +497,7 @@ func cloneOneVersion(
 	}
 	defer vbuffer.Dispose()
 
-	err = longtailutils.WriteToURI(targetFilePath, vbuffer.ToBuffer(), longtailutils.WithS3EndpointResolverURI(targetEndpointResolverURI))
+	err = longtailutils.WriteToURI(ctx, targetFilePath, vbuffer.ToBuffer(), longtailutils.WithS3EndpointResolverURI(targetEndpointResolverURI))
 	if err != nil {
 		return targetVersionIndex, errors.Wrap(err, fname)
 	}
@@ -500,7 +516,7 @@ func cloneOneVersion(
 			return targetVersionIndex, errors.Wrap(err, fname)
 		}
 		defer versionLocalStoreIndexBuffer.Dispose()
-		err = longtailutils.WriteToURI(versionLocalStoreIndexPath, versionLocalStoreIndexBuffer.ToBuffer(), longtailutils.WithS3EndpointResolverURI(targetEndpointResolverURI))
+		err = longtailutils.WriteToURI(ctx, versionLocalStoreIndexPath, versionLocalStoreIndexBuffer.ToBuffer(), longtailutils.WithS3EndpointResolverURI(targetEndpointResolverURI))
 		if err != nil {
 			return targetVersionIndex, errors.Wrap(err, fname)
 		}
@@ -515,6 +531,7 @@ func cloneOneVersion(
 }
 
 func cloneStore(
+	ctx context.Context,
 	numWorkerCount int,
 	remoteStoreWorkerCount int,
 	sourceStoreURI string,
@@ -580,7 +597,7 @@ func cloneStore(
 	localFS := longtaillib.CreateFSStorageAPI()
 	defer localFS.Dispose()
 
-	sourceRemoteIndexStore, err := remotestore.CreateBlockStoreForURI(sourceStoreURI, nil, jobs, remoteStoreWorkerCount, 8388608, 1024, remotestore.ReadOnly, enableFileMapping, longtailutils.WithS3EndpointResolverURI(sourceEndpointResolverURI))
+	sourceRemoteIndexStore, err := remotestore.CreateBlockStoreForURI(ctx, sourceStoreURI, nil, jobs, remoteStoreWorkerCount, 8388608, 1024, remotestore.ReadOnly, enableFileMapping, longtailutils.WithS3EndpointResolverURI(sourceEndpointResolverURI))
 	if err != nil {
 		return storeStats, timeStats, errors.Wrap(err, fname)
 	}
@@ -614,7 +631,7 @@ func cloneStore(
 	defer sourceLRUBlockStore.Dispose()
 	defer sourceStore.Dispose()
 
-	targetRemoteStore, err := remotestore.CreateBlockStoreForURI(targetStoreURI, nil, jobs, remoteStoreWorkerCount, targetBlockSize, maxChunksPerBlock, remotestore.ReadWrite, enableFileMapping, longtailutils.WithS3EndpointResolverURI(targetEndpointResolverURI))
+	targetRemoteStore, err := remotestore.CreateBlockStoreForURI(ctx, targetStoreURI, nil, jobs, remoteStoreWorkerCount, targetBlockSize, maxChunksPerBlock, remotestore.ReadWrite, enableFileMapping, longtailutils.WithS3EndpointResolverURI(targetEndpointResolverURI))
 	if err != nil {
 		return storeStats, timeStats, errors.Wrap(err, fname)
 	}
@@ -667,6 +684,7 @@ func cloneStore(
 		targetFilePath := targetsScanner.Text()
 
 		newCurrentVersionIndex, err := cloneOneVersion(
+			ctx,
 			targetPath,
 			jobs,
 			hashRegistry,
@@ -736,7 +754,12 @@ type CloneStoreCmd struct {
 }
 
 func (r *CloneStoreCmd) Run(ctx *Context) error {
+	cmdCtx, err := r.withIndexCache(ctx.Ctx)
+	if err != nil {
+		return err
+	}
 	storeStats, timeStats, err := cloneStore(
+		cmdCtx,
 		ctx.NumWorkerCount,
 		ctx.NumRemoteWorkerCount,
 		r.SourceStorageURI,
@@ -4,6 +4,7 @@ import (
 	"archive/zip"
 	"bufio"
 	"bytes"
+	"context"
 	"io"
 	"os"
 	"path/filepath"
@@ -62,13 +63,13 @@ func TestCloneStore(t *testing.T) {
 		fsSourceBlobPathPrefix + "/index/v1.lvi" + "\n" +
 			fsSourceBlobPathPrefix + "/index/v2.lvi" + "\n" +
 			fsSourceBlobPathPrefix + "/index/v3.lvi" + "\n")
-	longtailutils.WriteToURI(fsSourceBlobPathPrefix+"/source-files.txt", sourceFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsSourceBlobPathPrefix+"/source-files.txt", sourceFilesContent)
 
 	targetFilesContent := []byte(
 		fsTargetBlobPathPrefix + "/index/v1.lvi" + "\n" +
 			fsTargetBlobPathPrefix + "/index/v2.lvi" + "\n" +
 			fsTargetBlobPathPrefix + "/index/v3.lvi" + "\n")
-	longtailutils.WriteToURI(fsTargetBlobPathPrefix+"/target-files.txt", targetFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsTargetBlobPathPrefix+"/target-files.txt", targetFilesContent)
 
 	cmd, err := executeCommandLine("clone-store",
 		"--source-storage-uri", fsSourceBlobPathPrefix+"/storage",
@@ -122,13 +123,13 @@ func TestCloneStoreCreateVersionLocalStoreIndex(t *testing.T) {
 		fsSourceBlobPathPrefix + "/index/v1.lvi" + "\n" +
 			fsSourceBlobPathPrefix + "/index/v2.lvi" + "\n" +
 			fsSourceBlobPathPrefix + "/index/v3.lvi" + "\n")
-	longtailutils.WriteToURI(fsSourceBlobPathPrefix+"/source-files.txt", sourceFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsSourceBlobPathPrefix+"/source-files.txt", sourceFilesContent)
 
 	targetFilesContent := []byte(
 		fsTargetBlobPathPrefix + "/index/v1.lvi" + "\n" +
 			fsTargetBlobPathPrefix + "/index/v2.lvi" + "\n" +
 			fsTargetBlobPathPrefix + "/index/v3.lvi" + "\n")
-	longtailutils.WriteToURI(fsTargetBlobPathPrefix+"/target-files.txt", targetFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsTargetBlobPathPrefix+"/target-files.txt", targetFilesContent)
 
 	cmd, err := executeCommandLine("clone-store",
 		"--source-storage-uri", fsSourceBlobPathPrefix+"/storage",
@@ -171,19 +172,19 @@ func TestCloneStoreZipFallback(t *testing.T) {
 		fsSourceBlobPathPrefix + "/index/v1.lvi" + "\n" +
 			fsSourceBlobPathPrefix + "/index/v2.lvi" + "\n" +
 			fsSourceBlobPathPrefix + "/index/v3.lvi" + "\n")
-	longtailutils.WriteToURI(fsSourceBlobPathPrefix+"/source-files.txt", sourceFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsSourceBlobPathPrefix+"/source-files.txt", sourceFilesContent)
 
 	zipSourceFilesContent := []byte(
 		fsSourceBlobPathPrefix + "/index/v1.zip" + "\n" +
 			fsSourceBlobPathPrefix + "/index/v2.zip" + "\n" +
 			fsSourceBlobPathPrefix + "/index/v3.zip" + "\n")
-	longtailutils.WriteToURI(fsSourceBlobPathPrefix+"/source-zip-files.txt", zipSourceFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsSourceBlobPathPrefix+"/source-zip-files.txt", zipSourceFilesContent)
 
 	targetFilesContent := []byte(
 		fsTargetBlobPathPrefix + "/index/v1.lvi" + "\n" +
 			fsTargetBlobPathPrefix + "/index/v2.lvi" + "\n" +
 			fsTargetBlobPathPrefix + "/index/v3.lvi" + "\n")
-	longtailutils.WriteToURI(fsTargetBlobPathPrefix+"/target-files.txt", targetFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsTargetBlobPathPrefix+"/target-files.txt", targetFilesContent)
 
 	cmd, err := executeCommandLine("clone-store",
 		"--source-storage-uri", fsSourceBlobPathPrefix+"/storage",
@@ -205,3 +206,90 @@ func TestCloneStoreZipFallback(t *testing.T) {
 	assert.NoError(t, err, cmd)
 	validateContent(t, fsTargetBlobPathPrefix, "version/current", v3FilesCreate)
 }
+
+func TestCloneStoreInMemoryStore(t *testing.T) {
+	testPath, _ := os.MkdirTemp("", "test")
+	fsBlobPathPrefix := "fsblob://" + testPath
+	createVersionData(t, fsBlobPathPrefix)
+	memBlobPathPrefix := createMemStoreURI(t)
+	memSourcePrefix := memBlobPathPrefix + "/source"
+	memTargetPrefix := memBlobPathPrefix + "/target"
+	executeCommandLine("upsync", "--source-path", testPath+"/version/v1", "--target-path", memSourcePrefix+"/index/v1.lvi", "--storage-uri", memSourcePrefix+"/storage")
+	executeCommandLine("upsync", "--source-path", testPath+"/version/v2", "--target-path", memSourcePrefix+"/index/v2.lvi", "--storage-uri", memSourcePrefix+"/storage")
+
+	err := longtailstorelib.SeedMemBlobs(memSourcePrefix, map[string][]byte{
+		"source-files.txt": []byte(memSourcePrefix + "/index/v1.lvi" + "\n" + memSourcePrefix + "/index/v2.lvi" + "\n"),
+		"target-files.txt": []byte(memTargetPrefix + "/index/v1.lvi" + "\n" + memTargetPrefix + "/index/v2.lvi" + "\n")})
+	assert.NoError(t, err)
+
+	cmd, err := executeCommandLine("clone-store",
+		"--source-storage-uri", memSourcePrefix+"/storage",
+		"--target-storage-uri", memTargetPrefix+"/storage",
+		"--source-paths", memSourcePrefix+"/source-files.txt",
+		"--target-paths", memSourcePrefix+"/target-files.txt",
+		"--target-path", testPath+"/version/current")
+	assert.NoError(t, err, cmd)
+
+	targetIndexBlobs, err := longtailstorelib.GetMemBlobs(memTargetPrefix + "/index")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(targetIndexBlobs))
+
+	cmd, err = executeCommandLine("downsync", "--source-path", memTargetPrefix+"/index/v2.lvi", "--target-path", testPath+"/version/current", "--storage-uri", memTargetPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+	validateContent(t, fsBlobPathPrefix, "version/current", v2FilesCreate)
+}
+
+func TestCloneStoreWithFlakySource(t *testing.T) {
+	testPath, _ := os.MkdirTemp("", "test")
+	fsBlobPathPrefix := "fsblob://" + testPath
+	createVersionData(t, fsBlobPathPrefix)
+	memBlobPathPrefix := createMemStoreURI(t)
+	memSourcePrefix := memBlobPathPrefix + "/source"
+	memTargetPrefix := memBlobPathPrefix + "/target"
+	executeCommandLine("upsync", "--source-path", testPath+"/version/v1", "--target-path", memSourcePrefix+"/index/v1.lvi", "--storage-uri", memSourcePrefix+"/storage")
+	executeCommandLine("upsync", "--source-path", testPath+"/version/v2", "--target-path", memSourcePrefix+"/index/v2.lvi", "--storage-uri", memSourcePrefix+"/storage")
+
+	err := longtailstorelib.SeedMemBlobs(memSourcePrefix, map[string][]byte{
+		"source-files.txt": []byte(memSourcePrefix + "/index/v1.lvi" + "\n" + memSourcePrefix + "/index/v2.lvi" + "\n"),
+		"target-files.txt": []byte(memTargetPrefix + "/index/v1.lvi" + "\n" + memTargetPrefix + "/index/v2.lvi" + "\n")})
+	assert.NoError(t, err)
+
+	// Failed block reads from the source are retried
+	cmd, err := executeCommandLine("clone-store",
+		"--source-storage-uri", "fault+"+memSourcePrefix+"/storage?ops=read&error-rate=0.3&seed=3",
+		"--target-storage-uri", memTargetPrefix+"/storage",
+		"--source-paths", memSourcePrefix+"/source-files.txt",
+		"--target-paths", memSourcePrefix+"/target-files.txt",
+		"--target-path", testPath+"/version/current")
+	assert.NoError(t, err, cmd)
+
+	cmd, err = executeCommandLine("downsync", "--source-path", memTargetPrefix+"/index/v2.lvi", "--target-path", testPath+"/version/current", "--storage-uri", memTargetPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+	validateContent(t, fsBlobPathPrefix, "version/current", v2FilesCreate)
+}
+
+func TestCloneStoreWithFailingTarget(t *testing.T) {
+	testPath, _ := os.MkdirTemp("", "test")
+	fsBlobPathPrefix := "fsblob://" + testPath
+	createVersionData(t, fsBlobPathPrefix)
+	memBlobPathPrefix := createMemStoreURI(t)
+	memSourcePrefix := memBlobPathPrefix + "/source"
+	memTargetPrefix := memBlobPathPrefix + "/target"
+	executeCommandLine("upsync", "--source-path", testPath+"/version/v1", "--target-path", memSourcePrefix+"/index/v1.lvi", "--storage-uri", memSourcePrefix+"/storage")
+
+	err := longtailstorelib.SeedMemBlobs(memSourcePrefix, map[string][]byte{
+		"source-files.txt": []byte(memSourcePrefix + "/index/v1.lvi" + "\n"),
+		"target-files.txt": []byte(memTargetPrefix + "/index/v1.lvi" + "\n")})
+	assert.NoError(t, err)
+
+	// Blocks that can't be written to the target fail the clone without writing the target version index
+	cmd, err := executeCommandLine("clone-store",
+		"--source-storage-uri", memSourcePrefix+"/storage",
+		"--target-storage-uri", "fault+"+memTargetPrefix+"/storage?ops=write&error-rate=1",
+		"--source-paths", memSourcePrefix+"/source-files.txt",
+		"--target-paths", memSourcePrefix+"/target-files.txt",
+		"--target-path", testPath+"/version/current")
+	assert.Error(t, err, cmd)
+	targetIndexBlobs, _ := longtailstorelib.GetMemBlobs(memTargetPrefix + "/index")
+	assert.Equal(t, 0, len(targetIndexBlobs))
+}
@@ -0,0 +1,61 @@
+package commands
+
+import (
+	"context"
+	"time"
+
+	"github.com/DanEngelbrecht/golongtail/longtailutils"
+	"github.com/DanEngelbrecht/golongtail/remotestore"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func compactStoreIndex(
+	ctx context.Context,
+	blobStoreURI string,
+	s3EndpointResolverURI string,
+	minItems int) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error) {
+	const fname = "compactStoreIndex"
+	log := logrus.WithFields(logrus.Fields{
+		"fname":                 fname,
+		"blobStoreURI":          blobStoreURI,
+		"s3EndpointResolverURI": s3EndpointResolverURI,
+		"minItems":              minItems,
+	})
+	log.Info(fname)
+
+	storeStats := []longtailutils.StoreStat{}
+	timeStats := []longtailutils.TimeStat{}
+
+	compactStartTime := time.Now()
+	compactedItems, err := remotestore.CompactStoreIndex(ctx, blobStoreURI, minItems, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	if err != nil {
+		err = errors.Wrapf(err, "Failed compacting store index of `%s`", blobStoreURI)
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+	compactTime := time.Since(compactStartTime)
+	timeStats = append(timeStats, longtailutils.TimeStat{"Compact store index", compactTime})
+
+	log.WithField("compactedItems", compactedItems).Info("Compacted store index")
+	return storeStats, timeStats, nil
+}
+
+type CompactStoreIndexCmd struct {
+	StorageURIOption
+	S3EndpointResolverURLOption
+	StoreLeaseOption
+	MinItems int `name:"min-items" help:"Only compact the store index if it is split into at least this many items" default:"2"`
+}
+
+func (r *CompactStoreIndexCmd) Run(ctx *Context) error {
+	storeStats, timeStats, err := runWithStoreLease(ctx.Ctx, r.StorageURI, r.S3EndpointResolverURL, "compact-store-index", r.StoreLeaseTTL, func(leaseCtx context.Context) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error) {
+		return compactStoreIndex(
+			leaseCtx,
+			r.StorageURI,
+			r.S3EndpointResolverURL,
+			r.MinItems)
+	})
+	ctx.StoreStats = append(ctx.StoreStats, storeStats...)
+	ctx.TimeStats = append(ctx.TimeStats, timeStats...)
+	return err
+}
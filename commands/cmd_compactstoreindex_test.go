@@ -0,0 +1,83 @@
+package commands
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/DanEngelbrecht/golongtail/longtailstorelib"
+	"github.com/alecthomas/assert/v2"
+)
+
+// splitStoreIndex renames the store index so the next upload writes a second store index item
+func splitStoreIndex(t *testing.T, baseURI string, item string) {
+	store, _ := longtailstorelib.CreateBlobStoreForURI(baseURI)
+	client, _ := store.NewClient(context.Background())
+	defer client.Close()
+	storeIndexObject, _ := client.NewObject("storage/store.lsi")
+	data, err := storeIndexObject.Read(context.Background())
+	assert.NoError(t, err)
+	itemObject, _ := client.NewObject("storage/" + item)
+	ok, err := itemObject.Write(context.Background(), data)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	assert.NoError(t, storeIndexObject.Delete(context.Background()))
+}
+
+func getStoreIndexItems(t *testing.T, baseURI string) []string {
+	store, _ := longtailstorelib.CreateBlobStoreForURI(baseURI)
+	client, _ := store.NewClient(context.Background())
+	defer client.Close()
+	objects, err := client.GetObjects("storage/store")
+	assert.NoError(t, err)
+	items := []string{}
+	for _, object := range objects {
+		items = append(items, object.Name)
+	}
+	return items
+}
+
+func TestCompactStoreIndex(t *testing.T) {
+	testPath, _ := os.MkdirTemp("", "test")
+	fsBlobPathPrefix := "fsblob://" + testPath
+	createVersionData(t, fsBlobPathPrefix)
+
+	cmd, err := executeCommandLine("upsync", "--source-path", testPath+"/version/v1", "--target-path", fsBlobPathPrefix+"/index/v1.lvi", "--storage-uri", fsBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+	splitStoreIndex(t, fsBlobPathPrefix, "store_v1.lsi")
+	cmd, err = executeCommandLine("upsync", "--source-path", testPath+"/version/v2", "--target-path", fsBlobPathPrefix+"/index/v2.lvi", "--storage-uri", fsBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+	assert.Equal(t, []string{"storage/store.lsi", "storage/store_v1.lsi"}, getStoreIndexItems(t, fsBlobPathPrefix))
+
+	cmd, err = executeCommandLine("compact-store-index", "--storage-uri", fsBlobPathPrefix+"/storage", "--min-items", "3")
+	assert.NoError(t, err, cmd)
+	assert.Equal(t, 2, len(getStoreIndexItems(t, fsBlobPathPrefix)))
+
+	cmd, err = executeCommandLine("compact-store-index", "--storage-uri", fsBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+	assert.Equal(t, []string{"storage/store.lsi"}, getStoreIndexItems(t, fsBlobPathPrefix))
+
+	cmd, err = executeCommandLine("downsync", "--source-path", fsBlobPathPrefix+"/index/v1.lvi", "--target-path", testPath+"/version/current", "--storage-uri", fsBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+	validateContent(t, fsBlobPathPrefix, "version/current", v1FilesCreate)
+	cmd, err = executeCommandLine("downsync", "--source-path", fsBlobPathPrefix+"/index/v2.lvi", "--target-path", testPath+"/version/current", "--storage-uri", fsBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+	validateContent(t, fsBlobPathPrefix, "version/current", v2FilesCreate)
+}
+
+func TestUpsyncCompactStoreIndexThreshold(t *testing.T) {
+	testPath, _ := os.MkdirTemp("", "test")
+	fsBlobPathPrefix := "fsblob://" + testPath
+	createVersionData(t, fsBlobPathPrefix)
+
+	cmd, err := executeCommandLine("upsync", "--source-path", testPath+"/version/v1", "--target-path", fsBlobPathPrefix+"/index/v1.lvi", "--storage-uri", fsBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+	splitStoreIndex(t, fsBlobPathPrefix, "store_v1.lsi")
+	cmd, err = executeCommandLine("upsync", "--source-path", testPath+"/version/v2", "--target-path", fsBlobPathPrefix+"/index/v2.lvi", "--storage-uri", fsBlobPathPrefix+"/storage", "--compact-store-index-threshold", "2")
+	assert.NoError(t, err, cmd)
+	assert.Equal(t, []string{"storage/store.lsi"}, getStoreIndexItems(t, fsBlobPathPrefix))
+
+	cmd, err = executeCommandLine("downsync", "--source-path", fsBlobPathPrefix+"/index/v1.lvi", "--target-path", testPath+"/version/current", "--storage-uri", fsBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+	validateContent(t, fsBlobPathPrefix, "version/current", v1FilesCreate)
+}
@@ -13,6 +13,7 @@ import (
 )
 
 func cpVersionIndex(
+	ctx context.Context,
 	numWorkerCount int,
 	remoteStoreWorkerCount int,
 	blobStoreURI string,
@@ -50,7 +51,7 @@ func cpVersionIndex(
 	defer hashRegistry.Dispose()
 
 	// MaxBlockSize and MaxChunksPerBlock are just temporary values until we get the remote index settings
-	remoteIndexStore, err := remotestore.CreateBlockStoreForURI(blobStoreURI, nil, jobs, remoteStoreWorkerCount, 8388608, 1024, remotestore.ReadOnly, enableFileMapping, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	remoteIndexStore, err := remotestore.CreateBlockStoreForURI(ctx, blobStoreURI, nil, jobs, remoteStoreWorkerCount, 8388608, 1024, remotestore.ReadOnly, enableFileMapping, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
 	if err != nil {
 		return storeStats, timeStats, errors.Wrap(err, fname)
 	}
@@ -86,7 +87,7 @@ func cpVersionIndex(
 	timeStats = append(timeStats, longtailutils.TimeStat{"Setup", setupTime})
 
 	readSourceStartTime := time.Now()
-	vbuffer, err := longtailutils.ReadFromURI(versionIndexPath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	vbuffer, err := longtailutils.ReadFromURI(ctx, versionIndexPath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
 	if err != nil {
 		return storeStats, timeStats, errors.Wrap(err, fname)
 	}
@@ -157,7 +158,7 @@ func cpVersionIndex(
 			err = errors.Wrapf(err, "Longtail_StorageAPI.Read failed for `%s`", sourcePath)
 			return storeStats, timeStats, errors.Wrap(err, fname)
 		}
-		longtailutils.WriteToURI(targetPath, data, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+		longtailutils.WriteToURI(ctx, targetPath, data, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
 		offset += left
 	}
 	copyFileTime := time.Since(copyFileStartTime)
@@ -220,7 +221,12 @@ type CpCmd struct {
 }
 
 func (r *CpCmd) Run(ctx *Context) error {
+	cmdCtx, err := r.withIndexCache(ctx.Ctx)
+	if err != nil {
+		return err
+	}
 	storeStats, timeStats, err := cpVersionIndex(
+		cmdCtx,
 		ctx.NumWorkerCount,
 		ctx.NumRemoteWorkerCount,
 		r.StorageURI,
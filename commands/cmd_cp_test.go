@@ -14,10 +14,10 @@ func validateFileContentAndDelete(t *testing.T, baseURI string, sourcePath strin
 	client, _ := store.NewClient(context.Background())
 	defer client.Close()
 	o, _ := client.NewObject(sourcePath)
-	d, _ := o.Read()
+	d, _ := o.Read(context.Background())
 	s := string(d)
 	assert.Equal(t, s, expectedContent)
-	o.Delete()
+	o.Delete(context.Background())
 }
 
 func TestCp(t *testing.T) {
@@ -0,0 +1,74 @@
+package commands
+
+import (
+	"context"
+	"time"
+
+	"github.com/DanEngelbrecht/golongtail/longtailstorelib"
+	"github.com/DanEngelbrecht/golongtail/longtailutils"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func createHTTPManifest(
+	ctx context.Context,
+	blobStoreURI string,
+	s3EndpointResolverURI string) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error) {
+	const fname = "createHTTPManifest"
+	log := logrus.WithFields(logrus.Fields{
+		"fname":                 fname,
+		"blobStoreURI":          blobStoreURI,
+		"s3EndpointResolverURI": s3EndpointResolverURI,
+	})
+	log.Info(fname)
+
+	storeStats := []longtailutils.StoreStat{}
+	timeStats := []longtailutils.TimeStat{}
+
+	blobStore, err := longtailstorelib.CreateBlobStoreForURI(blobStoreURI, append(longtailstorelib.GetBlobStoreOptions(ctx), longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))...)
+	if err != nil {
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+
+	client, err := blobStore.NewClient(ctx)
+	if err != nil {
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+	defer client.Close()
+
+	getObjectsStartTime := time.Now()
+	objects, err := client.GetObjects("")
+	if err != nil {
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+	timeStats = append(timeStats, longtailutils.TimeStat{"Get objects", time.Since(getObjectsStartTime)})
+
+	writeManifestStartTime := time.Now()
+	manifestObject, err := client.NewObject(longtailstorelib.HTTPManifestName)
+	if err != nil {
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+	_, err = manifestObject.Write(ctx, longtailstorelib.CreateHTTPManifest(objects))
+	if err != nil {
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+	timeStats = append(timeStats, longtailutils.TimeStat{"Write manifest", time.Since(writeManifestStartTime)})
+
+	log.Infof("wrote manifest with %d objects to `%s`", len(objects), manifestObject.String())
+	return storeStats, timeStats, nil
+}
+
+type CreateHTTPManifestCmd struct {
+	StorageURIOption
+	S3EndpointResolverURLOption
+}
+
+func (r *CreateHTTPManifestCmd) Run(ctx *Context) error {
+	storeStats, timeStats, err := createHTTPManifest(
+		ctx.Ctx,
+		r.StorageURI,
+		r.S3EndpointResolverURL)
+	ctx.StoreStats = append(ctx.StoreStats, storeStats...)
+	ctx.TimeStats = append(ctx.TimeStats, timeStats...)
+	return err
+}
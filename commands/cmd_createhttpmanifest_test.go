@@ -0,0 +1,42 @@
+package commands
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/DanEngelbrecht/golongtail/longtailstorelib"
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestCreateHTTPManifest(t *testing.T) {
+	testPath, _ := os.MkdirTemp("", "test")
+	fsBlobPathPrefix := "fsblob://" + testPath
+	createVersionData(t, fsBlobPathPrefix)
+	executeCommandLine("upsync", "--source-path", testPath+"/version/v1", "--target-path", fsBlobPathPrefix+"/storage/index/v1.lvi", "--storage-uri", fsBlobPathPrefix+"/storage")
+
+	cmd, err := executeCommandLine("create-http-manifest", "--storage-uri", fsBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+
+	server := httptest.NewServer(http.FileServer(http.Dir(testPath + "/storage")))
+	defer server.Close()
+
+	store, err := longtailstorelib.CreateBlobStoreForURI(server.URL)
+	assert.NoError(t, err)
+	client, err := store.NewClient(context.Background())
+	assert.NoError(t, err)
+	defer client.Close()
+	items, err := client.GetObjects("index/")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(items))
+	assert.Equal(t, "index/v1.lvi", items[0].Name)
+
+	cmd, err = executeCommandLine("downsync", "--source-path", server.URL+"/index/v1.lvi", "--target-path", testPath+"/version/current", "--storage-uri", server.URL)
+	assert.NoError(t, err, cmd)
+	validateContent(t, fsBlobPathPrefix, "version/current", v1FilesCreate)
+
+	cmd, err = executeCommandLine("upsync", "--source-path", testPath+"/version/v2", "--target-path", fsBlobPathPrefix+"/index/v2.lvi", "--storage-uri", server.URL)
+	assert.Error(t, err, cmd)
+}
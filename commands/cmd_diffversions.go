@@ -0,0 +1,240 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/DanEngelbrecht/golongtail/longtaillib"
+	"github.com/DanEngelbrecht/golongtail/longtailutils"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+type versionDiffAsset struct {
+	Path string `json:"path"`
+	Size uint64 `json:"size"`
+}
+
+type versionDiffModifiedAsset struct {
+	Path       string `json:"path"`
+	SourceSize uint64 `json:"sourceSize"`
+	TargetSize uint64 `json:"targetSize"`
+}
+
+type versionDiffPermissionsAsset struct {
+	Path              string `json:"path"`
+	SourcePermissions string `json:"sourcePermissions"`
+	TargetPermissions string `json:"targetPermissions"`
+}
+
+type versionDiffReport struct {
+	SourceVersionIndexPath string                        `json:"sourceVersionIndexPath"`
+	TargetVersionIndexPath string                        `json:"targetVersionIndexPath"`
+	Added                  []versionDiffAsset            `json:"added"`
+	Removed                []versionDiffAsset            `json:"removed"`
+	Modified               []versionDiffModifiedAsset    `json:"modified"`
+	PermissionsChanged     []versionDiffPermissionsAsset `json:"permissionsChanged"`
+	// Chunks of the added and modified assets, a client updating from the source version downloads the blocks holding them
+	RequiredChunkCount int    `json:"requiredChunkCount"`
+	RequiredChunkSize  uint64 `json:"requiredChunkSize"`
+	// The part of the required chunks that is not in the source version at all
+	NewChunkCount int    `json:"newChunkCount"`
+	NewChunkSize  uint64 `json:"newChunkSize"`
+}
+
+func createVersionDiffReport(
+	sourceVersionIndex longtaillib.Longtail_VersionIndex,
+	targetVersionIndex longtaillib.Longtail_VersionIndex,
+	versionDiff longtaillib.Longtail_VersionDiff) (versionDiffReport, error) {
+	const fname = "createVersionDiffReport"
+	report := versionDiffReport{
+		Added:              []versionDiffAsset{},
+		Removed:            []versionDiffAsset{},
+		Modified:           []versionDiffModifiedAsset{},
+		PermissionsChanged: []versionDiffPermissionsAsset{},
+	}
+
+	for _, assetIndex := range versionDiff.GetTargetAddedAssetIndexes() {
+		report.Added = append(report.Added, versionDiffAsset{
+			Path: targetVersionIndex.GetAssetPath(assetIndex),
+			Size: targetVersionIndex.GetAssetSize(assetIndex)})
+	}
+	for _, assetIndex := range versionDiff.GetSourceRemovedAssetIndexes() {
+		report.Removed = append(report.Removed, versionDiffAsset{
+			Path: sourceVersionIndex.GetAssetPath(assetIndex),
+			Size: sourceVersionIndex.GetAssetSize(assetIndex)})
+	}
+	targetModifiedAssetIndexes := versionDiff.GetTargetContentModifiedAssetIndexes()
+	for i, sourceAssetIndex := range versionDiff.GetSourceContentModifiedAssetIndexes() {
+		targetAssetIndex := targetModifiedAssetIndexes[i]
+		report.Modified = append(report.Modified, versionDiffModifiedAsset{
+			Path:       targetVersionIndex.GetAssetPath(targetAssetIndex),
+			SourceSize: sourceVersionIndex.GetAssetSize(sourceAssetIndex),
+			TargetSize: targetVersionIndex.GetAssetSize(targetAssetIndex)})
+	}
+	targetPermissionsAssetIndexes := versionDiff.GetTargetPermissionsModifiedAssetIndexes()
+	for i, sourceAssetIndex := range versionDiff.GetSourcePermissionsModifiedAssetIndexes() {
+		targetAssetIndex := targetPermissionsAssetIndexes[i]
+		report.PermissionsChanged = append(report.PermissionsChanged, versionDiffPermissionsAsset{
+			Path:              targetVersionIndex.GetAssetPath(targetAssetIndex),
+			SourcePermissions: fmt.Sprintf("%04o", sourceVersionIndex.GetAssetPermissions(sourceAssetIndex)),
+			TargetPermissions: fmt.Sprintf("%04o", targetVersionIndex.GetAssetPermissions(targetAssetIndex))})
+	}
+	sort.Slice(report.Added, func(i, j int) bool { return report.Added[i].Path < report.Added[j].Path })
+	sort.Slice(report.Removed, func(i, j int) bool { return report.Removed[i].Path < report.Removed[j].Path })
+	sort.Slice(report.Modified, func(i, j int) bool { return report.Modified[i].Path < report.Modified[j].Path })
+	sort.Slice(report.PermissionsChanged, func(i, j int) bool {
+		return report.PermissionsChanged[i].Path < report.PermissionsChanged[j].Path
+	})
+
+	requiredChunkHashes, err := longtaillib.GetRequiredChunkHashes(targetVersionIndex, versionDiff)
+	if err != nil {
+		return report, errors.Wrap(err, fname)
+	}
+	targetChunkSizes := map[uint64]uint32{}
+	chunkSizes := targetVersionIndex.GetChunkSizes()
+	for i, chunkHash := range targetVersionIndex.GetChunkHashes() {
+		targetChunkSizes[chunkHash] = chunkSizes[i]
+	}
+	sourceChunks := map[uint64]bool{}
+	for _, chunkHash := range sourceVersionIndex.GetChunkHashes() {
+		sourceChunks[chunkHash] = true
+	}
+	for _, chunkHash := range requiredChunkHashes {
+		chunkSize := uint64(targetChunkSizes[chunkHash])
+		report.RequiredChunkCount++
+		report.RequiredChunkSize += chunkSize
+		if !sourceChunks[chunkHash] {
+			report.NewChunkCount++
+			report.NewChunkSize += chunkSize
+		}
+	}
+	return report, nil
+}
+
+func printVersionDiffReport(report versionDiffReport) {
+	fmt.Printf("Source:              %s\n", report.SourceVersionIndexPath)
+	fmt.Printf("Target:              %s\n", report.TargetVersionIndexPath)
+	printVersionDiffAssets(report)
+	fmt.Printf("Required Chunks:     %d   (%s)\n", report.RequiredChunkCount, longtailutils.ByteCountBinary(report.RequiredChunkSize))
+	fmt.Printf("New Chunks:          %d   (%s)\n", report.NewChunkCount, longtailutils.ByteCountBinary(report.NewChunkSize))
+}
+
+func printVersionDiffAssets(report versionDiffReport) {
+	fmt.Printf("Added:               %d\n", len(report.Added))
+	for _, asset := range report.Added {
+		fmt.Printf("  + %s   (%s)\n", asset.Path, longtailutils.ByteCountBinary(asset.Size))
+	}
+	fmt.Printf("Removed:             %d\n", len(report.Removed))
+	for _, asset := range report.Removed {
+		fmt.Printf("  - %s   (%s)\n", asset.Path, longtailutils.ByteCountBinary(asset.Size))
+	}
+	fmt.Printf("Modified:            %d\n", len(report.Modified))
+	for _, asset := range report.Modified {
+		fmt.Printf("  ~ %s   (%s -> %s)\n", asset.Path, longtailutils.ByteCountBinary(asset.SourceSize), longtailutils.ByteCountBinary(asset.TargetSize))
+	}
+	fmt.Printf("Permissions Changed: %d\n", len(report.PermissionsChanged))
+	for _, asset := range report.PermissionsChanged {
+		fmt.Printf("  * %s   (%s -> %s)\n", asset.Path, asset.SourcePermissions, asset.TargetPermissions)
+	}
+}
+
+func diffVersions(
+	ctx context.Context,
+	sourceVersionIndexPath string,
+	targetVersionIndexPath string,
+	s3EndpointResolverURI string,
+	format string) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error) {
+	const fname = "diffVersions"
+	log := logrus.WithFields(logrus.Fields{
+		"fname":                  fname,
+		"sourceVersionIndexPath": sourceVersionIndexPath,
+		"targetVersionIndexPath": targetVersionIndexPath,
+		"s3EndpointResolverURI":  s3EndpointResolverURI,
+		"format":                 format,
+	})
+	log.Info(fname)
+
+	storeStats := []longtailutils.StoreStat{}
+	timeStats := []longtailutils.TimeStat{}
+
+	readSourceStartTime := time.Now()
+	sourceVersionIndex, err := readVersionIndex(ctx, sourceVersionIndexPath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	if err != nil {
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+	defer sourceVersionIndex.Dispose()
+	timeStats = append(timeStats, longtailutils.TimeStat{"Read source index", time.Since(readSourceStartTime)})
+
+	readTargetStartTime := time.Now()
+	targetVersionIndex, err := readVersionIndex(ctx, targetVersionIndexPath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	if err != nil {
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+	defer targetVersionIndex.Dispose()
+	timeStats = append(timeStats, longtailutils.TimeStat{"Read target index", time.Since(readTargetStartTime)})
+
+	if sourceVersionIndex.GetHashIdentifier() != targetVersionIndex.GetHashIdentifier() {
+		err = fmt.Errorf("hash algorithm of `%s` (%s) does not match `%s` (%s)",
+			sourceVersionIndexPath,
+			longtailutils.HashIdentifierToString(sourceVersionIndex.GetHashIdentifier()),
+			targetVersionIndexPath,
+			longtailutils.HashIdentifierToString(targetVersionIndex.GetHashIdentifier()))
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+
+	diffStartTime := time.Now()
+	hashRegistry := longtaillib.CreateFullHashRegistry()
+	defer hashRegistry.Dispose()
+	hash, err := hashRegistry.GetHashAPI(targetVersionIndex.GetHashIdentifier())
+	if err != nil {
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+	versionDiff, err := longtaillib.CreateVersionDiff(hash, sourceVersionIndex, targetVersionIndex)
+	if err != nil {
+		err = errors.Wrapf(err, "Failed to create version diff. `%s` -> `%s`", sourceVersionIndexPath, targetVersionIndexPath)
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+	defer versionDiff.Dispose()
+
+	report, err := createVersionDiffReport(sourceVersionIndex, targetVersionIndex, versionDiff)
+	if err != nil {
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+	report.SourceVersionIndexPath = sourceVersionIndexPath
+	report.TargetVersionIndexPath = targetVersionIndexPath
+	timeStats = append(timeStats, longtailutils.TimeStat{"Diff versions", time.Since(diffStartTime)})
+
+	if format == "json" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return storeStats, timeStats, errors.Wrap(err, fname)
+		}
+		fmt.Printf("%s\n", data)
+	} else {
+		printVersionDiffReport(report)
+	}
+	return storeStats, timeStats, nil
+}
+
+type DiffVersionsCmd struct {
+	SourceVersionIndexPath string `name:"source-version-index-path" required:"" help:"URI to the version index to compare from (local file system, GCS and S3 bucket URI supported)"`
+	TargetVersionIndexPath string `name:"target-version-index-path" required:"" help:"URI to the version index to compare to (local file system, GCS and S3 bucket URI supported)"`
+	S3EndpointResolverURLOption
+	Format string `name:"format" help:"Output format [text json]" enum:"text,json" default:"text"`
+}
+
+func (r *DiffVersionsCmd) Run(ctx *Context) error {
+	storeStats, timeStats, err := diffVersions(
+		ctx.Ctx,
+		r.SourceVersionIndexPath,
+		r.TargetVersionIndexPath,
+		r.S3EndpointResolverURL,
+		r.Format)
+	ctx.StoreStats = append(ctx.StoreStats, storeStats...)
+	ctx.TimeStats = append(ctx.TimeStats, timeStats...)
+	return err
+}
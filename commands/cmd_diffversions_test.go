@@ -0,0 +1,53 @@
+package commands
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/DanEngelbrecht/golongtail/longtaillib"
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestDiffVersions(t *testing.T) {
+	testPath, _ := os.MkdirTemp("", "test")
+	fsBlobPathPrefix := "fsblob://" + testPath
+	createVersionData(t, fsBlobPathPrefix)
+	for _, version := range []string{"v1", "v2"} {
+		cmd, err := executeCommandLine("upsync", "--source-path", testPath+"/version/"+version, "--target-path", fsBlobPathPrefix+"/index/"+version+".lvi", "--storage-uri", fsBlobPathPrefix+"/storage")
+		assert.NoError(t, err, cmd)
+	}
+
+	cmd, err := executeCommandLine("diff-versions", "--source-version-index-path", fsBlobPathPrefix+"/index/v1.lvi", "--target-version-index-path", fsBlobPathPrefix+"/index/v2.lvi")
+	assert.NoError(t, err, cmd)
+	cmd, err = executeCommandLine("diff-versions", "--source-version-index-path", fsBlobPathPrefix+"/index/v1.lvi", "--target-version-index-path", fsBlobPathPrefix+"/index/v2.lvi", "--format", "json")
+	assert.NoError(t, err, cmd)
+	cmd, err = executeCommandLine("diff-versions", "--source-version-index-path", fsBlobPathPrefix+"/index/v1.lvi", "--target-version-index-path", fsBlobPathPrefix+"/index/missing.lvi")
+	assert.Error(t, err, cmd)
+
+	sourceVersionIndex, err := readVersionIndex(context.Background(), fsBlobPathPrefix+"/index/v1.lvi")
+	assert.NoError(t, err)
+	defer sourceVersionIndex.Dispose()
+	targetVersionIndex, err := readVersionIndex(context.Background(), fsBlobPathPrefix+"/index/v2.lvi")
+	assert.NoError(t, err)
+	defer targetVersionIndex.Dispose()
+	hash := longtaillib.CreateBlake3HashAPI()
+	defer hash.Dispose()
+	versionDiff, err := longtaillib.CreateVersionDiff(hash, sourceVersionIndex, targetVersionIndex)
+	assert.NoError(t, err)
+	defer versionDiff.Dispose()
+
+	report, err := createVersionDiffReport(sourceVersionIndex, targetVersionIndex, versionDiff)
+	assert.NoError(t, err)
+	assert.Equal(t, []versionDiffAsset{
+		{Path: "folder2/", Size: 0},
+		{Path: "folder2/anotherabitoftextinasubfolder2.txt", Size: uint64(len(v2FilesCreate["folder2/anotherabitoftextinasubfolder2.txt"]))},
+		{Path: "stuff.txt", Size: uint64(len(v2FilesCreate["stuff.txt"]))},
+	}, report.Added)
+	assert.Equal(t, 0, len(report.Removed))
+	assert.Equal(t, 0, len(report.Modified))
+	assert.Equal(t, 0, len(report.PermissionsChanged))
+	assert.Equal(t, 2, report.NewChunkCount)
+	assert.Equal(t, uint64(len(v2FilesCreate["folder2/anotherabitoftextinasubfolder2.txt"])+len(v2FilesCreate["stuff.txt"])), report.NewChunkSize)
+	assert.Equal(t, report.NewChunkSize, report.RequiredChunkSize)
+}
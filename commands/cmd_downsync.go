@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
@@ -13,9 +14,9 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func readVersionIndex(sourceFilePath string, opts ...longtailstorelib.BlobStoreOption) (longtaillib.Longtail_VersionIndex, error) {
+func readVersionIndex(ctx context.Context, sourceFilePath string, opts ...longtailstorelib.BlobStoreOption) (longtaillib.Longtail_VersionIndex, error) {
 	const fname = "readVersionIndex"
-	vbuffer, err := longtailutils.ReadFromURI(sourceFilePath, opts...)
+	vbuffer, err := longtailutils.ReadFromURI(ctx, sourceFilePath, opts...)
 	if err != nil {
 		return longtaillib.Longtail_VersionIndex{}, errors.Wrap(err, fname)
 	}
@@ -28,6 +29,7 @@ func readVersionIndex(sourceFilePath string, opts ...longtailstorelib.BlobStoreO
 }
 
 func downsync(
+	ctx context.Context,
 	numWorkerCount int,
 	remoteStoreWorkerCount int,
 	blobStoreURI string,
@@ -46,7 +48,9 @@ func downsync(
 	scanTarget bool,
 	cacheTargetIndex bool,
 	enableFileMapping bool,
-	useLegacyWrite bool) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error) {
+	useLegacyWrite bool,
+	dryRun bool,
+	repair bool) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error) {
 	const fname = "downsync"
 	log := logrus.WithFields(logrus.Fields{
 		"fname":                       fname,
@@ -69,6 +73,8 @@ func downsync(
 		"cacheTargetIndex":            cacheTargetIndex,
 		"enableFileMapping":           enableFileMapping,
 		"useLegacyWrite":              useLegacyWrite,
+		"dryRun":                      dryRun,
+		"repair":                      repair,
 	})
 	log.Info(fname)
 
@@ -117,13 +123,19 @@ func downsync(
 	fs := longtaillib.CreateFSStorageAPI()
 	defer fs.Dispose()
 
+	if repair {
+		// Repair validates the content of the target folder so it can't trust a pre-computed or cached index
+		targetIndexPath = ""
+		scanTarget = true
+	}
+
 	if targetIndexPath != "" {
 		cacheTargetIndex = false
 	}
 
 	cacheTargetIndexPath := longtailstorelib.NormalizeFileSystemPath(resolvedTargetFolderPath + "/.longtail.index.cache.lvi")
 
-	if cacheTargetIndex {
+	if cacheTargetIndex && !repair {
 		if longtaillib.FileExists(fs, cacheTargetIndexPath) {
 			targetIndexPath = cacheTargetIndexPath
 		}
@@ -141,7 +153,7 @@ func downsync(
 
 	var sourceVersionIndex longtaillib.Longtail_VersionIndex
 	for index, sourceFilePath := range sourceFilePaths {
-		oneVersionIndex, err := readVersionIndex(sourceFilePath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+		oneVersionIndex, err := readVersionIndex(ctx, sourceFilePath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
 		if err != nil {
 			err = errors.Wrapf(err, "Cant read version index from `%s`", sourceFilePath)
 			return storeStats, timeStats, errors.Wrap(err, fname)
@@ -193,7 +205,7 @@ func downsync(
 	}
 
 	// MaxBlockSize and MaxChunksPerBlock are just temporary values until we get the remote index settings
-	remoteIndexStore, err := remotestore.CreateBlockStoreForURI(blobStoreURI, versionLocalStoreIndexPaths, jobs, remoteStoreWorkerCount, 8388608, 1024, remotestore.ReadOnly, enableFileMapping, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	remoteIndexStore, err := remotestore.CreateBlockStoreForURI(ctx, blobStoreURI, versionLocalStoreIndexPaths, jobs, remoteStoreWorkerCount, 8388608, 1024, remotestore.ReadOnly, enableFileMapping, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
 	if err != nil {
 		return storeStats, timeStats, errors.Wrap(err, fname)
 	}
@@ -203,7 +215,8 @@ func downsync(
 	var cacheBlockStore longtaillib.Longtail_BlockStoreAPI
 	var compressBlockStore longtaillib.Longtail_BlockStoreAPI
 
-	if localCachePath == "" {
+	if localCachePath == "" || dryRun {
+		// A dry run checks the cache for blocks without opening it as a block store, so nothing is written to it
 		compressBlockStore = longtaillib.CreateCompressBlockStore(remoteIndexStore, creg)
 	} else {
 		localIndexStore = longtaillib.CreateFSBlockStore(jobs, localFS, longtailstorelib.NormalizeFileSystemPath(localCachePath), "", enableFileMapping)
@@ -245,10 +258,19 @@ func downsync(
 	timeStats = append(timeStats, longtailutils.TimeStat{"Read target index", readTargetIndexTime})
 
 	getExistingContentStartTime := time.Now()
-	versionDiff, err := longtaillib.CreateVersionDiff(
-		hash,
-		targetVersionIndex,
-		sourceVersionIndex)
+	// When repairing, the diff goes from the source version to itself and only rewrites the damaged assets
+	currentVersionIndex := targetVersionIndex
+	var versionDiff longtaillib.Longtail_VersionDiff
+	var repairedAssets []repairedAsset
+	if repair {
+		currentVersionIndex = sourceVersionIndex
+		versionDiff, repairedAssets, err = createRepairVersionDiff(sourceVersionIndex, targetVersionIndex, retainPermissions)
+	} else {
+		versionDiff, err = longtaillib.CreateVersionDiff(
+			hash,
+			targetVersionIndex,
+			sourceVersionIndex)
+	}
 	if err != nil {
 		err = errors.Wrapf(err, "Failed to create version diff. `%s` -> `%s`", targetFolderPath, sourceFilePaths[0])
 		return storeStats, timeStats, errors.Wrap(err, fname)
@@ -271,6 +293,23 @@ func downsync(
 	getExistingContentTime := time.Since(getExistingContentStartTime)
 	timeStats = append(timeStats, longtailutils.TimeStat{"Get content index", getExistingContentTime})
 
+	if dryRun {
+		dryRunStartTime := time.Now()
+		blockSizes, err := remotestore.GetStoredBlockSizes(ctx, blobStoreURI, retargettedVersionStoreIndex.GetBlockHashes(), longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+		if err != nil {
+			return storeStats, timeStats, errors.Wrap(err, fname)
+		}
+		dryRunReport, err := createChangeVersionDryRun(currentVersionIndex, sourceVersionIndex, versionDiff, retargettedVersionStoreIndex, blockSizes, localCachePath)
+		if err != nil {
+			return storeStats, timeStats, errors.Wrap(err, fname)
+		}
+		dryRunReport.SourceVersionIndexPath = resolvedTargetFolderPath
+		dryRunReport.TargetVersionIndexPath = strings.Join(sourceFilePaths, "|")
+		timeStats = append(timeStats, longtailutils.TimeStat{"Dry run", time.Since(dryRunStartTime)})
+		printChangeVersionDryRun(dryRunReport)
+		return storeStats, timeStats, nil
+	}
+
 	if cacheTargetIndex && longtaillib.FileExists(fs, cacheTargetIndexPath) {
 		err = longtaillib.DeleteFile(fs, cacheTargetIndexPath)
 		if err != nil {
@@ -292,7 +331,7 @@ func downsync(
 			jobs,
 			&changeVersionProgress,
 			retargettedVersionStoreIndex,
-			targetVersionIndex,
+			currentVersionIndex,
 			sourceVersionIndex,
 			versionDiff,
 			longtailstorelib.NormalizeFileSystemPath(resolvedTargetFolderPath),
@@ -306,7 +345,7 @@ func downsync(
 			jobs,
 			&changeVersionProgress,
 			retargettedVersionStoreIndex,
-			targetVersionIndex,
+			currentVersionIndex,
 			sourceVersionIndex,
 			versionDiff,
 			longtailstorelib.NormalizeFileSystemPath(resolvedTargetFolderPath),
@@ -321,6 +360,10 @@ func downsync(
 	changeVersionTime := time.Since(changeVersionStartTime)
 	timeStats = append(timeStats, longtailutils.TimeStat{"Change version", changeVersionTime})
 
+	if repair {
+		printRepairedAssets(resolvedTargetFolderPath, repairedAssets)
+	}
+
 	flushStartTime := time.Now()
 
 	var stores []longtaillib.Longtail_BlockStoreAPI
@@ -483,10 +526,21 @@ type DownsyncCmd struct {
 	CacheTargetIndexOption
 	EnableFileMappingOption
 	UseLegacyWriteOption
+	DownsyncDryRunOption
+	RepairOption
 }
 
 func (r *DownsyncCmd) Run(ctx *Context) error {
+	cmdCtx := ctx.Ctx
+	if !r.DryRun {
+		var err error
+		cmdCtx, err = r.withIndexCache(ctx.Ctx)
+		if err != nil {
+			return err
+		}
+	}
 	storeStats, timeStats, err := downsync(
+		cmdCtx,
 		ctx.NumWorkerCount,
 		ctx.NumRemoteWorkerCount,
 		r.StorageURI,
@@ -505,7 +559,9 @@ func (r *DownsyncCmd) Run(ctx *Context) error {
 		r.ScanTarget,
 		r.CacheTargetIndex,
 		r.EnableFileMapping,
-		r.UseLegacyWrite)
+		r.UseLegacyWrite,
+		r.DryRun,
+		r.Repair)
 	ctx.StoreStats = append(ctx.StoreStats, storeStats...)
 	ctx.TimeStats = append(ctx.TimeStats, timeStats...)
 	return err
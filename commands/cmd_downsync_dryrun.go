@@ -0,0 +1,121 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/DanEngelbrecht/golongtail/longtaillib"
+	"github.com/DanEngelbrecht/golongtail/longtailutils"
+	"github.com/DanEngelbrecht/golongtail/remotestore"
+	"github.com/pkg/errors"
+)
+
+// changeVersionDryRun is what updating a folder to a version would do, as reported by --dry-run
+type changeVersionDryRun struct {
+	versionDiffReport
+	// Required chunks that are not in any block of the store, updating would fail
+	MissingChunkCount int `json:"missingChunkCount"`
+	// Blocks holding the required chunks and their stored, compressed, size
+	BlockCount int    `json:"blockCount"`
+	BlockSize  uint64 `json:"blockSize"`
+	// The part of the blocks that is already in the local cache
+	CachedBlockCount int    `json:"cachedBlockCount"`
+	CachedBlockSize  uint64 `json:"cachedBlockSize"`
+	// The part of the blocks that has to be fetched from the store
+	FetchBlockCount int    `json:"fetchBlockCount"`
+	FetchBlockSize  uint64 `json:"fetchBlockSize"`
+	// Bytes written to the target folder and how much it grows, or shrinks
+	WriteSize      uint64 `json:"writeSize"`
+	DiskSpaceDelta int64  `json:"diskSpaceDelta"`
+	// Free space needed for the target folder to grow and, with a local cache, for the fetched blocks
+	DiskSpaceRequired uint64 `json:"diskSpaceRequired"`
+}
+
+// createChangeVersionDryRun reports what updating the folder described by currentVersionIndex to
+// newVersionIndex would do. blockSizes holds the stored size of the blocks in storeIndex, blocks
+// found in localCachePath are counted as cached
+func createChangeVersionDryRun(
+	currentVersionIndex longtaillib.Longtail_VersionIndex,
+	newVersionIndex longtaillib.Longtail_VersionIndex,
+	versionDiff longtaillib.Longtail_VersionDiff,
+	storeIndex longtaillib.Longtail_StoreIndex,
+	blockSizes map[uint64]uint64,
+	localCachePath string) (changeVersionDryRun, error) {
+	const fname = "createChangeVersionDryRun"
+	report, err := createVersionDiffReport(currentVersionIndex, newVersionIndex, versionDiff)
+	if err != nil {
+		return changeVersionDryRun{}, errors.Wrap(err, fname)
+	}
+	dryRun := changeVersionDryRun{versionDiffReport: report}
+
+	requiredChunkHashes, err := longtaillib.GetRequiredChunkHashes(newVersionIndex, versionDiff)
+	if err != nil {
+		return changeVersionDryRun{}, errors.Wrap(err, fname)
+	}
+	storedChunks := map[uint64]bool{}
+	for _, chunkHash := range storeIndex.GetChunkHashes() {
+		storedChunks[chunkHash] = true
+	}
+	for _, chunkHash := range requiredChunkHashes {
+		if !storedChunks[chunkHash] {
+			dryRun.MissingChunkCount++
+		}
+	}
+
+	for _, blockHash := range storeIndex.GetBlockHashes() {
+		dryRun.BlockCount++
+		if localCachePath != "" {
+			cachedBlockInfo, err := os.Stat(filepath.Join(localCachePath, remotestore.GetBlockPath(blockHash)))
+			if err == nil {
+				dryRun.BlockSize += uint64(cachedBlockInfo.Size())
+				dryRun.CachedBlockCount++
+				dryRun.CachedBlockSize += uint64(cachedBlockInfo.Size())
+				continue
+			}
+		}
+		dryRun.BlockSize += blockSizes[blockHash]
+		dryRun.FetchBlockCount++
+		dryRun.FetchBlockSize += blockSizes[blockHash]
+	}
+
+	for _, asset := range report.Added {
+		dryRun.WriteSize += asset.Size
+		dryRun.DiskSpaceDelta += int64(asset.Size)
+	}
+	for _, asset := range report.Modified {
+		dryRun.WriteSize += asset.TargetSize
+		dryRun.DiskSpaceDelta += int64(asset.TargetSize) - int64(asset.SourceSize)
+	}
+	for _, asset := range report.Removed {
+		dryRun.DiskSpaceDelta -= int64(asset.Size)
+	}
+	if dryRun.DiskSpaceDelta > 0 {
+		dryRun.DiskSpaceRequired = uint64(dryRun.DiskSpaceDelta)
+	}
+	if localCachePath != "" {
+		dryRun.DiskSpaceRequired += dryRun.FetchBlockSize
+	}
+	return dryRun, nil
+}
+
+func printChangeVersionDryRun(dryRun changeVersionDryRun) {
+	fmt.Printf("Dry run, nothing was written\n")
+	fmt.Printf("Target Folder:       %s\n", dryRun.SourceVersionIndexPath)
+	fmt.Printf("Version:             %s\n", dryRun.TargetVersionIndexPath)
+	printVersionDiffAssets(dryRun.versionDiffReport)
+	fmt.Printf("Required Chunks:     %d   (%s)\n", dryRun.RequiredChunkCount, longtailutils.ByteCountBinary(dryRun.RequiredChunkSize))
+	if dryRun.MissingChunkCount > 0 {
+		fmt.Printf("Missing Chunks:      %d\n", dryRun.MissingChunkCount)
+	}
+	fmt.Printf("Blocks:              %d   (%s)\n", dryRun.BlockCount, longtailutils.ByteCountBinary(dryRun.BlockSize))
+	fmt.Printf("Cached Blocks:       %d   (%s)\n", dryRun.CachedBlockCount, longtailutils.ByteCountBinary(dryRun.CachedBlockSize))
+	fmt.Printf("Fetch Blocks:        %d   (%s)\n", dryRun.FetchBlockCount, longtailutils.ByteCountBinary(dryRun.FetchBlockSize))
+	fmt.Printf("Write Size:          %s\n", longtailutils.ByteCountBinary(dryRun.WriteSize))
+	if dryRun.DiskSpaceDelta < 0 {
+		fmt.Printf("Disk Space Delta:    -%s\n", longtailutils.ByteCountBinary(uint64(-dryRun.DiskSpaceDelta)))
+	} else {
+		fmt.Printf("Disk Space Delta:    %s\n", longtailutils.ByteCountBinary(uint64(dryRun.DiskSpaceDelta)))
+	}
+	fmt.Printf("Disk Space Required: %s\n", longtailutils.ByteCountBinary(dryRun.DiskSpaceRequired))
+}
@@ -0,0 +1,85 @@
+package commands
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/DanEngelbrecht/golongtail/longtaillib"
+	"github.com/pkg/errors"
+)
+
+const (
+	repairReasonMissing     = "missing"
+	repairReasonSize        = "size"
+	repairReasonContent     = "content"
+	repairReasonPermissions = "permissions"
+)
+
+type repairedAsset struct {
+	Path   string
+	Reason string
+}
+
+// createRepairVersionDiff compares the folder index with the source version and returns a version diff from the
+// source version to itself that rewrites the missing and damaged assets, to be applied with the source version
+// index as both the current and the new version. Assets in the folder that are not in the source are left in place
+func createRepairVersionDiff(
+	sourceVersionIndex longtaillib.Longtail_VersionIndex,
+	folderVersionIndex longtaillib.Longtail_VersionIndex,
+	retainPermissions bool) (longtaillib.Longtail_VersionDiff, []repairedAsset, error) {
+	const fname = "createRepairVersionDiff"
+
+	folderAssets := map[string]verifyFolderAsset{}
+	folderAssetHashes := folderVersionIndex.GetAssetHashes()
+	for i := uint32(0); i < folderVersionIndex.GetAssetCount(); i++ {
+		folderAssets[folderVersionIndex.GetAssetPath(i)] = verifyFolderAsset{
+			size:        folderVersionIndex.GetAssetSize(i),
+			permissions: folderVersionIndex.GetAssetPermissions(i),
+			hash:        folderAssetHashes[i]}
+	}
+	report := createVerifyFolderReport(sourceVersionIndex, folderAssets, false, retainPermissions)
+
+	sourceAssetIndexes := map[string]uint32{}
+	for i := uint32(0); i < sourceVersionIndex.GetAssetCount(); i++ {
+		sourceAssetIndexes[sourceVersionIndex.GetAssetPath(i)] = i
+	}
+
+	repaired := []repairedAsset{}
+	addedAssetIndexes := []uint32{}
+	for _, asset := range report.Missing {
+		addedAssetIndexes = append(addedAssetIndexes, sourceAssetIndexes[asset.Path])
+		repaired = append(repaired, repairedAsset{Path: asset.Path, Reason: repairReasonMissing})
+	}
+	modifiedContentAssetIndexes := []uint32{}
+	for _, asset := range report.SizeMismatch {
+		modifiedContentAssetIndexes = append(modifiedContentAssetIndexes, sourceAssetIndexes[asset.Path])
+		repaired = append(repaired, repairedAsset{Path: asset.Path, Reason: repairReasonSize})
+	}
+	for _, asset := range report.ContentMismatch {
+		modifiedContentAssetIndexes = append(modifiedContentAssetIndexes, sourceAssetIndexes[asset.Path])
+		repaired = append(repaired, repairedAsset{Path: asset.Path, Reason: repairReasonContent})
+	}
+	modifiedPermissionsAssetIndexes := []uint32{}
+	for _, asset := range report.PermissionsMismatch {
+		modifiedPermissionsAssetIndexes = append(modifiedPermissionsAssetIndexes, sourceAssetIndexes[asset.Path])
+		repaired = append(repaired, repairedAsset{Path: asset.Path, Reason: repairReasonPermissions})
+	}
+	for _, assetIndexes := range [][]uint32{addedAssetIndexes, modifiedContentAssetIndexes, modifiedPermissionsAssetIndexes} {
+		sort.Slice(assetIndexes, func(i, j int) bool { return assetIndexes[i] < assetIndexes[j] })
+	}
+	sort.SliceStable(repaired, func(i, j int) bool { return repaired[i].Path < repaired[j].Path })
+
+	versionDiff, err := longtaillib.CreateVersionDiffFromAssetIndexes(addedAssetIndexes, modifiedContentAssetIndexes, modifiedPermissionsAssetIndexes)
+	if err != nil {
+		return longtaillib.Longtail_VersionDiff{}, nil, errors.Wrap(err, fname)
+	}
+	return versionDiff, repaired, nil
+}
+
+func printRepairedAssets(targetFolderPath string, repaired []repairedAsset) {
+	fmt.Printf("Target Folder:       %s\n", targetFolderPath)
+	fmt.Printf("Repaired:            %d\n", len(repaired))
+	for _, asset := range repaired {
+		fmt.Printf("  %-12s %s\n", asset.Reason, asset.Path)
+	}
+}
@@ -1,11 +1,15 @@
 package commands
 
 import (
+	"context"
 	"os"
 	"path"
 	"testing"
 
+	"github.com/DanEngelbrecht/golongtail/longtaillib"
+	"github.com/DanEngelbrecht/golongtail/longtailstorelib"
 	"github.com/DanEngelbrecht/golongtail/longtailutils"
+	"github.com/DanEngelbrecht/golongtail/remotestore"
 	"github.com/alecthomas/assert/v2"
 )
 
@@ -211,7 +215,7 @@ func TestDownsyncMissingIndex(t *testing.T) {
 	executeCommandLine("upsync", "--source-path", testPath+"/version/v2", "--target-path", fsBlobPathPrefix+"/index/v2.lvi", "--storage-uri", fsBlobPathPrefix+"/storage")
 	executeCommandLine("upsync", "--source-path", testPath+"/version/v3", "--target-path", fsBlobPathPrefix+"/index/v3.lvi", "--storage-uri", fsBlobPathPrefix+"/storage")
 
-	longtailutils.DeleteByURI(fsBlobPathPrefix + "/storage/store.lsi")
+	longtailutils.DeleteByURI(context.Background(), fsBlobPathPrefix+"/storage/store.lsi")
 
 	cmd, err := executeCommandLine("downsync", "--source-path", fsBlobPathPrefix+"/index/v1.lvi", "--target-path", testPath+"/version/current", "--storage-uri", fsBlobPathPrefix+"/storage")
 	assert.Error(t, err, cmd)
@@ -246,3 +250,206 @@ func TestMultiVersionDownsync(t *testing.T) {
 	assert.NoError(t, err, cmd)
 	validateContent(t, fsBlobPathPrefix, "target", layerData)
 }
+
+func TestDownsyncInMemoryStore(t *testing.T) {
+	testPath, _ := os.MkdirTemp("", "test")
+	fsBlobPathPrefix := "fsblob://" + testPath
+	createVersionData(t, fsBlobPathPrefix)
+	memBlobPathPrefix := createMemStoreURI(t)
+	executeCommandLine("upsync", "--source-path", testPath+"/version/v1", "--target-path", memBlobPathPrefix+"/index/v1.lvi", "--storage-uri", memBlobPathPrefix+"/storage")
+	executeCommandLine("upsync", "--source-path", testPath+"/version/v2", "--target-path", memBlobPathPrefix+"/index/v2.lvi", "--storage-uri", memBlobPathPrefix+"/storage")
+
+	cmd, err := executeCommandLine("downsync", "--source-path", memBlobPathPrefix+"/index/v1.lvi", "--target-path", testPath+"/version/current", "--storage-uri", memBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+	validateContent(t, fsBlobPathPrefix, "version/current", v1FilesCreate)
+	cmd, err = executeCommandLine("downsync", "--source-path", memBlobPathPrefix+"/index/v2.lvi", "--target-path", testPath+"/version/current", "--storage-uri", memBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+	validateContent(t, fsBlobPathPrefix, "version/current", v2FilesCreate)
+
+	// Removing the blocks from the in-memory store must make downsync fail
+	longtailstorelib.DeleteNamedMemBlobStore(t.Name())
+	cmd, err = executeCommandLine("downsync", "--source-path", memBlobPathPrefix+"/index/v1.lvi", "--target-path", testPath+"/version/current", "--storage-uri", memBlobPathPrefix+"/storage")
+	assert.Error(t, err, cmd)
+}
+
+func TestDownsyncDryRun(t *testing.T) {
+	testPath, _ := os.MkdirTemp("", "test")
+	fsBlobPathPrefix := "fsblob://" + testPath
+	createVersionData(t, fsBlobPathPrefix)
+	executeCommandLine("upsync", "--source-path", testPath+"/version/v1", "--target-path", fsBlobPathPrefix+"/index/v1.lvi", "--storage-uri", fsBlobPathPrefix+"/storage")
+	executeCommandLine("upsync", "--source-path", testPath+"/version/v2", "--target-path", fsBlobPathPrefix+"/index/v2.lvi", "--storage-uri", fsBlobPathPrefix+"/storage")
+
+	// Nothing is written to a target folder that does not exist yet
+	cmd, err := executeCommandLine("downsync", "--source-path", fsBlobPathPrefix+"/index/v1.lvi", "--target-path", testPath+"/version/current", "--storage-uri", fsBlobPathPrefix+"/storage", "--dry-run")
+	assert.NoError(t, err, cmd)
+	_, err = os.Stat(testPath + "/version/current")
+	assert.True(t, os.IsNotExist(err))
+
+	cmd, err = executeCommandLine("downsync", "--source-path", fsBlobPathPrefix+"/index/v1.lvi", "--target-path", testPath+"/version/current", "--storage-uri", fsBlobPathPrefix+"/storage", "--cache-path", testPath+"/cache")
+	assert.NoError(t, err, cmd)
+	validateContent(t, fsBlobPathPrefix, "version/current", v1FilesCreate)
+	cacheBlocks, err := os.ReadDir(testPath + "/cache/chunks")
+	assert.NoError(t, err)
+
+	// A dry run reads blocks marked by a prune but does not rescue them
+	markAllBlocksForPrune(t, fsBlobPathPrefix+"/storage")
+	cmd, err = executeCommandLine("downsync", "--source-path", fsBlobPathPrefix+"/index/v2.lvi", "--target-path", testPath+"/version/current", "--storage-uri", fsBlobPathPrefix+"/storage", "--cache-path", testPath+"/cache", "--dry-run")
+	assert.NoError(t, err, cmd)
+	validateContent(t, fsBlobPathPrefix, "version/current", v1FilesCreate)
+	dryRunCacheBlocks, err := os.ReadDir(testPath + "/cache/chunks")
+	assert.NoError(t, err)
+	assert.Equal(t, len(cacheBlocks), len(dryRunCacheBlocks))
+	assert.Equal(t, 0, len(getPruneRescueRecords(t, fsBlobPathPrefix+"/storage")))
+}
+
+func markAllBlocksForPrune(t *testing.T, storageURI string) {
+	storeIndex, err := remotestore.ReadStoreIndex(context.Background(), storageURI)
+	assert.NoError(t, err)
+	defer storeIndex.Dispose()
+	assert.NotZero(t, storeIndex.GetBlockCount())
+	assert.NoError(t, remotestore.MarkPruneCandidates(context.Background(), storageURI, storeIndex.GetBlockHashes()))
+}
+
+func getPruneRescueRecords(t *testing.T, storageURI string) []longtailstorelib.BlobProperties {
+	blobStore, err := longtailstorelib.CreateBlobStoreForURI(storageURI)
+	assert.NoError(t, err)
+	client, err := blobStore.NewClient(context.Background())
+	assert.NoError(t, err)
+	defer client.Close()
+	records, err := client.GetObjects(remotestore.PruneRescuedPath)
+	assert.NoError(t, err)
+	return records
+}
+
+func TestChangeVersionDryRun(t *testing.T) {
+	testPath, _ := os.MkdirTemp("", "test")
+	fsBlobPathPrefix := "fsblob://" + testPath
+	createVersionData(t, fsBlobPathPrefix)
+	executeCommandLine("upsync", "--source-path", testPath+"/version/v1", "--target-path", fsBlobPathPrefix+"/index/v1.lvi", "--storage-uri", fsBlobPathPrefix+"/storage")
+	executeCommandLine("upsync", "--source-path", testPath+"/version/v2", "--target-path", fsBlobPathPrefix+"/index/v2.lvi", "--storage-uri", fsBlobPathPrefix+"/storage")
+
+	currentVersionIndex, err := readVersionIndex(context.Background(), fsBlobPathPrefix+"/index/v1.lvi")
+	assert.NoError(t, err)
+	defer currentVersionIndex.Dispose()
+	newVersionIndex, err := readVersionIndex(context.Background(), fsBlobPathPrefix+"/index/v2.lvi")
+	assert.NoError(t, err)
+	defer newVersionIndex.Dispose()
+	hash := longtaillib.CreateBlake3HashAPI()
+	defer hash.Dispose()
+	versionDiff, err := longtaillib.CreateVersionDiff(hash, currentVersionIndex, newVersionIndex)
+	assert.NoError(t, err)
+	defer versionDiff.Dispose()
+	storeIndex, err := remotestore.ReadStoreIndex(context.Background(), fsBlobPathPrefix+"/storage")
+	assert.NoError(t, err)
+	defer storeIndex.Dispose()
+	blockHashes := storeIndex.GetBlockHashes()
+	blockSizes, err := remotestore.GetStoredBlockSizes(context.Background(), fsBlobPathPrefix+"/storage", blockHashes)
+	assert.NoError(t, err)
+	assert.Equal(t, len(blockHashes), len(blockSizes))
+
+	// Put one of the blocks in the cache
+	cachePath := path.Join(testPath, "cache")
+	cachedBlockPath := path.Join(cachePath, remotestore.GetBlockPath(blockHashes[0]))
+	assert.NoError(t, os.MkdirAll(path.Dir(cachedBlockPath), 0755))
+	assert.NoError(t, os.WriteFile(cachedBlockPath, make([]byte, blockSizes[blockHashes[0]]), 0644))
+
+	dryRun, err := createChangeVersionDryRun(currentVersionIndex, newVersionIndex, versionDiff, storeIndex, blockSizes, cachePath)
+	assert.NoError(t, err)
+	addedSize := uint64(len(v2FilesCreate["stuff.txt"]) + len(v2FilesCreate["folder2/anotherabitoftextinasubfolder2.txt"]))
+	assert.Equal(t, 3, len(dryRun.Added))
+	assert.Equal(t, 0, len(dryRun.Removed)+len(dryRun.Modified))
+	assert.Equal(t, 0, dryRun.MissingChunkCount)
+	assert.Equal(t, len(blockHashes), dryRun.BlockCount)
+	assert.Equal(t, 1, dryRun.CachedBlockCount)
+	assert.Equal(t, blockSizes[blockHashes[0]], dryRun.CachedBlockSize)
+	assert.Equal(t, len(blockHashes)-1, dryRun.FetchBlockCount)
+	assert.Equal(t, dryRun.BlockSize, dryRun.CachedBlockSize+dryRun.FetchBlockSize)
+	assert.Equal(t, addedSize, dryRun.WriteSize)
+	assert.Equal(t, int64(addedSize), dryRun.DiskSpaceDelta)
+	assert.Equal(t, addedSize+dryRun.FetchBlockSize, dryRun.DiskSpaceRequired)
+
+	// Going back to v1 removes the added files and frees their space
+	reverseDiff, err := longtaillib.CreateVersionDiff(hash, newVersionIndex, currentVersionIndex)
+	assert.NoError(t, err)
+	defer reverseDiff.Dispose()
+	dryRun, err = createChangeVersionDryRun(newVersionIndex, currentVersionIndex, reverseDiff, storeIndex, blockSizes, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(dryRun.Removed))
+	assert.Equal(t, uint64(0), dryRun.WriteSize)
+	assert.Equal(t, -int64(addedSize), dryRun.DiskSpaceDelta)
+	assert.Equal(t, uint64(0), dryRun.DiskSpaceRequired)
+}
+
+func TestDownsyncRepair(t *testing.T) {
+	testPath, _ := os.MkdirTemp("", "test")
+	fsBlobPathPrefix := "fsblob://" + testPath
+	createVersionData(t, fsBlobPathPrefix)
+	cmd, err := executeCommandLine("upsync", "--source-path", testPath+"/version/v1", "--target-path", fsBlobPathPrefix+"/index/v1.lvi", "--storage-uri", fsBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+	cmd, err = executeCommandLine("downsync", "--source-path", fsBlobPathPrefix+"/index/v1.lvi", "--target-path", testPath+"/version/current", "--storage-uri", fsBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+
+	err = os.WriteFile(testPath+"/version/current/abitoftext.txt", []byte("this is a TEST file"), 0644)
+	assert.NoError(t, err)
+	err = os.Remove(testPath + "/version/current/folder/abitoftextinasubfolder.txt")
+	assert.NoError(t, err)
+
+	// The cached target index hides the damage from a regular downsync
+	cmd, err = executeCommandLine("downsync", "--source-path", fsBlobPathPrefix+"/index/v1.lvi", "--target-path", testPath+"/version/current", "--storage-uri", fsBlobPathPrefix+"/storage", "--validate")
+	assert.Error(t, err, cmd)
+
+	cmd, err = executeCommandLine("downsync", "--source-path", fsBlobPathPrefix+"/index/v1.lvi", "--target-path", testPath+"/version/current", "--storage-uri", fsBlobPathPrefix+"/storage", "--repair", "--dry-run")
+	assert.NoError(t, err, cmd)
+	b, err := os.ReadFile(testPath + "/version/current/abitoftext.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "this is a TEST file", string(b))
+
+	cmd, err = executeCommandLine("downsync", "--source-path", fsBlobPathPrefix+"/index/v1.lvi", "--target-path", testPath+"/version/current", "--storage-uri", fsBlobPathPrefix+"/storage", "--repair", "--validate")
+	assert.NoError(t, err, cmd)
+	validateContent(t, fsBlobPathPrefix, "version/current", v1FilesCreate)
+
+	// Files that are not part of the version are left in place
+	err = os.WriteFile(testPath+"/version/current/extra.txt", []byte("extra"), 0644)
+	assert.NoError(t, err)
+	cmd, err = executeCommandLine("downsync", "--source-path", fsBlobPathPrefix+"/index/v1.lvi", "--target-path", testPath+"/version/current", "--storage-uri", fsBlobPathPrefix+"/storage", "--repair")
+	assert.NoError(t, err, cmd)
+	_, err = os.Stat(testPath + "/version/current/extra.txt")
+	assert.NoError(t, err)
+}
+
+func TestCreateRepairVersionDiff(t *testing.T) {
+	testPath, _ := os.MkdirTemp("", "test")
+	fsBlobPathPrefix := "fsblob://" + testPath
+	createVersionData(t, fsBlobPathPrefix)
+	cmd, err := executeCommandLine("upsync", "--source-path", testPath+"/version/v1", "--target-path", fsBlobPathPrefix+"/index/v1.lvi", "--storage-uri", fsBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+	cmd, err = executeCommandLine("upsync", "--source-path", testPath+"/version/v2", "--target-path", fsBlobPathPrefix+"/index/v2.lvi", "--storage-uri", fsBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+
+	v1VersionIndex, err := readVersionIndex(context.Background(), fsBlobPathPrefix+"/index/v1.lvi")
+	assert.NoError(t, err)
+	defer v1VersionIndex.Dispose()
+	v2VersionIndex, err := readVersionIndex(context.Background(), fsBlobPathPrefix+"/index/v2.lvi")
+	assert.NoError(t, err)
+	defer v2VersionIndex.Dispose()
+
+	versionDiff, repaired, err := createRepairVersionDiff(v2VersionIndex, v1VersionIndex, true)
+	assert.NoError(t, err)
+	defer versionDiff.Dispose()
+	assert.Equal(t, []repairedAsset{
+		{Path: "folder2/", Reason: repairReasonMissing},
+		{Path: "folder2/anotherabitoftextinasubfolder2.txt", Reason: repairReasonMissing},
+		{Path: "stuff.txt", Reason: repairReasonMissing},
+	}, repaired)
+	assert.Equal(t, uint32(0), versionDiff.GetSourceRemovedCount())
+	assert.Equal(t, uint32(3), versionDiff.GetTargetAddedCount())
+	assert.Equal(t, uint32(0), versionDiff.GetModifiedContentCount())
+
+	// Assets that are only in the folder are not removed
+	versionDiff2, repaired, err := createRepairVersionDiff(v1VersionIndex, v2VersionIndex, true)
+	assert.NoError(t, err)
+	defer versionDiff2.Dispose()
+	assert.Equal(t, 0, len(repaired))
+	assert.Equal(t, uint32(0), versionDiff2.GetSourceRemovedCount())
+	assert.Equal(t, uint32(0), versionDiff2.GetTargetAddedCount())
+}
@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
@@ -12,6 +13,7 @@ import (
 )
 
 func dumpVersionAssets(
+	ctx context.Context,
 	numWorkerCount int,
 	versionIndexPath string,
 	s3EndpointResolverURI string,
@@ -30,7 +32,7 @@ func dumpVersionAssets(
 	timeStats := []longtailutils.TimeStat{}
 
 	readSourceStartTime := time.Now()
-	vbuffer, err := longtailutils.ReadFromURI(versionIndexPath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	vbuffer, err := longtailutils.ReadFromURI(ctx, versionIndexPath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
 	if err != nil {
 		return storeStats, timeStats, errors.Wrap(err, fname)
 	}
@@ -80,6 +82,7 @@ type DumpVersionAssetsCmd struct {
 
 func (r *DumpVersionAssetsCmd) Run(ctx *Context) error {
 	storeStats, timeStats, err := dumpVersionAssets(
+		ctx.Ctx,
 		ctx.NumWorkerCount,
 		r.VersionIndexPath,
 		r.S3EndpointResolverURL,
@@ -2,6 +2,7 @@ package commands
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"time"
 
@@ -12,6 +13,7 @@ import (
 )
 
 func get(
+	ctx context.Context,
 	numWorkerCount int,
 	numRemoteWorkerCount int,
 	getConfigPath string,
@@ -27,7 +29,8 @@ func get(
 	scanTarget bool,
 	cacheTargetIndex bool,
 	enableFileMapping bool,
-	useLegacyWrite bool) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error) {
+	useLegacyWrite bool,
+	dryRun bool) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error) {
 	const fname = "get"
 	log := logrus.WithFields(logrus.Fields{
 		"fname":                 fname,
@@ -47,6 +50,7 @@ func get(
 		"cacheTargetIndex":      cacheTargetIndex,
 		"enableFileMapping":     enableFileMapping,
 		"useLegacyWrite":        useLegacyWrite,
+		"dryRun":                dryRun,
 	})
 	log.Info(fname)
 
@@ -68,7 +72,7 @@ func get(
 	var sourceFilePaths []string
 	var versionLocalStoreIndexPaths []string
 	for _, getConfigPath := range getConfigPaths {
-		vbuffer, err := longtailutils.ReadFromURI(getConfigPath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+		vbuffer, err := longtailutils.ReadFromURI(ctx, getConfigPath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
 		if err != nil {
 			return storeStats, timeStats, errors.Wrap(err, fname)
 		}
@@ -114,6 +118,7 @@ func get(
 	timeStats = append(timeStats, longtailutils.TimeStat{"Read get config", readGetConfigTime})
 
 	downSyncStoreStats, downSyncTimeStats, err := downsync(
+		ctx,
 		numWorkerCount,
 		numRemoteWorkerCount,
 		blobStoreURI,
@@ -132,7 +137,9 @@ func get(
 		scanTarget,
 		cacheTargetIndex,
 		enableFileMapping,
-		useLegacyWrite)
+		useLegacyWrite,
+		dryRun,
+		false)
 
 	storeStats = append(storeStats, downSyncStoreStats...)
 	timeStats = append(timeStats, downSyncTimeStats...)
@@ -156,10 +163,20 @@ type GetCmd struct {
 	CacheTargetIndexOption
 	EnableFileMappingOption
 	UseLegacyWriteOption
+	DownsyncDryRunOption
 }
 
 func (r *GetCmd) Run(ctx *Context) error {
+	cmdCtx := ctx.Ctx
+	if !r.DryRun {
+		var err error
+		cmdCtx, err = r.withIndexCache(ctx.Ctx)
+		if err != nil {
+			return err
+		}
+	}
 	storeStats, timeStats, err := get(
+		cmdCtx,
 		ctx.NumWorkerCount,
 		ctx.NumRemoteWorkerCount,
 		r.GetConfigURI,
@@ -175,7 +192,8 @@ func (r *GetCmd) Run(ctx *Context) error {
 		r.ScanTarget,
 		r.CacheTargetIndex,
 		r.EnableFileMapping,
-		r.UseLegacyWrite)
+		r.UseLegacyWrite,
+		r.DryRun)
 	ctx.StoreStats = append(ctx.StoreStats, storeStats...)
 	ctx.TimeStats = append(ctx.TimeStats, timeStats...)
 	return err
@@ -109,3 +109,18 @@ func TestMultiVersionGetMismatchStoreURI(t *testing.T) {
 	cmd, err := executeCommandLine("get", "--source-paths", fsBlobPathPrefix+"/index/base.json|"+fsBlobPathPrefix+"/index/layer2.json|"+fsBlobPathPrefix+"/index/layer3.json", "--target-path", testPath+"/target")
 	assert.Error(t, err, cmd)
 }
+
+func TestGetDryRun(t *testing.T) {
+	testPath, _ := os.MkdirTemp("", "test")
+	fsBlobPathPrefix := "fsblob://" + testPath
+	createVersionData(t, fsBlobPathPrefix)
+	executeCommandLine("put", "--source-path", testPath+"/version/v1", "--target-path", fsBlobPathPrefix+"/index/v1.json", "--storage-uri", fsBlobPathPrefix+"/storage")
+	executeCommandLine("put", "--source-path", testPath+"/version/v2", "--target-path", fsBlobPathPrefix+"/index/v2.json", "--storage-uri", fsBlobPathPrefix+"/storage")
+
+	cmd, err := executeCommandLine("get", "--source-path", fsBlobPathPrefix+"/index/v1.json", "--target-path", testPath+"/version/current")
+	assert.NoError(t, err, cmd)
+	validateContent(t, fsBlobPathPrefix, "version/current", v1FilesCreate)
+	cmd, err = executeCommandLine("get", "--source-path", fsBlobPathPrefix+"/index/v2.json", "--target-path", testPath+"/version/current", "--dry-run")
+	assert.NoError(t, err, cmd)
+	validateContent(t, fsBlobPathPrefix, "version/current", v1FilesCreate)
+}
@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"time"
 
 	"github.com/DanEngelbrecht/golongtail/longtaillib"
@@ -11,6 +12,7 @@ import (
 )
 
 func initRemoteStore(
+	ctx context.Context,
 	numWorkerCount int,
 	remoteStoreWorkerCount int,
 	blobStoreURI string,
@@ -35,7 +37,7 @@ func initRemoteStore(
 	jobs := longtaillib.CreateBikeshedJobAPI(uint32(numWorkerCount), 0)
 	defer jobs.Dispose()
 
-	remoteIndexStore, err := remotestore.CreateBlockStoreForURI(blobStoreURI, nil, jobs, remoteStoreWorkerCount, 8388608, 1024, remotestore.Init, false, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	remoteIndexStore, err := remotestore.CreateBlockStoreForURI(ctx, blobStoreURI, nil, jobs, remoteStoreWorkerCount, 8388608, 1024, remotestore.Init, false, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
 	if err != nil {
 		return storeStats, timeStats, errors.Wrap(err, fname)
 	}
@@ -75,15 +77,19 @@ type InitRemoteStoreCmd struct {
 	StorageURIOption
 	S3EndpointResolverURLOption
 	HashingOption
+	StoreLeaseOption
 }
 
 func (r *InitRemoteStoreCmd) Run(ctx *Context) error {
-	storeStats, timeStats, err := initRemoteStore(
-		ctx.NumWorkerCount,
-		ctx.NumRemoteWorkerCount,
-		r.StorageURI,
-		r.S3EndpointResolverURL,
-		r.Hashing)
+	storeStats, timeStats, err := runWithStoreLease(ctx.Ctx, r.StorageURI, r.S3EndpointResolverURL, "init-remote-store", r.StoreLeaseTTL, func(leaseCtx context.Context) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error) {
+		return initRemoteStore(
+			leaseCtx,
+			ctx.NumWorkerCount,
+			ctx.NumRemoteWorkerCount,
+			r.StorageURI,
+			r.S3EndpointResolverURL,
+			r.Hashing)
+	})
 	ctx.StoreStats = append(ctx.StoreStats, storeStats...)
 	ctx.TimeStats = append(ctx.TimeStats, timeStats...)
 	return err
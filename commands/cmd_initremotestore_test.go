@@ -47,7 +47,7 @@ func TestInitRemoteStore(t *testing.T) {
 	client, _ := store.NewClient(context.Background())
 	defer client.Close()
 	storeIndexObject, _ := client.NewObject("storage/store.lsi")
-	storeIndexObject.Delete()
+	storeIndexObject.Delete(context.Background())
 
 	// Init the store again to pick up existing blocks
 	cmd, err := executeCommandLine("init-remote-store", "--storage-uri", fsBlobPathPrefix+"/storage")
@@ -60,12 +60,12 @@ func TestInitRemoteStore(t *testing.T) {
 	executeCommandLine("get", "--source-path", fsBlobPathPrefix+"/index/v3.json", "--target-path", testPath+"/version/current")
 	validateContent(t, fsBlobPathPrefix, "version/current", v3FilesCreate)
 
-	storeIndexObject.Delete()
+	storeIndexObject.Delete(context.Background())
 	emptyStoreIndex, _ := longtaillib.CreateStoreIndexFromBlocks([]longtaillib.Longtail_BlockIndex{})
 	defer emptyStoreIndex.Dispose()
 	emptyStoreIndexBytes, _ := longtaillib.WriteStoreIndexToBuffer(emptyStoreIndex)
 	defer emptyStoreIndexBytes.Dispose()
-	storeIndexObject.Write(emptyStoreIndexBytes.ToBuffer())
+	storeIndexObject.Write(context.Background(), emptyStoreIndexBytes.ToBuffer())
 
 	// Force rebuilding the index even though it exists
 	cmd, err = executeCommandLine("init-remote-store", "--storage-uri", fsBlobPathPrefix+"/storage")
@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -11,6 +12,7 @@ import (
 )
 
 func ls(
+	ctx context.Context,
 	numWorkerCount int,
 	versionIndexPath string,
 	s3EndpointResolverURI string,
@@ -34,7 +36,7 @@ func ls(
 	defer hashRegistry.Dispose()
 
 	readSourceStartTime := time.Now()
-	vbuffer, err := longtailutils.ReadFromURI(versionIndexPath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	vbuffer, err := longtailutils.ReadFromURI(ctx, versionIndexPath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
 	if err != nil {
 		return storeStats, timeStats, errors.Wrap(err, fname)
 	}
@@ -126,6 +128,7 @@ type LsCmd struct {
 
 func (r *LsCmd) Run(ctx *Context) error {
 	storeStats, timeStats, err := ls(
+		ctx.Ctx,
 		ctx.NumWorkerCount,
 		r.VersionIndexPath,
 		r.S3EndpointResolverURL,
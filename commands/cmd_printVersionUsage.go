@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 	"runtime"
 	"time"
@@ -14,6 +15,7 @@ import (
 )
 
 func printVersionUsage(
+	ctx context.Context,
 	numWorkerCount int,
 	remoteStoreWorkerCount int,
 	blobStoreURI string,
@@ -43,7 +45,7 @@ func printVersionUsage(
 
 	var indexStore longtaillib.Longtail_BlockStoreAPI
 
-	remoteIndexStore, err := remotestore.CreateBlockStoreForURI(blobStoreURI, nil, jobs, remoteStoreWorkerCount, 8388608, 1024, remotestore.ReadOnly, false, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	remoteIndexStore, err := remotestore.CreateBlockStoreForURI(ctx, blobStoreURI, nil, jobs, remoteStoreWorkerCount, 8388608, 1024, remotestore.ReadOnly, false, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
 	if err != nil {
 		return storeStats, timeStats, errors.Wrap(err, fname)
 	}
@@ -73,7 +75,7 @@ func printVersionUsage(
 	timeStats = append(timeStats, longtailutils.TimeStat{"Setup", setupTime})
 
 	readSourceStartTime := time.Now()
-	vbuffer, err := longtailutils.ReadFromURI(versionIndexPath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	vbuffer, err := longtailutils.ReadFromURI(ctx, versionIndexPath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
 	if err != nil {
 		return storeStats, timeStats, errors.Wrap(err, fname)
 	}
@@ -220,6 +222,7 @@ type PrintVersionUsageCmd struct {
 
 func (r *PrintVersionUsageCmd) Run(ctx *Context) error {
 	storeStats, timeStats, err := printVersionUsage(
+		ctx.Ctx,
 		ctx.NumWorkerCount,
 		ctx.NumRemoteWorkerCount,
 		r.StorageURI,
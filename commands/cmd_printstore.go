@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -11,6 +12,7 @@ import (
 )
 
 func printStore(
+	ctx context.Context,
 	numWorkerCount int,
 	storeIndexPath string,
 	s3EndpointResolverURI string,
@@ -32,7 +34,7 @@ func printStore(
 
 	readStoreIndexStartTime := time.Now()
 
-	vbuffer, err := longtailutils.ReadFromURI(storeIndexPath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	vbuffer, err := longtailutils.ReadFromURI(ctx, storeIndexPath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
 	if err != nil {
 		return storeStats, timeStats, errors.Wrap(err, fname)
 	}
@@ -99,6 +101,7 @@ type PrintStoreCmd struct {
 
 func (r *PrintStoreCmd) Run(ctx *Context) error {
 	storeStats, timeStats, err := printStore(
+		ctx.Ctx,
 		ctx.NumWorkerCount,
 		r.StoreIndexPath,
 		r.S3EndpointResolverURL,
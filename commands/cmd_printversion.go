@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -11,6 +12,7 @@ import (
 )
 
 func printVersion(
+	ctx context.Context,
 	numWorkerCount int,
 	versionIndexPath string,
 	s3EndpointResolverURI string,
@@ -30,7 +32,7 @@ func printVersion(
 
 	readSourceStartTime := time.Now()
 
-	vbuffer, err := longtailutils.ReadFromURI(versionIndexPath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	vbuffer, err := longtailutils.ReadFromURI(ctx, versionIndexPath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
 	if err != nil {
 		return storeStats, timeStats, errors.Wrap(err, fname)
 	}
@@ -117,6 +119,7 @@ type PrintVersionCmd struct {
 
 func (r *PrintVersionCmd) Run(ctx *Context) error {
 	storeStats, timeStats, err := printVersion(
+		ctx.Ctx,
 		ctx.NumWorkerCount,
 		r.VersionIndexPath,
 		r.S3EndpointResolverURL,
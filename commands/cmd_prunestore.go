@@ -2,6 +2,7 @@ package commands
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"runtime"
@@ -21,12 +22,13 @@ type pruneOneResult struct {
 }
 
 func readPruneVersion(
+	ctx context.Context,
 	sourceFilePath string,
 	s3EndpointResolverURI string,
 	versionLocalStoreIndexFilePath string,
 	writeVersionLocalStoreIndex bool) (longtaillib.Longtail_VersionIndex, longtaillib.Longtail_StoreIndex, error) {
 	const fname = "readPruneVersion"
-	vbuffer, err := longtailutils.ReadFromURI(sourceFilePath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	vbuffer, err := longtailutils.ReadFromURI(ctx, sourceFilePath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
 	if err != nil {
 		return longtaillib.Longtail_VersionIndex{}, longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
 	}
@@ -38,7 +40,7 @@ func readPruneVersion(
 
 	var storeIndex longtaillib.Longtail_StoreIndex
 	if versionLocalStoreIndexFilePath != "" && !writeVersionLocalStoreIndex {
-		sbuffer, err := longtailutils.ReadFromURI(versionLocalStoreIndexFilePath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+		sbuffer, err := longtailutils.ReadFromURI(ctx, versionLocalStoreIndexFilePath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
 		if err == nil {
 			storeIndex, err = longtaillib.ReadStoreIndexFromBuffer(sbuffer)
 			if err != nil {
@@ -59,6 +61,7 @@ func readPruneVersion(
 }
 
 func pruneOne(
+	ctx context.Context,
 	remoteStore longtaillib.Longtail_BlockStoreAPI,
 	sourceFilePath string,
 	s3EndpointResolverURI string,
@@ -79,7 +82,7 @@ func pruneOne(
 		outResults <- result
 	}()
 
-	versionIndex, existingStoreIndex, err := readPruneVersion(sourceFilePath, s3EndpointResolverURI, versionLocalStoreIndexFilePath, writeVersionLocalStoreIndex)
+	versionIndex, existingStoreIndex, err := readPruneVersion(ctx, sourceFilePath, s3EndpointResolverURI, versionLocalStoreIndexFilePath, writeVersionLocalStoreIndex)
 	if err != nil {
 		result.err = errors.Wrap(err, fname)
 		return
@@ -121,7 +124,7 @@ func pruneOne(
 			result.err = errors.Wrap(err, fname)
 			return
 		}
-		err = longtailutils.WriteToURI(versionLocalStoreIndexFilePath, sbuffer.ToBuffer(), longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+		err = longtailutils.WriteToURI(ctx, versionLocalStoreIndexFilePath, sbuffer.ToBuffer(), longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
 		if err != nil {
 			existingStoreIndex.Dispose()
 			result.err = errors.Wrap(err, fname)
@@ -134,6 +137,7 @@ func pruneOne(
 }
 
 func gatherBlocksToKeep(
+	ctx context.Context,
 	remoteStoreWorkerCount int,
 	storageURI string,
 	s3EndpointResolverURI string,
@@ -156,7 +160,7 @@ func gatherBlocksToKeep(
 		"dryRun":                      dryRun,
 	})
 	log.Debug(fname)
-	remoteStore, err := remotestore.CreateBlockStoreForURI(storageURI, nil, jobs, remoteStoreWorkerCount, 8388608, 1024, remotestore.ReadOnly, false, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	remoteStore, err := remotestore.CreateBlockStoreForURI(ctx, storageURI, nil, jobs, remoteStoreWorkerCount, 8388608, 1024, remotestore.ReadOnly, false, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
 	if err != nil {
 		return nil, errors.Wrap(err, fname)
 	}
@@ -197,6 +201,7 @@ func gatherBlocksToKeep(
 
 		if sourceFilePath != "" {
 			go pruneOne(
+				ctx,
 				remoteStore,
 				sourceFilePath,
 				s3EndpointResolverURI,
@@ -266,6 +271,7 @@ func gatherBlocksToKeep(
 }
 
 func pruneStore(
+	ctx context.Context,
 	numWorkerCount int,
 	remoteStoreWorkerCount int,
 	storageURI string,
@@ -275,7 +281,8 @@ func pruneStore(
 	writeVersionLocalStoreIndex bool,
 	validateVersions bool,
 	skipInvalidVersions bool,
-	dryRun bool) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error) {
+	dryRun bool,
+	gracePeriod time.Duration) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error) {
 	const fname = "pruneStore"
 	log := logrus.WithFields(logrus.Fields{
 		"fname":                        fname,
@@ -289,6 +296,7 @@ func pruneStore(
 		"validateVersions":             validateVersions,
 		"skipInvalidVersions":          skipInvalidVersions,
 		"dryRun":                       dryRun,
+		"gracePeriod":                  gracePeriod,
 	})
 	log.Info(fname)
 
@@ -345,6 +353,7 @@ func pruneStore(
 
 	gatherBlocksToKeepStartTime := time.Now()
 	blocksToKeep, err := gatherBlocksToKeep(
+		ctx,
 		remoteStoreWorkerCount,
 		storageURI,
 		s3EndpointResolverURI,
@@ -367,7 +376,12 @@ func pruneStore(
 		fmt.Printf("Prune would keep %d blocks", len(blocksToKeep))
 		return storeStats, timeStats, nil
 	}
-	remoteStore, err := remotestore.CreateBlockStoreForURI(storageURI, nil, jobs, remoteStoreWorkerCount, 8388608, 1024, remotestore.ReadWrite, false, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+
+	if gracePeriod > 0 {
+		return markAndSweepStore(ctx, storageURI, s3EndpointResolverURI, blocksToKeep, gracePeriod, storeStats, timeStats)
+	}
+
+	remoteStore, err := remotestore.CreateBlockStoreForURI(ctx, storageURI, nil, jobs, remoteStoreWorkerCount, 8388608, 1024, remotestore.ReadWrite, false, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
 	if err != nil {
 		return storeStats, timeStats, errors.Wrap(err, fname)
 	}
@@ -399,6 +413,59 @@ func pruneStore(
 	return storeStats, timeStats, nil
 }
 
+// markAndSweepStore marks the blocks that are not in blocksToKeep as prune candidates, removes the candidates
+// marked by earlier prunes from the store index once they are past the grace period and deletes them once they
+// have been out of the store index for the grace period, unless an upload rescued them
+func markAndSweepStore(
+	ctx context.Context,
+	storageURI string,
+	s3EndpointResolverURI string,
+	blocksToKeep []uint64,
+	gracePeriod time.Duration,
+	storeStats []longtailutils.StoreStat,
+	timeStats []longtailutils.TimeStat) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error) {
+	const fname = "markAndSweepStore"
+	log := logrus.WithFields(logrus.Fields{
+		"fname":             fname,
+		"storageURI":        storageURI,
+		"len(blocksToKeep)": len(blocksToKeep),
+		"gracePeriod":       gracePeriod,
+	})
+	log.Debug(fname)
+
+	markStartTime := time.Now()
+	storeIndex, err := remotestore.ReadStoreIndex(ctx, storageURI, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	if err != nil {
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+	keep := make(map[uint64]bool, len(blocksToKeep))
+	for _, blockHash := range blocksToKeep {
+		keep[blockHash] = true
+	}
+	candidates := []uint64{}
+	for _, blockHash := range storeIndex.GetBlockHashes() {
+		if !keep[blockHash] {
+			candidates = append(candidates, blockHash)
+		}
+	}
+	storeIndex.Dispose()
+	err = remotestore.MarkPruneCandidates(ctx, storageURI, candidates, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	if err != nil {
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+	timeStats = append(timeStats, longtailutils.TimeStat{"Mark prune candidates", time.Since(markStartTime)})
+
+	sweepStartTime := time.Now()
+	deletedCount, rescuedCount, err := remotestore.SweepPruneCandidates(ctx, storageURI, gracePeriod, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	if err != nil {
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+	timeStats = append(timeStats, longtailutils.TimeStat{"Sweep prune candidates", time.Since(sweepStartTime)})
+
+	log.Infof("Marked %d blocks, pruned %d blocks, %d blocks were rescued", len(candidates), deletedCount, rescuedCount)
+	return storeStats, timeStats, nil
+}
+
 type PruneStoreCmd struct {
 	StorageURIOption
 	S3EndpointResolverURLOption
@@ -408,20 +475,34 @@ type PruneStoreCmd struct {
 	WriteVersionLocalStoreIndex bool   `name:"write-version-local-store-index" help:"Write a new version local store index for each version. This requires a valid version-local-store-index-paths input parameter"`
 	ValidateVersions            bool   `name:"validate-versions" help:"Verify that all content needed for a version is available in the store"`
 	SkipInvalidVersions         bool   `name:"skip-invalid-versions" help:"If an invalid version is found, disregard its blocks. If not set and validate-version is set, invalid version will abort with an error"`
+	StoreLeaseOption
+	GracePeriod time.Duration `name:"grace-period" help:"Mark unused blocks instead of deleting them, blocks marked by an earlier prune are removed from the store index once they have been marked for this long and deleted by a later prune once they have been out of the store index for this long, unless an upload reused them. Must be longer than the longest running upload, zero deletes unused blocks right away" default:"0"`
 }
 
 func (r *PruneStoreCmd) Run(ctx *Context) error {
-	storeStats, timeStats, err := pruneStore(
-		ctx.NumWorkerCount,
-		ctx.NumRemoteWorkerCount,
-		r.StorageURI,
-		r.S3EndpointResolverURL,
-		r.SourcePaths,
-		r.VersionLocalStoreIndexPaths,
-		r.WriteVersionLocalStoreIndex,
-		r.ValidateVersions,
-		r.SkipInvalidVersions,
-		r.DryRun)
+	prune := func(pruneCtx context.Context) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error) {
+		return pruneStore(
+			pruneCtx,
+			ctx.NumWorkerCount,
+			ctx.NumRemoteWorkerCount,
+			r.StorageURI,
+			r.S3EndpointResolverURL,
+			r.SourcePaths,
+			r.VersionLocalStoreIndexPaths,
+			r.WriteVersionLocalStoreIndex,
+			r.ValidateVersions,
+			r.SkipInvalidVersions,
+			r.DryRun,
+			r.GracePeriod)
+	}
+	var storeStats []longtailutils.StoreStat
+	var timeStats []longtailutils.TimeStat
+	var err error
+	if r.DryRun {
+		storeStats, timeStats, err = prune(ctx.Ctx)
+	} else {
+		storeStats, timeStats, err = runWithStoreLease(ctx.Ctx, r.StorageURI, r.S3EndpointResolverURL, "prune-store", r.StoreLeaseTTL, prune)
+	}
 	ctx.StoreStats = append(ctx.StoreStats, storeStats...)
 	ctx.TimeStats = append(ctx.TimeStats, timeStats...)
 	return err
@@ -21,7 +21,7 @@ func TestPruneStoreBlocks(t *testing.T) {
 	sourceFilesContent := []byte(
 		fsBlobPathPrefix + "/index/v1.lvi" + "\n" +
 			fsBlobPathPrefix + "/index/v2.lvi" + "\n")
-	longtailutils.WriteToURI(fsBlobPathPrefix+"/files.txt", sourceFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsBlobPathPrefix+"/files.txt", sourceFilesContent)
 
 	cmd, err := executeCommandLine("prune-store-index", "--source-paths", testPath+"/files.txt", "--store-index-path", fsBlobPathPrefix+"/storage/store.lsi")
 	assert.NoError(t, err, cmd)
@@ -57,7 +57,7 @@ func TestPruneStoreBlocksDryRun(t *testing.T) {
 	sourceFilesContent := []byte(
 		fsBlobPathPrefix + "/index/v1.lvi" + "\n" +
 			fsBlobPathPrefix + "/index/v2.lvi" + "\n")
-	longtailutils.WriteToURI(fsBlobPathPrefix+"/files.txt", sourceFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsBlobPathPrefix+"/files.txt", sourceFilesContent)
 
 	cmd, err := executeCommandLine("prune-store-index", "--source-paths", testPath+"/files.txt", "--store-index-path", fsBlobPathPrefix+"/storage/store.lsi")
 	assert.NoError(t, err, cmd)
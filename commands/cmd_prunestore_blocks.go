@@ -21,6 +21,7 @@ type ReadStoreIndexAsyncResult struct {
 }
 
 func pruneStoreBlocks(
+	ctx context.Context,
 	numWorkerCount int,
 	storeIndexPath string,
 	s3EndpointResolverURI string,
@@ -41,12 +42,12 @@ func pruneStoreBlocks(
 	storeStats := []longtailutils.StoreStat{}
 	timeStats := []longtailutils.TimeStat{}
 
-	blobStore, err := longtailstorelib.CreateBlobStoreForURI(blocksRootPath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	blobStore, err := longtailstorelib.CreateBlobStoreForURI(blocksRootPath, append(longtailstorelib.GetBlobStoreOptions(ctx), longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))...)
 	if err != nil {
 		return storeStats, timeStats, errors.Wrap(err, fname)
 	}
 
-	client, err := blobStore.NewClient(context.Background())
+	client, err := blobStore.NewClient(ctx)
 	if err != nil {
 		return storeStats, timeStats, errors.Wrap(err, fname)
 	}
@@ -56,7 +57,7 @@ func pruneStoreBlocks(
 	go func() {
 		const fname = "GetStoreIndexAsync"
 		start := time.Now()
-		storeIndexBuffer, err := longtailutils.ReadFromURI(storeIndexPath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+		storeIndexBuffer, err := longtailutils.ReadFromURI(ctx, storeIndexPath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
 		if err != nil {
 			readStoreIndexAsyncResultChannel <- ReadStoreIndexAsyncResult{err: errors.Wrap(err, fname), elapsed: time.Since(start)}
 			return
@@ -69,39 +70,32 @@ func pruneStoreBlocks(
 		readStoreIndexAsyncResultChannel <- ReadStoreIndexAsyncResult{err: nil, elapsed: time.Since(start), storeIndex: storeIndex}
 	}()
 
-	getBlockObjectsStartTime := time.Now()
-	allObjects, err := client.GetObjects("")
-	if err != nil {
-		return storeStats, timeStats, errors.Wrap(err, fname)
-	}
-	timeStats = append(timeStats, longtailutils.TimeStat{"Get block objects", time.Since(getBlockObjectsStartTime)})
-
-	checkFoundBlocksStartTime := time.Now()
 	blockNameRegExPattern := ".*0x([0-9,a-f,A-F]*).*" + blockExtension
 	blockNameRegEx, err := regexp.Compile(blockNameRegExPattern)
 	if err != nil {
 		return storeStats, timeStats, errors.Wrap(err, fname)
 	}
 
-	findObjectsProgress := longtailutils.CreateProgress("Checking found blocks     ", 1)
-	defer findObjectsProgress.Dispose()
-
+	// Stream the listing so we only keep the block names around, not every object in the store
+	getBlockObjectsStartTime := time.Now()
 	blocksFound := make(map[uint64]string)
-	for i, object := range allObjects {
-		findObjectsProgress.OnProgress(uint32(len(allObjects)), uint32(i))
+	err = client.WalkObjects("", func(object longtailstorelib.BlobProperties) error {
 		m := blockNameRegEx.FindSubmatch([]byte(object.Name))
 		if len(m) < 2 {
-			continue
+			return nil
 		}
 		hashString := string(m[1])
 		hash, err := strconv.ParseUint(hashString, 16, 64)
 		if err != nil {
-			continue
+			return nil
 		}
 		blocksFound[hash] = object.Name
+		return nil
+	})
+	if err != nil {
+		return storeStats, timeStats, errors.Wrap(err, fname)
 	}
-	findObjectsProgress.OnProgress(uint32(len(allObjects)), uint32(len(allObjects)))
-	timeStats = append(timeStats, longtailutils.TimeStat{"Check found blocks", time.Since(checkFoundBlocksStartTime)})
+	timeStats = append(timeStats, longtailutils.TimeStat{"Get block objects", time.Since(getBlockObjectsStartTime)})
 
 	fmt.Printf("Found %d blocks\n", len(blocksFound))
 
@@ -167,7 +161,7 @@ func pruneStoreBlocks(
 
 	deleteClientChannel := make(chan *longtailstorelib.BlobClient, workerCount)
 	for i := 0; i < workerCount; i++ {
-		workerClient, err := blobStore.NewClient(context.Background())
+		workerClient, err := blobStore.NewClient(ctx)
 		if err != nil {
 			return storeStats, timeStats, errors.Wrap(err, fname)
 		}
@@ -201,7 +195,7 @@ func pruneStoreBlocks(
 					deleteResultChannel <- errors.Wrap(err, fname)
 					return
 				}
-				err = object.Delete()
+				err = object.Delete(ctx)
 				if err != nil {
 					deleteResultChannel <- errors.Wrap(err, fname)
 					return
@@ -266,16 +260,29 @@ type PruneStoreBlocksCmd struct {
 	BlocksRootPath string `name:"blocks-root-path" help:"Root path uri for all blocks to check" required:""`
 	BlockExtension string `name:"block-extension" help:"The file extension to use when finding blocks" default:".lsb"`
 	DryRun         bool   `name:"dry-run" help:"Don't prune, just show how many blocks would be deleted if prune was run"`
+	StoreLeaseURIOption
+	StoreLeaseOption
 }
 
 func (r *PruneStoreBlocksCmd) Run(ctx *Context) error {
-	storeStats, timeStats, err := pruneStoreBlocks(
-		ctx.NumWorkerCount,
-		r.StoreIndexPath,
-		r.S3EndpointResolverURL,
-		r.BlocksRootPath,
-		r.BlockExtension,
-		r.DryRun)
+	prune := func(pruneCtx context.Context) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error) {
+		return pruneStoreBlocks(
+			pruneCtx,
+			ctx.NumWorkerCount,
+			r.StoreIndexPath,
+			r.S3EndpointResolverURL,
+			r.BlocksRootPath,
+			r.BlockExtension,
+			r.DryRun)
+	}
+	var storeStats []longtailutils.StoreStat
+	var timeStats []longtailutils.TimeStat
+	var err error
+	if r.DryRun {
+		storeStats, timeStats, err = prune(ctx.Ctx)
+	} else {
+		storeStats, timeStats, err = runWithStoreLease(ctx.Ctx, storeLeaseURI(r.StoreLeaseURI, r.StoreIndexPath), r.S3EndpointResolverURL, "prune-store-blocks", r.StoreLeaseTTL, prune)
+	}
 	ctx.StoreStats = append(ctx.StoreStats, storeStats...)
 	ctx.TimeStats = append(ctx.TimeStats, timeStats...)
 	return err
@@ -2,6 +2,7 @@ package commands
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"strings"
@@ -14,6 +15,7 @@ import (
 )
 
 func pruneOneUsingStoreIndex(
+	ctx context.Context,
 	storeIndex longtaillib.Longtail_StoreIndex,
 	sourceFilePath string,
 	s3EndpointResolverURI string,
@@ -34,7 +36,7 @@ func pruneOneUsingStoreIndex(
 		outResults <- result
 	}()
 
-	versionIndex, existingStoreIndex, err := readPruneVersion(sourceFilePath, s3EndpointResolverURI, versionLocalStoreIndexFilePath, writeVersionLocalStoreIndex)
+	versionIndex, existingStoreIndex, err := readPruneVersion(ctx, sourceFilePath, s3EndpointResolverURI, versionLocalStoreIndexFilePath, writeVersionLocalStoreIndex)
 	if err != nil {
 		result.err = errors.Wrap(err, fname)
 		return
@@ -76,7 +78,7 @@ func pruneOneUsingStoreIndex(
 			return
 		}
 		defer sbuffer.Dispose()
-		err = longtailutils.WriteToURI(versionLocalStoreIndexFilePath, sbuffer.ToBuffer(), longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+		err = longtailutils.WriteToURI(ctx, versionLocalStoreIndexFilePath, sbuffer.ToBuffer(), longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
 		if err != nil {
 			existingStoreIndex.Dispose()
 			result.err = errors.Wrap(err, fname)
@@ -89,6 +91,7 @@ func pruneOneUsingStoreIndex(
 }
 
 func gatherBlocksToKeepFromStoreIndex(
+	ctx context.Context,
 	numWorkerCount int,
 	storeIndex longtaillib.Longtail_StoreIndex,
 	s3EndpointResolverURI string,
@@ -141,6 +144,7 @@ func gatherBlocksToKeepFromStoreIndex(
 
 		if sourceFilePath != "" {
 			go pruneOneUsingStoreIndex(
+				ctx,
 				storeIndex,
 				sourceFilePath,
 				s3EndpointResolverURI,
@@ -210,6 +214,7 @@ func gatherBlocksToKeepFromStoreIndex(
 }
 
 func pruneStoreIndex(
+	ctx context.Context,
 	numWorkerCount int,
 	storeIndexPath string,
 	s3EndpointResolverURI string,
@@ -280,7 +285,7 @@ func pruneStoreIndex(
 	}
 
 	readStoreIndexStartTime := time.Now()
-	storeIndexBuffer, err := longtailutils.ReadFromURI(storeIndexPath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	storeIndexBuffer, err := longtailutils.ReadFromURI(ctx, storeIndexPath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
 	if err != nil {
 		return storeStats, timeStats, errors.Wrap(err, fname)
 	}
@@ -295,6 +300,7 @@ func pruneStoreIndex(
 	gatherBlocksToKeepStartTime := time.Now()
 
 	blocksToKeep, err := gatherBlocksToKeepFromStoreIndex(
+		ctx,
 		numWorkerCount,
 		storeIndex,
 		s3EndpointResolverURI,
@@ -335,7 +341,7 @@ func pruneStoreIndex(
 	if err != nil {
 		return storeStats, timeStats, errors.Wrap(err, fname)
 	}
-	err = longtailutils.WriteToURI(storeIndexPath, prunedStoreIndexBuffer.ToBuffer(), longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	err = longtailutils.WriteToURI(ctx, storeIndexPath, prunedStoreIndexBuffer.ToBuffer(), longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
 	timeStats = append(timeStats, longtailutils.TimeStat{"Write store index", time.Since(writeStoreIndexStartTime)})
 	if err != nil {
 		return storeStats, timeStats, errors.Wrap(err, fname)
@@ -353,19 +359,32 @@ type PruneStoreIndexCmd struct {
 	WriteVersionLocalStoreIndex bool   `name:"write-version-local-store-index" help:"Write a new version local store index for each version. This requires a valid version-local-store-index-paths input parameter"`
 	ValidateVersions            bool   `name:"validate-versions" help:"Verify that all content needed for a version is available in the store"`
 	SkipInvalidVersions         bool   `name:"skip-invalid-versions" help:"If an invalid version is found, disregard its blocks. If not set and validate-version is set, invalid version will abort with an error"`
+	StoreLeaseURIOption
+	StoreLeaseOption
 }
 
 func (r *PruneStoreIndexCmd) Run(ctx *Context) error {
-	storeStats, timeStats, err := pruneStoreIndex(
-		ctx.NumWorkerCount,
-		r.StoreIndexPath,
-		r.S3EndpointResolverURL,
-		r.SourcePaths,
-		r.VersionLocalStoreIndexPaths,
-		r.WriteVersionLocalStoreIndex,
-		r.ValidateVersions,
-		r.SkipInvalidVersions,
-		r.DryRun)
+	prune := func(pruneCtx context.Context) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error) {
+		return pruneStoreIndex(
+			pruneCtx,
+			ctx.NumWorkerCount,
+			r.StoreIndexPath,
+			r.S3EndpointResolverURL,
+			r.SourcePaths,
+			r.VersionLocalStoreIndexPaths,
+			r.WriteVersionLocalStoreIndex,
+			r.ValidateVersions,
+			r.SkipInvalidVersions,
+			r.DryRun)
+	}
+	var storeStats []longtailutils.StoreStat
+	var timeStats []longtailutils.TimeStat
+	var err error
+	if r.DryRun {
+		storeStats, timeStats, err = prune(ctx.Ctx)
+	} else {
+		storeStats, timeStats, err = runWithStoreLease(ctx.Ctx, storeLeaseURI(r.StoreLeaseURI, r.StoreIndexPath), r.S3EndpointResolverURL, "prune-store-index", r.StoreLeaseTTL, prune)
+	}
 	ctx.StoreStats = append(ctx.StoreStats, storeStats...)
 	ctx.TimeStats = append(ctx.TimeStats, timeStats...)
 	return err
@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"os"
 	"testing"
 
@@ -19,7 +20,7 @@ func TestPruneIndex(t *testing.T) {
 	sourceFilesContent := []byte(
 		fsBlobPathPrefix + "/index/v1.lvi" + "\n" +
 			fsBlobPathPrefix + "/index/v2.lvi" + "\n")
-	longtailutils.WriteToURI(fsBlobPathPrefix+"/files.txt", sourceFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsBlobPathPrefix+"/files.txt", sourceFilesContent)
 
 	cmd, err := executeCommandLine("prune-store-index", "--source-paths", testPath+"/files.txt", "--store-index-path", fsBlobPathPrefix+"/storage/store.lsi")
 	assert.NoError(t, err, cmd)
@@ -45,12 +46,12 @@ func TestPruneIndexWithLSI(t *testing.T) {
 	sourceFilesContent := []byte(
 		fsBlobPathPrefix + "/index/v1.lvi" + "\n" +
 			fsBlobPathPrefix + "/index/v2.lvi" + "\n")
-	longtailutils.WriteToURI(fsBlobPathPrefix+"/files.txt", sourceFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsBlobPathPrefix+"/files.txt", sourceFilesContent)
 
 	lsiFilesContent := []byte(
 		fsBlobPathPrefix + "/index/v1.lsi" + "\n" +
 			fsBlobPathPrefix + "/index/v2.lsi" + "\n")
-	longtailutils.WriteToURI(fsBlobPathPrefix+"/files-lsi.txt", lsiFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsBlobPathPrefix+"/files-lsi.txt", lsiFilesContent)
 
 	cmd, err := executeCommandLine("prune-store-index", "--source-paths", testPath+"/files.txt", "--version-local-store-index-paths", testPath+"/files-lsi.txt", "--store-index-path", fsBlobPathPrefix+"/storage/store.lsi")
 	assert.NoError(t, err, cmd)
@@ -76,12 +77,12 @@ func TestPruneIndexWithLSIAndWriteLSI(t *testing.T) {
 	sourceFilesContent := []byte(
 		fsBlobPathPrefix + "/index/v1.lvi" + "\n" +
 			fsBlobPathPrefix + "/index/v2.lvi" + "\n")
-	longtailutils.WriteToURI(fsBlobPathPrefix+"/files.txt", sourceFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsBlobPathPrefix+"/files.txt", sourceFilesContent)
 
 	lsiFilesContent := []byte(
 		fsBlobPathPrefix + "/index/v1.lsi" + "\n" +
 			fsBlobPathPrefix + "/index/v2.lsi" + "\n")
-	longtailutils.WriteToURI(fsBlobPathPrefix+"/files-lsi.txt", lsiFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsBlobPathPrefix+"/files-lsi.txt", lsiFilesContent)
 
 	cmd, err := executeCommandLine("prune-store-index", "--source-paths", testPath+"/files.txt", "--version-local-store-index-paths", testPath+"/files-lsi.txt", "--store-index-path", fsBlobPathPrefix+"/storage/store.lsi", "--write-version-local-store-index")
 	assert.NoError(t, err, cmd)
@@ -109,7 +110,7 @@ func TestPruneIndexDryRun(t *testing.T) {
 	sourceFilesContent := []byte(
 		fsBlobPathPrefix + "/index/v1.lvi" + "\n" +
 			fsBlobPathPrefix + "/index/v2.lvi" + "\n")
-	longtailutils.WriteToURI(fsBlobPathPrefix+"/files.txt", sourceFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsBlobPathPrefix+"/files.txt", sourceFilesContent)
 
 	cmd, err := executeCommandLine("prune-store-index", "--source-paths", testPath+"/files.txt", "--store-index-path", fsBlobPathPrefix+"/storage/store.lsi", "--dry-run")
 	assert.NoError(t, err, cmd)
@@ -135,12 +136,12 @@ func TestPruneIndexWithLSIDryRun(t *testing.T) {
 	sourceFilesContent := []byte(
 		fsBlobPathPrefix + "/index/v1.lvi" + "\n" +
 			fsBlobPathPrefix + "/index/v2.lvi" + "\n")
-	longtailutils.WriteToURI(fsBlobPathPrefix+"/files.txt", sourceFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsBlobPathPrefix+"/files.txt", sourceFilesContent)
 
 	lsiFilesContent := []byte(
 		fsBlobPathPrefix + "/index/v1.lsi" + "\n" +
 			fsBlobPathPrefix + "/index/v2.lsi" + "\n")
-	longtailutils.WriteToURI(fsBlobPathPrefix+"/files-lsi.txt", lsiFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsBlobPathPrefix+"/files-lsi.txt", lsiFilesContent)
 
 	cmd, err := executeCommandLine("prune-store-index", "--source-paths", testPath+"/files.txt", "--version-local-store-index-paths", testPath+"/files-lsi.txt", "--store-index-path", fsBlobPathPrefix+"/storage/store.lsi", "--dry-run")
 	assert.NoError(t, err, cmd)
@@ -168,12 +169,12 @@ func TestPruneIndexWithLSIAndWriteLSIDryRun(t *testing.T) {
 	sourceFilesContent := []byte(
 		fsBlobPathPrefix + "/index/v1.lvi" + "\n" +
 			fsBlobPathPrefix + "/index/v2.lvi" + "\n")
-	longtailutils.WriteToURI(fsBlobPathPrefix+"/files.txt", sourceFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsBlobPathPrefix+"/files.txt", sourceFilesContent)
 
 	lsiFilesContent := []byte(
 		fsBlobPathPrefix + "/index/v1.lsi" + "\n" +
 			fsBlobPathPrefix + "/index/v2.lsi" + "\n")
-	longtailutils.WriteToURI(fsBlobPathPrefix+"/files-lsi.txt", lsiFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsBlobPathPrefix+"/files-lsi.txt", lsiFilesContent)
 
 	cmd, err := executeCommandLine("prune-store-index", "--source-paths", testPath+"/files.txt", "--version-local-store-index-paths", testPath+"/files-lsi.txt", "--store-index-path", fsBlobPathPrefix+"/storage/store.lsi", "--write-version-local-store-index", "--dry-run")
 	assert.NoError(t, err, cmd)
@@ -1,10 +1,13 @@
 package commands
 
 import (
+	"context"
 	"os"
 	"testing"
 
+	"github.com/DanEngelbrecht/golongtail/longtailstorelib"
 	"github.com/DanEngelbrecht/golongtail/longtailutils"
+	"github.com/DanEngelbrecht/golongtail/remotestore"
 	"github.com/alecthomas/assert/v2"
 )
 
@@ -19,7 +22,7 @@ func TestPrune(t *testing.T) {
 	sourceFilesContent := []byte(
 		fsBlobPathPrefix + "/index/v1.lvi" + "\n" +
 			fsBlobPathPrefix + "/index/v2.lvi" + "\n")
-	longtailutils.WriteToURI(fsBlobPathPrefix+"/files.txt", sourceFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsBlobPathPrefix+"/files.txt", sourceFilesContent)
 
 	cmd, err := executeCommandLine("prune-store", "--source-paths", testPath+"/files.txt", "--storage-uri", fsBlobPathPrefix+"/storage")
 	assert.NoError(t, err, cmd)
@@ -34,6 +37,59 @@ func TestPrune(t *testing.T) {
 	assert.Error(t, err, cmd)
 }
 
+func TestPruneGracePeriod(t *testing.T) {
+	testPath, _ := os.MkdirTemp("", "test")
+	testPruneGracePeriod(t, testPath, "fsblob://"+testPath+"/storage")
+}
+
+func TestPruneGracePeriodLocalStore(t *testing.T) {
+	// Writers to a plain path store must rescue marked blocks just like writers going through a blob store
+	testPath, _ := os.MkdirTemp("", "test")
+	testPruneGracePeriod(t, testPath, testPath+"/storage")
+}
+
+func testPruneGracePeriod(t *testing.T, testPath string, storageURI string) {
+	fsBlobPathPrefix := "fsblob://" + testPath
+	createVersionData(t, fsBlobPathPrefix)
+	executeCommandLine("upsync", "--source-path", testPath+"/version/v1", "--target-path", fsBlobPathPrefix+"/index/v1.lvi", "--storage-uri", storageURI)
+	executeCommandLine("upsync", "--source-path", testPath+"/version/v2", "--target-path", fsBlobPathPrefix+"/index/v2.lvi", "--storage-uri", storageURI)
+	executeCommandLine("upsync", "--source-path", testPath+"/version/v3", "--target-path", fsBlobPathPrefix+"/index/v3.lvi", "--storage-uri", storageURI)
+
+	sourceFilesContent := []byte(
+		fsBlobPathPrefix + "/index/v1.lvi" + "\n" +
+			fsBlobPathPrefix + "/index/v2.lvi" + "\n")
+	longtailutils.WriteToURI(context.Background(), fsBlobPathPrefix+"/files.txt", sourceFilesContent)
+
+	// The first prune only marks the blocks used by v3
+	cmd, err := executeCommandLine("prune-store", "--source-paths", testPath+"/files.txt", "--storage-uri", storageURI, "--grace-period", "1h")
+	assert.NoError(t, err, cmd)
+	cmd, err = executeCommandLine("downsync", "--source-path", fsBlobPathPrefix+"/index/v3.lvi", "--target-path", testPath+"/version/current", "--storage-uri", storageURI)
+	assert.NoError(t, err, cmd)
+	validateContent(t, fsBlobPathPrefix, "version/current", v3FilesCreate)
+
+	// Uploading v3 again reuses the marked blocks which rescues them from the sweep
+	cmd, err = executeCommandLine("upsync", "--source-path", testPath+"/version/v3", "--target-path", fsBlobPathPrefix+"/index/v3b.lvi", "--storage-uri", storageURI)
+	assert.NoError(t, err, cmd)
+	cmd, err = executeCommandLine("prune-store", "--source-paths", testPath+"/files.txt", "--storage-uri", storageURI, "--grace-period", "1ns")
+	assert.NoError(t, err, cmd)
+	cmd, err = executeCommandLine("downsync", "--source-path", fsBlobPathPrefix+"/index/v3b.lvi", "--target-path", testPath+"/version/current", "--storage-uri", storageURI)
+	assert.NoError(t, err, cmd)
+	validateContent(t, fsBlobPathPrefix, "version/current", v3FilesCreate)
+
+	// Without uploads the blocks are marked again, removed from the store index and deleted once the grace period has passed
+	cmd, err = executeCommandLine("prune-store", "--source-paths", testPath+"/files.txt", "--storage-uri", storageURI, "--grace-period", "1ns")
+	assert.NoError(t, err, cmd)
+	cmd, err = executeCommandLine("prune-store", "--source-paths", testPath+"/files.txt", "--storage-uri", storageURI, "--grace-period", "1ns")
+	assert.NoError(t, err, cmd)
+	cmd, err = executeCommandLine("prune-store", "--source-paths", testPath+"/files.txt", "--storage-uri", storageURI, "--grace-period", "1ns")
+	assert.NoError(t, err, cmd)
+	cmd, err = executeCommandLine("downsync", "--source-path", fsBlobPathPrefix+"/index/v2.lvi", "--target-path", testPath+"/version/current", "--storage-uri", storageURI)
+	assert.NoError(t, err, cmd)
+	validateContent(t, fsBlobPathPrefix, "version/current", v2FilesCreate)
+	cmd, err = executeCommandLine("downsync", "--source-path", fsBlobPathPrefix+"/index/v3.lvi", "--target-path", testPath+"/version/current", "--storage-uri", storageURI)
+	assert.Error(t, err, cmd)
+}
+
 func TestPruneWithValidate(t *testing.T) {
 	testPath, _ := os.MkdirTemp("", "test")
 	fsBlobPathPrefix := "fsblob://" + testPath
@@ -45,7 +101,7 @@ func TestPruneWithValidate(t *testing.T) {
 	sourceFilesContent := []byte(
 		fsBlobPathPrefix + "/index/v1.lvi" + "\n" +
 			fsBlobPathPrefix + "/index/v2.lvi" + "\n")
-	longtailutils.WriteToURI(fsBlobPathPrefix+"/files.txt", sourceFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsBlobPathPrefix+"/files.txt", sourceFilesContent)
 
 	cmd, err := executeCommandLine("prune-store", "--source-paths", testPath+"/files.txt", "--storage-uri", fsBlobPathPrefix+"/storage", "--validate-versions")
 	assert.NoError(t, err, cmd)
@@ -71,12 +127,12 @@ func TestPruneWithLSI(t *testing.T) {
 	sourceFilesContent := []byte(
 		fsBlobPathPrefix + "/index/v1.lvi" + "\n" +
 			fsBlobPathPrefix + "/index/v2.lvi" + "\n")
-	longtailutils.WriteToURI(fsBlobPathPrefix+"/files.txt", sourceFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsBlobPathPrefix+"/files.txt", sourceFilesContent)
 
 	lsiFilesContent := []byte(
 		fsBlobPathPrefix + "/index/v1.lsi" + "\n" +
 			fsBlobPathPrefix + "/index/v2.lsi" + "\n")
-	longtailutils.WriteToURI(fsBlobPathPrefix+"/files-lsi.txt", lsiFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsBlobPathPrefix+"/files-lsi.txt", lsiFilesContent)
 
 	cmd, err := executeCommandLine("prune-store", "--source-paths", testPath+"/files.txt", "--version-local-store-index-paths", testPath+"/files-lsi.txt", "--storage-uri", fsBlobPathPrefix+"/storage")
 	assert.NoError(t, err, cmd)
@@ -102,12 +158,12 @@ func TestPruneWithLSIAndWriteLSI(t *testing.T) {
 	sourceFilesContent := []byte(
 		fsBlobPathPrefix + "/index/v1.lvi" + "\n" +
 			fsBlobPathPrefix + "/index/v2.lvi" + "\n")
-	longtailutils.WriteToURI(fsBlobPathPrefix+"/files.txt", sourceFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsBlobPathPrefix+"/files.txt", sourceFilesContent)
 
 	lsiFilesContent := []byte(
 		fsBlobPathPrefix + "/index/v1.lsi" + "\n" +
 			fsBlobPathPrefix + "/index/v2.lsi" + "\n")
-	longtailutils.WriteToURI(fsBlobPathPrefix+"/files-lsi.txt", lsiFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsBlobPathPrefix+"/files-lsi.txt", lsiFilesContent)
 
 	cmd, err := executeCommandLine("prune-store", "--source-paths", testPath+"/files.txt", "--version-local-store-index-paths", testPath+"/files-lsi.txt", "--storage-uri", fsBlobPathPrefix+"/storage", "--write-version-local-store-index")
 	assert.NoError(t, err, cmd)
@@ -133,7 +189,7 @@ func TestPruneDryRun(t *testing.T) {
 	sourceFilesContent := []byte(
 		fsBlobPathPrefix + "/index/v1.lvi" + "\n" +
 			fsBlobPathPrefix + "/index/v2.lvi" + "\n")
-	longtailutils.WriteToURI(fsBlobPathPrefix+"/files.txt", sourceFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsBlobPathPrefix+"/files.txt", sourceFilesContent)
 
 	cmd, err := executeCommandLine("prune-store", "--source-paths", testPath+"/files.txt", "--storage-uri", fsBlobPathPrefix+"/storage", "--dry-run")
 	assert.NoError(t, err, cmd)
@@ -159,12 +215,12 @@ func TestPruneWithLSIDryRun(t *testing.T) {
 	sourceFilesContent := []byte(
 		fsBlobPathPrefix + "/index/v1.lvi" + "\n" +
 			fsBlobPathPrefix + "/index/v2.lvi" + "\n")
-	longtailutils.WriteToURI(fsBlobPathPrefix+"/files.txt", sourceFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsBlobPathPrefix+"/files.txt", sourceFilesContent)
 
 	lsiFilesContent := []byte(
 		fsBlobPathPrefix + "/index/v1.lsi" + "\n" +
 			fsBlobPathPrefix + "/index/v2.lsi" + "\n")
-	longtailutils.WriteToURI(fsBlobPathPrefix+"/files-lsi.txt", lsiFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsBlobPathPrefix+"/files-lsi.txt", lsiFilesContent)
 
 	cmd, err := executeCommandLine("prune-store", "--source-paths", testPath+"/files.txt", "--version-local-store-index-paths", testPath+"/files-lsi.txt", "--storage-uri", fsBlobPathPrefix+"/storage", "--dry-run")
 	assert.NoError(t, err, cmd)
@@ -190,12 +246,12 @@ func TestPruneWithLSIAndWriteLSIDryRun(t *testing.T) {
 	sourceFilesContent := []byte(
 		fsBlobPathPrefix + "/index/v1.lvi" + "\n" +
 			fsBlobPathPrefix + "/index/v2.lvi" + "\n")
-	longtailutils.WriteToURI(fsBlobPathPrefix+"/files.txt", sourceFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsBlobPathPrefix+"/files.txt", sourceFilesContent)
 
 	lsiFilesContent := []byte(
 		fsBlobPathPrefix + "/index/v1.lsi" + "\n" +
 			fsBlobPathPrefix + "/index/v2.lsi" + "\n")
-	longtailutils.WriteToURI(fsBlobPathPrefix+"/files-lsi.txt", lsiFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsBlobPathPrefix+"/files-lsi.txt", lsiFilesContent)
 
 	cmd, err := executeCommandLine("prune-store", "--source-paths", testPath+"/files.txt", "--version-local-store-index-paths", testPath+"/files-lsi.txt", "--storage-uri", fsBlobPathPrefix+"/storage", "--write-version-local-store-index", "--dry-run")
 	assert.NoError(t, err, cmd)
@@ -221,12 +277,12 @@ func TestPruneWithValidateAndLSIAndWriteLSIDryRun(t *testing.T) {
 	sourceFilesContent := []byte(
 		fsBlobPathPrefix + "/index/v1.lvi" + "\n" +
 			fsBlobPathPrefix + "/index/v2.lvi" + "\n")
-	longtailutils.WriteToURI(fsBlobPathPrefix+"/files.txt", sourceFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsBlobPathPrefix+"/files.txt", sourceFilesContent)
 
 	lsiFilesContent := []byte(
 		fsBlobPathPrefix + "/index/v1.lsi" + "\n" +
 			fsBlobPathPrefix + "/index/v2.lsi" + "\n")
-	longtailutils.WriteToURI(fsBlobPathPrefix+"/files-lsi.txt", lsiFilesContent)
+	longtailutils.WriteToURI(context.Background(), fsBlobPathPrefix+"/files-lsi.txt", lsiFilesContent)
 
 	cmd, err := executeCommandLine("prune-store", "--source-paths", testPath+"/files.txt", "--version-local-store-index-paths", testPath+"/files-lsi.txt", "--storage-uri", fsBlobPathPrefix+"/storage", "--write-version-local-store-index", "--dry-run", "--validate-versions")
 	assert.NoError(t, err, cmd)
@@ -240,3 +296,98 @@ func TestPruneWithValidateAndLSIAndWriteLSIDryRun(t *testing.T) {
 	cmd, err = executeCommandLine("downsync", "--source-path", fsBlobPathPrefix+"/index/v3.lvi", "--target-path", testPath+"/version/current", "--storage-uri", fsBlobPathPrefix+"/storage")
 	assert.NoError(t, err, cmd)
 }
+
+func TestPruneInMemoryStore(t *testing.T) {
+	testPath, _ := os.MkdirTemp("", "test")
+	fsBlobPathPrefix := "fsblob://" + testPath
+	createVersionData(t, fsBlobPathPrefix)
+	memBlobPathPrefix := createMemStoreURI(t)
+	executeCommandLine("upsync", "--source-path", testPath+"/version/v1", "--target-path", memBlobPathPrefix+"/index/v1.lvi", "--storage-uri", memBlobPathPrefix+"/storage")
+	executeCommandLine("upsync", "--source-path", testPath+"/version/v2", "--target-path", memBlobPathPrefix+"/index/v2.lvi", "--storage-uri", memBlobPathPrefix+"/storage")
+	executeCommandLine("upsync", "--source-path", testPath+"/version/v3", "--target-path", memBlobPathPrefix+"/index/v3.lvi", "--storage-uri", memBlobPathPrefix+"/storage")
+
+	err := longtailstorelib.SeedMemBlobs(memBlobPathPrefix, map[string][]byte{
+		"files.txt": []byte(memBlobPathPrefix + "/index/v1.lvi" + "\n" + memBlobPathPrefix + "/index/v2.lvi" + "\n")})
+	assert.NoError(t, err)
+
+	blobsBefore, _ := longtailstorelib.GetMemBlobs(memBlobPathPrefix + "/storage")
+	cmd, err := executeCommandLine("prune-store", "--source-paths", memBlobPathPrefix+"/files.txt", "--storage-uri", memBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+	blobsAfter, _ := longtailstorelib.GetMemBlobs(memBlobPathPrefix + "/storage")
+	assert.True(t, len(blobsAfter) < len(blobsBefore))
+
+	cmd, err = executeCommandLine("downsync", "--source-path", memBlobPathPrefix+"/index/v2.lvi", "--target-path", testPath+"/version/current", "--storage-uri", memBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+	validateContent(t, fsBlobPathPrefix, "version/current", v2FilesCreate)
+	cmd, err = executeCommandLine("downsync", "--source-path", memBlobPathPrefix+"/index/v3.lvi", "--target-path", testPath+"/version/current", "--storage-uri", memBlobPathPrefix+"/storage")
+	assert.Error(t, err, cmd)
+}
+
+func TestPruneStoreWithFailingDeletes(t *testing.T) {
+	testPath, _ := os.MkdirTemp("", "test")
+	fsBlobPathPrefix := "fsblob://" + testPath
+	createVersionData(t, fsBlobPathPrefix)
+	memBlobPathPrefix := createMemStoreURI(t)
+	executeCommandLine("upsync", "--source-path", testPath+"/version/v1", "--target-path", memBlobPathPrefix+"/index/v1.lvi", "--storage-uri", memBlobPathPrefix+"/storage")
+	executeCommandLine("upsync", "--source-path", testPath+"/version/v2", "--target-path", memBlobPathPrefix+"/index/v2.lvi", "--storage-uri", memBlobPathPrefix+"/storage")
+	executeCommandLine("upsync", "--source-path", testPath+"/version/v3", "--target-path", memBlobPathPrefix+"/index/v3.lvi", "--storage-uri", memBlobPathPrefix+"/storage")
+
+	err := longtailstorelib.SeedMemBlobs(memBlobPathPrefix, map[string][]byte{
+		"files.txt": []byte(memBlobPathPrefix + "/index/v1.lvi" + "\n" + memBlobPathPrefix + "/index/v2.lvi" + "\n")})
+	assert.NoError(t, err)
+
+	// The store index is pruned before blocks are deleted so failing deletes only leave unreferenced blocks behind
+	storeIndex, err := remotestore.ReadStoreIndex(context.Background(), memBlobPathPrefix+"/storage")
+	assert.NoError(t, err)
+	blockCountBefore := storeIndex.GetBlockCount()
+	storeIndex.Dispose()
+	blobsBefore, _ := longtailstorelib.GetMemBlobs(memBlobPathPrefix + "/storage")
+	faultyStorageURI := "fault+" + memBlobPathPrefix + "/storage?ops=delete&error-rate=1"
+	cmd, err := executeCommandLine("prune-store", "--source-paths", memBlobPathPrefix+"/files.txt", "--storage-uri", faultyStorageURI)
+	assert.Error(t, err, cmd)
+	blobsAfter, _ := longtailstorelib.GetMemBlobs(memBlobPathPrefix + "/storage")
+	assert.Equal(t, len(blobsBefore), len(blobsAfter))
+	storeIndex, err = remotestore.ReadStoreIndex(context.Background(), memBlobPathPrefix+"/storage")
+	assert.NoError(t, err)
+	assert.True(t, storeIndex.GetBlockCount() < blockCountBefore)
+	storeIndex.Dispose()
+
+	cmd, err = executeCommandLine("downsync", "--source-path", memBlobPathPrefix+"/index/v2.lvi", "--target-path", testPath+"/version/current", "--storage-uri", memBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+	validateContent(t, fsBlobPathPrefix, "version/current", v2FilesCreate)
+
+	// The blocks left behind are removed once the deletes succeed
+	cmd, err = executeCommandLine("prune-store-blocks", "--store-index-path", memBlobPathPrefix+"/storage/store.lsi", "--blocks-root-path", memBlobPathPrefix+"/storage/chunks")
+	assert.NoError(t, err, cmd)
+	blobsAfter, _ = longtailstorelib.GetMemBlobs(memBlobPathPrefix + "/storage")
+	assert.True(t, len(blobsAfter) < len(blobsBefore))
+
+	cmd, err = executeCommandLine("downsync", "--source-path", memBlobPathPrefix+"/index/v1.lvi", "--target-path", testPath+"/version/current", "--storage-uri", memBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+	validateContent(t, fsBlobPathPrefix, "version/current", v1FilesCreate)
+}
+
+func TestPruneStoreWithFailingStoreIndexWrite(t *testing.T) {
+	testPath, _ := os.MkdirTemp("", "test")
+	fsBlobPathPrefix := "fsblob://" + testPath
+	createVersionData(t, fsBlobPathPrefix)
+	memBlobPathPrefix := createMemStoreURI(t)
+	executeCommandLine("upsync", "--source-path", testPath+"/version/v1", "--target-path", memBlobPathPrefix+"/index/v1.lvi", "--storage-uri", memBlobPathPrefix+"/storage")
+	executeCommandLine("upsync", "--source-path", testPath+"/version/v2", "--target-path", memBlobPathPrefix+"/index/v2.lvi", "--storage-uri", memBlobPathPrefix+"/storage")
+
+	err := longtailstorelib.SeedMemBlobs(memBlobPathPrefix, map[string][]byte{
+		"files.txt": []byte(memBlobPathPrefix + "/index/v1.lvi" + "\n")})
+	assert.NoError(t, err)
+
+	// If the pruned store index can't be written no blocks may be deleted
+	blobsBefore, _ := longtailstorelib.GetMemBlobs(memBlobPathPrefix + "/storage")
+	faultyStorageURI := "fault+" + memBlobPathPrefix + "/storage?ops=write,lock&error-rate=1"
+	cmd, err := executeCommandLine("prune-store", "--source-paths", memBlobPathPrefix+"/files.txt", "--storage-uri", faultyStorageURI)
+	assert.Error(t, err, cmd)
+	blobsAfter, _ := longtailstorelib.GetMemBlobs(memBlobPathPrefix + "/storage")
+	assert.Equal(t, len(blobsBefore), len(blobsAfter))
+
+	cmd, err = executeCommandLine("downsync", "--source-path", memBlobPathPrefix+"/index/v2.lvi", "--target-path", testPath+"/version/current", "--storage-uri", memBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+	validateContent(t, fsBlobPathPrefix, "version/current", v2FilesCreate)
+}
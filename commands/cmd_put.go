@@ -15,6 +15,7 @@ import (
 )
 
 func put(
+	ctx context.Context,
 	numWorkerCount int,
 	numRemoteWorkerCount int,
 	blobStoreURI string,
@@ -33,7 +34,10 @@ func put(
 	versionLocalStoreIndexPath string,
 	targetPath string,
 	disableVersionLocalStoreIndex bool,
-	enableFileMapping bool) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error) {
+	enableFileMapping bool,
+	compactStoreIndexThreshold int,
+	checkStoreLease bool,
+	dryRun bool) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error) {
 	const fname = "put"
 	log := logrus.WithContext(context.Background()).WithFields(logrus.Fields{
 		"fname":                      fname,
@@ -55,6 +59,9 @@ func put(
 		"versionLocalStoreIndexPath": versionLocalStoreIndexPath,
 		"targetPath":                 targetPath,
 		"enableFileMapping":          enableFileMapping,
+		"compactStoreIndexThreshold": compactStoreIndexThreshold,
+		"checkStoreLease":            checkStoreLease,
+		"dryRun":                     dryRun,
 	})
 	log.Info(fname)
 
@@ -91,6 +98,7 @@ func put(
 	}
 
 	downSyncStoreStats, downSyncTimeStats, err := upsync(
+		ctx,
 		numWorkerCount,
 		numRemoteWorkerCount,
 		blobStoreURI,
@@ -107,12 +115,15 @@ func put(
 		excludeFilterRegEx,
 		minBlockUsagePercent,
 		versionLocalStoreIndexPath,
-		enableFileMapping)
+		enableFileMapping,
+		compactStoreIndexThreshold,
+		checkStoreLease,
+		dryRun)
 
 	storeStats = append(storeStats, downSyncStoreStats...)
 	timeStats = append(timeStats, downSyncTimeStats...)
 
-	if err == nil {
+	if err == nil && !dryRun {
 		writeGetConfigStartTime := time.Now()
 
 		v := viper.New()
@@ -143,7 +154,7 @@ func put(
 		}
 		os.Remove(tmpFilePath)
 
-		err = longtailutils.WriteToURI(targetPath, bytes, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+		err = longtailutils.WriteToURI(ctx, targetPath, bytes, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
 		if err != nil {
 			return storeStats, timeStats, errors.Wrapf(err, fname)
 		}
@@ -174,10 +185,14 @@ type PutCmd struct {
 	SourcePathExcludeRegExOption
 	MinBlockUsagePercentOption
 	EnableFileMappingOption
+	CompactStoreIndexThresholdOption
+	CheckStoreLeaseOption
+	UpsyncDryRunOption
 }
 
 func (r *PutCmd) Run(ctx *Context) error {
 	storeStats, timeStats, err := put(
+		ctx.Ctx,
 		ctx.NumWorkerCount,
 		ctx.NumRemoteWorkerCount,
 		r.OptionalStorageURI,
@@ -196,7 +211,10 @@ func (r *PutCmd) Run(ctx *Context) error {
 		r.VersionLocalStoreIndexPath,
 		r.GetConfigURI,
 		r.DisableVersionLocalStoreIndex,
-		r.EnableFileMapping)
+		r.EnableFileMapping,
+		r.CompactStoreIndexThreshold,
+		r.CheckStoreLease,
+		r.DryRun)
 	ctx.StoreStats = append(ctx.StoreStats, storeStats...)
 	ctx.TimeStats = append(ctx.TimeStats, timeStats...)
 	return err
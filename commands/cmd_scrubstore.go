@@ -0,0 +1,424 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/DanEngelbrecht/golongtail/longtaillib"
+	"github.com/DanEngelbrecht/golongtail/longtailstorelib"
+	"github.com/DanEngelbrecht/golongtail/longtailutils"
+	"github.com/DanEngelbrecht/golongtail/remotestore"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	scrubStatusOK      = "ok"
+	scrubStatusCorrupt = "corrupt"
+)
+
+type scrubBlock struct {
+	BlockHash string `json:"blockHash"`
+	Path      string `json:"path,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// scrubReport is written as json by scrub-store
+type scrubReport struct {
+	StorageURI    string       `json:"storageURI"`
+	HeadOnly      bool         `json:"headOnly"`
+	IndexedBlocks int          `json:"indexedBlocks"`
+	StoredBlocks  int          `json:"storedBlocks"`
+	CheckedBlocks int          `json:"checkedBlocks"`
+	ResumedBlocks int          `json:"resumedBlocks"`
+	Missing       []scrubBlock `json:"missing"`
+	Corrupt       []scrubBlock `json:"corrupt"`
+	Orphaned      []scrubBlock `json:"orphaned"`
+}
+
+// scrubResumeHeader is the first line in the resume file, it records the store the file belongs to
+type scrubResumeHeader struct {
+	StorageURI string `json:"storageURI"`
+}
+
+// scrubResumeEntry is one line in the resume file, it records the outcome of a downloaded block
+type scrubResumeEntry struct {
+	BlockHash string `json:"blockHash"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+type scrubResult struct {
+	blockHash uint64
+	path      string
+	err       error
+}
+
+var scrubBlockNameRegEx = regexp.MustCompile(`0x([0-9a-fA-F]{16})\.lsb$`)
+
+func formatBlockHash(blockHash uint64) string {
+	return fmt.Sprintf("0x%016x", blockHash)
+}
+
+// readScrubResumeFile returns the entries recorded by earlier runs of storageURI, a missing or empty file is not
+// an error but a file recorded for another store is. A trailing partial line left by an interrupted run is ignored
+func readScrubResumeFile(path string, storageURI string) (map[uint64]scrubResumeEntry, error) {
+	const fname = "readScrubResumeFile"
+	entries := map[uint64]scrubResumeEntry{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) || (err == nil && len(data) == 0) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	header := scrubResumeHeader{}
+	if !scanner.Scan() || json.Unmarshal(scanner.Bytes(), &header) != nil || header.StorageURI != storageURI {
+		err = fmt.Errorf("resume file `%s` was not recorded for `%s`, remove it or use another resume path", path, storageURI)
+		return nil, errors.Wrap(err, fname)
+	}
+	for scanner.Scan() {
+		var entry scrubResumeEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		blockHash, err := strconv.ParseUint(entry.BlockHash, 0, 64)
+		if err != nil {
+			continue
+		}
+		entries[blockHash] = entry
+	}
+	return entries, nil
+}
+
+// scrubBlockHeader reads the block index at the start of a stored block and checks it and the listed size of
+// the block against the store index
+func scrubBlockHeader(
+	ctx context.Context,
+	client longtailstorelib.BlobClient,
+	verifier *remotestore.BlockVerifier,
+	blockHash uint64,
+	object longtailstorelib.BlobProperties,
+	listedSizes bool) error {
+	const fname = "scrubBlockHeader"
+	blockIndexSize, _ := verifier.BlockIndexDataSize(blockHash)
+	blobObject, err := client.NewObject(object.Name)
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	var data []byte
+	_, err = longtailstorelib.RetryBlobOperation(ctx, client, nil, func() error {
+		var err error
+		data, err = blobObject.ReadRange(ctx, 0, blockIndexSize)
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	storedSize := object.Size
+	if !listedSizes {
+		storedSize = -1
+	}
+	err = verifier.VerifyStoredBlockHeader(blockHash, data, storedSize)
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	return nil
+}
+
+func scrubStore(
+	ctx context.Context,
+	numWorkerCount int,
+	blobStoreURI string,
+	s3EndpointResolverURI string,
+	reportPath string,
+	resumePath string,
+	headOnly bool) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error) {
+	const fname = "scrubStore"
+	log := logrus.WithFields(logrus.Fields{
+		"fname":                 fname,
+		"numWorkerCount":        numWorkerCount,
+		"blobStoreURI":          blobStoreURI,
+		"s3EndpointResolverURI": s3EndpointResolverURI,
+		"reportPath":            reportPath,
+		"resumePath":            resumePath,
+		"headOnly":              headOnly,
+	})
+	log.Info(fname)
+	storeStats := []longtailutils.StoreStat{}
+	timeStats := []longtailutils.TimeStat{}
+
+	blobStoreOptions := append(longtailstorelib.GetBlobStoreOptions(ctx), longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	blobStore, err := longtailstorelib.CreateBlobStoreForURI(blobStoreURI, blobStoreOptions...)
+	if err != nil {
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+	// Encrypted stores list the size of the encrypted blocks so the listed size can't be checked
+	listedSizes := longtailstorelib.GetEncryptionOptions(blobStoreOptions...).KeyRing == nil
+
+	readStoreIndexStartTime := time.Now()
+	storeIndex, err := remotestore.ReadStoreIndex(ctx, blobStoreURI, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	if err != nil {
+		err = errors.Wrapf(err, "Failed reading store index of `%s`", blobStoreURI)
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+	defer storeIndex.Dispose()
+	indexedBlockHashes := append([]uint64{}, storeIndex.GetBlockHashes()...)
+	verifier := remotestore.NewBlockVerifier(storeIndex)
+	timeStats = append(timeStats, longtailutils.TimeStat{"Read store index", time.Since(readStoreIndexStartTime)})
+
+	client, err := blobStore.NewClient(ctx)
+	if err != nil {
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+	defer client.Close()
+
+	// Stream the listing so we only keep the block names around, not every object in the store
+	getBlockObjectsStartTime := time.Now()
+	storedBlocks := map[uint64]longtailstorelib.BlobProperties{}
+	err = client.WalkObjects("chunks/", func(object longtailstorelib.BlobProperties) error {
+		m := scrubBlockNameRegEx.FindStringSubmatch(object.Name)
+		if len(m) < 2 {
+			return nil
+		}
+		blockHash, err := strconv.ParseUint(m[1], 16, 64)
+		if err != nil {
+			return nil
+		}
+		storedBlocks[blockHash] = object
+		return nil
+	})
+	if err != nil {
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+	timeStats = append(timeStats, longtailutils.TimeStat{"Get block objects", time.Since(getBlockObjectsStartTime)})
+
+	report := scrubReport{
+		StorageURI:    blobStoreURI,
+		HeadOnly:      headOnly,
+		IndexedBlocks: len(indexedBlockHashes),
+		StoredBlocks:  len(storedBlocks),
+		Missing:       []scrubBlock{},
+		Corrupt:       []scrubBlock{},
+		Orphaned:      []scrubBlock{},
+	}
+
+	resumeEntries := map[uint64]scrubResumeEntry{}
+	if resumePath != "" && !headOnly {
+		resumeEntries, err = readScrubResumeFile(resumePath, blobStoreURI)
+		if err != nil {
+			return storeStats, timeStats, errors.Wrap(err, fname)
+		}
+	}
+
+	indexedBlocks := make(map[uint64]bool, len(indexedBlockHashes))
+	blocksToCheck := []uint64{}
+	for _, blockHash := range indexedBlockHashes {
+		indexedBlocks[blockHash] = true
+		object, exists := storedBlocks[blockHash]
+		if !exists {
+			report.Missing = append(report.Missing, scrubBlock{BlockHash: formatBlockHash(blockHash), Path: remotestore.GetBlockPath(blockHash)})
+			continue
+		}
+		if object.Size == 0 {
+			report.Corrupt = append(report.Corrupt, scrubBlock{BlockHash: formatBlockHash(blockHash), Path: object.Name, Error: "block is empty"})
+			continue
+		}
+		if entry, exists := resumeEntries[blockHash]; exists {
+			report.ResumedBlocks++
+			report.CheckedBlocks++
+			if entry.Status == scrubStatusCorrupt {
+				report.Corrupt = append(report.Corrupt, scrubBlock{BlockHash: entry.BlockHash, Path: object.Name, Error: entry.Error})
+			}
+			continue
+		}
+		blocksToCheck = append(blocksToCheck, blockHash)
+	}
+	for blockHash, object := range storedBlocks {
+		if !indexedBlocks[blockHash] {
+			report.Orphaned = append(report.Orphaned, scrubBlock{BlockHash: formatBlockHash(blockHash), Path: object.Name, Size: object.Size})
+		}
+	}
+
+	if len(blocksToCheck) > 0 {
+		verifyBlocksStartTime := time.Now()
+		var resumeFile *os.File
+		if resumePath != "" && !headOnly {
+			resumeFile, err = os.OpenFile(resumePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				return storeStats, timeStats, errors.Wrap(err, fname)
+			}
+			defer resumeFile.Close()
+			if len(resumeEntries) == 0 {
+				err = resumeFile.Truncate(0)
+				if err == nil {
+					line, _ := json.Marshal(scrubResumeHeader{StorageURI: blobStoreURI})
+					_, err = resumeFile.Write(append(line, '\n'))
+				}
+				if err != nil {
+					return storeStats, timeStats, errors.Wrap(err, fname)
+				}
+			}
+		}
+
+		workerCount := numWorkerCount
+		if workerCount == 0 {
+			workerCount = remotestore.DefaultWorkerCount(blobStoreURI)
+		}
+		if workerCount > len(blocksToCheck) {
+			workerCount = len(blocksToCheck)
+		}
+		if workerCount < 1 {
+			workerCount = 1
+		}
+		blockChannel := make(chan uint64)
+		resultChannel := make(chan scrubResult, workerCount)
+		workerCtx, cancelWorkers := context.WithCancel(ctx)
+		defer cancelWorkers()
+		wg := sync.WaitGroup{}
+		for i := 0; i < workerCount; i++ {
+			workerClient, err := blobStore.NewClient(ctx)
+			if err != nil {
+				close(blockChannel)
+				wg.Wait()
+				return storeStats, timeStats, errors.Wrap(err, fname)
+			}
+			wg.Add(1)
+			go func(client longtailstorelib.BlobClient) {
+				defer wg.Done()
+				defer client.Close()
+				for blockHash := range blockChannel {
+					object := storedBlocks[blockHash]
+					var err error
+					if headOnly {
+						err = scrubBlockHeader(workerCtx, client, verifier, blockHash, object, listedSizes)
+					} else {
+						var data []byte
+						data, _, err = longtailutils.ReadBlobWithRetry(workerCtx, client, nil, object.Name)
+						if err == nil {
+							err = verifier.VerifyStoredBlockData(blockHash, data)
+						}
+					}
+					resultChannel <- scrubResult{blockHash: blockHash, path: object.Name, err: err}
+				}
+			}(workerClient)
+		}
+		go func() {
+			defer close(blockChannel)
+			for _, blockHash := range blocksToCheck {
+				select {
+				case blockChannel <- blockHash:
+				case <-workerCtx.Done():
+					return
+				}
+			}
+		}()
+		go func() {
+			wg.Wait()
+			close(resultChannel)
+		}()
+
+		verifyBlocksProgress := longtailutils.CreateProgress("Verifying blocks          ", 1)
+		defer verifyBlocksProgress.Dispose()
+		completed := uint32(0)
+		totalCount := uint32(len(blocksToCheck))
+		for result := range resultChannel {
+			completed++
+			verifyBlocksProgress.OnProgress(totalCount, completed)
+			if workerCtx.Err() != nil {
+				continue
+			}
+			if longtaillib.IsNotExist(result.err) {
+				// Deleted after we listed it, it is not recorded in the resume file so the next run checks it again
+				report.Missing = append(report.Missing, scrubBlock{BlockHash: formatBlockHash(result.blockHash), Path: result.path})
+				continue
+			}
+			entry := scrubResumeEntry{BlockHash: formatBlockHash(result.blockHash), Status: scrubStatusOK}
+			if result.err != nil {
+				log.WithError(result.err).WithField("path", result.path).Warn("Corrupt block")
+				entry.Status = scrubStatusCorrupt
+				entry.Error = result.err.Error()
+				report.Corrupt = append(report.Corrupt, scrubBlock{BlockHash: entry.BlockHash, Path: result.path, Error: entry.Error})
+			}
+			report.CheckedBlocks++
+			if resumeFile != nil && err == nil {
+				line, _ := json.Marshal(entry)
+				_, err = resumeFile.Write(append(line, '\n'))
+				if err != nil {
+					cancelWorkers()
+				}
+			}
+		}
+		timeStats = append(timeStats, longtailutils.TimeStat{"Verify blocks", time.Since(verifyBlocksStartTime)})
+		if ctx.Err() != nil {
+			return storeStats, timeStats, errors.Wrap(ctx.Err(), fname)
+		}
+		if err != nil {
+			return storeStats, timeStats, errors.Wrap(err, fname)
+		}
+	}
+
+	for _, blocks := range [][]scrubBlock{report.Missing, report.Corrupt, report.Orphaned} {
+		sort.Slice(blocks, func(i, j int) bool { return blocks[i].BlockHash < blocks[j].BlockHash })
+	}
+
+	reportData, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+	if reportPath == "" {
+		fmt.Printf("%s\n", reportData)
+	} else {
+		err = longtailutils.WriteToURI(ctx, reportPath, reportData, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+		if err != nil {
+			return storeStats, timeStats, errors.Wrap(err, fname)
+		}
+	}
+
+	// The scrub is complete so the next run should start over
+	if resumePath != "" {
+		err = os.Remove(resumePath)
+		if err != nil && !os.IsNotExist(err) {
+			log.WithError(err).Warn("Failed removing resume file")
+		}
+	}
+
+	if len(report.Missing) > 0 || len(report.Corrupt) > 0 {
+		err = fmt.Errorf("store `%s` has %d missing and %d corrupt blocks", blobStoreURI, len(report.Missing), len(report.Corrupt))
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+	return storeStats, timeStats, nil
+}
+
+type ScrubStoreCmd struct {
+	StorageURIOption
+	S3EndpointResolverURLOption
+	ReportPath string `name:"report-path" help:"URI to write the json report of missing, corrupt and orphaned blocks to, the report is written to stdout if not set"`
+	ResumePath string `name:"resume-path" help:"Local file recording the blocks that have been verified so an interrupted scrub can continue where it left off, it is removed once the scrub completes"`
+	HeadOnly   bool   `name:"head-only" help:"Only read the block index at the start of each block and check it and the block size against the store index instead of downloading and verifying the whole block"`
+}
+
+func (r *ScrubStoreCmd) Run(ctx *Context) error {
+	storeStats, timeStats, err := scrubStore(
+		ctx.Ctx,
+		ctx.NumRemoteWorkerCount,
+		r.StorageURI,
+		r.S3EndpointResolverURL,
+		r.ReportPath,
+		r.ResumePath,
+		r.HeadOnly)
+	ctx.StoreStats = append(ctx.StoreStats, storeStats...)
+	ctx.TimeStats = append(ctx.TimeStats, timeStats...)
+	return err
+}
@@ -0,0 +1,149 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DanEngelbrecht/golongtail/longtailstorelib"
+	"github.com/alecthomas/assert/v2"
+)
+
+func readScrubReport(t *testing.T, path string) scrubReport {
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	var report scrubReport
+	assert.NoError(t, json.Unmarshal(data, &report))
+	return report
+}
+
+func TestScrubStore(t *testing.T) {
+	testPath, _ := os.MkdirTemp("", "test")
+	fsBlobPathPrefix := "fsblob://" + testPath
+	createVersionData(t, fsBlobPathPrefix)
+	for _, version := range []string{"v1", "v2", "v3"} {
+		cmd, err := executeCommandLine("upsync", "--source-path", testPath+"/version/"+version, "--target-path", fsBlobPathPrefix+"/index/"+version+".lvi", "--storage-uri", fsBlobPathPrefix+"/storage")
+		assert.NoError(t, err, cmd)
+	}
+	reportPath := filepath.Join(testPath, "report.json")
+
+	cmd, err := executeCommandLine("scrub-store", "--storage-uri", fsBlobPathPrefix+"/storage", "--report-path", reportPath)
+	assert.NoError(t, err, cmd)
+	report := readScrubReport(t, reportPath)
+	assert.True(t, report.IndexedBlocks > 1)
+	assert.Equal(t, report.IndexedBlocks, report.StoredBlocks)
+	assert.Equal(t, report.IndexedBlocks, report.CheckedBlocks)
+	assert.Equal(t, 0, len(report.Missing)+len(report.Corrupt)+len(report.Orphaned))
+
+	store, _ := longtailstorelib.CreateBlobStoreForURI(fsBlobPathPrefix + "/storage")
+	client, _ := store.NewClient(context.Background())
+	defer client.Close()
+	blocks, err := client.GetObjects("chunks/")
+	assert.NoError(t, err)
+	corruptObject, _ := client.NewObject(blocks[0].Name)
+	_, err = corruptObject.Write(context.Background(), []byte("corrupt"))
+	assert.NoError(t, err)
+	missingObject, _ := client.NewObject(blocks[1].Name)
+	assert.NoError(t, missingObject.Delete(context.Background()))
+	orphanObject, _ := client.NewObject("chunks/0000/0x0000000000000001.lsb")
+	_, err = orphanObject.Write(context.Background(), []byte("orphan"))
+	assert.NoError(t, err)
+	corruptBlockHash := scrubBlockNameRegEx.FindStringSubmatch(blocks[0].Name)[1]
+
+	cmd, err = executeCommandLine("scrub-store", "--storage-uri", fsBlobPathPrefix+"/storage", "--report-path", reportPath)
+	assert.Error(t, err, cmd)
+	report = readScrubReport(t, reportPath)
+	assert.Equal(t, 1, len(report.Missing))
+	assert.Equal(t, blocks[1].Name, report.Missing[0].Path)
+	assert.Equal(t, 1, len(report.Corrupt))
+	assert.Equal(t, "0x"+corruptBlockHash, report.Corrupt[0].BlockHash)
+	assert.Equal(t, 1, len(report.Orphaned))
+	assert.Equal(t, "0x0000000000000001", report.Orphaned[0].BlockHash)
+
+	// Reading the block index at the start of the blocks finds the corrupt block
+	cmd, err = executeCommandLine("scrub-store", "--storage-uri", fsBlobPathPrefix+"/storage", "--report-path", reportPath, "--head-only")
+	assert.Error(t, err, cmd)
+	report = readScrubReport(t, reportPath)
+	assert.Equal(t, 1, len(report.Missing))
+	assert.Equal(t, 1, len(report.Corrupt))
+	assert.Equal(t, "0x"+corruptBlockHash, report.Corrupt[0].BlockHash)
+	assert.Equal(t, 1, len(report.Orphaned))
+}
+
+func TestScrubStoreHeadOnlyTruncatedBlock(t *testing.T) {
+	testPath, _ := os.MkdirTemp("", "test")
+	fsBlobPathPrefix := "fsblob://" + testPath
+	createVersionData(t, fsBlobPathPrefix)
+	cmd, err := executeCommandLine("upsync", "--source-path", testPath+"/version/v1", "--target-path", fsBlobPathPrefix+"/index/v1.lvi", "--storage-uri", fsBlobPathPrefix+"/storage", "--compression-algorithm", "none")
+	assert.NoError(t, err, cmd)
+
+	store, _ := longtailstorelib.CreateBlobStoreForURI(fsBlobPathPrefix + "/storage")
+	client, _ := store.NewClient(context.Background())
+	defer client.Close()
+	blocks, err := client.GetObjects("chunks/")
+	assert.NoError(t, err)
+	truncatedObject, _ := client.NewObject(blocks[0].Name)
+	data, err := truncatedObject.Read(context.Background())
+	assert.NoError(t, err)
+	_, err = truncatedObject.Write(context.Background(), data[:len(data)-1])
+	assert.NoError(t, err)
+
+	// The block index is intact but the block is shorter than its chunks
+	reportPath := filepath.Join(testPath, "report.json")
+	cmd, err = executeCommandLine("scrub-store", "--storage-uri", fsBlobPathPrefix+"/storage", "--report-path", reportPath, "--head-only")
+	assert.Error(t, err, cmd)
+	report := readScrubReport(t, reportPath)
+	assert.Equal(t, report.IndexedBlocks, report.CheckedBlocks)
+	assert.Equal(t, 1, len(report.Corrupt))
+	assert.Equal(t, blocks[0].Name, report.Corrupt[0].Path)
+}
+
+func TestScrubStoreResume(t *testing.T) {
+	testPath, _ := os.MkdirTemp("", "test")
+	fsBlobPathPrefix := "fsblob://" + testPath
+	createVersionData(t, fsBlobPathPrefix)
+	cmd, err := executeCommandLine("upsync", "--source-path", testPath+"/version/v1", "--target-path", fsBlobPathPrefix+"/index/v1.lvi", "--storage-uri", fsBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+
+	store, _ := longtailstorelib.CreateBlobStoreForURI(fsBlobPathPrefix + "/storage")
+	client, _ := store.NewClient(context.Background())
+	defer client.Close()
+	blocks, err := client.GetObjects("chunks/")
+	assert.NoError(t, err)
+	corruptObject, _ := client.NewObject(blocks[0].Name)
+	_, err = corruptObject.Write(context.Background(), []byte("corrupt"))
+	assert.NoError(t, err)
+	corruptBlockHash := "0x" + scrubBlockNameRegEx.FindStringSubmatch(blocks[0].Name)[1]
+
+	// An earlier run that was interrupted verified the block before it was damaged
+	resumePath := filepath.Join(testPath, "scrub.resume")
+	err = os.WriteFile(resumePath, []byte(`{"storageURI":"`+fsBlobPathPrefix+`/storage"}`+"\n"+`{"blockHash":"`+corruptBlockHash+`","status":"ok"}`+"\n"+`{"blockHash":"0x`), 0644)
+	assert.NoError(t, err)
+
+	// A resume file recorded for another store is refused
+	otherResumePath := filepath.Join(testPath, "other.resume")
+	err = os.WriteFile(otherResumePath, []byte(`{"storageURI":"`+fsBlobPathPrefix+`/other"}`+"\n"+`{"blockHash":"`+corruptBlockHash+`","status":"ok"}`+"\n"), 0644)
+	assert.NoError(t, err)
+	cmd, err = executeCommandLine("scrub-store", "--storage-uri", fsBlobPathPrefix+"/storage", "--resume-path", otherResumePath)
+	assert.Error(t, err, cmd)
+
+	reportPath := filepath.Join(testPath, "report.json")
+	cmd, err = executeCommandLine("scrub-store", "--storage-uri", fsBlobPathPrefix+"/storage", "--report-path", reportPath, "--resume-path", resumePath)
+	assert.NoError(t, err, cmd)
+	report := readScrubReport(t, reportPath)
+	assert.Equal(t, 1, report.ResumedBlocks)
+	assert.Equal(t, report.IndexedBlocks, report.CheckedBlocks)
+	assert.Equal(t, 0, len(report.Corrupt))
+	_, err = os.Stat(resumePath)
+	assert.True(t, os.IsNotExist(err))
+
+	// Without the resume file the next run starts over and finds the damaged block
+	cmd, err = executeCommandLine("scrub-store", "--storage-uri", fsBlobPathPrefix+"/storage", "--report-path", reportPath, "--resume-path", resumePath)
+	assert.Error(t, err, cmd)
+	report = readScrubReport(t, reportPath)
+	assert.Equal(t, 0, report.ResumedBlocks)
+	assert.Equal(t, 1, len(report.Corrupt))
+	assert.Equal(t, corruptBlockHash, report.Corrupt[0].BlockHash)
+}
@@ -0,0 +1,202 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/DanEngelbrecht/golongtail/longtailutils"
+	"github.com/DanEngelbrecht/golongtail/remotestore"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultStoreLeaseTTL is used when an upload takes the store lease to compact the store index
+const defaultStoreLeaseTTL = 2 * time.Minute
+
+// storeLeaseURI returns the store to take the lease of for commands that work on a store index path, the
+// store index of a store is in its root so the folder of storeIndexPath is used unless storageURI is set
+func storeLeaseURI(storageURI string, storeIndexPath string) string {
+	if storageURI != "" {
+		return storageURI
+	}
+	i := strings.LastIndexAny(storeIndexPath, "/\\")
+	if i == -1 {
+		return "."
+	}
+	return storeIndexPath[:i]
+}
+
+// runWithStoreLease runs operation while holding the store lease of blobStoreURI, operation is passed
+// a context that is cancelled if the lease is lost. Stores that can't lock objects have no lease and
+// operation runs without it
+func runWithStoreLease(
+	ctx context.Context,
+	blobStoreURI string,
+	s3EndpointResolverURI string,
+	operation string,
+	ttl time.Duration,
+	run func(ctx context.Context) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error)) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error) {
+	const fname = "runWithStoreLease"
+	log := logrus.WithFields(logrus.Fields{
+		"fname":        fname,
+		"blobStoreURI": blobStoreURI,
+		"operation":    operation,
+		"ttl":          ttl,
+	})
+
+	acquireStartTime := time.Now()
+	lease, err := remotestore.AcquireStoreLease(ctx, blobStoreURI, operation, ttl, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	if errors.Is(err, remotestore.ErrStoreLeaseNotSupported) {
+		log.Warnf("Store `%s` does not support locking, running `%s` without the store lease", blobStoreURI, operation)
+		return run(ctx)
+	}
+	if err != nil {
+		return []longtailutils.StoreStat{}, []longtailutils.TimeStat{}, errors.Wrap(err, fname)
+	}
+	acquireTime := time.Since(acquireStartTime)
+
+	storeStats, timeStats, err := run(lease.Context())
+	timeStats = append([]longtailutils.TimeStat{{"Acquire store lease", acquireTime}}, timeStats...)
+
+	releaseErr := lease.Release(ctx)
+	if err != nil {
+		if releaseErr != nil {
+			log.WithError(releaseErr).Warn("Failed releasing store lease")
+		}
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+	if releaseErr != nil {
+		releaseErr = errors.Wrapf(releaseErr, "Store lease of `%s` was not held until `%s` completed", blobStoreURI, operation)
+		return storeStats, timeStats, errors.Wrap(releaseErr, fname)
+	}
+	return storeStats, timeStats, nil
+}
+
+// ensureStoreLeaseNotHeld fails if a maintenance operation holds an unexpired store lease on blobStoreURI
+func ensureStoreLeaseNotHeld(
+	ctx context.Context,
+	blobStoreURI string,
+	s3EndpointResolverURI string) error {
+	const fname = "ensureStoreLeaseNotHeld"
+	record, exists, err := remotestore.ReadStoreLease(ctx, blobStoreURI, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	if exists && !record.IsExpired(time.Now()) {
+		err = errors.Wrapf(remotestore.ErrStoreLeaseHeld, "Store `%s` is locked, %s", blobStoreURI, record)
+		return errors.Wrap(err, fname)
+	}
+	return nil
+}
+
+type storeLockReport struct {
+	Held    bool                          `json:"held"`
+	Expired bool                          `json:"expired"`
+	Lease   *remotestore.StoreLeaseRecord `json:"lease,omitempty"`
+}
+
+func storeLockStatus(
+	ctx context.Context,
+	blobStoreURI string,
+	s3EndpointResolverURI string) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error) {
+	const fname = "storeLockStatus"
+	log := logrus.WithFields(logrus.Fields{
+		"fname":                 fname,
+		"blobStoreURI":          blobStoreURI,
+		"s3EndpointResolverURI": s3EndpointResolverURI,
+	})
+	log.Info(fname)
+
+	storeStats := []longtailutils.StoreStat{}
+	timeStats := []longtailutils.TimeStat{}
+
+	readStartTime := time.Now()
+	record, exists, err := remotestore.ReadStoreLease(ctx, blobStoreURI, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	if err != nil {
+		err = errors.Wrapf(err, "Failed reading store lease of `%s`", blobStoreURI)
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+	timeStats = append(timeStats, longtailutils.TimeStat{"Read store lease", time.Since(readStartTime)})
+
+	status := storeLockReport{}
+	if exists {
+		status.Expired = record.IsExpired(time.Now())
+		status.Held = !status.Expired
+		status.Lease = &record
+	}
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+	fmt.Printf("%s\n", data)
+	return storeStats, timeStats, nil
+}
+
+func storeLockBreak(
+	ctx context.Context,
+	blobStoreURI string,
+	s3EndpointResolverURI string) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error) {
+	const fname = "storeLockBreak"
+	log := logrus.WithFields(logrus.Fields{
+		"fname":                 fname,
+		"blobStoreURI":          blobStoreURI,
+		"s3EndpointResolverURI": s3EndpointResolverURI,
+	})
+	log.Info(fname)
+
+	storeStats := []longtailutils.StoreStat{}
+	timeStats := []longtailutils.TimeStat{}
+
+	breakStartTime := time.Now()
+	record, exists, err := remotestore.BreakStoreLease(ctx, blobStoreURI, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	if err != nil {
+		err = errors.Wrapf(err, "Failed breaking store lease of `%s`", blobStoreURI)
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+	timeStats = append(timeStats, longtailutils.TimeStat{"Break store lease", time.Since(breakStartTime)})
+
+	if !exists {
+		log.Info("Store lease is not held")
+		return storeStats, timeStats, nil
+	}
+	log.Warnf("Broke store lease %s", record)
+	return storeStats, timeStats, nil
+}
+
+type StoreLockStatusCmd struct {
+	StorageURIOption
+	S3EndpointResolverURLOption
+}
+
+func (r *StoreLockStatusCmd) Run(ctx *Context) error {
+	storeStats, timeStats, err := storeLockStatus(
+		ctx.Ctx,
+		r.StorageURI,
+		r.S3EndpointResolverURL)
+	ctx.StoreStats = append(ctx.StoreStats, storeStats...)
+	ctx.TimeStats = append(ctx.TimeStats, timeStats...)
+	return err
+}
+
+type StoreLockBreakCmd struct {
+	StorageURIOption
+	S3EndpointResolverURLOption
+}
+
+func (r *StoreLockBreakCmd) Run(ctx *Context) error {
+	storeStats, timeStats, err := storeLockBreak(
+		ctx.Ctx,
+		r.StorageURI,
+		r.S3EndpointResolverURL)
+	ctx.StoreStats = append(ctx.StoreStats, storeStats...)
+	ctx.TimeStats = append(ctx.TimeStats, timeStats...)
+	return err
+}
+
+type StoreLockCmd struct {
+	Status StoreLockStatusCmd `cmd:"" name:"status" help:"Show the holder, operation and expiry of the store lease"`
+	Break  StoreLockBreakCmd  `cmd:"" name:"break" help:"Remove the store lease, even if it is held. CAUTION! Only break the lease of an operation that is known to have stopped"`
+}
@@ -0,0 +1,62 @@
+package commands
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DanEngelbrecht/golongtail/remotestore"
+	"github.com/alecthomas/assert/v2"
+	"github.com/pkg/errors"
+)
+
+func TestStoreLock(t *testing.T) {
+	testPath, _ := os.MkdirTemp("", "test")
+	fsBlobPathPrefix := "fsblob://" + testPath
+	createVersionData(t, fsBlobPathPrefix)
+	cmd, err := executeCommandLine("upsync", "--source-path", testPath+"/version/v1", "--target-path", fsBlobPathPrefix+"/index/v1.lvi", "--storage-uri", fsBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+
+	cmd, err = executeCommandLine("store-lock", "status", "--storage-uri", fsBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+
+	lease, err := remotestore.AcquireStoreLease(context.Background(), fsBlobPathPrefix+"/storage", "prune-store", time.Minute)
+	assert.NoError(t, err)
+
+	cmd, err = executeCommandLine("store-lock", "status", "--storage-uri", fsBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+	cmd, err = executeCommandLine("compact-store-index", "--storage-uri", fsBlobPathPrefix+"/storage")
+	assert.Error(t, err, cmd)
+	cmd, err = executeCommandLine("init-remote-store", "--storage-uri", fsBlobPathPrefix+"/storage")
+	assert.Error(t, err, cmd)
+	// The prune commands that take a store index path hold the lease of the store the index is in
+	assert.NoError(t, os.WriteFile(testPath+"/files.txt", []byte(fsBlobPathPrefix+"/index/v1.lvi\n"), 0644))
+	cmd, err = executeCommandLine("prune-store-index", "--source-paths", testPath+"/files.txt", "--store-index-path", fsBlobPathPrefix+"/storage/store.lsi")
+	assert.Error(t, err, cmd)
+	cmd, err = executeCommandLine("prune-store-blocks", "--store-index-path", fsBlobPathPrefix+"/storage/store.lsi", "--blocks-root-path", fsBlobPathPrefix+"/storage/chunks")
+	assert.Error(t, err, cmd)
+	cmd, err = executeCommandLine("upsync", "--source-path", testPath+"/version/v2", "--target-path", fsBlobPathPrefix+"/index/v2.lvi", "--storage-uri", fsBlobPathPrefix+"/storage", "--check-store-lease")
+	assert.Error(t, err, cmd)
+	// Uploads that don't check the lease are not blocked, they skip compacting the store index
+	cmd, err = executeCommandLine("upsync", "--source-path", testPath+"/version/v2", "--target-path", fsBlobPathPrefix+"/index/v2.lvi", "--storage-uri", fsBlobPathPrefix+"/storage", "--compact-store-index-threshold", "1")
+	assert.NoError(t, err, cmd)
+
+	cmd, err = executeCommandLine("store-lock", "break", "--storage-uri", fsBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+	assert.True(t, errors.Is(lease.Release(context.Background()), remotestore.ErrStoreLeaseLost))
+
+	cmd, err = executeCommandLine("compact-store-index", "--storage-uri", fsBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+	cmd, err = executeCommandLine("upsync", "--source-path", testPath+"/version/v3", "--target-path", fsBlobPathPrefix+"/index/v3.lvi", "--storage-uri", fsBlobPathPrefix+"/storage", "--check-store-lease")
+	assert.NoError(t, err, cmd)
+	_, exists, err := remotestore.ReadStoreLease(context.Background(), fsBlobPathPrefix+"/storage")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestStoreLeaseURI(t *testing.T) {
+	assert.Equal(t, "s3://bucket/store", storeLeaseURI("", "s3://bucket/store/store.lsi"))
+	assert.Equal(t, "C:\\stores\\store", storeLeaseURI("", "C:\\stores\\store\\store.lsi"))
+	assert.Equal(t, "gs://bucket/store", storeLeaseURI("gs://bucket/store", "/tmp/store.lsi"))
+}
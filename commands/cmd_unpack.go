@@ -25,7 +25,8 @@ func unpack(
 	scanTarget bool,
 	cacheTargetIndex bool,
 	enableFileMapping bool,
-	useLegacyWrite bool) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error) {
+	useLegacyWrite bool,
+	dryRun bool) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error) {
 	const fname = "unpack"
 	log := logrus.WithContext(context.Background()).WithFields(logrus.Fields{
 		"fname":              fname,
@@ -41,6 +42,7 @@ func unpack(
 		"cacheTargetIndex":   cacheTargetIndex,
 		"enableFileMapping":  enableFileMapping,
 		"useLegacyWrite":     useLegacyWrite,
+		"dryRun":             dryRun,
 	})
 	log.Info(fname)
 
@@ -192,6 +194,25 @@ func unpack(
 	getExistingContentTime := time.Since(getExistingContentStartTime)
 	timeStats = append(timeStats, longtailutils.TimeStat{"Get content index", getExistingContentTime})
 
+	if dryRun {
+		dryRunStartTime := time.Now()
+		blockSizes := map[uint64]uint64{}
+		archiveBlockSizes := archiveIndex.GetBlockSizes()
+		archiveStoreIndex := archiveIndex.GetStoreIndex()
+		for i, blockHash := range archiveStoreIndex.GetBlockHashes() {
+			blockSizes[blockHash] = uint64(archiveBlockSizes[i])
+		}
+		dryRunReport, err := createChangeVersionDryRun(targetVersionIndex, sourceVersionIndex, versionDiff, retargettedVersionStoreIndex, blockSizes, "")
+		if err != nil {
+			return storeStats, timeStats, errors.Wrap(err, fname)
+		}
+		dryRunReport.SourceVersionIndexPath = resolvedTargetFolderPath
+		dryRunReport.TargetVersionIndexPath = sourceFilePath
+		timeStats = append(timeStats, longtailutils.TimeStat{"Dry run", time.Since(dryRunStartTime)})
+		printChangeVersionDryRun(dryRunReport)
+		return storeStats, timeStats, nil
+	}
+
 	if cacheTargetIndex && longtaillib.FileExists(fs, cacheTargetIndexPath) {
 		err = longtaillib.DeleteFile(fs, cacheTargetIndexPath)
 		if err != nil {
@@ -386,6 +407,7 @@ type UnpackCmd struct {
 	CacheTargetIndexOption
 	EnableFileMappingOption
 	UseLegacyWriteOption
+	DownsyncDryRunOption
 }
 
 func (r *UnpackCmd) Run(ctx *Context) error {
@@ -401,7 +423,8 @@ func (r *UnpackCmd) Run(ctx *Context) error {
 		r.ScanTarget,
 		r.CacheTargetIndex,
 		r.EnableFileMapping,
-		r.UseLegacyWrite)
+		r.UseLegacyWrite,
+		r.DryRun)
 	ctx.StoreStats = append(ctx.StoreStats, storeStats...)
 	ctx.TimeStats = append(ctx.TimeStats, timeStats...)
 	return err
@@ -44,3 +44,17 @@ func TestUnpackWithValidate(t *testing.T) {
 	assert.NoError(t, err, cmd)
 	validateContent(t, testPath, "version/current", v3FilesCreate)
 }
+
+func TestUnpackDryRun(t *testing.T) {
+	testPath, _ := os.MkdirTemp("", "test")
+	createVersionData(t, testPath)
+	executeCommandLine("pack", "--source-path", testPath+"/version/v1", "--target-path", testPath+"/index/v1.la")
+	executeCommandLine("pack", "--source-path", testPath+"/version/v3", "--target-path", testPath+"/index/v3.la")
+
+	cmd, err := executeCommandLine("unpack", "--source-path", testPath+"/index/v1.la", "--target-path", testPath+"/version/current")
+	assert.NoError(t, err, cmd)
+	validateContent(t, testPath, "version/current", v1FilesCreate)
+	cmd, err = executeCommandLine("unpack", "--source-path", testPath+"/index/v3.la", "--target-path", testPath+"/version/current", "--dry-run")
+	assert.NoError(t, err, cmd)
+	validateContent(t, testPath, "version/current", v1FilesCreate)
+}
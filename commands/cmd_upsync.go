@@ -13,6 +13,7 @@ import (
 )
 
 func upsync(
+	ctx context.Context,
 	numWorkerCount int,
 	remoteStoreWorkerCount int,
 	blobStoreURI string,
@@ -29,7 +30,10 @@ func upsync(
 	excludeFilterRegEx string,
 	minBlockUsagePercent uint32,
 	versionLocalStoreIndexPath string,
-	enableFileMapping bool) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error) {
+	enableFileMapping bool,
+	compactStoreIndexThreshold int,
+	checkStoreLease bool,
+	dryRun bool) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error) {
 	const fname = "upsync"
 	log := logrus.WithContext(context.Background()).WithFields(logrus.Fields{
 		"fname":                      fname,
@@ -50,6 +54,9 @@ func upsync(
 		"minBlockUsagePercent":       minBlockUsagePercent,
 		"versionLocalStoreIndexPath": versionLocalStoreIndexPath,
 		"enableFileMapping":          enableFileMapping,
+		"compactStoreIndexThreshold": compactStoreIndexThreshold,
+		"checkStoreLease":            checkStoreLease,
+		"dryRun":                     dryRun,
 	})
 	log.Info(fname)
 
@@ -57,6 +64,13 @@ func upsync(
 	timeStats := []longtailutils.TimeStat{}
 
 	setupStartTime := time.Now()
+	if checkStoreLease {
+		err := ensureStoreLeaseNotHeld(ctx, blobStoreURI, s3EndpointResolverURI)
+		if err != nil {
+			return storeStats, timeStats, errors.Wrap(err, fname)
+		}
+	}
+
 	pathFilter, err := longtailutils.MakeRegexPathFilter(includeFilterRegEx, excludeFilterRegEx)
 	if err != nil {
 		return storeStats, timeStats, errors.Wrapf(err, fname)
@@ -100,7 +114,11 @@ func upsync(
 		enableFileMapping,
 		&sourceFolderScanner)
 
-	remoteStore, err := remotestore.CreateBlockStoreForURI(blobStoreURI, nil, jobs, remoteStoreWorkerCount, targetBlockSize, maxChunksPerBlock, remotestore.ReadWrite, enableFileMapping, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	accessType := remotestore.ReadWrite
+	if dryRun {
+		accessType = remotestore.ReadOnly
+	}
+	remoteStore, err := remotestore.CreateBlockStoreForURI(ctx, blobStoreURI, nil, jobs, remoteStoreWorkerCount, targetBlockSize, maxChunksPerBlock, accessType, enableFileMapping, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
 	if err != nil {
 		return storeStats, timeStats, errors.Wrapf(err, fname)
 	}
@@ -141,6 +159,11 @@ func upsync(
 	getMissingContentTime := time.Since(getMissingContentStartTime)
 	timeStats = append(timeStats, longtailutils.TimeStat{"Get content index", getMissingContentTime})
 
+	if dryRun {
+		printUpsyncDryRun(sourceFolderPath, blobStoreURI, createUpsyncDryRun(vindex, existingRemoteStoreIndex, versionMissingStoreIndex))
+		return storeStats, timeStats, nil
+	}
+
 	writeContentStartTime := time.Now()
 	if versionMissingStoreIndex.GetBlockCount() > 0 {
 		writeContentProgress := longtailutils.CreateProgress("Writing content blocks    ", 1)
@@ -176,6 +199,22 @@ func upsync(
 	flushTime := time.Since(flushStartTime)
 	timeStats = append(timeStats, longtailutils.TimeStat{"Flush", flushTime})
 
+	if compactStoreIndexThreshold > 0 {
+		compactStartTime := time.Now()
+		// The upload is complete at this point so failing to compact is not an error
+		_, _, err := runWithStoreLease(ctx, blobStoreURI, s3EndpointResolverURI, "compact-store-index", defaultStoreLeaseTTL, func(leaseCtx context.Context) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error) {
+			_, err := remotestore.CompactStoreIndex(leaseCtx, blobStoreURI, compactStoreIndexThreshold, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+			return nil, nil, err
+		})
+		if errors.Is(err, remotestore.ErrStoreLeaseHeld) {
+			log.Infof("Skipped compacting store index of `%s`, another maintenance operation holds the store lease", blobStoreURI)
+		} else if err != nil {
+			log.WithError(err).Warnf("Failed compacting store index of `%s`", blobStoreURI)
+		}
+		compactTime := time.Since(compactStartTime)
+		timeStats = append(timeStats, longtailutils.TimeStat{"Compact store index", compactTime})
+	}
+
 	indexStoreStats, err := indexStore.GetStats()
 	if err == nil {
 		storeStats = append(storeStats, longtailutils.StoreStat{"Compress", indexStoreStats})
@@ -193,7 +232,7 @@ func upsync(
 	}
 	defer vbuffer.Dispose()
 
-	err = longtailutils.WriteToURI(targetFilePath, vbuffer.ToBuffer(), longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	err = longtailutils.WriteToURI(ctx, targetFilePath, vbuffer.ToBuffer(), longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
 	if err != nil {
 		return storeStats, timeStats, errors.Wrapf(err, fname)
 	}
@@ -214,7 +253,7 @@ func upsync(
 			err = errors.Wrapf(err, "Failed serializing store index for `%s`", versionLocalStoreIndexPath)
 			return storeStats, timeStats, errors.Wrapf(err, fname)
 		}
-		err = longtailutils.WriteToURI(versionLocalStoreIndexPath, versionLocalStoreIndexBuffer.ToBuffer(), longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+		err = longtailutils.WriteToURI(ctx, versionLocalStoreIndexPath, versionLocalStoreIndexBuffer.ToBuffer(), longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
 		if err != nil {
 			return storeStats, timeStats, errors.Wrapf(err, fname)
 		}
@@ -241,10 +280,14 @@ type UpsyncCmd struct {
 	SourcePathIncludeRegExOption
 	SourcePathExcludeRegExOption
 	EnableFileMappingOption
+	CompactStoreIndexThresholdOption
+	CheckStoreLeaseOption
+	UpsyncDryRunOption
 }
 
 func (r *UpsyncCmd) Run(ctx *Context) error {
 	storeStats, timeStats, err := upsync(
+		ctx.Ctx,
 		ctx.NumWorkerCount,
 		ctx.NumRemoteWorkerCount,
 		r.StorageURI,
@@ -261,7 +304,10 @@ func (r *UpsyncCmd) Run(ctx *Context) error {
 		r.ExcludeFilterRegEx,
 		r.MinBlockUsagePercent,
 		r.VersionLocalStoreIndexPath,
-		r.EnableFileMapping)
+		r.EnableFileMapping,
+		r.CompactStoreIndexThreshold,
+		r.CheckStoreLease,
+		r.DryRun)
 	ctx.StoreStats = append(ctx.StoreStats, storeStats...)
 	ctx.TimeStats = append(ctx.TimeStats, timeStats...)
 	return err
@@ -0,0 +1,74 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/DanEngelbrecht/golongtail/longtaillib"
+	"github.com/DanEngelbrecht/golongtail/longtailutils"
+)
+
+// upsyncDryRun is what uploading a version would add to the store, as reported by --dry-run
+// Sizes are uncompressed, the size of the written blocks depends on the compression
+type upsyncDryRun struct {
+	// Unique chunks of the version
+	ChunkCount int    `json:"chunkCount"`
+	ChunkSize  uint64 `json:"chunkSize"`
+	// Chunks found in blocks of the store that are used enough to be reused
+	ReusedChunkCount int    `json:"reusedChunkCount"`
+	ReusedChunkSize  uint64 `json:"reusedChunkSize"`
+	ReusedBlockCount int    `json:"reusedBlockCount"`
+	// Chunks that would be written to new blocks
+	NewChunkCount int    `json:"newChunkCount"`
+	NewChunkSize  uint64 `json:"newChunkSize"`
+	NewBlockCount int    `json:"newBlockCount"`
+}
+
+// createUpsyncDryRun reports what uploading versionIndex would add to a store, existingStoreIndex holds the
+// blocks that would be reused and missingStoreIndex the blocks that would be written
+func createUpsyncDryRun(
+	versionIndex longtaillib.Longtail_VersionIndex,
+	existingStoreIndex longtaillib.Longtail_StoreIndex,
+	missingStoreIndex longtaillib.Longtail_StoreIndex) upsyncDryRun {
+	dryRun := upsyncDryRun{
+		ReusedBlockCount: int(existingStoreIndex.GetBlockCount()),
+		NewBlockCount:    int(missingStoreIndex.GetBlockCount()),
+	}
+
+	existingChunks := map[uint64]bool{}
+	for _, chunkHash := range existingStoreIndex.GetChunkHashes() {
+		existingChunks[chunkHash] = true
+	}
+	versionChunks := map[uint64]bool{}
+	chunkSizes := versionIndex.GetChunkSizes()
+	for i, chunkHash := range versionIndex.GetChunkHashes() {
+		if versionChunks[chunkHash] {
+			continue
+		}
+		versionChunks[chunkHash] = true
+		chunkSize := uint64(chunkSizes[i])
+		dryRun.ChunkCount++
+		dryRun.ChunkSize += chunkSize
+		if existingChunks[chunkHash] {
+			dryRun.ReusedChunkCount++
+			dryRun.ReusedChunkSize += chunkSize
+		}
+	}
+
+	missingChunkSizes := missingStoreIndex.GetChunkSizes()
+	for i := range missingStoreIndex.GetChunkHashes() {
+		dryRun.NewChunkCount++
+		dryRun.NewChunkSize += uint64(missingChunkSizes[i])
+	}
+	return dryRun
+}
+
+func printUpsyncDryRun(sourceFolderPath string, blobStoreURI string, dryRun upsyncDryRun) {
+	fmt.Printf("Dry run, nothing was uploaded\n")
+	fmt.Printf("Source:              %s\n", sourceFolderPath)
+	fmt.Printf("Store:               %s\n", blobStoreURI)
+	fmt.Printf("Chunks:              %d   (%s)\n", dryRun.ChunkCount, longtailutils.ByteCountBinary(dryRun.ChunkSize))
+	fmt.Printf("Reused Chunks:       %d   (%s)\n", dryRun.ReusedChunkCount, longtailutils.ByteCountBinary(dryRun.ReusedChunkSize))
+	fmt.Printf("New Chunks:          %d   (%s)\n", dryRun.NewChunkCount, longtailutils.ByteCountBinary(dryRun.NewChunkSize))
+	fmt.Printf("Reused Blocks:       %d\n", dryRun.ReusedBlockCount)
+	fmt.Printf("New Blocks:          %d\n", dryRun.NewBlockCount)
+}
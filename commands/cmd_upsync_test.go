@@ -1,10 +1,15 @@
 package commands
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 
+	"github.com/DanEngelbrecht/golongtail/longtaillib"
+	"github.com/DanEngelbrecht/golongtail/longtailstorelib"
+	"github.com/DanEngelbrecht/golongtail/remotestore"
 	"github.com/alecthomas/assert/v2"
 )
 
@@ -84,3 +89,93 @@ func TestUpsyncWithBrokenLSI(t *testing.T) {
 //		t.Errorf("%s: %s", cmd, err)
 //	}
 //}
+
+func TestUpsyncInMemoryStore(t *testing.T) {
+	testPath, _ := os.MkdirTemp("", "test")
+	fsBlobPathPrefix := "fsblob://" + testPath
+	createVersionData(t, fsBlobPathPrefix)
+	memBlobPathPrefix := createMemStoreURI(t)
+
+	cmd, err := executeCommandLine("upsync", "--source-path", testPath+"/version/v1", "--target-path", memBlobPathPrefix+"/index/v1.lvi", "--storage-uri", memBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+
+	indexBlobs, err := longtailstorelib.GetMemBlobs(memBlobPathPrefix + "/index")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(indexBlobs))
+	assert.NotZero(t, len(indexBlobs["v1.lvi"]))
+
+	storageBlobs, err := longtailstorelib.GetMemBlobs(memBlobPathPrefix + "/storage")
+	assert.NoError(t, err)
+	_, exists := storageBlobs["store.lsi"]
+	assert.True(t, exists)
+	blockCount := 0
+	for name := range storageBlobs {
+		if strings.HasPrefix(name, "chunks/") {
+			blockCount++
+		}
+	}
+	assert.NotZero(t, blockCount)
+}
+
+func TestUpsyncDryRun(t *testing.T) {
+	testPath, _ := os.MkdirTemp("", "test")
+	fsBlobPathPrefix := "fsblob://" + testPath
+	createVersionData(t, fsBlobPathPrefix)
+
+	cmd, err := executeCommandLine("upsync", "--source-path", testPath+"/version/v1", "--target-path", fsBlobPathPrefix+"/index/v1.lvi", "--storage-uri", fsBlobPathPrefix+"/storage", "--dry-run")
+	assert.NoError(t, err, cmd)
+	_, err = os.Stat(testPath + "/storage")
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(testPath + "/index/v1.lvi")
+	assert.True(t, os.IsNotExist(err))
+
+	cmd, err = executeCommandLine("upsync", "--source-path", testPath+"/version/v1", "--target-path", fsBlobPathPrefix+"/index/v1.lvi", "--storage-uri", fsBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+	storeItems := getStoreIndexItems(t, fsBlobPathPrefix)
+
+	// A dry run that reuses blocks marked by a prune does not rescue them
+	markAllBlocksForPrune(t, fsBlobPathPrefix+"/storage")
+	cmd, err = executeCommandLine("upsync", "--source-path", testPath+"/version/v1", "--target-path", fsBlobPathPrefix+"/index/v1-copy.lvi", "--storage-uri", fsBlobPathPrefix+"/storage", "--dry-run")
+	assert.NoError(t, err, cmd)
+	cmd, err = executeCommandLine("put", "--source-path", testPath+"/version/v2", "--target-path", fsBlobPathPrefix+"/index/v2.json", "--storage-uri", fsBlobPathPrefix+"/storage", "--dry-run")
+	assert.NoError(t, err, cmd)
+	_, err = os.Stat(testPath + "/index/v2.json")
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(testPath + "/index/version-data")
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, storeItems, getStoreIndexItems(t, fsBlobPathPrefix))
+	assert.Equal(t, 0, len(getPruneRescueRecords(t, fsBlobPathPrefix+"/storage")))
+}
+
+func TestCreateUpsyncDryRun(t *testing.T) {
+	testPath, _ := os.MkdirTemp("", "test")
+	fsBlobPathPrefix := "fsblob://" + testPath
+	createVersionData(t, fsBlobPathPrefix)
+	cmd, err := executeCommandLine("upsync", "--source-path", testPath+"/version/v1", "--target-path", fsBlobPathPrefix+"/index/v1.lvi", "--storage-uri", fsBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+
+	versionIndex, err := readVersionIndex(context.Background(), fsBlobPathPrefix+"/index/v1.lvi")
+	assert.NoError(t, err)
+	defer versionIndex.Dispose()
+	storeIndex, err := remotestore.ReadStoreIndex(context.Background(), fsBlobPathPrefix+"/storage")
+	assert.NoError(t, err)
+	defer storeIndex.Dispose()
+	emptyStoreIndex, err := longtaillib.CreateStoreIndexFromBlocks([]longtaillib.Longtail_BlockIndex{})
+	assert.NoError(t, err)
+	defer emptyStoreIndex.Dispose()
+
+	// Everything is new in an empty store
+	dryRun := createUpsyncDryRun(versionIndex, emptyStoreIndex, storeIndex)
+	assert.True(t, dryRun.ChunkCount > 0)
+	assert.Equal(t, 0, dryRun.ReusedChunkCount)
+	assert.Equal(t, dryRun.ChunkCount, dryRun.NewChunkCount)
+	assert.Equal(t, dryRun.ChunkSize, dryRun.NewChunkSize)
+	assert.Equal(t, int(storeIndex.GetBlockCount()), dryRun.NewBlockCount)
+
+	// Uploading the same version again reuses everything
+	dryRun = createUpsyncDryRun(versionIndex, storeIndex, emptyStoreIndex)
+	assert.Equal(t, dryRun.ChunkCount, dryRun.ReusedChunkCount)
+	assert.Equal(t, dryRun.ChunkSize, dryRun.ReusedChunkSize)
+	assert.Equal(t, 0, dryRun.NewChunkCount)
+	assert.Equal(t, 0, dryRun.NewBlockCount)
+}
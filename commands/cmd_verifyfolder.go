@@ -0,0 +1,291 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/DanEngelbrecht/golongtail/longtaillib"
+	"github.com/DanEngelbrecht/golongtail/longtailstorelib"
+	"github.com/DanEngelbrecht/golongtail/longtailutils"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// The version index downsync caches in the target folder is not part of the version
+const cacheTargetIndexName = ".longtail.index.cache.lvi"
+
+type verifyFolderSizeMismatch struct {
+	Path         string `json:"path"`
+	ExpectedSize uint64 `json:"expectedSize"`
+	Size         uint64 `json:"size"`
+}
+
+type verifyFolderPermissionsMismatch struct {
+	Path                string `json:"path"`
+	ExpectedPermissions string `json:"expectedPermissions"`
+	Permissions         string `json:"permissions"`
+}
+
+// verifyFolderReport is the difference between a folder and a version index, as reported by verify-folder
+type verifyFolderReport struct {
+	VersionIndexPath string `json:"versionIndexPath"`
+	TargetPath       string `json:"targetPath"`
+	// In quick mode file content is not hashed and ContentMismatch is always empty
+	Quick               bool                              `json:"quick"`
+	AssetCount          int                               `json:"assetCount"`
+	Missing             []versionDiffAsset                `json:"missing"`
+	Extra               []versionDiffAsset                `json:"extra"`
+	SizeMismatch        []verifyFolderSizeMismatch        `json:"sizeMismatch"`
+	ContentMismatch     []versionDiffAsset                `json:"contentMismatch"`
+	PermissionsMismatch []verifyFolderPermissionsMismatch `json:"permissionsMismatch"`
+}
+
+func (report *verifyFolderReport) mismatchCount() int {
+	return len(report.Missing) + len(report.Extra) + len(report.SizeMismatch) + len(report.ContentMismatch) + len(report.PermissionsMismatch)
+}
+
+type verifyFolderAsset struct {
+	size        uint64
+	permissions uint16
+	hash        uint64
+}
+
+// createVerifyFolderReport compares the assets of versionIndex with the scanned folder assets
+// Content hashes are only compared if folderAssets has them, files with a size mismatch are not checked for content
+func createVerifyFolderReport(
+	versionIndex longtaillib.Longtail_VersionIndex,
+	folderAssets map[string]verifyFolderAsset,
+	quick bool,
+	checkPermissions bool) verifyFolderReport {
+	report := verifyFolderReport{
+		Quick:               quick,
+		AssetCount:          int(versionIndex.GetAssetCount()),
+		Missing:             []versionDiffAsset{},
+		Extra:               []versionDiffAsset{},
+		SizeMismatch:        []verifyFolderSizeMismatch{},
+		ContentMismatch:     []versionDiffAsset{},
+		PermissionsMismatch: []verifyFolderPermissionsMismatch{},
+	}
+
+	versionAssets := map[string]bool{}
+	assetHashes := versionIndex.GetAssetHashes()
+	for i := uint32(0); i < versionIndex.GetAssetCount(); i++ {
+		path := versionIndex.GetAssetPath(i)
+		size := versionIndex.GetAssetSize(i)
+		versionAssets[path] = true
+		folderAsset, exists := folderAssets[path]
+		if !exists {
+			report.Missing = append(report.Missing, versionDiffAsset{Path: path, Size: size})
+			continue
+		}
+		if folderAsset.size != size {
+			report.SizeMismatch = append(report.SizeMismatch, verifyFolderSizeMismatch{Path: path, ExpectedSize: size, Size: folderAsset.size})
+		} else if !quick && folderAsset.hash != assetHashes[i] {
+			report.ContentMismatch = append(report.ContentMismatch, versionDiffAsset{Path: path, Size: size})
+		}
+		permissions := versionIndex.GetAssetPermissions(i)
+		if checkPermissions && folderAsset.permissions != permissions {
+			report.PermissionsMismatch = append(report.PermissionsMismatch, verifyFolderPermissionsMismatch{
+				Path:                path,
+				ExpectedPermissions: fmt.Sprintf("%04o", permissions),
+				Permissions:         fmt.Sprintf("%04o", folderAsset.permissions)})
+		}
+	}
+	for path, folderAsset := range folderAssets {
+		if versionAssets[path] || path == cacheTargetIndexName {
+			continue
+		}
+		report.Extra = append(report.Extra, versionDiffAsset{Path: path, Size: folderAsset.size})
+	}
+
+	sort.Slice(report.Missing, func(i, j int) bool { return report.Missing[i].Path < report.Missing[j].Path })
+	sort.Slice(report.Extra, func(i, j int) bool { return report.Extra[i].Path < report.Extra[j].Path })
+	sort.Slice(report.SizeMismatch, func(i, j int) bool { return report.SizeMismatch[i].Path < report.SizeMismatch[j].Path })
+	sort.Slice(report.ContentMismatch, func(i, j int) bool { return report.ContentMismatch[i].Path < report.ContentMismatch[j].Path })
+	sort.Slice(report.PermissionsMismatch, func(i, j int) bool {
+		return report.PermissionsMismatch[i].Path < report.PermissionsMismatch[j].Path
+	})
+	return report
+}
+
+func printVerifyFolderReport(report verifyFolderReport) {
+	fmt.Printf("Version:             %s\n", report.VersionIndexPath)
+	fmt.Printf("Target Folder:       %s\n", report.TargetPath)
+	fmt.Printf("Assets:              %d\n", report.AssetCount)
+	fmt.Printf("Missing:             %d\n", len(report.Missing))
+	for _, asset := range report.Missing {
+		fmt.Printf("  - %s   (%s)\n", asset.Path, longtailutils.ByteCountBinary(asset.Size))
+	}
+	fmt.Printf("Extra:               %d\n", len(report.Extra))
+	for _, asset := range report.Extra {
+		fmt.Printf("  + %s   (%s)\n", asset.Path, longtailutils.ByteCountBinary(asset.Size))
+	}
+	fmt.Printf("Size Mismatch:       %d\n", len(report.SizeMismatch))
+	for _, asset := range report.SizeMismatch {
+		fmt.Printf("  ~ %s   (%s, expected %s)\n", asset.Path, longtailutils.ByteCountBinary(asset.Size), longtailutils.ByteCountBinary(asset.ExpectedSize))
+	}
+	if !report.Quick {
+		fmt.Printf("Content Mismatch:    %d\n", len(report.ContentMismatch))
+		for _, asset := range report.ContentMismatch {
+			fmt.Printf("  ! %s   (%s)\n", asset.Path, longtailutils.ByteCountBinary(asset.Size))
+		}
+	}
+	fmt.Printf("Permission Mismatch: %d\n", len(report.PermissionsMismatch))
+	for _, asset := range report.PermissionsMismatch {
+		fmt.Printf("  * %s   (%s, expected %s)\n", asset.Path, asset.Permissions, asset.ExpectedPermissions)
+	}
+}
+
+func verifyFolder(
+	ctx context.Context,
+	numWorkerCount int,
+	versionIndexPath string,
+	s3EndpointResolverURI string,
+	targetFolderPath string,
+	checkPermissions bool,
+	quick bool,
+	enableFileMapping bool,
+	format string) ([]longtailutils.StoreStat, []longtailutils.TimeStat, error) {
+	const fname = "verifyFolder"
+	log := logrus.WithFields(logrus.Fields{
+		"fname":                 fname,
+		"numWorkerCount":        numWorkerCount,
+		"versionIndexPath":      versionIndexPath,
+		"s3EndpointResolverURI": s3EndpointResolverURI,
+		"targetFolderPath":      targetFolderPath,
+		"checkPermissions":      checkPermissions,
+		"quick":                 quick,
+		"enableFileMapping":     enableFileMapping,
+		"format":                format,
+	})
+	log.Info(fname)
+
+	storeStats := []longtailutils.StoreStat{}
+	timeStats := []longtailutils.TimeStat{}
+
+	setupStartTime := time.Now()
+	jobs := longtaillib.CreateBikeshedJobAPI(uint32(numWorkerCount), 0)
+	defer jobs.Dispose()
+	fs := longtaillib.CreateFSStorageAPI()
+	defer fs.Dispose()
+	timeStats = append(timeStats, longtailutils.TimeStat{"Setup", time.Since(setupStartTime)})
+
+	readSourceStartTime := time.Now()
+	versionIndex, err := readVersionIndex(ctx, versionIndexPath, longtailutils.WithS3EndpointResolverURI(s3EndpointResolverURI))
+	if err != nil {
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+	defer versionIndex.Dispose()
+	timeStats = append(timeStats, longtailutils.TimeStat{"Read source index", time.Since(readSourceStartTime)})
+
+	scanStartTime := time.Now()
+	normalizedTargetFolderPath := longtailstorelib.NormalizeFileSystemPath(targetFolderPath)
+	fileInfos, err := longtaillib.GetFilesRecursively2(fs, jobs, longtaillib.Longtail_PathFilterAPI{}, normalizedTargetFolderPath)
+	if err != nil {
+		err = errors.Wrapf(err, "Failed to scan `%s`", targetFolderPath)
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+	defer fileInfos.Dispose()
+	folderAssets := map[string]verifyFolderAsset{}
+	fileSizes := fileInfos.GetFileSizes()
+	filePermissions := fileInfos.GetFilePermissions()
+	for i := uint32(0); i < fileInfos.GetFileCount(); i++ {
+		folderAssets[fileInfos.GetPath(i)] = verifyFolderAsset{size: fileSizes[i], permissions: filePermissions[i]}
+	}
+	timeStats = append(timeStats, longtailutils.TimeStat{"Scan folder", time.Since(scanStartTime)})
+
+	if !quick {
+		hashStartTime := time.Now()
+		hashRegistry := longtaillib.CreateFullHashRegistry()
+		defer hashRegistry.Dispose()
+		hash, err := hashRegistry.GetHashAPI(versionIndex.GetHashIdentifier())
+		if err != nil {
+			return storeStats, timeStats, errors.Wrap(err, fname)
+		}
+		chunker := longtaillib.CreateHPCDCChunkerAPI()
+		defer chunker.Dispose()
+
+		createVersionIndexProgress := longtailutils.CreateProgress("Verifying folder          ", 1)
+		defer createVersionIndexProgress.Dispose()
+		folderVersionIndex, err := longtaillib.CreateVersionIndex(
+			fs,
+			hash,
+			chunker,
+			jobs,
+			&createVersionIndexProgress,
+			normalizedTargetFolderPath,
+			fileInfos,
+			nil,
+			versionIndex.GetTargetChunkSize(),
+			enableFileMapping)
+		if err != nil {
+			err = errors.Wrapf(err, "Failed to create version index for `%s`", targetFolderPath)
+			return storeStats, timeStats, errors.Wrap(err, fname)
+		}
+		defer folderVersionIndex.Dispose()
+		folderAssetHashes := folderVersionIndex.GetAssetHashes()
+		for i, assetHash := range folderAssetHashes {
+			path := folderVersionIndex.GetAssetPath(uint32(i))
+			folderAsset := folderAssets[path]
+			folderAsset.hash = assetHash
+			folderAssets[path] = folderAsset
+		}
+		timeStats = append(timeStats, longtailutils.TimeStat{"Hash folder", time.Since(hashStartTime)})
+	}
+
+	report := createVerifyFolderReport(versionIndex, folderAssets, quick, checkPermissions)
+	report.VersionIndexPath = versionIndexPath
+	report.TargetPath = targetFolderPath
+
+	if format == "json" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return storeStats, timeStats, errors.Wrap(err, fname)
+		}
+		fmt.Printf("%s\n", data)
+	} else {
+		printVerifyFolderReport(report)
+	}
+
+	if report.mismatchCount() > 0 {
+		err = fmt.Errorf("folder `%s` does not match `%s`: %d missing, %d extra, %d size, %d content and %d permission mismatches",
+			targetFolderPath,
+			versionIndexPath,
+			len(report.Missing),
+			len(report.Extra),
+			len(report.SizeMismatch),
+			len(report.ContentMismatch),
+			len(report.PermissionsMismatch))
+		return storeStats, timeStats, errors.Wrap(err, fname)
+	}
+	return storeStats, timeStats, nil
+}
+
+type VerifyFolderCmd struct {
+	VersionIndexPathOption
+	S3EndpointResolverURLOption
+	TargetPath       string `name:"target-path" required:"" help:"Folder to verify against the version index"`
+	CheckPermissions bool   `name:"check-permissions" negatable:"" help:"Compare file and directory permissions with the version index" default:"true"`
+	Quick            bool   `name:"quick" help:"Only compare the file list and sizes, skip hashing the file content"`
+	EnableFileMappingOption
+	Format string `name:"format" help:"Output format [text json]" enum:"text,json" default:"text"`
+}
+
+func (r *VerifyFolderCmd) Run(ctx *Context) error {
+	storeStats, timeStats, err := verifyFolder(
+		ctx.Ctx,
+		ctx.NumWorkerCount,
+		r.VersionIndexPath,
+		r.S3EndpointResolverURL,
+		r.TargetPath,
+		r.CheckPermissions,
+		r.Quick,
+		r.EnableFileMapping,
+		r.Format)
+	ctx.StoreStats = append(ctx.StoreStats, storeStats...)
+	ctx.TimeStats = append(ctx.TimeStats, timeStats...)
+	return err
+}
@@ -0,0 +1,87 @@
+package commands
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestVerifyFolder(t *testing.T) {
+	testPath, _ := os.MkdirTemp("", "test")
+	fsBlobPathPrefix := "fsblob://" + testPath
+	createVersionData(t, fsBlobPathPrefix)
+	cmd, err := executeCommandLine("upsync", "--source-path", testPath+"/version/v1", "--target-path", fsBlobPathPrefix+"/index/v1.lvi", "--storage-uri", fsBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+
+	cmd, err = executeCommandLine("verify-folder", "--version-index-path", fsBlobPathPrefix+"/index/v1.lvi", "--target-path", testPath+"/version/v1")
+	assert.NoError(t, err, cmd)
+	cmd, err = executeCommandLine("verify-folder", "--version-index-path", fsBlobPathPrefix+"/index/v1.lvi", "--target-path", testPath+"/version/v2")
+	assert.Error(t, err, cmd)
+
+	// Same size, different content is only found when hashing
+	err = os.WriteFile(testPath+"/version/v1/abitoftext.txt", []byte("this is a TEST file"), 0644)
+	assert.NoError(t, err)
+	cmd, err = executeCommandLine("verify-folder", "--version-index-path", fsBlobPathPrefix+"/index/v1.lvi", "--target-path", testPath+"/version/v1", "--quick")
+	assert.NoError(t, err, cmd)
+	cmd, err = executeCommandLine("verify-folder", "--version-index-path", fsBlobPathPrefix+"/index/v1.lvi", "--target-path", testPath+"/version/v1", "--format", "json")
+	assert.Error(t, err, cmd)
+
+	err = os.WriteFile(testPath+"/version/v1/abitoftext.txt", []byte("this is a test file"), 0644)
+	assert.NoError(t, err)
+	err = os.WriteFile(testPath+"/version/v1/extra.txt", []byte("extra"), 0644)
+	assert.NoError(t, err)
+	cmd, err = executeCommandLine("verify-folder", "--version-index-path", fsBlobPathPrefix+"/index/v1.lvi", "--target-path", testPath+"/version/v1", "--quick")
+	assert.Error(t, err, cmd)
+}
+
+func TestCreateVerifyFolderReport(t *testing.T) {
+	testPath, _ := os.MkdirTemp("", "test")
+	fsBlobPathPrefix := "fsblob://" + testPath
+	createVersionData(t, fsBlobPathPrefix)
+	cmd, err := executeCommandLine("upsync", "--source-path", testPath+"/version/v1", "--target-path", fsBlobPathPrefix+"/index/v1.lvi", "--storage-uri", fsBlobPathPrefix+"/storage")
+	assert.NoError(t, err, cmd)
+	versionIndex, err := readVersionIndex(context.Background(), fsBlobPathPrefix+"/index/v1.lvi")
+	assert.NoError(t, err)
+	defer versionIndex.Dispose()
+
+	folderAssets := map[string]verifyFolderAsset{}
+	assetHashes := versionIndex.GetAssetHashes()
+	for i := uint32(0); i < versionIndex.GetAssetCount(); i++ {
+		folderAssets[versionIndex.GetAssetPath(i)] = verifyFolderAsset{
+			size:        versionIndex.GetAssetSize(i),
+			permissions: versionIndex.GetAssetPermissions(i),
+			hash:        assetHashes[i]}
+	}
+	report := createVerifyFolderReport(versionIndex, folderAssets, false, true)
+	assert.Equal(t, 0, report.mismatchCount())
+
+	delete(folderAssets, "empty-file")
+	folderAssets["extra.txt"] = verifyFolderAsset{size: 5}
+	folderAssets[cacheTargetIndexName] = verifyFolderAsset{size: 100}
+	asset := folderAssets["abitoftext.txt"]
+	asset.size++
+	folderAssets["abitoftext.txt"] = asset
+	asset = folderAssets["folder/abitoftextinasubfolder.txt"]
+	asset.hash++
+	folderAssets["folder/abitoftextinasubfolder.txt"] = asset
+	asset = folderAssets["folder/anotherabitoftextinasubfolder.txt"]
+	asset.permissions = 0600
+	folderAssets["folder/anotherabitoftextinasubfolder.txt"] = asset
+
+	report = createVerifyFolderReport(versionIndex, folderAssets, false, true)
+	assert.Equal(t, []versionDiffAsset{{Path: "empty-file", Size: 0}}, report.Missing)
+	assert.Equal(t, []versionDiffAsset{{Path: "extra.txt", Size: 5}}, report.Extra)
+	assert.Equal(t, 1, len(report.SizeMismatch))
+	assert.Equal(t, "abitoftext.txt", report.SizeMismatch[0].Path)
+	assert.Equal(t, 1, len(report.ContentMismatch))
+	assert.Equal(t, "folder/abitoftextinasubfolder.txt", report.ContentMismatch[0].Path)
+	assert.Equal(t, 1, len(report.PermissionsMismatch))
+	assert.Equal(t, "0600", report.PermissionsMismatch[0].Permissions)
+
+	report = createVerifyFolderReport(versionIndex, folderAssets, true, false)
+	assert.Equal(t, 0, len(report.ContentMismatch))
+	assert.Equal(t, 0, len(report.PermissionsMismatch))
+	assert.Equal(t, 3, report.mismatchCount())
+}
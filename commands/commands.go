@@ -1,5 +1,7 @@
 package commands
 
+import "time"
+
 // Cli ...
 var Cli struct {
 	LogLevel                string                     `name:"log-level" help:"Log level [debug, info, warn, error]" enum:"debug, info, warn, error" default:"warn" `
@@ -10,6 +12,16 @@ var Cli struct {
 	MemTraceCSV             string                     `name:"mem-trace-csv" help:"Output path for detailed memory statistics from longtail in csv format"`
 	WorkerCount             int                        `name:"worker-count" help:"Set number of workers created, defaults to match number of logical CPUs (zero for default count)" default:"0"`
 	RemoteWorkerCount       int                        `name:"remote-worker-count" help:"Set number of workers created for the remote store, defaults to match number of logical CPUs with upper limit of 8 for networked remote stores (zero for default count)" default:"0"`
+	BlobOperationTimeout    time.Duration              `name:"blob-operation-timeout" help:"Timeout for each individual blob store operation, such as reading or writing a block, zero to disable" default:"0"`
+	BlobMaxRetries          int                        `name:"blob-max-retries" help:"Number of times a failed blob store operation is retried, zero to disable retries" default:"6"`
+	BlobRetryMaxDelay       time.Duration              `name:"blob-retry-max-delay" help:"Upper limit for the exponential back off between retries of blob store operations" default:"5s"`
+	EncryptionKeyFile       string                     `name:"encryption-key-file" help:"Encrypt all blob store objects with keys read from this file, one '<key id>:<base64 key>' per line, the first key is used for writing" xor:"encryption-key"`
+	EncryptionKeyEnv        string                     `name:"encryption-key-env" help:"Same as --encryption-key-file but reads the keys, separated by commas, from the named environment variable" xor:"encryption-key"`
+	MaxTransferRate         string                     `name:"max-transfer-rate" help:"Limit the combined upload and download rate of blob stores in bytes per second, such as 500K, 10MB or 1.5MiB, zero for no limit" default:"0"`
+	MaxDownloadRate         string                     `name:"max-download-rate" help:"Limit the download rate of blob stores in bytes per second, zero for no limit" default:"0"`
+	MaxUploadRate           string                     `name:"max-upload-rate" help:"Limit the upload rate of blob stores in bytes per second, zero for no limit" default:"0"`
+	RateControlFile         string                     `name:"rate-control-file" help:"File with 'total=<rate>', 'download=<rate>' and 'upload=<rate>' lines that changes the rate limits while running, it is re-read when modified or when receiving SIGHUP"`
+	VerifyBlockContent      bool                       `name:"verify-block-content" help:"Decompress each block fetched from a remote store and check the hash of every chunk, by default only the block index and the size of the block are checked against the store index"`
 	LogToConsole            bool                       `name:"log-to-console" help:"Enable logging to console" default:"true" negatable:""`
 	LogFilePath             string                     `name:"log-file-path" help:"Path to log file for json formatted logging"`
 	LogColoring             bool                       `name:"log-coloring" help:"Use colored logging for stdout"`
@@ -19,6 +31,8 @@ var Cli struct {
 	Get                     GetCmd                     `cmd:"" name:"get" help:"Download a folder using a get-config"`
 	ValidateVersion         ValidateVersionCmd         `cmd:"" name:"validate-version" help:"Validate a version index against a content store making sure all content needed is in the store" aliases:"validate"`
 	PrintVersion            PrintVersionCmd            `cmd:"" name:"print-version" help:"Print info about a version index" aliases:"printVersionIndex"`
+	DiffVersions            DiffVersionsCmd            `cmd:"" name:"diff-versions" help:"List the assets that differ between two version indexes and how much a client has to download to update"`
+	VerifyFolder            VerifyFolderCmd            `cmd:"" name:"verify-folder" help:"Verify that a local folder matches a version index and report missing, extra and mismatched files"`
 	PrintStore              PrintStoreCmd              `cmd:"" name:"print-store" help:"Print info about a store index" aliases:"printStoreIndex"`
 	PrintVersionUsage       PrintVersionUsageCmd       `cmd:"" name:"print-version-usage" help:"Shows block usage and asset fragmentaiton stats about a version index" aliases:"stats"`
 	DumpVersionAssets       DumpVersionAssetsCmd       `cmd:"" name:"dump-version-assets" help:"Lists all the asset paths inside a version index" aliases:"dump"`
@@ -27,11 +41,15 @@ var Cli struct {
 	InitRemoteStore         InitRemoteStoreCmd         `cmd:"" name:"init-remote-store" help:"Open/create a remote store and force rebuild the store index" aliases:"init"`
 	CreateVersionStoreIndex CreateVersionStoreIndexCmd `cmd:"" name:"create-version-store-index" help:"Create a store index optimized for a version index" aliases:"createVersionStoreIndex"`
 	CloneStore              CloneStoreCmd              `cmd:"" name:"clone-store" help:"Clone all the data needed to cover a set of versions from one store into a new store" aliases:"cloneStore"`
-	PruneStore              PruneStoreCmd              `cmd:"" name:"prune-store" help:"Prune blocks in a store which are not used by the files in the input list. CAUTION! Running uploads to a store that is being pruned may cause loss of the uploaded data" aliases:"pruneStore"`
-	PruneStoreIndex         PruneStoreIndexCmd         `cmd:"" name:"prune-store-index" help:"Prune blocks in a store index which are not used by the files in the input list. CAUTION! Running uploads to a store that is being pruned may cause loss of the uploaded data"`
-	PruneStoreBlocks        PruneStoreBlocksCmd        `cmd:"" name:"prune-store-blocks" help:"Prune blocks in a store which are not present in the store index. CAUTION! Running uploads to a store that is being pruned may cause loss of the uploaded data"`
+	PruneStore              PruneStoreCmd              `cmd:"" name:"prune-store" help:"Prune blocks in a store which are not used by the files in the input list. CAUTION! Unless --grace-period is set, running uploads to a store that is being pruned may cause loss of the uploaded data" aliases:"pruneStore"`
+	PruneStoreIndex         PruneStoreIndexCmd         `cmd:"" name:"prune-store-index" help:"Prune blocks in a store index which are not used by the files in the input list. CAUTION! Running uploads to a store that is being pruned may cause loss of the uploaded data, use prune-store with --grace-period to prune while uploading"`
+	PruneStoreBlocks        PruneStoreBlocksCmd        `cmd:"" name:"prune-store-blocks" help:"Prune blocks in a store which are not present in the store index. CAUTION! Running uploads to a store that is being pruned may cause loss of the uploaded data, use prune-store with --grace-period to prune while uploading"`
+	CompactStoreIndex       CompactStoreIndexCmd       `cmd:"" name:"compact-store-index" help:"Merge the store index items written by uploads into a single store index item, safe to run while uploading"`
+	ScrubStore              ScrubStoreCmd              `cmd:"" name:"scrub-store" help:"Verify every block in the store index and report missing, corrupt and orphaned blocks"`
+	StoreLock               StoreLockCmd               `cmd:"" name:"store-lock" help:"Show or break the lease held by maintenance operations such as init-remote-store, compact-store-index and prune-store"`
 	Version                 VersionCmd                 `cmd:"" name:"version" help:"Show version number"`
 	Pack                    PackCmd                    `cmd:"" name:"pack" help:"Pack a source to an archive"`
 	Unpack                  UnpackCmd                  `cmd:"" name:"unpack" help:"Unpack an archive"`
 	Put                     PutCmd                     `cmd:"" name:"put" help:"Upload a folder"`
+	CreateHTTPManifest      CreateHTTPManifestCmd      `cmd:"" name:"create-http-manifest" help:"Write a manifest listing all objects in a store so it can be served from a http(s) file host"`
 }
@@ -27,6 +27,7 @@ func executeCommandLine(command string, params ...string) (string, error) {
 	}
 
 	context := &Context{
+		Ctx:            context.Background(),
 		NumWorkerCount: runtime.NumCPU(),
 	}
 	err = ctx.Run(context)
@@ -43,7 +44,7 @@ func createContent(store longtailstorelib.BlobStore, path string, content map[st
 	for f, d := range content {
 		o, _ := client.NewObject(path + f)
 		b := []byte(d)
-		o.Write(b)
+		o.Write(context.Background(), b)
 	}
 }
 
@@ -57,7 +58,7 @@ func validateContent(t *testing.T, baseURI string, path string, content map[stri
 		n := f.Name[len(path)+1:]
 		if c, exists := content[n]; exists {
 			o, _ := client.NewObject(f.Name)
-			b, _ := o.Read()
+			b, _ := o.Read(context.Background())
 			d := string(b)
 			if d != c {
 				t.Errorf("Content of file `%s` does not match. Expected `%s`, got `%s`", n, d, c)
@@ -127,3 +128,10 @@ func createLayeredData(t *testing.T, baseURI string) {
 	store, _ := longtailstorelib.CreateBlobStoreForURI(baseURI)
 	createContent(store, "source/", layerData)
 }
+
+// createMemStoreURI returns the uri of an in-memory store that lives for the duration of the test
+func createMemStoreURI(t *testing.T) string {
+	name := t.Name()
+	t.Cleanup(func() { longtailstorelib.DeleteNamedMemBlobStore(name) })
+	return "mem://" + name
+}
@@ -1,8 +1,17 @@
 package commands
 
-import "github.com/DanEngelbrecht/golongtail/longtailutils"
+import (
+	"context"
+	"time"
+
+	"github.com/DanEngelbrecht/golongtail/longtailstorelib"
+	"github.com/DanEngelbrecht/golongtail/longtailutils"
+	"github.com/pkg/errors"
+)
 
 type Context struct {
+	// Ctx bounds all blob store operations of the command, cancelling it aborts the command
+	Ctx                  context.Context
 	NumWorkerCount       int
 	NumRemoteWorkerCount int
 	StoreStats           []longtailutils.StoreStat
@@ -34,7 +43,7 @@ type TargetPathExcludeRegExOption struct {
 }
 
 type StorageURIOption struct {
-	StorageURI string `name:"storage-uri" help:"Storage URI (local file system, GCS and S3 bucket URI supported)" required:""`
+	StorageURI string `name:"storage-uri" help:"Storage URI (local file system, GCS, S3 bucket and Azure container URI supported)" required:""`
 }
 
 type S3EndpointResolverURLOption struct {
@@ -42,7 +51,26 @@ type S3EndpointResolverURLOption struct {
 }
 
 type CachePathOption struct {
-	CachePath string `name:"cache-path" help:"Location for cached blocks"`
+	CachePath         string `name:"cache-path" help:"Location for cached blocks"`
+	IndexCacheMaxSize string `name:"index-cache-max-size" help:"Enables caching store and version indexes in a folder next to --cache-path and limits its size, such as 256MiB, the index cache is off if zero" default:"0"`
+}
+
+// withIndexCache returns ctx with the index cache that goes with --cache-path, ctx is returned
+// unchanged if there is no cache path or the index cache is disabled
+func (option CachePathOption) withIndexCache(ctx context.Context) (context.Context, error) {
+	const fname = "withIndexCache"
+	if option.CachePath == "" {
+		return ctx, nil
+	}
+	maxSize, err := longtailstorelib.ParseByteSize(option.IndexCacheMaxSize)
+	if err != nil {
+		return ctx, errors.Wrap(err, fname)
+	}
+	if maxSize == 0 {
+		return ctx, nil
+	}
+	cache := longtailstorelib.NewIndexCache(longtailstorelib.IndexCachePath(option.CachePath), maxSize)
+	return longtailstorelib.WithBlobStoreOptions(ctx, longtailstorelib.WithIndexCache(cache)), nil
 }
 
 type RetainPermissionsOption struct {
@@ -89,6 +117,34 @@ type StoreIndexPathOption struct {
 	StoreIndexPath string `name:"store-index-path" required:"" help:"URI to store index (local file system, GCS and S3 bucket URI supported)"`
 }
 
+type CompactStoreIndexThresholdOption struct {
+	CompactStoreIndexThreshold int `name:"compact-store-index-threshold" help:"Compact the store index after uploading if it is split into at least this many items, zero to disable" default:"0"`
+}
+
+type DownsyncDryRunOption struct {
+	DryRun bool `name:"dry-run" help:"Don't write anything, report the files that would change, the blocks to fetch and the disk space required"`
+}
+
+type RepairOption struct {
+	Repair bool `name:"repair" help:"Validate the target path against the source and only rewrite the missing and damaged files, files that are not part of the source are left in place"`
+}
+
+type UpsyncDryRunOption struct {
+	DryRun bool `name:"dry-run" help:"Don't upload or write any index, report how many chunks and blocks would be added to the store"`
+}
+
+type StoreLeaseOption struct {
+	StoreLeaseTTL time.Duration `name:"store-lease-ttl" help:"Hold the store lease while running, other maintenance operations wait for it to be released or expire this long after the last heartbeat" default:"2m"`
+}
+
+type StoreLeaseURIOption struct {
+	StoreLeaseURI string `name:"storage-uri" help:"Store to hold the store lease of while running, the folder of --store-index-path if not set"`
+}
+
+type CheckStoreLeaseOption struct {
+	CheckStoreLease bool `name:"check-store-lease" help:"Fail before uploading if a maintenance operation holds the store lease"`
+}
+
 type MinBlockUsagePercentOption struct {
 	MinBlockUsagePercent uint32 `name:"min-block-usage-percent" help:"Minimum percent of block content than must match for it to be considered \"existing\". Default is 80, allowing for up to 20% redundant data in blocks. Use 0 to use any block use all and 100 for no redundant data in blocks" default:"80"`
 }
@@ -256,6 +256,54 @@ static struct Longtail_VersionIndex* GetArchiveVersionIndex(struct Longtail_Arch
     return &archive_index->m_VersionIndex;
 }
 
+static void* CopyVersionDiffAssetIndexes(uint32_t** out_indexes, void* p, uint32_t count, const uint32_t* asset_indexes)
+{
+    *out_indexes = (uint32_t*)p;
+    if (count > 0)
+    {
+        memcpy(p, asset_indexes, sizeof(uint32_t) * count);
+    }
+    return &((uint32_t*)p)[count];
+}
+
+// A version diff from a version to itself that writes the added assets and rewrites the modified ones
+static struct Longtail_VersionDiff* CreateVersionDiffFromAssetIndexes(
+    uint32_t added_count,
+    const uint32_t* added_asset_indexes,
+    uint32_t modified_content_count,
+    const uint32_t* modified_content_asset_indexes,
+    uint32_t modified_permissions_count,
+    const uint32_t* modified_permissions_asset_indexes)
+{
+    size_t size = sizeof(struct Longtail_VersionDiff) +
+        sizeof(uint32_t) * 4 +
+        sizeof(uint32_t) * added_count +
+        sizeof(uint32_t) * modified_content_count * 2 +
+        sizeof(uint32_t) * modified_permissions_count * 2;
+    struct Longtail_VersionDiff* version_diff = (struct Longtail_VersionDiff*)Longtail_Alloc("CreateVersionDiffFromAssetIndexes", size);
+    if (!version_diff)
+    {
+        return 0;
+    }
+    uint32_t* counts = (uint32_t*)&version_diff[1];
+    version_diff->m_SourceRemovedCount = &counts[0];
+    version_diff->m_TargetAddedCount = &counts[1];
+    version_diff->m_ModifiedContentCount = &counts[2];
+    version_diff->m_ModifiedPermissionsCount = &counts[3];
+    counts[0] = 0;
+    counts[1] = added_count;
+    counts[2] = modified_content_count;
+    counts[3] = modified_permissions_count;
+    void* p = &counts[4];
+    p = CopyVersionDiffAssetIndexes(&version_diff->m_SourceRemovedAssetIndexes, p, 0, 0);
+    p = CopyVersionDiffAssetIndexes(&version_diff->m_TargetAddedAssetIndexes, p, added_count, added_asset_indexes);
+    p = CopyVersionDiffAssetIndexes(&version_diff->m_SourceContentModifiedAssetIndexes, p, modified_content_count, modified_content_asset_indexes);
+    p = CopyVersionDiffAssetIndexes(&version_diff->m_TargetContentModifiedAssetIndexes, p, modified_content_count, modified_content_asset_indexes);
+    p = CopyVersionDiffAssetIndexes(&version_diff->m_SourcePermissionsModifiedAssetIndexes, p, modified_permissions_count, modified_permissions_asset_indexes);
+    CopyVersionDiffAssetIndexes(&version_diff->m_TargetPermissionsModifiedAssetIndexes, p, modified_permissions_count, modified_permissions_asset_indexes);
+    return version_diff;
+}
+
 static void EnableMemtrace() {
     Longtail_MemTracer_Init();
     Longtail_SetReAllocAndFree(Longtail_MemTracer_ReAlloc, Longtail_MemTracer_Free);
@@ -4,6 +4,7 @@ package longtaillib
 // #include "golongtail.h"
 import "C"
 import (
+	"context"
 	"fmt"
 	"os"
 	"sync/atomic"
@@ -123,6 +124,12 @@ func errorToErrno(err error, fallback C.int) C.int {
 	if IsBadFormat(err) {
 		return C.EBADF
 	}
+	if errors.Is(err, context.Canceled) {
+		return C.ECANCELED
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return C.ETIMEDOUT
+	}
 	return fallback
 }
 
@@ -159,6 +166,23 @@ func BadFormatErr() error {
 	return errnoToError(C.EBADF)
 }
 
+// IsCancelled returns true if err is caused by a cancelled operation, either from
+// longtail or from a cancelled context
+func IsCancelled(err error) bool {
+	if err == nil {
+		return false
+	}
+	var longtailError *longtailError
+	if errors.As(err, &longtailError) {
+		return longtailError.Errno == C.ECANCELED
+	}
+	return errors.Is(err, context.Canceled)
+}
+
+func CancelledErr() error {
+	return errnoToError(C.ECANCELED)
+}
+
 func AccessViolationErr() error {
 	return errnoToError(C.EACCES)
 }
@@ -625,6 +649,21 @@ func (hashAPI *Longtail_HashAPI) GetIdentifier() uint32 {
 	return uint32(C.Longtail_Hash_GetIdentifier(hashAPI.cHashAPI))
 }
 
+// HashBuffer returns the hash of data, the same hash the api gives chunks with that content
+func (hashAPI *Longtail_HashAPI) HashBuffer(data []byte) (uint64, error) {
+	const fname = "HashBuffer"
+	var cData unsafe.Pointer
+	if len(data) > 0 {
+		cData = unsafe.Pointer(&data[0])
+	}
+	var hash C.TLongtail_Hash
+	errno := C.Longtail_Hash_HashBuffer(hashAPI.cHashAPI, C.uint32_t(len(data)), cData, &hash)
+	if errno != 0 {
+		return 0, errors.Wrap(errnoToError(errno), fname)
+	}
+	return uint64(hash), nil
+}
+
 func (storeIndex *Longtail_StoreIndex) Copy() (Longtail_StoreIndex, error) {
 	const fname = "Copy"
 	if storeIndex.cStoreIndex == nil {
@@ -700,6 +739,30 @@ func (storeIndex *Longtail_StoreIndex) GetChunkSizes() []uint32 {
 	return carray2slice32(storeIndex.cStoreIndex.m_ChunkSizes, size)
 }
 
+func (storeIndex *Longtail_StoreIndex) GetBlockChunksOffsets() []uint32 {
+	if storeIndex.cStoreIndex == nil {
+		return nil
+	}
+	size := int(*storeIndex.cStoreIndex.m_BlockCount)
+	return carray2slice32(storeIndex.cStoreIndex.m_BlockChunksOffsets, size)
+}
+
+func (storeIndex *Longtail_StoreIndex) GetBlockChunkCounts() []uint32 {
+	if storeIndex.cStoreIndex == nil {
+		return nil
+	}
+	size := int(*storeIndex.cStoreIndex.m_BlockCount)
+	return carray2slice32(storeIndex.cStoreIndex.m_BlockChunkCounts, size)
+}
+
+func (storeIndex *Longtail_StoreIndex) GetBlockTags() []uint32 {
+	if storeIndex.cStoreIndex == nil {
+		return nil
+	}
+	size := int(*storeIndex.cStoreIndex.m_BlockCount)
+	return carray2slice32(storeIndex.cStoreIndex.m_BlockTags, size)
+}
+
 func (versionIndex *Longtail_VersionIndex) IsValid() bool {
 	return versionIndex.cVersionIndex != nil
 }
@@ -730,6 +793,15 @@ func (archiveIndex *Longtail_ArchiveIndex) GetVersionIndex() Longtail_VersionInd
 	return Longtail_VersionIndex{cVersionIndex: C.GetArchiveVersionIndex(archiveIndex.cArchiveIndex)}
 }
 
+// GetBlockSizes returns the stored size of each block in the archive, in the same order as the blocks of GetStoreIndex()
+func (archiveIndex *Longtail_ArchiveIndex) GetBlockSizes() []uint32 {
+	if archiveIndex.cArchiveIndex == nil {
+		return nil
+	}
+	size := int(*archiveIndex.cArchiveIndex.m_StoreIndex.m_BlockCount)
+	return carray2slice32(archiveIndex.cArchiveIndex.m_BlockSizes, size)
+}
+
 func (versionIndex *Longtail_VersionIndex) GetVersion() uint32 {
 	if versionIndex.cVersionIndex == nil {
 		return 0
@@ -860,6 +932,94 @@ func (versionDiff *Longtail_VersionDiff) Dispose() {
 	}
 }
 
+func (versionDiff *Longtail_VersionDiff) IsValid() bool {
+	return versionDiff.cVersionDiff != nil
+}
+
+func (versionDiff *Longtail_VersionDiff) GetSourceRemovedCount() uint32 {
+	if versionDiff.cVersionDiff == nil {
+		return 0
+	}
+	return uint32(*versionDiff.cVersionDiff.m_SourceRemovedCount)
+}
+
+func (versionDiff *Longtail_VersionDiff) GetTargetAddedCount() uint32 {
+	if versionDiff.cVersionDiff == nil {
+		return 0
+	}
+	return uint32(*versionDiff.cVersionDiff.m_TargetAddedCount)
+}
+
+func (versionDiff *Longtail_VersionDiff) GetModifiedContentCount() uint32 {
+	if versionDiff.cVersionDiff == nil {
+		return 0
+	}
+	return uint32(*versionDiff.cVersionDiff.m_ModifiedContentCount)
+}
+
+func (versionDiff *Longtail_VersionDiff) GetModifiedPermissionsCount() uint32 {
+	if versionDiff.cVersionDiff == nil {
+		return 0
+	}
+	return uint32(*versionDiff.cVersionDiff.m_ModifiedPermissionsCount)
+}
+
+// GetSourceRemovedAssetIndexes returns the indexes in the source version index of the assets missing from the target
+func (versionDiff *Longtail_VersionDiff) GetSourceRemovedAssetIndexes() []uint32 {
+	if versionDiff.cVersionDiff == nil {
+		return nil
+	}
+	size := int(*versionDiff.cVersionDiff.m_SourceRemovedCount)
+	return carray2slice32(versionDiff.cVersionDiff.m_SourceRemovedAssetIndexes, size)
+}
+
+// GetTargetAddedAssetIndexes returns the indexes in the target version index of the assets missing from the source
+func (versionDiff *Longtail_VersionDiff) GetTargetAddedAssetIndexes() []uint32 {
+	if versionDiff.cVersionDiff == nil {
+		return nil
+	}
+	size := int(*versionDiff.cVersionDiff.m_TargetAddedCount)
+	return carray2slice32(versionDiff.cVersionDiff.m_TargetAddedAssetIndexes, size)
+}
+
+// GetSourceContentModifiedAssetIndexes returns the source version index side of the assets with modified content,
+// it has the same order as GetTargetContentModifiedAssetIndexes
+func (versionDiff *Longtail_VersionDiff) GetSourceContentModifiedAssetIndexes() []uint32 {
+	if versionDiff.cVersionDiff == nil {
+		return nil
+	}
+	size := int(*versionDiff.cVersionDiff.m_ModifiedContentCount)
+	return carray2slice32(versionDiff.cVersionDiff.m_SourceContentModifiedAssetIndexes, size)
+}
+
+// GetTargetContentModifiedAssetIndexes returns the target version index side of the assets with modified content
+func (versionDiff *Longtail_VersionDiff) GetTargetContentModifiedAssetIndexes() []uint32 {
+	if versionDiff.cVersionDiff == nil {
+		return nil
+	}
+	size := int(*versionDiff.cVersionDiff.m_ModifiedContentCount)
+	return carray2slice32(versionDiff.cVersionDiff.m_TargetContentModifiedAssetIndexes, size)
+}
+
+// GetSourcePermissionsModifiedAssetIndexes returns the source version index side of the assets with modified permissions,
+// it has the same order as GetTargetPermissionsModifiedAssetIndexes
+func (versionDiff *Longtail_VersionDiff) GetSourcePermissionsModifiedAssetIndexes() []uint32 {
+	if versionDiff.cVersionDiff == nil {
+		return nil
+	}
+	size := int(*versionDiff.cVersionDiff.m_ModifiedPermissionsCount)
+	return carray2slice32(versionDiff.cVersionDiff.m_SourcePermissionsModifiedAssetIndexes, size)
+}
+
+// GetTargetPermissionsModifiedAssetIndexes returns the target version index side of the assets with modified permissions
+func (versionDiff *Longtail_VersionDiff) GetTargetPermissionsModifiedAssetIndexes() []uint32 {
+	if versionDiff.cVersionDiff == nil {
+		return nil
+	}
+	size := int(*versionDiff.cVersionDiff.m_ModifiedPermissionsCount)
+	return carray2slice32(versionDiff.cVersionDiff.m_TargetPermissionsModifiedAssetIndexes, size)
+}
+
 // CreateFullHashRegistry ...
 func CreateFullHashRegistry() Longtail_HashRegistryAPI {
 	return Longtail_HashRegistryAPI{cHashRegistryAPI: C.Longtail_CreateFullHashRegistry()}
@@ -1584,6 +1744,12 @@ func WriteBlockIndexToBuffer(index Longtail_BlockIndex) (NativeBuffer, error) {
 	return NativeBuffer{buffer, size}, nil
 }
 
+// GetBlockIndexDataSize returns the size of a serialized block index with chunkCount chunks,
+// it is the size of the header at the start of a serialized stored block
+func GetBlockIndexDataSize(chunkCount uint32) int {
+	return int(C.Longtail_GetBlockIndexDataSize(C.uint32_t(chunkCount)))
+}
+
 // ReadBlockIndexFromBuffer ...
 func ReadBlockIndexFromBuffer(buffer []byte) (Longtail_BlockIndex, error) {
 	const fname = "ReadBlockIndexFromBuffer"
@@ -2233,6 +2399,35 @@ func CreateVersionDiff(
 	return Longtail_VersionDiff{cVersionDiff: versionDiff}, nil
 }
 
+// CreateVersionDiffFromAssetIndexes creates a version diff from a version index to itself that writes the added
+// assets and rewrites the content and permissions of the modified assets. Nothing is removed. Use it with the same
+// version index as source and target to repair assets in a folder without rescanning it
+func CreateVersionDiffFromAssetIndexes(
+	addedAssetIndexes []uint32,
+	modifiedContentAssetIndexes []uint32,
+	modifiedPermissionsAssetIndexes []uint32) (Longtail_VersionDiff, error) {
+	const fname = "CreateVersionDiffFromAssetIndexes"
+
+	assetIndexesPtr := func(assetIndexes []uint32) *C.uint32_t {
+		if len(assetIndexes) == 0 {
+			return nil
+		}
+		return (*C.uint32_t)(unsafe.Pointer(&assetIndexes[0]))
+	}
+
+	versionDiff := C.CreateVersionDiffFromAssetIndexes(
+		C.uint32_t(len(addedAssetIndexes)),
+		assetIndexesPtr(addedAssetIndexes),
+		C.uint32_t(len(modifiedContentAssetIndexes)),
+		assetIndexesPtr(modifiedContentAssetIndexes),
+		C.uint32_t(len(modifiedPermissionsAssetIndexes)),
+		assetIndexesPtr(modifiedPermissionsAssetIndexes))
+	if versionDiff == nil {
+		return Longtail_VersionDiff{cVersionDiff: nil}, errors.Wrap(errnoToError(C.ENOMEM), fname)
+	}
+	return Longtail_VersionDiff{cVersionDiff: versionDiff}, nil
+}
+
 // ChangeVersion ...
 func ChangeVersion(
 	contentBlockStoreAPI Longtail_BlockStoreAPI,
@@ -614,6 +614,32 @@ func TestPutGetStoredBlock(t *testing.T) {
 	blockStoreProxy.Dispose()
 }
 
+func TestHashBuffer(t *testing.T) {
+	SetLogger(&testLogger{t: t})
+	defer SetLogger(nil)
+	SetAssert(&testAssert{t: t})
+	defer SetAssert(nil)
+	SetLogLevel(1)
+
+	blake3 := CreateBlake3HashAPI()
+	defer blake3.Dispose()
+
+	data := make([]uint8, 30)
+	for index := range data {
+		data[index] = uint8(index)
+	}
+	hash, err := blake3.HashBuffer(data)
+	assert.NoError(t, err)
+	sameHash, err := blake3.HashBuffer(append([]uint8{}, data...))
+	assert.NoError(t, err)
+	assert.Equal(t, hash, sameHash)
+
+	data[15]++
+	otherHash, err := blake3.HashBuffer(data)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash, otherHash)
+}
+
 func TestPruneStoredBlocks(t *testing.T) {
 	SetLogger(&testLogger{t: t})
 	defer SetLogger(nil)
@@ -751,6 +777,11 @@ func TestPruneStoredBlocks(t *testing.T) {
 		sort.Slice(blockHashes, func(i, j int) bool { return blockHashes[i] < blockHashes[j] })
 		assert.Equal(t, expectedBlockHashes[0], blockHashes[0])
 		assert.Equal(t, expectedBlockHashes[1], blockHashes[1])
+		blockChunkCounts := prunedStoreIndex.GetBlockChunkCounts()
+		assert.Equal(t, 2, len(blockChunkCounts))
+		assert.Equal(t, 2, len(prunedStoreIndex.GetBlockChunksOffsets()))
+		assert.Equal(t, 2, len(prunedStoreIndex.GetBlockTags()))
+		assert.Equal(t, prunedStoreIndex.GetChunkCount(), blockChunkCounts[0]+blockChunkCounts[1])
 	}
 
 	blockStoreProxy.Dispose()
@@ -1187,6 +1218,78 @@ func TestChangeVersion(t *testing.T) {
 	assert.NoError(t, err, "blockStoreProxy.Flush() OnComplete:")
 }
 
+func TestVersionDiff(t *testing.T) {
+	storageAPI := CreateInMemStorageAPI()
+	defer storageAPI.Dispose()
+	storageAPI.WriteToStorage("source", "kept.txt", []byte("kept in both versions"))
+	storageAPI.WriteToStorage("source", "modified.bin", randomArray(65535))
+	storageAPI.WriteToStorage("source", "removed.txt", []byte("only in source"))
+	storageAPI.WriteToStorage("target", "kept.txt", []byte("kept in both versions"))
+	storageAPI.WriteToStorage("target", "modified.bin", randomArray(65535))
+	storageAPI.WriteToStorage("target", "added.txt", []byte("only in target"))
+
+	hashAPI := CreateBlake2HashAPI()
+	defer hashAPI.Dispose()
+	chunkerAPI := CreateHPCDCChunkerAPI()
+	defer chunkerAPI.Dispose()
+	jobAPI := CreateBikeshedJobAPI(uint32(runtime.NumCPU()), 0)
+	defer jobAPI.Dispose()
+
+	versionIndexes := []Longtail_VersionIndex{}
+	for _, rootPath := range []string{"source", "target"} {
+		fileInfos, err := GetFilesRecursively(storageAPI, Longtail_PathFilterAPI{}, rootPath)
+		assert.NoError(t, err, "GetFilesRecursively()")
+		defer fileInfos.Dispose()
+		createVersionProgress := CreateProgress(t, "CreateVersionIndex")
+		versionIndex, err := CreateVersionIndex(
+			storageAPI,
+			hashAPI,
+			chunkerAPI,
+			jobAPI,
+			&createVersionProgress,
+			rootPath,
+			fileInfos,
+			make([]uint32, fileInfos.GetFileCount()),
+			32768,
+			false)
+		assert.NoError(t, err, "CreateVersionIndex()")
+		defer versionIndex.Dispose()
+		versionIndexes = append(versionIndexes, versionIndex)
+	}
+	sourceVersionIndex := versionIndexes[0]
+	targetVersionIndex := versionIndexes[1]
+
+	versionDiff, err := CreateVersionDiff(hashAPI, sourceVersionIndex, targetVersionIndex)
+	assert.NoError(t, err, "CreateVersionDiff()")
+	defer versionDiff.Dispose()
+	assert.True(t, versionDiff.IsValid())
+
+	assert.Equal(t, uint32(1), versionDiff.GetSourceRemovedCount())
+	assert.Equal(t, "removed.txt", sourceVersionIndex.GetAssetPath(versionDiff.GetSourceRemovedAssetIndexes()[0]))
+	assert.Equal(t, uint32(1), versionDiff.GetTargetAddedCount())
+	assert.Equal(t, "added.txt", targetVersionIndex.GetAssetPath(versionDiff.GetTargetAddedAssetIndexes()[0]))
+	assert.Equal(t, uint32(1), versionDiff.GetModifiedContentCount())
+	assert.Equal(t, "modified.bin", sourceVersionIndex.GetAssetPath(versionDiff.GetSourceContentModifiedAssetIndexes()[0]))
+	assert.Equal(t, "modified.bin", targetVersionIndex.GetAssetPath(versionDiff.GetTargetContentModifiedAssetIndexes()[0]))
+	assert.Equal(t, uint32(0), versionDiff.GetModifiedPermissionsCount())
+	assert.Equal(t, 0, len(versionDiff.GetSourcePermissionsModifiedAssetIndexes()))
+	assert.Equal(t, 0, len(versionDiff.GetTargetPermissionsModifiedAssetIndexes()))
+}
+
+func TestCreateVersionDiffFromAssetIndexes(t *testing.T) {
+	versionDiff, err := CreateVersionDiffFromAssetIndexes([]uint32{3}, []uint32{1, 2}, nil)
+	assert.NoError(t, err, "CreateVersionDiffFromAssetIndexes()")
+	defer versionDiff.Dispose()
+	assert.True(t, versionDiff.IsValid())
+
+	assert.Equal(t, uint32(0), versionDiff.GetSourceRemovedCount())
+	assert.Equal(t, []uint32{3}, versionDiff.GetTargetAddedAssetIndexes())
+	assert.Equal(t, uint32(2), versionDiff.GetModifiedContentCount())
+	assert.Equal(t, []uint32{1, 2}, versionDiff.GetSourceContentModifiedAssetIndexes())
+	assert.Equal(t, []uint32{1, 2}, versionDiff.GetTargetContentModifiedAssetIndexes())
+	assert.Equal(t, uint32(0), versionDiff.GetModifiedPermissionsCount())
+}
+
 func TestChangeVersion2(t *testing.T) {
 	storageAPI := createFilledStorage("content")
 	defer storageAPI.Dispose()
@@ -0,0 +1,428 @@
+package longtailstorelib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/pkg/errors"
+)
+
+// Credentials for Azure are picked up from the environment:
+//
+//	AZURE_STORAGE_CONNECTION_STRING - full connection string, takes precedence (use this for Azurite)
+//	AZURE_STORAGE_KEY               - shared key for the account given in the URI
+//
+// If none of the above is set the default Azure credential chain is used (environment, managed identity, az cli)
+const (
+	azureConnectionStringEnv = "AZURE_STORAGE_CONNECTION_STRING"
+	azureStorageKeyEnv       = "AZURE_STORAGE_KEY"
+)
+
+type azureBlobStore struct {
+	scheme        string
+	accountName   string
+	containerName string
+	prefix        string
+	serviceURL    string
+}
+
+type azureBlobClient struct {
+	ctx       context.Context
+	store     *azureBlobStore
+	container *container.Client
+}
+
+type azureBlobObject struct {
+	client         *azureBlobClient
+	blobClient     *blockblob.Client
+	path           string
+	writeCondition *blob.AccessConditions
+}
+
+// NewAzureBlobStore ...
+// Accepts URIs in the form abfs[s]://<container>@<account>.dfs.core.windows.net/<path>
+// or abfs[s]://<container>/<path> when the account is given by a connection string
+func NewAzureBlobStore(u *url.URL) (BlobStore, error) {
+	const fname = "NewAzureBlobStore"
+	if u.Scheme != "abfs" && u.Scheme != "abfss" {
+		err := fmt.Errorf("invalid scheme '%s', expected 'abfs' or 'abfss'", u.Scheme)
+		return nil, errors.Wrap(err, fname)
+	}
+	prefix := u.Path
+	if len(u.Path) > 0 {
+		prefix = u.Path[1:] // strip initial slash
+	}
+
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	s := &azureBlobStore{scheme: u.Scheme, prefix: prefix}
+	if u.User != nil && u.User.Username() != "" {
+		s.containerName = u.User.Username()
+		s.accountName = strings.Split(u.Host, ".")[0]
+		protocol := "https"
+		if u.Scheme == "abfs" {
+			protocol = "http"
+		}
+		s.serviceURL = fmt.Sprintf("%s://%s.blob.core.windows.net/", protocol, s.accountName)
+	} else {
+		s.containerName = u.Host
+	}
+	if s.containerName == "" {
+		err := fmt.Errorf("missing container name in `%s`", u.String())
+		return nil, errors.Wrap(err, fname)
+	}
+	return s, nil
+}
+
+func (blobStore *azureBlobStore) newServiceClient() (*azblob.Client, error) {
+	if connectionString := os.Getenv(azureConnectionStringEnv); connectionString != "" {
+		return azblob.NewClientFromConnectionString(connectionString, nil)
+	}
+	if blobStore.accountName == "" {
+		return nil, fmt.Errorf("no storage account in `%s` and %s is not set", blobStore.String(), azureConnectionStringEnv)
+	}
+	if accountKey := os.Getenv(azureStorageKeyEnv); accountKey != "" {
+		credential, err := azblob.NewSharedKeyCredential(blobStore.accountName, accountKey)
+		if err != nil {
+			return nil, err
+		}
+		return azblob.NewClientWithSharedKeyCredential(blobStore.serviceURL, credential, nil)
+	}
+	credential, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, err
+	}
+	return azblob.NewClient(blobStore.serviceURL, credential, nil)
+}
+
+func (blobStore *azureBlobStore) NewClient(ctx context.Context) (BlobClient, error) {
+	const fname = "azureBlobStore.NewClient"
+	client, err := blobStore.newServiceClient()
+	if err != nil {
+		err = errors.Wrapf(err, "Failed to create client for `%s`", blobStore.String())
+		return nil, errors.Wrap(err, fname)
+	}
+	containerClient := client.ServiceClient().NewContainerClient(blobStore.containerName)
+	return &azureBlobClient{ctx: ctx, store: blobStore, container: containerClient}, nil
+}
+
+func (blobStore *azureBlobStore) String() string {
+	if blobStore.accountName == "" {
+		return blobStore.scheme + "://" + blobStore.containerName + "/" + blobStore.prefix
+	}
+	return blobStore.scheme + "://" + blobStore.containerName + "@" + blobStore.accountName + "/" + blobStore.prefix
+}
+
+func (blobClient *azureBlobClient) NewObject(path string) (BlobObject, error) {
+	azurePath := blobClient.store.prefix + path
+	return &azureBlobObject{
+			client:         blobClient,
+			blobClient:     blobClient.container.NewBlockBlobClient(azurePath),
+			path:           azurePath,
+			writeCondition: nil},
+		nil
+}
+
+func (blobClient *azureBlobClient) GetObjects(pathPrefix string) ([]BlobProperties, error) {
+	const fname = "azureBlobClient.GetObjects"
+	var items []BlobProperties
+	err := blobClient.WalkObjects(pathPrefix, func(properties BlobProperties) error {
+		items = append(items, properties)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return items, nil
+}
+
+func (blobClient *azureBlobClient) WalkObjects(pathPrefix string, walkFn BlobWalkFunc) error {
+	const fname = "azureBlobClient.WalkObjects"
+	prefix := blobClient.store.prefix + pathPrefix
+	pager := blobClient.container.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix: &prefix,
+	})
+	for pager.More() {
+		page, err := pager.NextPage(blobClient.ctx)
+		if err != nil {
+			return errors.Wrap(err, fname)
+		}
+		for _, item := range page.Segment.BlobItems {
+			if item.Name == nil {
+				continue
+			}
+			itemName := (*item.Name)[len(blobClient.store.prefix):]
+			var size int64
+			var version string
+			if item.Properties != nil && item.Properties.ContentLength != nil {
+				size = *item.Properties.ContentLength
+			}
+			if item.Properties != nil && item.Properties.ETag != nil {
+				version = string(*item.Properties.ETag)
+			}
+			err = walkFn(BlobProperties{Size: size, Name: itemName, Version: version})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (blobClient *azureBlobClient) SupportsLocking() bool {
+	return true
+}
+
+func (blobClient *azureBlobClient) Close() {
+	blobClient.container = nil
+}
+
+func (blobClient *azureBlobClient) ClassifyError(err error) BlobErrorClass {
+	if bloberror.HasCode(err, bloberror.ServerBusy) {
+		return BlobErrorThrottled
+	}
+	var responseErr *azcore.ResponseError
+	if errors.As(err, &responseErr) {
+		return classifyHTTPStatus(responseErr.StatusCode)
+	}
+	if class, ok := classifyNetworkError(err); ok {
+		return class
+	}
+	return BlobErrorPermanent
+}
+
+func (blobClient *azureBlobClient) String() string {
+	return blobClient.store.String()
+}
+
+// isAzureWriteConditionFailure returns true if the error is due to an ETag mismatch
+// or an If-None-Match on an object that has been created since we locked it
+func isAzureWriteConditionFailure(err error) bool {
+	if bloberror.HasCode(err, bloberror.ConditionNotMet, bloberror.BlobAlreadyExists, bloberror.TargetConditionNotMet) {
+		return true
+	}
+	var responseErr *azcore.ResponseError
+	if errors.As(err, &responseErr) {
+		return responseErr.StatusCode == http.StatusPreconditionFailed || responseErr.StatusCode == http.StatusConflict
+	}
+	return false
+}
+
+func (blobObject *azureBlobObject) Read(ctx context.Context) ([]byte, error) {
+	const fname = "azureBlobObject.Read"
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	response, err := blobObject.blobClient.DownloadStream(ctx, nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound, bloberror.ContainerNotFound) {
+		err = errors.Wrapf(os.ErrNotExist, "%v", err)
+		return nil, errors.Wrap(err, fname)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	data, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return data, nil
+}
+
+func (blobObject *azureBlobObject) ReadIfChanged(ctx context.Context, version string) ([]byte, string, bool, error) {
+	const fname = "azureBlobObject.ReadIfChanged"
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	var options *blob.DownloadStreamOptions
+	if version != "" {
+		etag := azcore.ETag(version)
+		options = &blob.DownloadStreamOptions{AccessConditions: &blob.AccessConditions{ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfNoneMatch: &etag}}}
+	}
+	response, err := blobObject.blobClient.DownloadStream(ctx, options)
+	if bloberror.HasCode(err, bloberror.BlobNotFound, bloberror.ContainerNotFound) {
+		err = errors.Wrapf(os.ErrNotExist, "%v", err)
+		return nil, "", false, errors.Wrap(err, fname)
+	}
+	var responseErr *azcore.ResponseError
+	if errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusNotModified {
+		return nil, version, false, nil
+	}
+	if err != nil {
+		return nil, "", false, errors.Wrap(err, fname)
+	}
+	data, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, "", false, errors.Wrap(err, fname)
+	}
+	currentVersion := ""
+	if response.ETag != nil {
+		currentVersion = string(*response.ETag)
+	}
+	return data, currentVersion, true, nil
+}
+
+func (blobObject *azureBlobObject) OpenRead(ctx context.Context) (io.ReadCloser, error) {
+	const fname = "azureBlobObject.OpenRead"
+	response, err := blobObject.blobClient.DownloadStream(ctx, nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound, bloberror.ContainerNotFound) {
+		err = errors.Wrapf(os.ErrNotExist, "%v", err)
+		return nil, errors.Wrap(err, fname)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return response.Body, nil
+}
+
+func (blobObject *azureBlobObject) ReadRange(ctx context.Context, offset int64, length int64) ([]byte, error) {
+	const fname = "azureBlobObject.ReadRange"
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	if length <= 0 {
+		return []byte{}, nil
+	}
+	response, err := blobObject.blobClient.DownloadStream(ctx, &blob.DownloadStreamOptions{
+		Range: blob.HTTPRange{Offset: offset, Count: length},
+	})
+	if bloberror.HasCode(err, bloberror.BlobNotFound, bloberror.ContainerNotFound) {
+		err = errors.Wrapf(os.ErrNotExist, "%v", err)
+		return nil, errors.Wrap(err, fname)
+	}
+	if bloberror.HasCode(err, bloberror.InvalidRange) {
+		return []byte{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	data, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return data, nil
+}
+
+func (blobObject *azureBlobObject) LockWriteVersion(ctx context.Context) (bool, error) {
+	const fname = "azureBlobObject.LockWriteVersion"
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	properties, err := blobObject.blobClient.GetProperties(ctx, nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		etagAny := azcore.ETagAny
+		blobObject.writeCondition = &blob.AccessConditions{ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfNoneMatch: &etagAny}}
+		return false, nil
+	} else if err != nil {
+		err = errors.Wrapf(err, "Cant lock %s", blobObject.path)
+		return false, errors.Wrap(err, fname)
+	}
+
+	blobObject.writeCondition = &blob.AccessConditions{ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfMatch: properties.ETag}}
+	return true, nil
+}
+
+func (blobObject *azureBlobObject) Exists(ctx context.Context) (bool, error) {
+	const fname = "azureBlobObject.Exists"
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	_, err := blobObject.blobClient.GetProperties(ctx, nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound, bloberror.ContainerNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	return true, nil
+}
+
+func (blobObject *azureBlobObject) Size(ctx context.Context) (int64, error) {
+	const fname = "azureBlobObject.Size"
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	properties, err := blobObject.blobClient.GetProperties(ctx, nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound, bloberror.ContainerNotFound) {
+		err = errors.Wrapf(os.ErrNotExist, "%s does not exist", blobObject.String())
+		return 0, errors.Wrap(err, fname)
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, fname)
+	}
+	if properties.ContentLength == nil {
+		return 0, nil
+	}
+	return *properties.ContentLength, nil
+}
+
+func (blobObject *azureBlobObject) Write(ctx context.Context, data []byte) (bool, error) {
+	const fname = "azureBlobObject.Write"
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	contentType := "application/octet-stream"
+	options := &blockblob.UploadOptions{
+		HTTPHeaders:      &blob.HTTPHeaders{BlobContentType: &contentType},
+		AccessConditions: blobObject.writeCondition,
+	}
+	_, err := blobObject.blobClient.Upload(ctx, streaming.NopCloser(bytes.NewReader(data)), options)
+	if err != nil {
+		if blobObject.writeCondition != nil && isAzureWriteConditionFailure(err) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, fname)
+	}
+	return true, nil
+}
+
+func (blobObject *azureBlobObject) WriteFrom(ctx context.Context, reader io.Reader) (bool, error) {
+	const fname = "azureBlobObject.WriteFrom"
+	contentType := "application/octet-stream"
+	options := &blockblob.UploadStreamOptions{
+		HTTPHeaders:      &blob.HTTPHeaders{BlobContentType: &contentType},
+		AccessConditions: blobObject.writeCondition,
+	}
+	_, err := blobObject.blobClient.UploadStream(ctx, reader, options)
+	if err != nil {
+		if blobObject.writeCondition != nil && isAzureWriteConditionFailure(err) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, fname)
+	}
+	return true, nil
+}
+
+func (blobObject *azureBlobObject) Delete(ctx context.Context) error {
+	const fname = "azureBlobObject.Delete"
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	_, err := blobObject.blobClient.Delete(ctx, &blob.DeleteOptions{AccessConditions: blobObject.writeCondition})
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return nil
+	}
+	if bloberror.HasCode(err, bloberror.ConditionNotMet) {
+		err = errors.Wrapf(ErrBlobVersionChanged, "%s: %v", blobObject.String(), err)
+		return errors.Wrap(err, fname)
+	}
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	return nil
+}
+
+func (blobObject *azureBlobObject) String() string {
+	return fmt.Sprintf("%s/%s", blobObject.client.String(), blobObject.path)
+}
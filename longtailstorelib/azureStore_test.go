@@ -0,0 +1,188 @@
+package longtailstorelib
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/DanEngelbrecht/golongtail/longtaillib"
+)
+
+// These tests needs an Azure storage account or an Azurite emulator, for Azurite use
+// AZURE_STORAGE_CONNECTION_STRING="UseDevelopmentStorage=true"
+func createAzureTestStore(t *testing.T, path string) BlobStore {
+	connectionString := os.Getenv(azureConnectionStringEnv)
+	if connectionString == "" {
+		t.Skip()
+	}
+	client, err := azblob.NewClientFromConnectionString(connectionString, nil)
+	if err != nil {
+		t.Fatalf("azblob.NewClientFromConnectionString() err == %s", err)
+	}
+	_, err = client.CreateContainer(context.Background(), "longtail-test", nil)
+	if err != nil && !bloberror.HasCode(err, bloberror.ContainerAlreadyExists) {
+		t.Fatalf("client.CreateContainer() err == %s", err)
+	}
+	u, err := url.Parse("abfss://longtail-test/" + path)
+	if err != nil {
+		t.Errorf("url.Parse() err == %s", err)
+	}
+	blobStore, err := NewAzureBlobStore(u)
+	if err != nil {
+		t.Errorf("NewAzureBlobStore() err == %s", err)
+	}
+	return blobStore
+}
+
+func TestAzureBlobStore(t *testing.T) {
+	blobStore := createAzureTestStore(t, "test-azure-blob-store")
+	client, err := blobStore.NewClient(context.Background())
+	if err != nil {
+		t.Errorf("blobStore.NewClient() err == %s", err)
+	}
+	defer client.Close()
+	{
+		// Clean up any old test data
+		object, _ := client.NewObject("test.txt")
+		object.Delete(context.Background())
+		object, _ = client.NewObject("path/first.txt")
+		object.Delete(context.Background())
+		object, _ = client.NewObject("path/second.txt")
+		object.Delete(context.Background())
+	}
+	object, err := client.NewObject("test.txt")
+	if err != nil {
+		t.Errorf("client.NewObject() err == %s", err)
+	}
+
+	exists, err := object.Exists(context.Background())
+	if err != nil {
+		t.Error("object.Exists() err != nil")
+	}
+	if exists {
+		t.Error("object.Exists() true != false")
+	}
+
+	data, err := object.Read(context.Background())
+	if !longtaillib.IsNotExist(err) {
+		t.Errorf("object.Read() err == %s", err)
+	}
+
+	testData := []byte("apa")
+	ok, err := object.Write(context.Background(), testData)
+	if !ok {
+		t.Errorf("object.Write() ok != true")
+	}
+	if err != nil {
+		t.Errorf("object.Write() err == %s", err)
+	}
+
+	exists, err = object.Exists(context.Background())
+	if err != nil {
+		t.Error("object.Exists() err != nil")
+	}
+	if !exists {
+		t.Error("object.Exists() false != true")
+	}
+
+	data, err = object.Read(context.Background())
+	if err != nil {
+		t.Errorf("object.Read() err == %s", err)
+	}
+	if string(data) != string(testData) {
+		t.Errorf("object.Read() %q != %q", string(data), string(testData))
+	}
+
+	object, _ = client.NewObject("path/first.txt")
+	_, _ = object.Write(context.Background(), []byte("dog"))
+	object, _ = client.NewObject("path/second.txt")
+	_, _ = object.Write(context.Background(), []byte("cat"))
+
+	objects, _ := client.GetObjects("")
+	if len(objects) != 3 {
+		t.Errorf("len(objects) %d != 3", len(objects))
+	}
+
+	objects, _ = client.GetObjects("path/")
+	if len(objects) != 2 {
+		t.Errorf("len(objects) %d != 2", len(objects))
+	}
+}
+
+func TestAzureBlobStoreVersioning(t *testing.T) {
+	blobStore := createAzureTestStore(t, "test-azure-blob-store-versioning")
+	client, err := blobStore.NewClient(context.Background())
+	if err != nil {
+		t.Errorf("blobStore.NewClient() err == %s", err)
+	}
+	defer client.Close()
+	if !client.SupportsLocking() {
+		t.Error("client.SupportsLocking() false != true")
+	}
+	{
+		// Clean up any old test data
+		object, _ := client.NewObject("test.txt")
+		object.Delete(context.Background())
+	}
+	object, err := client.NewObject("test.txt")
+	if err != nil {
+		t.Errorf("client.NewObject() err == %s", err)
+	}
+	exists, err := object.LockWriteVersion(context.Background())
+	if err != nil {
+		t.Errorf("object.LockWriteVersion() err == %s", err)
+	}
+	if exists {
+		t.Errorf("object.LockWriteVersion() exists != false")
+	}
+	ok, err := object.Write(context.Background(), []byte("apa"))
+	if !ok {
+		t.Errorf("object.Write() ok != true")
+	}
+	if err != nil {
+		t.Errorf("object.Write() err == %s", err)
+	}
+	ok, err = object.Write(context.Background(), []byte("skapa"))
+	if ok {
+		t.Errorf("object.Write() ok != false")
+	}
+	if err != nil {
+		t.Errorf("object.Write() err == %s", err)
+	}
+	exists, err = object.LockWriteVersion(context.Background())
+	if err != nil {
+		t.Errorf("object.LockWriteVersion() err == %s", err)
+	}
+	if !exists {
+		t.Errorf("object.LockWriteVersion() exists == false")
+	}
+	ok, err = object.Write(context.Background(), []byte("skapa"))
+	if !ok {
+		t.Errorf("object.Write() ok == false")
+	}
+	if err != nil {
+		t.Errorf("object.Write() err == %s", err)
+	}
+	err = object.Delete(context.Background())
+	if err == nil {
+		t.Error("object.Delete() err != nil")
+	}
+	_, err = object.LockWriteVersion(context.Background())
+	if err != nil {
+		t.Errorf("object.LockWriteVersion() err == %s", err)
+	}
+	err = object.Delete(context.Background())
+	if err != nil {
+		t.Errorf("object.Delete() err == %s", err)
+	}
+	exists, err = object.Exists(context.Background())
+	if err != nil {
+		t.Error("object.Exists() err != nil")
+	}
+	if exists {
+		t.Error("object.Exists() true != false")
+	}
+}
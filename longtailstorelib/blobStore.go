@@ -2,27 +2,46 @@ package longtailstorelib
 
 import (
 	"context"
-	"fmt"
-	"net/url"
-	"strings"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
 // BlobObject
+// All operations are bound to the ctx passed to them, a network store also applies
+// the timeout set on ctx with WithOperationTimeout to each single operation
 type BlobObject interface {
 	// returns false, nil if the object does not exist
 	// returns false, err on error
 	// returns true, nil if the object exists
-	Exists() (bool, error)
+	Exists(ctx context.Context) (bool, error)
 
 	// Locked the version for Write and Delete operations
 	// If the underlying file has changed between the LockWriteVersion call
 	// and a Write or Delete operation the operation will fail
-	LockWriteVersion() (bool, error)
+	LockWriteVersion(ctx context.Context) (bool, error)
 
 	// returns nil, error on error
 	// returns []byte, nil on success
-	// returns nil, nil if the underlying file no longer exists
-	Read() ([]byte, error)
+	// returns nil, err wrapping os.ErrNotExist if the object does not exist
+	Read(ctx context.Context) ([]byte, error)
+
+	// Streaming variant of Read(), the operation timeout is not applied since
+	// the reader may outlive the call, the caller must Close() the returned reader
+	// returns nil, error on error
+	// returns io.ReadCloser, nil on success
+	// A missing object fails with an error wrapping os.ErrNotExist, either from
+	// OpenRead() or from the first Read() of the returned reader
+	OpenRead(ctx context.Context) (io.ReadCloser, error)
+
+	// Reads up to length bytes starting at offset, the result is shorter than
+	// length if the object ends before offset + length
+	// returns nil, error on error
+	// returns []byte, nil on success
+	// returns nil, err wrapping os.ErrNotExist if the object does not exist
+	ReadRange(ctx context.Context, offset int64, length int64) ([]byte, error)
 
 	// If no write condition is set:
 	//   returns true, nil on success
@@ -31,24 +50,115 @@ type BlobObject interface {
 	//   returns true, nil if a version locked write succeeded
 	//   returns false, nil if the write was prevented due to a version change
 	//   returns false, err on error
-	Write(data []byte) (bool, error)
+	Write(ctx context.Context, data []byte) (bool, error)
+
+	// Streaming variant of Write(), reads from reader until io.EOF, the operation
+	// timeout is not applied since the duration depends on the reader
+	// Same return values and write condition semantics as Write()
+	WriteFrom(ctx context.Context, reader io.Reader) (bool, error)
 
-	// Will return an error if a version lock is set with LockWriteVersion()
-	// and the underlying file has changed
-	Delete() error
+	// Will return an error wrapping ErrBlobVersionChanged if a version lock is set
+	// with LockWriteVersion() and the underlying file has changed
+	// Deleting an object that does not exist is not an error
+	Delete(ctx context.Context) error
 
 	String() string
 }
 
+// ErrBlobVersionChanged is wrapped by the error of a version locked Delete when the object
+// changed after it was locked
+var ErrBlobVersionChanged = errors.New("blob changed after it was locked")
+
+// SourcedBlobObject is implemented by objects that can be read from more than one copy, such as
+// the objects of a mirrored store, so a caller that finds a bad copy can read the other copies
+type SourcedBlobObject interface {
+	// Number of copies that can be read with ReadSource
+	SourceCount() int
+	// Same as Read() but only reads the copy at index source
+	ReadSource(ctx context.Context, source int) ([]byte, error)
+}
+
+// VersionedBlobObject is implemented by objects that can skip downloading content that has not changed
+type VersionedBlobObject interface {
+	// Reads the object unless it still has version, the version is store specific such as the generation
+	// of a GCS object or the ETag of an S3 or Azure object and is the same as BlobProperties.Version
+	// returns nil, version, false, nil if the object still has version
+	// returns data, current version, true, nil if the object was read, the version is empty if the store did not report one
+	// returns nil, "", false, err wrapping os.ErrNotExist if the object does not exist
+	ReadIfChanged(ctx context.Context, version string) ([]byte, string, bool, error)
+}
+
+// ReadObjectIfChanged reads object unless it still has version, see VersionedBlobObject
+// Objects that don't implement VersionedBlobObject are always read and report no version
+func ReadObjectIfChanged(ctx context.Context, object BlobObject, version string) ([]byte, string, bool, error) {
+	if versioned, ok := object.(VersionedBlobObject); ok {
+		return versioned.ReadIfChanged(ctx, version)
+	}
+	data, err := object.Read(ctx)
+	if err != nil {
+		return nil, "", false, err
+	}
+	return data, "", true, nil
+}
+
+// SizedBlobObject is implemented by objects that can return their size without reading or listing them
+type SizedBlobObject interface {
+	// returns the size of the object
+	// returns 0, err wrapping os.ErrNotExist if the object does not exist
+	Size(ctx context.Context) (int64, error)
+}
+
+// GetObjectSize returns the size of the object at path in client, the object is asked for its size if it
+// implements SizedBlobObject, otherwise the size is found by listing path
+// Returns 0, err wrapping os.ErrNotExist if the object does not exist
+func GetObjectSize(ctx context.Context, client BlobClient, path string) (int64, error) {
+	const fname = "GetObjectSize"
+	object, err := client.NewObject(path)
+	if err != nil {
+		return 0, errors.Wrap(err, fname)
+	}
+	size, err := getObjectSize(ctx, client, object, path)
+	if err != nil {
+		return 0, errors.Wrap(err, fname)
+	}
+	return size, nil
+}
+
+func getObjectSize(ctx context.Context, client BlobClient, object BlobObject, path string) (int64, error) {
+	if sized, ok := object.(SizedBlobObject); ok {
+		return sized.Size(ctx)
+	}
+	objects, err := client.GetObjects(path)
+	if err != nil {
+		return 0, err
+	}
+	for _, properties := range objects {
+		if properties.Name == path {
+			return properties.Size, nil
+		}
+	}
+	return 0, errors.Wrapf(os.ErrNotExist, "%s does not exist", object.String())
+}
+
 type BlobProperties struct {
 	Size int64
 	Name string
+	// Version changes whenever the content of the object changes, such as the generation
+	// of a GCS object or the ETag of an S3 or Azure object. Empty if the store can't tell
+	Version string
 }
 
+// BlobWalkFunc is called for each object found by BlobClient.WalkObjects
+// Returning an error stops the walk and the error is returned from WalkObjects
+type BlobWalkFunc func(properties BlobProperties) error
+
 // BlobClient
 type BlobClient interface {
 	NewObject(path string) (BlobObject, error)
 	GetObjects(pathPrefix string) ([]BlobProperties, error)
+	// Streaming variant of GetObjects, calls walkFn for each object as the listing
+	// progresses without collecting all the objects in memory
+	WalkObjects(pathPrefix string, walkFn BlobWalkFunc) error
 	SupportsLocking() bool
 	String() string
 	Close()
@@ -62,30 +172,83 @@ type BlobStore interface {
 
 type BlobStoreOption func(options interface{})
 
-func CreateBlobStoreForURI(uri string, opts ...BlobStoreOption) (BlobStore, error) {
-	// Special case since filepaths may not bare nicely as a url
-	if strings.HasPrefix(uri, "fsblob://") {
-		return NewFSBlobStore(uri[len("fsblob://"):], false)
+type operationTimeoutKey struct{}
+
+// WithOperationTimeout returns a copy of ctx which makes blob stores limit each
+// individual operation, such as reading or writing an object, to timeout
+// A zero timeout disables the limit
+func WithOperationTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, operationTimeoutKey{}, timeout)
+}
+
+// GetOperationTimeout returns the timeout set on ctx with WithOperationTimeout
+func GetOperationTimeout(ctx context.Context) time.Duration {
+	timeout, _ := ctx.Value(operationTimeoutKey{}).(time.Duration)
+	return timeout
+}
+
+// withOperationTimeout derives the context for a single operation on a network store
+func withOperationTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := GetOperationTimeout(ctx)
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+type blobStoreOptionsKey struct{}
+
+// WithBlobStoreOptions returns a copy of ctx carrying opts, they are added to the options of
+// stores opened by longtailutils URI helpers and remotestore.CreateBlockStoreForURI with that ctx
+func WithBlobStoreOptions(ctx context.Context, opts ...BlobStoreOption) context.Context {
+	allOpts := append(GetBlobStoreOptions(ctx), opts...)
+	return context.WithValue(ctx, blobStoreOptionsKey{}, allOpts)
+}
+
+// GetBlobStoreOptions returns the options set on ctx with WithBlobStoreOptions
+func GetBlobStoreOptions(ctx context.Context) []BlobStoreOption {
+	opts, _ := ctx.Value(blobStoreOptionsKey{}).([]BlobStoreOption)
+	return append([]BlobStoreOption{}, opts...)
+}
+
+// decorateBlobStore applies the wrappers requested by opts, such as encryption
+// Rate limiting is applied closest to the backend so it measures the stored size
+func decorateBlobStore(blobStore BlobStore, opts ...BlobStoreOption) (BlobStore, error) {
+	bandwidthOptions := GetBandwidthOptions(opts...)
+	if bandwidthOptions.Limits != nil {
+		blobStore = NewRateLimitedBlobStore(blobStore, bandwidthOptions.Limits)
 	}
-	// Special case for unc paths
-	if strings.HasPrefix(uri, UNCPrefix) {
-		return NewFSBlobStore(uri, false)
+	encryptionOptions := GetEncryptionOptions(opts...)
+	if encryptionOptions.KeyRing != nil {
+		return NewEncryptedBlobStore(blobStore, encryptionOptions.KeyRing)
 	}
-	blobStoreURL, err := url.Parse(uri)
-	if err == nil {
-		switch blobStoreURL.Scheme {
-		case "gs":
-			return NewGCSBlobStore(blobStoreURL, false)
-		case "s3":
-			return NewS3BlobStore(blobStoreURL, opts...)
-		case "abfs":
-			return nil, fmt.Errorf("azure Gen1 storage not yet implemented")
-		case "abfss":
-			return nil, fmt.Errorf("azure Gen2 storage not yet implemented")
-		case "file":
-			return NewFSBlobStore(blobStoreURL.Host+blobStoreURL.Path, false)
-		}
+	return blobStore, nil
+}
+
+func CreateBlobStoreForURI(uri string, opts ...BlobStoreOption) (BlobStore, error) {
+	const fname = "CreateBlobStoreForURI"
+	if u, scheme, ok := LookupBlobStoreScheme(uri); ok {
+		return scheme.NewBlobStore(u, opts...)
+	}
+	blobStore, err := NewFSBlobStore(uri, false)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
 	}
+	return decorateBlobStore(blobStore, opts...)
+}
 
-	return NewFSBlobStore(uri, false)
+// sliceRange returns the part of data covered by offset and length, clamped to the size of data
+func sliceRange(data []byte, offset int64, length int64) []byte {
+	size := int64(len(data))
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= size || length <= 0 {
+		return []byte{}
+	}
+	end := offset + length
+	if end > size {
+		end = size
+	}
+	return data[offset:end]
 }
@@ -0,0 +1,149 @@
+package longtailstorelib
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// BlobStoreFactory creates a BlobStore for a URI using a registered scheme
+type BlobStoreFactory func(u *url.URL, opts ...BlobStoreOption) (BlobStore, error)
+
+// BlobStoreSchemeDefaults holds the defaults used when opening a store with a scheme
+type BlobStoreSchemeDefaults struct {
+	// Upper limit for the default number of remote store workers, zero means no limit.
+	// Networked stores use this to avoid overflowing the network connection
+	MaxWorkerCount int
+	// The store can only be opened for reading
+	ReadOnly bool
+}
+
+// BlobStoreScheme is a scheme registered with RegisterBlobStoreScheme
+type BlobStoreScheme struct {
+	Name     string
+	Factory  BlobStoreFactory
+	Defaults BlobStoreSchemeDefaults
+}
+
+// NewBlobStore creates the store for u with the scheme factory and applies the
+// wrappers requested by opts on top of it, such as WithEncryptionKeyRing
+func (scheme BlobStoreScheme) NewBlobStore(u *url.URL, opts ...BlobStoreOption) (BlobStore, error) {
+	const fname = "BlobStoreScheme.NewBlobStore"
+	blobStore, err := scheme.Factory(u, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return decorateBlobStore(blobStore, opts...)
+}
+
+const networkedStoreMaxWorkerCount = 8
+
+var (
+	blobStoreSchemesMutex sync.RWMutex
+	blobStoreSchemes      = map[string]BlobStoreScheme{}
+)
+
+// RegisterBlobStoreScheme makes factory handle URIs with the given scheme in both
+// CreateBlobStoreForURI and remotestore.CreateBlockStoreForURI
+// Registering an already registered scheme replaces the previous factory
+func RegisterBlobStoreScheme(scheme string, factory BlobStoreFactory, defaults BlobStoreSchemeDefaults) {
+	scheme = strings.ToLower(scheme)
+	blobStoreSchemesMutex.Lock()
+	defer blobStoreSchemesMutex.Unlock()
+	blobStoreSchemes[scheme] = BlobStoreScheme{Name: scheme, Factory: factory, Defaults: defaults}
+}
+
+// UnregisterBlobStoreScheme removes a scheme registered with RegisterBlobStoreScheme
+func UnregisterBlobStoreScheme(scheme string) {
+	blobStoreSchemesMutex.Lock()
+	defer blobStoreSchemesMutex.Unlock()
+	delete(blobStoreSchemes, strings.ToLower(scheme))
+}
+
+// GetBlobStoreScheme returns the registered scheme, false if it is not registered
+func GetBlobStoreScheme(scheme string) (BlobStoreScheme, bool) {
+	blobStoreSchemesMutex.RLock()
+	defer blobStoreSchemesMutex.RUnlock()
+	s, exists := blobStoreSchemes[strings.ToLower(scheme)]
+	return s, exists
+}
+
+// LookupBlobStoreScheme parses uri and finds its registered scheme
+// Returns false if uri is a plain path or uses a scheme that is not registered
+func LookupBlobStoreScheme(uri string) (*url.URL, BlobStoreScheme, bool) {
+	// Special case since filepaths may not parse nicely as a url
+	if strings.HasPrefix(uri, "fsblob://") {
+		scheme, exists := GetBlobStoreScheme("fsblob")
+		return &url.URL{Scheme: "fsblob", Path: uri[len("fsblob://"):]}, scheme, exists
+	}
+	// Special case for unc paths
+	if strings.HasPrefix(uri, UNCPrefix) {
+		return nil, BlobStoreScheme{}, false
+	}
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme == "" {
+		return nil, BlobStoreScheme{}, false
+	}
+	scheme, exists := GetBlobStoreScheme(u.Scheme)
+	if exists {
+		return u, scheme, true
+	}
+	// Wrapping schemes such as `fault+s3://` are handled by the wrapper factory
+	// using the defaults of the wrapped scheme
+	wrapperName, innerName, isWrapped := strings.Cut(u.Scheme, "+")
+	if !isWrapped {
+		return nil, BlobStoreScheme{}, false
+	}
+	wrapper, wrapperExists := GetBlobStoreScheme(wrapperName)
+	inner, innerExists := GetBlobStoreScheme(innerName)
+	if !wrapperExists || !innerExists {
+		return nil, BlobStoreScheme{}, false
+	}
+	return u, BlobStoreScheme{Name: strings.ToLower(u.Scheme), Factory: wrapper.Factory, Defaults: inner.Defaults}, true
+}
+
+// FileSystemPathFromURL returns the local file system path of a file:// url
+func FileSystemPathFromURL(u *url.URL) string {
+	path := u.Host + u.Path
+	// file:///c:/path has a leading slash before the drive letter
+	if len(path) > 2 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+	return path
+}
+
+func init() {
+	networked := BlobStoreSchemeDefaults{MaxWorkerCount: networkedStoreMaxWorkerCount}
+	// fsblob:// stores always lock objects, as block stores always did, so the store index, leases and
+	// prune candidates of local stores can be updated safely. file:// stores don't lock objects
+	RegisterBlobStoreScheme("fsblob", func(u *url.URL, opts ...BlobStoreOption) (BlobStore, error) {
+		return NewFSBlobStore(u.Path, true)
+	}, BlobStoreSchemeDefaults{})
+	RegisterBlobStoreScheme("file", func(u *url.URL, opts ...BlobStoreOption) (BlobStore, error) {
+		return NewFSBlobStore(FileSystemPathFromURL(u), false)
+	}, BlobStoreSchemeDefaults{})
+	RegisterBlobStoreScheme("mem", func(u *url.URL, opts ...BlobStoreOption) (BlobStore, error) {
+		return NewNamedMemBlobStore(u.Host, u.Path)
+	}, BlobStoreSchemeDefaults{})
+	RegisterBlobStoreScheme("gs", func(u *url.URL, opts ...BlobStoreOption) (BlobStore, error) {
+		return NewGCSBlobStore(u, false)
+	}, networked)
+	RegisterBlobStoreScheme("s3", func(u *url.URL, opts ...BlobStoreOption) (BlobStore, error) {
+		return NewS3BlobStore(u, opts...)
+	}, networked)
+	azureFactory := func(u *url.URL, opts ...BlobStoreOption) (BlobStore, error) {
+		return NewAzureBlobStore(u)
+	}
+	RegisterBlobStoreScheme("abfs", azureFactory, networked)
+	RegisterBlobStoreScheme("abfss", azureFactory, networked)
+	httpFactory := func(u *url.URL, opts ...BlobStoreOption) (BlobStore, error) {
+		return NewHTTPBlobStore(u)
+	}
+	RegisterBlobStoreScheme("fault", newFaultyBlobStoreForURI, BlobStoreSchemeDefaults{})
+	RegisterBlobStoreScheme("mirror", newMirroredBlobStoreForURI, networked)
+	readOnlyNetworked := BlobStoreSchemeDefaults{MaxWorkerCount: networkedStoreMaxWorkerCount, ReadOnly: true}
+	RegisterBlobStoreScheme("http", httpFactory, readOnlyNetworked)
+	RegisterBlobStoreScheme("https", httpFactory, readOnlyNetworked)
+}
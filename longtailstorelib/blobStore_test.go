@@ -3,7 +3,9 @@ package longtailstorelib
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/url"
 	"sort"
 	"strings"
 	"testing"
@@ -11,13 +13,14 @@ import (
 
 	"github.com/DanEngelbrecht/golongtail/longtaillib"
 	"github.com/alecthomas/assert/v2"
+	"github.com/pkg/errors"
 )
 
 func TestCreateStoreAndClient(t *testing.T) {
 	blobStore, err := NewMemBlobStore("the_path", true)
 	assert.NoError(t, err, "NewMemBlobStore()")
 	client, err := blobStore.NewClient(context.Background())
-	assert.NoError(t, err, "blobStore.NewClient(context.Background())")
+	assert.NoError(t, err, "blobStore.NewClient()")
 	defer client.Close()
 }
 
@@ -29,7 +32,7 @@ func TestListObjectsInEmptyStore(t *testing.T) {
 	assert.NoError(t, err, "client.GetObjects(\"\"))")
 	assert.Equal(t, len(objects), 0)
 	obj, _ := client.NewObject("should-not-exist")
-	data, err := obj.Read()
+	data, err := obj.Read(context.Background())
 	assert.True(t, longtaillib.IsNotExist(err))
 	assert.Equal(t, data, nil)
 }
@@ -40,18 +43,18 @@ func TestSingleObjectStore(t *testing.T) {
 	defer client.Close()
 	obj, err := client.NewObject("my-fine-object.txt")
 	assert.NoError(t, err, "client.NewObject(\"my-fine-object.txt\")")
-	exists, _ := obj.Exists()
+	exists, _ := obj.Exists(context.Background())
 	assert.False(t, exists, "obj.Exists()")
 	testContent := "the content of the object"
-	ok, err := obj.Write([]byte(testContent))
+	ok, err := obj.Write(context.Background(), []byte(testContent))
 	assert.NoError(t, err, "obj.Write([]byte(testContent))")
 	assert.True(t, ok, "obj.Write([]byte(testContent))")
 	assert.NoError(t, err, "obj.Write([]byte(testContent)")
-	data, err := obj.Read()
+	data, err := obj.Read(context.Background())
 	assert.NoError(t, err, "obj.Read()")
 	dataString := string(data)
 	assert.Equal(t, dataString, testContent)
-	err = obj.Delete()
+	err = obj.Delete(context.Background())
 	assert.NoError(t, err, "obj.Delete()")
 }
 
@@ -61,9 +64,9 @@ func TestDeleteObject(t *testing.T) {
 	defer client.Close()
 	obj, _ := client.NewObject("my-fine-object.txt")
 	testContent := "the content of the object"
-	_, _ = obj.Write([]byte(testContent))
-	obj.Delete()
-	exists, err := obj.Exists()
+	_, _ = obj.Write(context.Background(), []byte(testContent))
+	obj.Delete(context.Background())
+	exists, err := obj.Exists(context.Background())
 	assert.NoError(t, err, "obj.Exists()")
 	assert.False(t, exists, "obj.Exists()")
 }
@@ -73,11 +76,11 @@ func TestListObjects(t *testing.T) {
 	client, _ := blobStore.NewClient(context.Background())
 	defer client.Close()
 	obj, _ := client.NewObject("my-fine-object1.txt")
-	obj.Write([]byte("my-fine-object1.txt"))
+	obj.Write(context.Background(), []byte("my-fine-object1.txt"))
 	obj, _ = client.NewObject("my-fine-object2.txt")
-	obj.Write([]byte("my-fine-object2.txt"))
+	obj.Write(context.Background(), []byte("my-fine-object2.txt"))
 	obj, _ = client.NewObject("my-fine-object3.txt")
-	obj.Write([]byte("my-fine-object3.txt"))
+	obj.Write(context.Background(), []byte("my-fine-object3.txt"))
 	objects, err := client.GetObjects("")
 	assert.NoError(t, err, "TestListObjects() client.GetObjects(\"\")")
 	assert.Equal(t, len(objects), 3)
@@ -85,13 +88,95 @@ func TestListObjects(t *testing.T) {
 		readObj, err := client.NewObject(o.Name)
 		assert.NoError(t, err)
 		assert.NotEqual(t, readObj, nil)
-		data, err := readObj.Read()
+		data, err := readObj.Read(context.Background())
 		assert.NoError(t, err, nil)
 		stringData := string(data)
 		assert.Equal(t, stringData, o.Name)
 	}
 }
 
+func TestWalkObjects(t *testing.T) {
+	blobStore, _ := NewMemBlobStore("the_path", true)
+	client, _ := blobStore.NewClient(context.Background())
+	defer client.Close()
+	for i := 0; i < 5; i++ {
+		obj, _ := client.NewObject(fmt.Sprintf("walk/object%d.txt", i))
+		obj.Write(context.Background(), []byte("data"))
+	}
+	obj, _ := client.NewObject("other.txt")
+	obj.Write(context.Background(), []byte("data"))
+
+	var names []string
+	err := client.WalkObjects("walk/", func(properties BlobProperties) error {
+		assert.Equal(t, int64(4), properties.Size)
+		names = append(names, properties.Name)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(names))
+
+	stopErr := fmt.Errorf("stop")
+	count := 0
+	err = client.WalkObjects("", func(properties BlobProperties) error {
+		count++
+		return stopErr
+	})
+	assert.Equal(t, stopErr, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestReadRangeAndStreaming(t *testing.T) {
+	blobStore, _ := NewMemBlobStore("the_path", true)
+	client, _ := blobStore.NewClient(context.Background())
+	defer client.Close()
+	obj, _ := client.NewObject("ranged.txt")
+	ok, err := obj.WriteFrom(context.Background(), strings.NewReader("0123456789"))
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	data, err := obj.ReadRange(context.Background(), 2, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "2345", string(data))
+	data, err = obj.ReadRange(context.Background(), 8, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "89", string(data))
+	data, err = obj.ReadRange(context.Background(), 20, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(data))
+
+	reader, err := obj.OpenRead(context.Background())
+	assert.NoError(t, err)
+	data, err = ioutil.ReadAll(reader)
+	reader.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, "0123456789", string(data))
+
+	missing, _ := client.NewObject("missing.txt")
+	_, err = missing.OpenRead(context.Background())
+	assert.True(t, longtaillib.IsNotExist(err))
+	_, err = missing.ReadRange(context.Background(), 0, 10)
+	assert.True(t, longtaillib.IsNotExist(err))
+}
+
+func TestGetObjectSize(t *testing.T) {
+	ctx := context.Background()
+	memStore, _ := NewMemBlobStore("the_path", true)
+	fsStore, _ := NewFSBlobStore(t.TempDir(), true)
+	mirrorStore, _ := NewMirroredBlobStore([]BlobStore{memStore, fsStore}, MirrorOptions{})
+	for _, blobStore := range []BlobStore{memStore, fsStore, mirrorStore} {
+		client, _ := blobStore.NewClient(ctx)
+		object, _ := client.NewObject("chunks/0000/block.lsb")
+		_, err := object.Write(ctx, []byte("0123456789"))
+		assert.NoError(t, err)
+		size, err := GetObjectSize(ctx, client, "chunks/0000/block.lsb")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(10), size)
+		_, err = GetObjectSize(ctx, client, "chunks/0000/block")
+		assert.True(t, longtaillib.IsNotExist(err))
+		client.Close()
+	}
+}
+
 func TestGenerationWrite(t *testing.T) {
 	blobStore, _ := NewMemBlobStore("the_path", true)
 	client, _ := blobStore.NewClient(context.Background())
@@ -100,33 +185,33 @@ func TestGenerationWrite(t *testing.T) {
 	testContent1 := "the content of the object1"
 	testContent2 := "the content of the object2"
 	testContent3 := "the content of the object3"
-	exists, err := obj.LockWriteVersion()
+	exists, err := obj.LockWriteVersion(context.Background())
 	assert.False(t, exists)
 	assert.NoError(t, err, "obj.LockWriteVersion()")
-	ok, err := obj.Write([]byte(testContent1))
+	ok, err := obj.Write(context.Background(), []byte(testContent1))
 	assert.True(t, ok)
 	assert.True(t, ok)
 	assert.NoError(t, err, "obj.Write([]byte(testContent1)")
-	ok, err = obj.Write([]byte(testContent2))
+	ok, err = obj.Write(context.Background(), []byte(testContent2))
 	assert.False(t, ok)
 	assert.NoError(t, err, "obj.Write([]byte(testContent2))")
 	obj2, _ := client.NewObject("my-fine-object.txt")
-	exists, err = obj.LockWriteVersion()
+	exists, err = obj.LockWriteVersion(context.Background())
 	assert.True(t, exists)
 	assert.NoError(t, err, "obj.LockWriteVersion()")
-	exists, err = obj2.LockWriteVersion()
+	exists, err = obj2.LockWriteVersion(context.Background())
 	assert.True(t, exists)
 	assert.NoError(t, err, "obj2.LockWriteVersion()")
-	ok, err = obj.Write([]byte(testContent2))
+	ok, err = obj.Write(context.Background(), []byte(testContent2))
 	assert.True(t, ok)
 	assert.NoError(t, err, "obj.Write([]byte(testContent2))")
-	ok, err = obj2.Write([]byte(testContent3))
+	ok, err = obj2.Write(context.Background(), []byte(testContent3))
 	assert.False(t, ok)
 	assert.NoError(t, err, "obj2.Write([]byte(testContent3))")
-	err = obj.Delete()
-	assert.Error(t, err)
-	obj.LockWriteVersion()
-	err = obj.Delete()
+	err = obj.Delete(context.Background())
+	assert.True(t, errors.Is(err, ErrBlobVersionChanged))
+	obj.LockWriteVersion(context.Background())
+	err = obj.Delete(context.Background())
 	assert.NoError(t, err, "obj.Delete()")
 }
 
@@ -142,13 +227,13 @@ func writeANumberWithRetry(number int, blobStore BlobStore) error {
 	}
 	retries := 0
 	for {
-		exists, err := object.LockWriteVersion()
+		exists, err := object.LockWriteVersion(context.Background())
 		if err != nil {
 			return err
 		}
 		var sliceData []string
 		if exists {
-			data, err := object.Read()
+			data, err := object.Read(context.Background())
 			if err != nil {
 				return err
 			}
@@ -159,7 +244,7 @@ func writeANumberWithRetry(number int, blobStore BlobStore) error {
 		sort.Strings(sliceData)
 		newData := strings.Join(sliceData, "\n")
 
-		ok, err := object.Write([]byte(newData))
+		ok, err := object.Write(context.Background(), []byte(newData))
 		if err != nil {
 			return err
 		}
@@ -210,11 +295,115 @@ func TestCreateFileBlobStoreFromPath(t *testing.T) {
 }
 
 func TestCreateAzureGen1BlobStoreFromPath(t *testing.T) {
-	_, err := CreateBlobStoreForURI("abfs://my-blob-store")
-	assert.Error(t, err)
+	blobStore, err := CreateBlobStoreForURI("abfs://my-container@my-account.dfs.core.windows.net/store")
+	assert.NoError(t, err)
+	assert.Equal(t, "abfs://my-container@my-account/store/", blobStore.String())
 }
 
 func TestCreateAzureGen2BlobStoreFromPath(t *testing.T) {
-	_, err := CreateBlobStoreForURI("abfss://my-blob-store")
+	blobStore, err := CreateBlobStoreForURI("abfss://my-container@my-account.dfs.core.windows.net/store")
+	assert.NoError(t, err)
+	assert.Equal(t, "abfss://my-container@my-account/store/", blobStore.String())
+}
+
+func TestRegisterBlobStoreScheme(t *testing.T) {
+	memStore, _ := NewMemBlobStore("registered", true)
+	RegisterBlobStoreScheme("test-scheme", func(u *url.URL, opts ...BlobStoreOption) (BlobStore, error) {
+		assert.Equal(t, "my-store", u.Host)
+		return memStore, nil
+	}, BlobStoreSchemeDefaults{MaxWorkerCount: 2, ReadOnly: true})
+	defer UnregisterBlobStoreScheme("test-scheme")
+
+	blobStore, err := CreateBlobStoreForURI("test-scheme://my-store/path")
+	assert.NoError(t, err)
+	assert.Equal(t, memStore, blobStore)
+
+	_, scheme, ok := LookupBlobStoreScheme("TEST-SCHEME://my-store")
+	assert.True(t, ok)
+	assert.Equal(t, "test-scheme", scheme.Name)
+	assert.Equal(t, 2, scheme.Defaults.MaxWorkerCount)
+	assert.True(t, scheme.Defaults.ReadOnly)
+
+	_, _, ok = LookupBlobStoreScheme("unknown-scheme://my-store")
+	assert.False(t, ok)
+	_, _, ok = LookupBlobStoreScheme("c:\\temp\\my-blob-store")
+	assert.False(t, ok)
+
+	gcsScheme, ok := GetBlobStoreScheme("gs")
+	assert.True(t, ok)
+	assert.Equal(t, 8, gcsScheme.Defaults.MaxWorkerCount)
+	fsScheme, ok := GetBlobStoreScheme("fsblob")
+	assert.True(t, ok)
+	assert.Equal(t, 0, fsScheme.Defaults.MaxWorkerCount)
+
+	UnregisterBlobStoreScheme("TEST-SCHEME")
+	_, _, ok = LookupBlobStoreScheme("test-scheme://my-store")
+	assert.False(t, ok)
+}
+
+func TestFSBlobSchemeLocking(t *testing.T) {
+	for uri, supportsLocking := range map[string]bool{"fsblob://" + t.TempDir(): true, "file://" + t.TempDir(): false} {
+		blobStore, err := CreateBlobStoreForURI(uri)
+		assert.NoError(t, err)
+		client, err := blobStore.NewClient(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, supportsLocking, client.SupportsLocking(), uri)
+		client.Close()
+	}
+}
+
+func TestFileSystemPathFromURL(t *testing.T) {
+	u, _ := url.Parse("file:///tmp/my-blob-store")
+	assert.Equal(t, "/tmp/my-blob-store", FileSystemPathFromURL(u))
+	u, _ = url.Parse("file:///c:/temp/my-blob-store")
+	assert.Equal(t, "c:/temp/my-blob-store", FileSystemPathFromURL(u))
+	u, _ = url.Parse("file://my-blob-store")
+	assert.Equal(t, "my-blob-store", FileSystemPathFromURL(u))
+}
+
+func TestNamedMemBlobStore(t *testing.T) {
+	defer DeleteNamedMemBlobStore("named-store")
+	err := SeedMemBlobs("mem://named-store/a", map[string][]byte{"first.txt": []byte("first"), "sub/second.txt": []byte("second")})
+	assert.NoError(t, err)
+
+	blobStore, err := CreateBlobStoreForURI("mem://named-store/a")
+	assert.NoError(t, err)
+	assert.Equal(t, "mem://named-store/a/", blobStore.String())
+	client, _ := blobStore.NewClient(context.Background())
+	defer client.Close()
+	assert.True(t, client.SupportsLocking())
+	object, _ := client.NewObject("first.txt")
+	data, err := object.Read(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "first", string(data))
+	assert.Equal(t, "mem://named-store/a/first.txt", object.String())
+	objects, _ := client.GetObjects("sub/")
+	assert.Equal(t, 1, len(objects))
+	assert.Equal(t, BlobProperties{Name: "sub/second.txt", Size: 6, Version: objects[0].Version}, objects[0])
+	assert.NotEqual(t, "", objects[0].Version)
+
+	// A store with the same name shares the content, the path selects a part of it
+	rootStore, _ := CreateBlobStoreForURI("mem://named-store")
+	rootClient, _ := rootStore.NewClient(context.Background())
+	defer rootClient.Close()
+	object, _ = rootClient.NewObject("b/third.txt")
+	_, err = object.Write(context.Background(), []byte("third"))
+	assert.NoError(t, err)
+
+	blobs, err := GetMemBlobs("mem://named-store")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{
+		"a/first.txt":      []byte("first"),
+		"a/sub/second.txt": []byte("second"),
+		"b/third.txt":      []byte("third")}, blobs)
+
+	DeleteNamedMemBlobStore("named-store")
+	blobs, err = GetMemBlobs("mem://named-store")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(blobs))
+
+	_, err = CreateBlobStoreForURI("mem:///no-name")
+	assert.Error(t, err)
+	_, err = GetMemBlobs("fsblob://not-in-memory")
 	assert.Error(t, err)
 }
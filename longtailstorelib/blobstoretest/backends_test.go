@@ -0,0 +1,162 @@
+package blobstoretest_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/DanEngelbrecht/golongtail/longtailstorelib"
+	"github.com/DanEngelbrecht/golongtail/longtailstorelib/blobstoretest"
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestMemBlobStore(t *testing.T) {
+	blobstoretest.Run(t, func(t *testing.T) longtailstorelib.BlobStore {
+		blobStore, err := longtailstorelib.NewMemBlobStore("", true)
+		assert.NoError(t, err)
+		return blobStore
+	}, blobstoretest.Options{})
+}
+
+func TestMemBlobStoreWithoutLocking(t *testing.T) {
+	blobstoretest.Run(t, func(t *testing.T) longtailstorelib.BlobStore {
+		blobStore, err := longtailstorelib.NewMemBlobStore("", false)
+		assert.NoError(t, err)
+		return blobStore
+	}, blobstoretest.Options{})
+}
+
+func TestNamedMemBlobStore(t *testing.T) {
+	blobstoretest.Run(t, func(t *testing.T) longtailstorelib.BlobStore {
+		name := strings.ReplaceAll(t.Name(), "/", "_")
+		t.Cleanup(func() { longtailstorelib.DeleteNamedMemBlobStore(name) })
+		blobStore, err := longtailstorelib.CreateBlobStoreForURI("mem://" + name + "/store")
+		assert.NoError(t, err)
+		return blobStore
+	}, blobstoretest.Options{})
+}
+
+func TestFSBlobStore(t *testing.T) {
+	blobstoretest.Run(t, func(t *testing.T) longtailstorelib.BlobStore {
+		blobStore, err := longtailstorelib.NewFSBlobStore(t.TempDir(), true)
+		assert.NoError(t, err)
+		return blobStore
+	}, blobstoretest.Options{})
+}
+
+func TestFSBlobStoreWithoutLocking(t *testing.T) {
+	blobstoretest.Run(t, func(t *testing.T) longtailstorelib.BlobStore {
+		blobStore, err := longtailstorelib.NewFSBlobStore(t.TempDir(), false)
+		assert.NoError(t, err)
+		return blobStore
+	}, blobstoretest.Options{})
+}
+
+func TestDecoratedBlobStores(t *testing.T) {
+	keyRing, err := longtailstorelib.ParseEncryptionKeys("key1:MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	assert.NoError(t, err)
+	decorations := []struct {
+		name    string
+		opts    []longtailstorelib.BlobStoreOption
+		options blobstoretest.Options
+	}{
+		{"Encrypted", []longtailstorelib.BlobStoreOption{longtailstorelib.WithEncryptionKeyRing(keyRing)}, blobstoretest.Options{IgnoreListedSize: true}},
+		{"RateLimited", []longtailstorelib.BlobStoreOption{longtailstorelib.WithBandwidthLimits(longtailstorelib.NewBandwidthLimits(0, 0, 0))}, blobstoretest.Options{}},
+	}
+	for _, decoration := range decorations {
+		decoration := decoration
+		t.Run(decoration.name, func(t *testing.T) {
+			blobstoretest.Run(t, func(t *testing.T) longtailstorelib.BlobStore {
+				blobStore, err := longtailstorelib.CreateBlobStoreForURI("fsblob://"+t.TempDir(), decoration.opts...)
+				assert.NoError(t, err)
+				return blobStore
+			}, decoration.options)
+		})
+	}
+	t.Run("Faulty", func(t *testing.T) {
+		blobstoretest.Run(t, func(t *testing.T) longtailstorelib.BlobStore {
+			backingStore, _ := longtailstorelib.NewMemBlobStore("", true)
+			return longtailstorelib.NewFaultyBlobStore(backingStore, longtailstorelib.FaultConfig{})
+		}, blobstoretest.Options{})
+	})
+}
+
+func TestMirroredBlobStore(t *testing.T) {
+	for _, writeMode := range []longtailstorelib.MirrorWriteMode{longtailstorelib.MirrorWritePrimary, longtailstorelib.MirrorWriteAll} {
+		writeMode := writeMode
+		t.Run(string(writeMode), func(t *testing.T) {
+			blobstoretest.Run(t, func(t *testing.T) longtailstorelib.BlobStore {
+				primary, _ := longtailstorelib.NewMemBlobStore("", true)
+				replica, _ := longtailstorelib.NewMemBlobStore("", true)
+				blobStore, err := longtailstorelib.NewMirroredBlobStore([]longtailstorelib.BlobStore{primary, replica}, longtailstorelib.MirrorOptions{WriteMode: writeMode})
+				assert.NoError(t, err)
+				return blobStore
+			}, blobstoretest.Options{})
+		})
+	}
+}
+
+// createCloudTestStore opens a unique path below the store URI in the environment variable
+// uriVariable and deletes everything written to it when the test completes
+func createCloudTestStore(t *testing.T, uriVariable string, opts ...longtailstorelib.BlobStoreOption) longtailstorelib.BlobStore {
+	storeURI := os.Getenv(uriVariable)
+	if storeURI == "" {
+		t.Skipf("set %s to run against a real store", uriVariable)
+	}
+	path := fmt.Sprintf("%s/%s-%08x", strings.TrimSuffix(storeURI, "/"), strings.ReplaceAll(t.Name(), "/", "_"), rand.Uint32())
+	blobStore, err := longtailstorelib.CreateBlobStoreForURI(path, opts...)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		client, err := blobStore.NewClient(context.Background())
+		if err != nil {
+			return
+		}
+		defer client.Close()
+		objects, _ := client.GetObjects("")
+		for _, o := range objects {
+			if object, err := client.NewObject(o.Name); err == nil {
+				object.Delete(context.Background())
+			}
+		}
+	})
+	return blobStore
+}
+
+// To run against a local MinIO set
+// LONGTAIL_TEST_S3_URI=s3://<bucket>/<path>
+// LONGTAIL_TEST_S3_ENDPOINT=http://127.0.0.1:9000
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_REGION
+func TestS3BlobStore(t *testing.T) {
+	endpoint := os.Getenv("LONGTAIL_TEST_S3_ENDPOINT")
+	blobstoretest.Run(t, func(t *testing.T) longtailstorelib.BlobStore {
+		return createCloudTestStore(t, "LONGTAIL_TEST_S3_URI", func(options interface{}) {
+			s3Options, ok := options.(*longtailstorelib.S3Options)
+			if !ok {
+				return
+			}
+			s3Options.EnableLocking = true
+			if endpoint != "" {
+				s3Options.EndpointResolverURI = endpoint
+				s3Options.UsePathStyle = true
+			}
+		})
+	}, blobstoretest.Options{})
+}
+
+// Set LONGTAIL_TEST_GCS_URI=gs://<bucket>/<path> and use application default credentials
+func TestGCSBlobStore(t *testing.T) {
+	blobstoretest.Run(t, func(t *testing.T) longtailstorelib.BlobStore {
+		return createCloudTestStore(t, "LONGTAIL_TEST_GCS_URI")
+	}, blobstoretest.Options{})
+}
+
+// Set LONGTAIL_TEST_AZURE_URI=abfss://<container>@<account>.dfs.core.windows.net/<path>
+// and AZURE_STORAGE_CONNECTION_STRING or AZURE_STORAGE_KEY, Azurite works as well
+func TestAzureBlobStore(t *testing.T) {
+	blobstoretest.Run(t, func(t *testing.T) longtailstorelib.BlobStore {
+		return createCloudTestStore(t, "LONGTAIL_TEST_AZURE_URI")
+	}, blobstoretest.Options{})
+}
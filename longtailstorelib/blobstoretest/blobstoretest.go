@@ -0,0 +1,670 @@
+// Package blobstoretest provides a contract test suite for longtailstorelib.BlobStore
+// implementations so that all backends behave the same way towards the remote store.
+//
+// A backend runs the suite from a regular test:
+//
+//	func TestMyBlobStore(t *testing.T) {
+//		blobstoretest.Run(t, func(t *testing.T) longtailstorelib.BlobStore {
+//			return newEmptyMyBlobStore(t)
+//		}, blobstoretest.Options{})
+//	}
+package blobstoretest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/DanEngelbrecht/golongtail/longtailstorelib"
+	"github.com/alecthomas/assert/v2"
+	"github.com/pkg/errors"
+)
+
+// DefaultLargeObjectSize is large enough to cross the multipart thresholds of the cloud backends
+const DefaultLargeObjectSize = 8*1024*1024 + 17
+
+// StoreFactory returns a new empty store, it is called once for each test in the suite
+// Use t.Cleanup to remove any data written by the test
+type StoreFactory func(t *testing.T) longtailstorelib.BlobStore
+
+// Options adjusts the suite to the store under test
+type Options struct {
+	// Size in bytes of the object written by the large object test, zero uses DefaultLargeObjectSize
+	// and a negative size skips the test
+	LargeObjectSize int
+	// Number of goroutines racing for the same object in the locking tests, zero uses 5
+	Concurrency int
+	// Don't check the sizes reported by GetObjects and WalkObjects, for stores that list the
+	// size of the stored data rather than the content, such as encrypted stores
+	IgnoreListedSize bool
+}
+
+// Run runs the contract suite against the stores created by newStore, each part of the
+// contract runs as a subtest. Locking tests are skipped for stores that do not support locking
+func Run(t *testing.T, newStore StoreFactory, options Options) {
+	if options.LargeObjectSize == 0 {
+		options.LargeObjectSize = DefaultLargeObjectSize
+	}
+	if options.Concurrency == 0 {
+		options.Concurrency = 5
+	}
+	tests := []struct {
+		name string
+		test func(t *testing.T, blobStore longtailstorelib.BlobStore, options Options)
+	}{
+		{"MissingObject", testMissingObject},
+		{"WriteRead", testWriteRead},
+		{"EmptyObject", testEmptyObject},
+		{"ReadRange", testReadRange},
+		{"Streaming", testStreaming},
+		{"LargeObject", testLargeObject},
+		{"Delete", testDelete},
+		{"ListObjects", testListObjects},
+		{"WalkObjectsStop", testWalkObjectsStop},
+		{"ListedVersion", testListedVersion},
+		{"ReadIfChanged", testReadIfChanged},
+		{"ConcurrentWrites", testConcurrentWrites},
+		{"LockNewObject", testLockNewObject},
+		{"LockExistingObject", testLockExistingObject},
+		{"LockedDelete", testLockedDelete},
+		{"LockingRace", testLockingRace},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			blobStore := newStore(t)
+			test.test(t, blobStore, options)
+		})
+	}
+}
+
+func newClient(t *testing.T, blobStore longtailstorelib.BlobStore) longtailstorelib.BlobClient {
+	client, err := blobStore.NewClient(context.Background())
+	assert.NoError(t, err)
+	t.Cleanup(client.Close)
+	return client
+}
+
+func newObject(t *testing.T, client longtailstorelib.BlobClient, path string) longtailstorelib.BlobObject {
+	object, err := client.NewObject(path)
+	assert.NoError(t, err)
+	return object
+}
+
+func write(t *testing.T, object longtailstorelib.BlobObject, data []byte) {
+	ok, err := object.Write(context.Background(), data)
+	assert.NoError(t, err, object.String())
+	assert.True(t, ok, object.String())
+}
+
+func requireLocking(t *testing.T, client longtailstorelib.BlobClient) {
+	if !client.SupportsLocking() {
+		t.Skipf("%s does not support locking", client.String())
+	}
+}
+
+func testMissingObject(t *testing.T, blobStore longtailstorelib.BlobStore, options Options) {
+	ctx := context.Background()
+	client := newClient(t, blobStore)
+	object := newObject(t, client, "missing/object.txt")
+
+	exists, err := object.Exists(ctx)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	data, err := object.Read(ctx)
+	assert.True(t, errors.Is(err, os.ErrNotExist), "Read of a missing object must fail with os.ErrNotExist, got %v", err)
+	assert.Equal(t, 0, len(data))
+
+	reader, err := object.OpenRead(ctx)
+	if err == nil {
+		// Some stores only report a missing object when reading starts
+		_, err = io.ReadAll(reader)
+		reader.Close()
+	}
+	assert.True(t, errors.Is(err, os.ErrNotExist), "OpenRead of a missing object must fail with os.ErrNotExist, got %v", err)
+
+	_, err = object.ReadRange(ctx, 0, 10)
+	assert.True(t, errors.Is(err, os.ErrNotExist), "ReadRange of a missing object must fail with os.ErrNotExist, got %v", err)
+
+	objects, err := client.GetObjects("missing")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(objects))
+}
+
+func testWriteRead(t *testing.T, blobStore longtailstorelib.BlobStore, options Options) {
+	ctx := context.Background()
+	client := newClient(t, blobStore)
+	object := newObject(t, client, "folder/object.txt")
+
+	write(t, object, []byte("first version"))
+	exists, err := object.Exists(ctx)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	data, err := object.Read(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "first version", string(data))
+
+	// Writes replace the content and are visible to other objects and clients with the same path
+	write(t, object, []byte("second"))
+	otherObject := newObject(t, newClient(t, blobStore), "folder/object.txt")
+	data, err = otherObject.Read(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", string(data))
+
+	// The written data is copied, changing the buffer afterwards does not change the object
+	buffer := []byte("buffer")
+	write(t, object, buffer)
+	buffer[0] = 'X'
+	data, _ = object.Read(ctx)
+	assert.Equal(t, "buffer", string(data))
+}
+
+func testEmptyObject(t *testing.T, blobStore longtailstorelib.BlobStore, options Options) {
+	ctx := context.Background()
+	client := newClient(t, blobStore)
+	object := newObject(t, client, "empty")
+	write(t, object, []byte{})
+	exists, err := object.Exists(ctx)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	data, err := object.Read(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(data))
+	objects, err := client.GetObjects("empty")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"empty"}, sortedNames(objects))
+	if !options.IgnoreListedSize {
+		assert.Equal(t, int64(0), objects[0].Size)
+	}
+}
+
+func testReadRange(t *testing.T, blobStore longtailstorelib.BlobStore, options Options) {
+	ctx := context.Background()
+	client := newClient(t, blobStore)
+	object := newObject(t, client, "range.txt")
+	write(t, object, []byte("0123456789"))
+
+	ranges := []struct {
+		offset   int64
+		length   int64
+		expected string
+	}{
+		{0, 10, "0123456789"},
+		{2, 4, "2345"},
+		{9, 1, "9"},
+		{8, 10, "89"},
+		{10, 5, ""},
+		{20, 5, ""},
+		{3, 0, ""},
+	}
+	for _, r := range ranges {
+		data, err := object.ReadRange(ctx, r.offset, r.length)
+		assert.NoError(t, err, "ReadRange(%d, %d)", r.offset, r.length)
+		assert.Equal(t, r.expected, string(data), "ReadRange(%d, %d)", r.offset, r.length)
+	}
+}
+
+func testStreaming(t *testing.T, blobStore longtailstorelib.BlobStore, options Options) {
+	ctx := context.Background()
+	client := newClient(t, blobStore)
+	object := newObject(t, client, "streamed.bin")
+
+	// Use a reader that is not an io.Seeker to make sure stores can't depend on seeking
+	content := []byte(strings.Repeat("streamed content ", 1000))
+	ok, err := object.WriteFrom(ctx, io.MultiReader(bytes.NewReader(content)))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	reader, err := object.OpenRead(ctx)
+	assert.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.NoError(t, reader.Close())
+	assert.Equal(t, content, data)
+
+	data, err = object.Read(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, content, data)
+}
+
+func testLargeObject(t *testing.T, blobStore longtailstorelib.BlobStore, options Options) {
+	if options.LargeObjectSize < 0 {
+		t.Skip("large objects are disabled for this store")
+	}
+	ctx := context.Background()
+	client := newClient(t, blobStore)
+	content := make([]byte, options.LargeObjectSize)
+	rand.New(rand.NewSource(int64(options.LargeObjectSize))).Read(content)
+
+	object := newObject(t, client, "large.bin")
+	write(t, object, content)
+	data, err := object.Read(ctx)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(content, data), "large object content differs")
+
+	streamed := newObject(t, client, "large-streamed.bin")
+	ok, err := streamed.WriteFrom(ctx, io.MultiReader(bytes.NewReader(content)))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	reader, err := streamed.OpenRead(ctx)
+	assert.NoError(t, err)
+	data, err = io.ReadAll(reader)
+	reader.Close()
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(content, data), "large streamed object content differs")
+
+	tail, err := streamed.ReadRange(ctx, int64(len(content)-100), 1000)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(content[len(content)-100:], tail), "large object tail differs")
+
+	objects, err := client.GetObjects("large")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(objects))
+	for _, o := range objects {
+		if !options.IgnoreListedSize {
+			assert.Equal(t, int64(len(content)), o.Size, o.Name)
+		}
+	}
+}
+
+func testDelete(t *testing.T, blobStore longtailstorelib.BlobStore, options Options) {
+	ctx := context.Background()
+	client := newClient(t, blobStore)
+	object := newObject(t, client, "deleted.txt")
+	write(t, object, []byte("data"))
+	assert.NoError(t, object.Delete(ctx))
+	exists, err := object.Exists(ctx)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	_, err = object.Read(ctx)
+	assert.True(t, errors.Is(err, os.ErrNotExist), "Read of a deleted object must fail with os.ErrNotExist, got %v", err)
+	objects, err := client.GetObjects("deleted")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(objects))
+
+	// Deleting an object that does not exist is not an error
+	assert.NoError(t, object.Delete(ctx))
+	assert.NoError(t, newObject(t, client, "never-written.txt").Delete(ctx))
+
+	// The object can be written again after it was deleted
+	write(t, object, []byte("again"))
+	data, err := object.Read(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "again", string(data))
+}
+
+func sortedNames(objects []longtailstorelib.BlobProperties) []string {
+	names := make([]string, 0, len(objects))
+	for _, o := range objects {
+		names = append(names, o.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func testListObjects(t *testing.T, blobStore longtailstorelib.BlobStore, options Options) {
+	client := newClient(t, blobStore)
+	objects, err := client.GetObjects("")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(objects), "a new store must be empty")
+
+	files := []string{"a.txt", "ab.txt", "block.gen", "chunks/0001/0001aaaa.lsb", "chunks/0001/0001bbbb.lsb", "chunks/0002/0002cccc.lsb", "chunksextra.txt", "store.lsi"}
+	for _, name := range files {
+		write(t, newObject(t, client, name), []byte(name))
+	}
+
+	prefixes := map[string][]string{
+		"":            files,
+		"a":           {"a.txt", "ab.txt"},
+		"ab":          {"ab.txt"},
+		"chunks":      {"chunks/0001/0001aaaa.lsb", "chunks/0001/0001bbbb.lsb", "chunks/0002/0002cccc.lsb", "chunksextra.txt"},
+		"chunks/":     {"chunks/0001/0001aaaa.lsb", "chunks/0001/0001bbbb.lsb", "chunks/0002/0002cccc.lsb"},
+		"chunks/0001": {"chunks/0001/0001aaaa.lsb", "chunks/0001/0001bbbb.lsb"},
+		"store.lsi":   {"store.lsi"},
+		"nothing":     {},
+	}
+	for prefix, expected := range prefixes {
+		objects, err := client.GetObjects(prefix)
+		assert.NoError(t, err, "GetObjects(%q)", prefix)
+		assert.Equal(t, expected, sortedNames(objects), "GetObjects(%q)", prefix)
+		for _, o := range objects {
+			if !options.IgnoreListedSize {
+				assert.Equal(t, int64(len(o.Name)), o.Size, o.Name)
+			}
+		}
+
+		var walked []longtailstorelib.BlobProperties
+		err = client.WalkObjects(prefix, func(properties longtailstorelib.BlobProperties) error {
+			walked = append(walked, properties)
+			return nil
+		})
+		assert.NoError(t, err, "WalkObjects(%q)", prefix)
+		assert.Equal(t, expected, sortedNames(walked), "WalkObjects(%q)", prefix)
+	}
+}
+
+func listedVersion(t *testing.T, client longtailstorelib.BlobClient, name string) string {
+	objects, err := client.GetObjects(name)
+	assert.NoError(t, err)
+	for _, o := range objects {
+		if o.Name == name {
+			return o.Version
+		}
+	}
+	t.Fatalf("%s is not listed", name)
+	return ""
+}
+
+func testListedVersion(t *testing.T, blobStore longtailstorelib.BlobStore, options Options) {
+	ctx := context.Background()
+	client := newClient(t, blobStore)
+	object := newObject(t, client, "versioned.lsi")
+	write(t, object, []byte("first"))
+	firstVersion := listedVersion(t, client, "versioned.lsi")
+	if firstVersion == "" {
+		t.Skipf("%s does not list object versions", client.String())
+	}
+	assert.Equal(t, firstVersion, listedVersion(t, client, "versioned.lsi"), "listing must not change the version")
+
+	// File system stores can only tell changes apart by modification time and size
+	write(t, object, []byte("second version"))
+	secondVersion := listedVersion(t, client, "versioned.lsi")
+	assert.NotEqual(t, firstVersion, secondVersion, "a write must change the version")
+
+	assert.NoError(t, object.Delete(ctx))
+	write(t, object, []byte("third"))
+	assert.NotEqual(t, firstVersion, listedVersion(t, client, "versioned.lsi"), "a recreated object must not reuse a version")
+	assert.NotEqual(t, secondVersion, listedVersion(t, client, "versioned.lsi"), "a recreated object must not reuse a version")
+}
+
+func testReadIfChanged(t *testing.T, blobStore longtailstorelib.BlobStore, options Options) {
+	ctx := context.Background()
+	client := newClient(t, blobStore)
+	object := newObject(t, client, "conditional.lsi")
+	versioned, ok := object.(longtailstorelib.VersionedBlobObject)
+	if !ok {
+		t.Skipf("%s does not support conditional reads", client.String())
+	}
+	_, _, _, err := versioned.ReadIfChanged(ctx, "")
+	assert.True(t, errors.Is(err, os.ErrNotExist), "ReadIfChanged of a missing object must wrap os.ErrNotExist, got %v", err)
+
+	write(t, object, []byte("first"))
+	data, version, changed, err := versioned.ReadIfChanged(ctx, "")
+	assert.NoError(t, err)
+	assert.True(t, changed, "a read without a version must read the object")
+	assert.Equal(t, "first", string(data))
+	if version == "" {
+		t.Skipf("%s does not report object versions", client.String())
+	}
+
+	data, unchangedVersion, changed, err := versioned.ReadIfChanged(ctx, version)
+	assert.NoError(t, err)
+	assert.False(t, changed, "an unchanged object must not be read")
+	assert.Equal(t, 0, len(data))
+	assert.Equal(t, version, unchangedVersion)
+
+	write(t, object, []byte("second version"))
+	data, secondVersion, changed, err := versioned.ReadIfChanged(ctx, version)
+	assert.NoError(t, err)
+	assert.True(t, changed, "a changed object must be read")
+	assert.Equal(t, "second version", string(data))
+	assert.NotEqual(t, version, secondVersion)
+}
+
+func testWalkObjectsStop(t *testing.T, blobStore longtailstorelib.BlobStore, options Options) {
+	client := newClient(t, blobStore)
+	for i := 0; i < 5; i++ {
+		write(t, newObject(t, client, fmt.Sprintf("walk/%d.txt", i)), []byte("data"))
+	}
+	stop := errors.New("stop walking")
+	count := 0
+	err := client.WalkObjects("walk/", func(properties longtailstorelib.BlobProperties) error {
+		count++
+		return stop
+	})
+	assert.True(t, errors.Is(err, stop), "the error returned by walkFn must be returned from WalkObjects, got %v", err)
+	assert.Equal(t, 1, count)
+}
+
+func testConcurrentWrites(t *testing.T, blobStore longtailstorelib.BlobStore, options Options) {
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	errs := make(chan error, options.Concurrency*10)
+	for worker := 0; worker < options.Concurrency; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			client, err := blobStore.NewClient(ctx)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer client.Close()
+			for i := 0; i < 10; i++ {
+				name := fmt.Sprintf("concurrent/%d/%d.txt", worker, i)
+				object, err := client.NewObject(name)
+				if err == nil {
+					_, err = object.Write(ctx, []byte(name))
+				}
+				if err != nil {
+					errs <- err
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	client := newClient(t, blobStore)
+	objects, err := client.GetObjects("concurrent/")
+	assert.NoError(t, err)
+	assert.Equal(t, options.Concurrency*10, len(objects))
+	for _, o := range objects {
+		data, err := newObject(t, client, o.Name).Read(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, o.Name, string(data))
+	}
+}
+
+func testLockNewObject(t *testing.T, blobStore longtailstorelib.BlobStore, options Options) {
+	ctx := context.Background()
+	client := newClient(t, blobStore)
+	requireLocking(t, client)
+
+	first := newObject(t, client, "store.lsi")
+	exists, err := first.LockWriteVersion(ctx)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	second := newObject(t, newClient(t, blobStore), "store.lsi")
+	exists, err = second.LockWriteVersion(ctx)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// Only the first of two writers that locked a missing object may create it
+	ok, err := first.Write(ctx, []byte("first"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = second.Write(ctx, []byte("second"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = second.WriteFrom(ctx, strings.NewReader("second"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	data, err := first.Read(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", string(data))
+}
+
+func testLockExistingObject(t *testing.T, blobStore longtailstorelib.BlobStore, options Options) {
+	ctx := context.Background()
+	client := newClient(t, blobStore)
+	requireLocking(t, client)
+	write(t, newObject(t, client, "store.lsi"), []byte("initial"))
+
+	first := newObject(t, client, "store.lsi")
+	exists, err := first.LockWriteVersion(ctx)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	second := newObject(t, newClient(t, blobStore), "store.lsi")
+	exists, err = second.LockWriteVersion(ctx)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	ok, err := second.Write(ctx, []byte("second"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = first.Write(ctx, []byte("first"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// A write without a lock also invalidates the lock of others
+	exists, err = first.LockWriteVersion(ctx)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	write(t, newObject(t, client, "store.lsi"), []byte("unlocked"))
+	ok, err = first.Write(ctx, []byte("first"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// After locking again the write succeeds
+	exists, err = first.LockWriteVersion(ctx)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	ok, err = first.Write(ctx, []byte("first"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	data, _ := second.Read(ctx)
+	assert.Equal(t, "first", string(data))
+}
+
+func testLockedDelete(t *testing.T, blobStore longtailstorelib.BlobStore, options Options) {
+	ctx := context.Background()
+	client := newClient(t, blobStore)
+	requireLocking(t, client)
+
+	// A changed object is not deleted
+	object := newObject(t, client, "changed.txt")
+	write(t, object, []byte("initial"))
+	locked := newObject(t, client, "changed.txt")
+	_, err := locked.LockWriteVersion(ctx)
+	assert.NoError(t, err)
+	write(t, object, []byte("changed"))
+	err = locked.Delete(ctx)
+	assert.True(t, errors.Is(err, longtailstorelib.ErrBlobVersionChanged), "%v", err)
+	exists, _ := object.Exists(ctx)
+	assert.True(t, exists)
+
+	// An object created after it was locked is not deleted
+	locked = newObject(t, client, "created.txt")
+	exists, err = locked.LockWriteVersion(ctx)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	write(t, newObject(t, client, "created.txt"), []byte("created"))
+	err = locked.Delete(ctx)
+	assert.True(t, errors.Is(err, longtailstorelib.ErrBlobVersionChanged), "%v", err)
+	exists, _ = locked.Exists(ctx)
+	assert.True(t, exists)
+
+	// An unchanged object is deleted
+	locked = newObject(t, client, "unchanged.txt")
+	write(t, locked, []byte("unchanged"))
+	_, err = locked.LockWriteVersion(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, locked.Delete(ctx))
+	exists, _ = locked.Exists(ctx)
+	assert.False(t, exists)
+
+	// An object that was locked while missing and is still missing can be deleted
+	locked = newObject(t, client, "still-missing.txt")
+	_, err = locked.LockWriteVersion(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, locked.Delete(ctx))
+}
+
+// appendWithLock appends line to the object at path using a lock, read, modify, write loop
+func appendWithLock(ctx context.Context, blobStore longtailstorelib.BlobStore, path string, line string) error {
+	client, err := blobStore.NewClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	object, err := client.NewObject(path)
+	if err != nil {
+		return err
+	}
+	for attempt := 0; attempt < 1000; attempt++ {
+		exists, err := object.LockWriteVersion(ctx)
+		if err != nil {
+			return err
+		}
+		var data []byte
+		if exists {
+			data, err = object.Read(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		ok, err := object.Write(ctx, append(data, []byte(line+"\n")...))
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to append `%s` to `%s`", line, path)
+}
+
+func testLockingRace(t *testing.T, blobStore longtailstorelib.BlobStore, options Options) {
+	ctx := context.Background()
+	client := newClient(t, blobStore)
+	requireLocking(t, client)
+
+	const rounds = 3
+	var wg sync.WaitGroup
+	errs := make(chan error, options.Concurrency*rounds)
+	for round := 0; round < rounds; round++ {
+		for worker := 0; worker < options.Concurrency; worker++ {
+			wg.Add(1)
+			go func(number int) {
+				defer wg.Done()
+				err := appendWithLock(ctx, blobStore, "race.txt", strconv.Itoa(number))
+				if err != nil {
+					errs <- err
+				}
+			}(round*options.Concurrency + worker)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	// No append may be lost when writers race for the same object
+	data, err := newObject(t, client, "race.txt").Read(ctx)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	sort.Slice(lines, func(i, j int) bool {
+		a, _ := strconv.Atoi(lines[i])
+		b, _ := strconv.Atoi(lines[j])
+		return a < b
+	})
+	expected := make([]string, 0, rounds*options.Concurrency)
+	for i := 0; i < rounds*options.Concurrency; i++ {
+		expected = append(expected, strconv.Itoa(i))
+	}
+	assert.Equal(t, expected, lines)
+}
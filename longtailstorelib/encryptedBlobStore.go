@@ -0,0 +1,410 @@
+package longtailstorelib
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Each encrypted object starts with a header holding the id of the key used to encrypt it:
+//
+//	magic (4 bytes) | key id length (1 byte) | key id | nonce (12 bytes)
+//
+// followed by the AES-GCM sealed payload. The header and the name of the object are authenticated
+// as additional data so the key id can't be altered and the object can't be given another name,
+// such as swapping two blocks, without failing decryption. The folders of the path are not
+// authenticated so the object decrypts the same whatever folder of the store it is opened from
+var encryptedBlobMagic = []byte("LTE1")
+
+const maxEncryptionKeyIDLength = 255
+
+// EncryptionKeyRing holds the keys for an encrypted blob store
+// New objects are encrypted with the current key, any key in the ring can decrypt
+// so keys can be rotated by making a new key current while keeping the old ones
+type EncryptionKeyRing struct {
+	currentKeyID string
+	aeads        map[string]cipher.AEAD
+}
+
+// NewEncryptionKeyRing creates a key ring from raw keys, each key must be 16, 24 or 32
+// bytes to select AES-128, AES-192 or AES-256. currentKeyID selects the key used for writing
+func NewEncryptionKeyRing(currentKeyID string, keys map[string][]byte) (*EncryptionKeyRing, error) {
+	const fname = "NewEncryptionKeyRing"
+	if _, exists := keys[currentKeyID]; !exists {
+		err := fmt.Errorf("current key `%s` is not in the key ring", currentKeyID)
+		return nil, errors.Wrap(err, fname)
+	}
+	keyRing := &EncryptionKeyRing{currentKeyID: currentKeyID, aeads: make(map[string]cipher.AEAD)}
+	for id, key := range keys {
+		if len(id) == 0 || len(id) > maxEncryptionKeyIDLength {
+			err := fmt.Errorf("key id `%s` must be 1 to %d bytes long", id, maxEncryptionKeyIDLength)
+			return nil, errors.Wrap(err, fname)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			err = errors.Wrapf(err, "invalid key `%s`", id)
+			return nil, errors.Wrap(err, fname)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, errors.Wrap(err, fname)
+		}
+		keyRing.aeads[id] = aead
+	}
+	return keyRing, nil
+}
+
+// ParseEncryptionKeys parses a list of `<key id>:<base64 encoded key>` entries separated
+// by new lines or commas, empty entries and lines starting with `#` are ignored.
+// The first key is the current key used for writing, the others are only used for reading
+func ParseEncryptionKeys(data string) (*EncryptionKeyRing, error) {
+	const fname = "ParseEncryptionKeys"
+	currentKeyID := ""
+	keys := make(map[string][]byte)
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, entry := range strings.Split(line, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			parts := strings.SplitN(entry, ":", 2)
+			if len(parts) != 2 {
+				err := fmt.Errorf("malformed key entry, expected `<key id>:<base64 key>`")
+				return nil, errors.Wrap(err, fname)
+			}
+			id := strings.TrimSpace(parts[0])
+			key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(parts[1]))
+			if err != nil {
+				err = errors.Wrapf(err, "invalid base64 data for key `%s`", id)
+				return nil, errors.Wrap(err, fname)
+			}
+			if _, exists := keys[id]; exists {
+				err = fmt.Errorf("duplicate key id `%s`", id)
+				return nil, errors.Wrap(err, fname)
+			}
+			if currentKeyID == "" {
+				currentKeyID = id
+			}
+			keys[id] = key
+		}
+	}
+	if len(keys) == 0 {
+		err := fmt.Errorf("no encryption keys found")
+		return nil, errors.Wrap(err, fname)
+	}
+	keyRing, err := NewEncryptionKeyRing(currentKeyID, keys)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return keyRing, nil
+}
+
+// ReadEncryptionKeyFile reads a key ring from a local file, see ParseEncryptionKeys for the format
+func ReadEncryptionKeyFile(path string) (*EncryptionKeyRing, error) {
+	const fname = "ReadEncryptionKeyFile"
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	keyRing, err := ParseEncryptionKeys(string(data))
+	if err != nil {
+		err = errors.Wrapf(err, "Failed reading keys from `%s`", path)
+		return nil, errors.Wrap(err, fname)
+	}
+	return keyRing, nil
+}
+
+// ReadEncryptionKeyEnv reads a key ring from an environment variable, see ParseEncryptionKeys for the format
+func ReadEncryptionKeyEnv(name string) (*EncryptionKeyRing, error) {
+	const fname = "ReadEncryptionKeyEnv"
+	data, exists := os.LookupEnv(name)
+	if !exists {
+		err := fmt.Errorf("environment variable `%s` is not set", name)
+		return nil, errors.Wrap(err, fname)
+	}
+	keyRing, err := ParseEncryptionKeys(data)
+	if err != nil {
+		err = errors.Wrapf(err, "Failed reading keys from environment variable `%s`", name)
+		return nil, errors.Wrap(err, fname)
+	}
+	return keyRing, nil
+}
+
+// CurrentKeyID returns the id of the key used to encrypt new objects
+func (keyRing *EncryptionKeyRing) CurrentKeyID() string {
+	return keyRing.currentKeyID
+}
+
+// encryptedBlobAdditionalData returns the additional data authenticated for the object at path
+// Blocks and store index items are named by their content hash with an extension for the kind of
+// object, so their name identifies them without the root the store was opened at
+func encryptedBlobAdditionalData(header []byte, path string) []byte {
+	name := path[strings.LastIndex(path, "/")+1:]
+	additionalData := make([]byte, 0, len(header)+len(name))
+	additionalData = append(additionalData, header...)
+	return append(additionalData, name...)
+}
+
+func (keyRing *EncryptionKeyRing) seal(path string, data []byte) ([]byte, error) {
+	aead := keyRing.aeads[keyRing.currentKeyID]
+	header := make([]byte, 0, len(encryptedBlobMagic)+1+len(keyRing.currentKeyID)+aead.NonceSize())
+	header = append(header, encryptedBlobMagic...)
+	header = append(header, byte(len(keyRing.currentKeyID)))
+	header = append(header, keyRing.currentKeyID...)
+	nonce := make([]byte, aead.NonceSize())
+	_, err := io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, err
+	}
+	header = append(header, nonce...)
+	return aead.Seal(header, nonce, data, encryptedBlobAdditionalData(header, path)), nil
+}
+
+func (keyRing *EncryptionKeyRing) open(path string, data []byte) ([]byte, error) {
+	if len(data) < len(encryptedBlobMagic)+1 || !bytes.Equal(data[:len(encryptedBlobMagic)], encryptedBlobMagic) {
+		return nil, fmt.Errorf("object is not encrypted")
+	}
+	offset := len(encryptedBlobMagic)
+	keyIDLength := int(data[offset])
+	offset++
+	if len(data) < offset+keyIDLength {
+		return nil, fmt.Errorf("encryption header is truncated")
+	}
+	keyID := string(data[offset : offset+keyIDLength])
+	offset += keyIDLength
+	aead, exists := keyRing.aeads[keyID]
+	if !exists {
+		return nil, fmt.Errorf("object is encrypted with unknown key `%s`", keyID)
+	}
+	if len(data) < offset+aead.NonceSize()+aead.Overhead() {
+		return nil, fmt.Errorf("encryption header is truncated")
+	}
+	nonce := data[offset : offset+aead.NonceSize()]
+	header := data[:offset+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, data[len(header):], encryptedBlobAdditionalData(header, path))
+	if err != nil {
+		return nil, errors.Wrapf(err, "decryption with key `%s` failed", keyID)
+	}
+	return plaintext, nil
+}
+
+// EncryptionOptions configures the encryption applied by CreateBlobStoreForURI
+type EncryptionOptions struct {
+	KeyRing *EncryptionKeyRing
+}
+
+// WithEncryptionKeyRing makes the created blob store encrypt all objects with keyRing
+func WithEncryptionKeyRing(keyRing *EncryptionKeyRing) BlobStoreOption {
+	return func(options interface{}) {
+		encryptionOptions, ok := options.(*EncryptionOptions)
+		if !ok {
+			return
+		}
+		encryptionOptions.KeyRing = keyRing
+	}
+}
+
+// GetEncryptionOptions collects the encryption options from opts
+func GetEncryptionOptions(opts ...BlobStoreOption) EncryptionOptions {
+	options := EncryptionOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+type encryptedBlobStore struct {
+	store   BlobStore
+	keyRing *EncryptionKeyRing
+}
+
+type encryptedBlobClient struct {
+	client BlobClient
+	store  *encryptedBlobStore
+}
+
+type encryptedBlobObject struct {
+	object BlobObject
+	path   string
+	client *encryptedBlobClient
+}
+
+// NewEncryptedBlobStore wraps store so object payloads are encrypted with AES-GCM on write
+// and decrypted on read. Listing, Exists, Delete and LockWriteVersion are passed through as
+// is so the locking semantics of store are kept. The object sizes reported by GetObjects
+// and WalkObjects are the sizes of the encrypted objects
+func NewEncryptedBlobStore(store BlobStore, keyRing *EncryptionKeyRing) (BlobStore, error) {
+	const fname = "NewEncryptedBlobStore"
+	if keyRing == nil {
+		err := fmt.Errorf("missing encryption key ring for `%s`", store.String())
+		return nil, errors.Wrap(err, fname)
+	}
+	return &encryptedBlobStore{store: store, keyRing: keyRing}, nil
+}
+
+func (blobStore *encryptedBlobStore) NewClient(ctx context.Context) (BlobClient, error) {
+	const fname = "encryptedBlobStore.NewClient"
+	client, err := blobStore.store.NewClient(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return &encryptedBlobClient{client: client, store: blobStore}, nil
+}
+
+func (blobStore *encryptedBlobStore) String() string {
+	return blobStore.store.String()
+}
+
+func (blobClient *encryptedBlobClient) NewObject(path string) (BlobObject, error) {
+	const fname = "encryptedBlobClient.NewObject"
+	object, err := blobClient.client.NewObject(path)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return &encryptedBlobObject{object: object, path: path, client: blobClient}, nil
+}
+
+func (blobClient *encryptedBlobClient) GetObjects(pathPrefix string) ([]BlobProperties, error) {
+	return blobClient.client.GetObjects(pathPrefix)
+}
+
+func (blobClient *encryptedBlobClient) WalkObjects(pathPrefix string, walkFn BlobWalkFunc) error {
+	return blobClient.client.WalkObjects(pathPrefix, walkFn)
+}
+
+func (blobClient *encryptedBlobClient) SupportsLocking() bool {
+	return blobClient.client.SupportsLocking()
+}
+
+func (blobClient *encryptedBlobClient) ClassifyError(err error) BlobErrorClass {
+	return ClassifyBlobError(blobClient.client, err)
+}
+
+func (blobClient *encryptedBlobClient) String() string {
+	return blobClient.client.String()
+}
+
+func (blobClient *encryptedBlobClient) Close() {
+	blobClient.client.Close()
+}
+
+func (blobObject *encryptedBlobObject) Exists(ctx context.Context) (bool, error) {
+	return blobObject.object.Exists(ctx)
+}
+
+// Size returns the size of the encrypted object
+func (blobObject *encryptedBlobObject) Size(ctx context.Context) (int64, error) {
+	return getObjectSize(ctx, blobObject.client.client, blobObject.object, blobObject.path)
+}
+
+func (blobObject *encryptedBlobObject) LockWriteVersion(ctx context.Context) (bool, error) {
+	return blobObject.object.LockWriteVersion(ctx)
+}
+
+func (blobObject *encryptedBlobObject) Read(ctx context.Context) ([]byte, error) {
+	const fname = "encryptedBlobObject.Read"
+	data, err := blobObject.object.Read(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	plaintext, err := blobObject.client.store.keyRing.open(blobObject.path, data)
+	if err != nil {
+		err = errors.Wrapf(err, "Failed decrypting `%s`", blobObject.String())
+		return nil, errors.Wrap(err, fname)
+	}
+	return plaintext, nil
+}
+
+func (blobObject *encryptedBlobObject) SourceCount() int {
+	if sourced, ok := blobObject.object.(SourcedBlobObject); ok {
+		return sourced.SourceCount()
+	}
+	return 1
+}
+
+func (blobObject *encryptedBlobObject) ReadSource(ctx context.Context, source int) ([]byte, error) {
+	const fname = "encryptedBlobObject.ReadSource"
+	sourced, ok := blobObject.object.(SourcedBlobObject)
+	if !ok {
+		return blobObject.Read(ctx)
+	}
+	data, err := sourced.ReadSource(ctx, source)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	plaintext, err := blobObject.client.store.keyRing.open(blobObject.path, data)
+	if err != nil {
+		err = errors.Wrapf(err, "Failed decrypting `%s`", blobObject.String())
+		return nil, errors.Wrap(err, fname)
+	}
+	return plaintext, nil
+}
+
+// OpenRead has to read and authenticate the whole object before any data is returned
+func (blobObject *encryptedBlobObject) OpenRead(ctx context.Context) (io.ReadCloser, error) {
+	const fname = "encryptedBlobObject.OpenRead"
+	data, err := blobObject.Read(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// ReadRange has to read and authenticate the whole object before the range is returned
+func (blobObject *encryptedBlobObject) ReadRange(ctx context.Context, offset int64, length int64) ([]byte, error) {
+	const fname = "encryptedBlobObject.ReadRange"
+	data, err := blobObject.Read(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return sliceRange(data, offset, length), nil
+}
+
+func (blobObject *encryptedBlobObject) Write(ctx context.Context, data []byte) (bool, error) {
+	const fname = "encryptedBlobObject.Write"
+	ciphertext, err := blobObject.client.store.keyRing.seal(blobObject.path, data)
+	if err != nil {
+		err = errors.Wrapf(err, "Failed encrypting `%s`", blobObject.String())
+		return false, errors.Wrap(err, fname)
+	}
+	ok, err := blobObject.object.Write(ctx, ciphertext)
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	return ok, nil
+}
+
+func (blobObject *encryptedBlobObject) WriteFrom(ctx context.Context, reader io.Reader) (bool, error) {
+	const fname = "encryptedBlobObject.WriteFrom"
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	ok, err := blobObject.Write(ctx, data)
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	return ok, nil
+}
+
+func (blobObject *encryptedBlobObject) Delete(ctx context.Context) error {
+	return blobObject.object.Delete(ctx)
+}
+
+func (blobObject *encryptedBlobObject) String() string {
+	return blobObject.object.String()
+}
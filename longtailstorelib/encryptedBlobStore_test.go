@@ -0,0 +1,197 @@
+package longtailstorelib
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/pkg/errors"
+)
+
+func testEncryptionKey(seed byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{seed}, 32))
+}
+
+func TestEncryptedBlobStoreReadWrite(t *testing.T) {
+	keyRing, err := ParseEncryptionKeys("key1:" + testEncryptionKey(1))
+	assert.NoError(t, err)
+	backingStore, _ := NewMemBlobStore("", true)
+	blobStore, err := NewEncryptedBlobStore(backingStore, keyRing)
+	assert.NoError(t, err)
+	client, _ := blobStore.NewClient(context.Background())
+	defer client.Close()
+
+	object, _ := client.NewObject("test.txt")
+	ok, err := object.Write(context.Background(), []byte("some secret data"))
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	data, err := object.Read(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "some secret data", string(data))
+	data, err = object.ReadRange(context.Background(), 5, 6)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", string(data))
+
+	backingClient, _ := backingStore.NewClient(context.Background())
+	defer backingClient.Close()
+	backingObject, _ := backingClient.NewObject("test.txt")
+	stored, _ := backingObject.Read(context.Background())
+	assert.True(t, bytes.HasPrefix(stored, []byte("LTE1\x04key1")))
+	assert.False(t, bytes.Contains(stored, []byte("secret")))
+
+	// Tampering with the stored data must fail decryption
+	tampered := append([]byte{}, stored...)
+	tampered[len(tampered)-1] ^= 0xff
+	backingObject.Write(context.Background(), tampered)
+	_, err = object.Read(context.Background())
+	assert.Error(t, err)
+
+	// Encrypted data moved from another path must fail decryption
+	other, _ := client.NewObject("other.txt")
+	_, err = other.Write(context.Background(), []byte("other secret data"))
+	assert.NoError(t, err)
+	otherBackingObject, _ := backingClient.NewObject("other.txt")
+	otherStored, _ := otherBackingObject.Read(context.Background())
+	backingObject.Write(context.Background(), otherStored)
+	_, err = object.Read(context.Background())
+	assert.Error(t, err)
+
+	// Unencrypted data is not accepted
+	backingObject.Write(context.Background(), []byte("plain"))
+	_, err = object.Read(context.Background())
+	assert.Error(t, err)
+
+	missing, _ := client.NewObject("missing.txt")
+	_, err = missing.Read(context.Background())
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
+
+func TestEncryptedBlobStoreKeyRotation(t *testing.T) {
+	oldKeyRing, _ := ParseEncryptionKeys("old:" + testEncryptionKey(1))
+	newKeyRing, err := ParseEncryptionKeys("new:" + testEncryptionKey(2) + ",old:" + testEncryptionKey(1))
+	assert.NoError(t, err)
+	assert.Equal(t, "new", newKeyRing.CurrentKeyID())
+	onlyNewKeyRing, _ := ParseEncryptionKeys("new:" + testEncryptionKey(2))
+
+	backingStore, _ := NewMemBlobStore("", true)
+	oldStore, _ := NewEncryptedBlobStore(backingStore, oldKeyRing)
+	oldClient, _ := oldStore.NewClient(context.Background())
+	defer oldClient.Close()
+	object, _ := oldClient.NewObject("rotated.txt")
+	object.Write(context.Background(), []byte("written with old key"))
+
+	newStore, _ := NewEncryptedBlobStore(backingStore, newKeyRing)
+	newClient, _ := newStore.NewClient(context.Background())
+	defer newClient.Close()
+	object, _ = newClient.NewObject("rotated.txt")
+	data, err := object.Read(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "written with old key", string(data))
+	object.Write(context.Background(), []byte("written with new key"))
+
+	onlyNewStore, _ := NewEncryptedBlobStore(backingStore, onlyNewKeyRing)
+	onlyNewClient, _ := onlyNewStore.NewClient(context.Background())
+	defer onlyNewClient.Close()
+	object, _ = onlyNewClient.NewObject("rotated.txt")
+	data, err = object.Read(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "written with new key", string(data))
+
+	object, _ = oldClient.NewObject("rotated.txt")
+	_, err = object.Read(context.Background())
+	assert.Error(t, err)
+}
+
+func TestEncryptedBlobStoreLocking(t *testing.T) {
+	keyRing, _ := ParseEncryptionKeys("key1:" + testEncryptionKey(1))
+	backingStore, _ := NewMemBlobStore("", true)
+	blobStore, _ := NewEncryptedBlobStore(backingStore, keyRing)
+	client, _ := blobStore.NewClient(context.Background())
+	defer client.Close()
+	assert.True(t, client.SupportsLocking())
+
+	object, _ := client.NewObject("locked.txt")
+	exists, err := object.LockWriteVersion(context.Background())
+	assert.False(t, exists)
+	assert.NoError(t, err)
+	ok, err := object.Write(context.Background(), []byte("first"))
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	otherObject, _ := client.NewObject("locked.txt")
+	exists, _ = otherObject.LockWriteVersion(context.Background())
+	assert.True(t, exists)
+	ok, err = object.Write(context.Background(), []byte("second"))
+	assert.False(t, ok)
+	assert.NoError(t, err)
+	ok, err = otherObject.Write(context.Background(), []byte("third"))
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	data, _ := otherObject.Read(context.Background())
+	assert.Equal(t, "third", string(data))
+}
+
+func TestEncryptionKeySources(t *testing.T) {
+	_, err := ParseEncryptionKeys("")
+	assert.Error(t, err)
+	_, err = ParseEncryptionKeys("no-separator")
+	assert.Error(t, err)
+	_, err = ParseEncryptionKeys("short:" + base64.StdEncoding.EncodeToString([]byte("too short")))
+	assert.Error(t, err)
+	_, err = ParseEncryptionKeys("dup:" + testEncryptionKey(1) + ",dup:" + testEncryptionKey(2))
+	assert.Error(t, err)
+
+	keyFile := filepath.Join(t.TempDir(), "keys.txt")
+	os.WriteFile(keyFile, []byte("# rotated 2026-10-01\nkey2:"+testEncryptionKey(2)+"\n\nkey1:"+testEncryptionKey(1)+"\n"), 0600)
+	keyRing, err := ReadEncryptionKeyFile(keyFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "key2", keyRing.CurrentKeyID())
+
+	t.Setenv("LONGTAIL_TEST_ENCRYPTION_KEYS", "key3:"+testEncryptionKey(3))
+	keyRing, err = ReadEncryptionKeyEnv("LONGTAIL_TEST_ENCRYPTION_KEYS")
+	assert.NoError(t, err)
+	assert.Equal(t, "key3", keyRing.CurrentKeyID())
+	_, err = ReadEncryptionKeyEnv("LONGTAIL_TEST_ENCRYPTION_KEYS_MISSING")
+	assert.Error(t, err)
+}
+
+func TestCreateEncryptedBlobStoreForURI(t *testing.T) {
+	defer DeleteNamedMemBlobStore("encrypted")
+	keyRing, _ := ParseEncryptionKeys("key1:" + testEncryptionKey(1))
+	ctx := WithBlobStoreOptions(context.Background(), WithEncryptionKeyRing(keyRing))
+	blobStore, err := CreateBlobStoreForURI("mem://encrypted/store", GetBlobStoreOptions(ctx)...)
+	assert.NoError(t, err)
+	client, _ := blobStore.NewClient(ctx)
+	defer client.Close()
+	object, _ := client.NewObject("file.txt")
+	object.Write(ctx, []byte("encrypted content"))
+
+	blobs, _ := GetMemBlobs("mem://encrypted/store")
+	assert.True(t, bytes.HasPrefix(blobs["file.txt"], []byte("LTE1")))
+
+	plainStore, _ := CreateBlobStoreForURI("mem://encrypted/store")
+	plainClient, _ := plainStore.NewClient(context.Background())
+	defer plainClient.Close()
+	plainObject, _ := plainClient.NewObject("file.txt")
+	data, _ := plainObject.Read(context.Background())
+	assert.NotEqual(t, "encrypted content", string(data))
+	data, _ = object.Read(ctx)
+	assert.Equal(t, "encrypted content", string(data))
+
+	// Objects decrypt when the store is opened at another folder
+	blockPath := "chunks/0a1b/0a1b2c3d4e5f6071.lrb"
+	blockObject, _ := client.NewObject(blockPath)
+	blockObject.Write(ctx, []byte("block content"))
+	blockStore, err := CreateBlobStoreForURI("mem://encrypted/store/chunks/0a1b", GetBlobStoreOptions(ctx)...)
+	assert.NoError(t, err)
+	blockClient, _ := blockStore.NewClient(ctx)
+	defer blockClient.Close()
+	movedObject, _ := blockClient.NewObject("0a1b2c3d4e5f6071.lrb")
+	data, err = movedObject.Read(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "block content", string(data))
+}
@@ -0,0 +1,495 @@
+package longtailstorelib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FaultOperation names a group of blob operations that faults can be injected into
+type FaultOperation string
+
+const (
+	// FaultRead covers Exists, Read, OpenRead and ReadRange
+	FaultRead FaultOperation = "read"
+	// FaultWrite covers Write and WriteFrom
+	FaultWrite FaultOperation = "write"
+	// FaultDelete covers Delete
+	FaultDelete FaultOperation = "delete"
+	// FaultList covers GetObjects and WalkObjects
+	FaultList FaultOperation = "list"
+	// FaultLock covers LockWriteVersion
+	FaultLock FaultOperation = "lock"
+)
+
+// ErrInjectedFault is returned by operations failed by a faulty blob store
+var ErrInjectedFault = errors.New("injected blob store fault")
+
+// FaultConfig controls the faults injected by a FaultyBlobStore
+// All rates are probabilities between 0 and 1 applied to each single operation
+type FaultConfig struct {
+	// Operations that faults are injected into, all operations if empty
+	Operations []FaultOperation
+	// Rate of operations failing with ErrInjectedFault, a failing OpenRead returns
+	// a reader that fails half way through the object instead
+	ErrorRate float64
+	// Delay added to each operation
+	Latency time.Duration
+	// Rate of writes that report success without storing anything
+	LostWriteRate float64
+	// Rate of reads returning the content the object had before the last write
+	// made through the store, or not found if the object did not exist before it
+	StaleReadRate float64
+	// Seed for the random generator, the same seed and sequence of operations gives the same faults
+	Seed int64
+}
+
+// ParseFaultConfig reads a FaultConfig from URI query parameters: `ops` is a comma separated
+// list of operations, `error-rate`, `lost-write-rate` and `stale-read-rate` are rates,
+// `latency` is a duration such as `50ms` and `seed` is an integer
+func ParseFaultConfig(query url.Values) (FaultConfig, error) {
+	const fname = "ParseFaultConfig"
+	config := FaultConfig{}
+	if ops := query.Get("ops"); ops != "" {
+		for _, op := range strings.Split(ops, ",") {
+			operation := FaultOperation(strings.ToLower(strings.TrimSpace(op)))
+			switch operation {
+			case FaultRead, FaultWrite, FaultDelete, FaultList, FaultLock:
+				config.Operations = append(config.Operations, operation)
+			default:
+				return FaultConfig{}, errors.Wrap(fmt.Errorf("unknown fault operation `%s`", op), fname)
+			}
+		}
+	}
+	rates := map[string]*float64{
+		"error-rate":      &config.ErrorRate,
+		"lost-write-rate": &config.LostWriteRate,
+		"stale-read-rate": &config.StaleReadRate,
+	}
+	for name, rate := range rates {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			return FaultConfig{}, errors.Wrap(fmt.Errorf("%s must be between 0 and 1, got `%s`", name, value), fname)
+		}
+		*rate = parsed
+	}
+	if value := query.Get("latency"); value != "" {
+		latency, err := time.ParseDuration(value)
+		if err != nil {
+			return FaultConfig{}, errors.Wrap(err, fname)
+		}
+		config.Latency = latency
+	}
+	if value := query.Get("seed"); value != "" {
+		seed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return FaultConfig{}, errors.Wrap(err, fname)
+		}
+		config.Seed = seed
+	}
+	return config, nil
+}
+
+// faultQueryParameters are removed from the URI before it is passed on to the wrapped scheme
+var faultQueryParameters = []string{"ops", "error-rate", "lost-write-rate", "stale-read-rate", "latency", "seed"}
+
+// newFaultyBlobStoreForURI handles `fault+<scheme>://...` URIs by opening the URI
+// without the `fault+` prefix and fault parameters and wrapping it in a FaultyBlobStore
+func newFaultyBlobStoreForURI(u *url.URL, opts ...BlobStoreOption) (BlobStore, error) {
+	const fname = "newFaultyBlobStoreForURI"
+	query := u.Query()
+	config, err := ParseFaultConfig(query)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	for _, name := range faultQueryParameters {
+		query.Del(name)
+	}
+	inner := *u
+	inner.Scheme = strings.TrimPrefix(u.Scheme, "fault+")
+	inner.RawQuery = query.Encode()
+	innerURL, innerScheme, ok := LookupBlobStoreScheme(inner.String())
+	if !ok || innerScheme.Name == "fault" {
+		err := fmt.Errorf("`%s` does not wrap a known blob store scheme", u.String())
+		return nil, errors.Wrap(err, fname)
+	}
+	// The inner store is created without decorations, they are applied on top of the faults
+	blobStore, err := innerScheme.Factory(innerURL, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return NewFaultyBlobStore(blobStore, config), nil
+}
+
+// FaultyBlobStore wraps a BlobStore and injects failures, latency, lost writes and
+// stale reads into its operations to test how callers deal with partial failures
+type FaultyBlobStore struct {
+	store BlobStore
+
+	mutex    sync.Mutex
+	config   FaultConfig
+	random   *rand.Rand
+	previous map[string][]byte
+
+	injectedCount int64
+}
+
+type faultyBlobClient struct {
+	client BlobClient
+	store  *FaultyBlobStore
+}
+
+type faultyBlobObject struct {
+	object BlobObject
+	path   string
+	client *faultyBlobClient
+}
+
+// NewFaultyBlobStore wraps store and injects the faults described by config
+func NewFaultyBlobStore(store BlobStore, config FaultConfig) *FaultyBlobStore {
+	return &FaultyBlobStore{
+		store:    store,
+		config:   config,
+		random:   rand.New(rand.NewSource(config.Seed)),
+		previous: map[string][]byte{},
+	}
+}
+
+// SetConfig replaces the fault configuration, for example to heal the store in the middle of a test
+func (blobStore *FaultyBlobStore) SetConfig(config FaultConfig) {
+	blobStore.mutex.Lock()
+	defer blobStore.mutex.Unlock()
+	blobStore.config = config
+	blobStore.random = rand.New(rand.NewSource(config.Seed))
+}
+
+// InjectedFaultCount returns the number of errors, lost writes and stale reads injected so far
+func (blobStore *FaultyBlobStore) InjectedFaultCount() int64 {
+	return atomic.LoadInt64(&blobStore.injectedCount)
+}
+
+func (blobStore *FaultyBlobStore) NewClient(ctx context.Context) (BlobClient, error) {
+	const fname = "FaultyBlobStore.NewClient"
+	client, err := blobStore.store.NewClient(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return &faultyBlobClient{client: client, store: blobStore}, nil
+}
+
+func (blobStore *FaultyBlobStore) String() string {
+	return blobStore.store.String()
+}
+
+// appliesTo returns true if faults are injected into operation, the store mutex must be held
+func (blobStore *FaultyBlobStore) appliesTo(operation FaultOperation) bool {
+	if len(blobStore.config.Operations) == 0 {
+		return true
+	}
+	for _, o := range blobStore.config.Operations {
+		if o == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// roll decides if a fault with the given rate should be injected into operation
+func (blobStore *FaultyBlobStore) roll(operation FaultOperation, rate func(config *FaultConfig) float64) bool {
+	blobStore.mutex.Lock()
+	defer blobStore.mutex.Unlock()
+	if !blobStore.appliesTo(operation) {
+		return false
+	}
+	r := rate(&blobStore.config)
+	if r <= 0 || blobStore.random.Float64() >= r {
+		return false
+	}
+	atomic.AddInt64(&blobStore.injectedCount, 1)
+	return true
+}
+
+// before applies the latency and returns the injected error, if any, for operation
+func (blobStore *FaultyBlobStore) before(ctx context.Context, operation FaultOperation, what string) error {
+	blobStore.mutex.Lock()
+	latency := time.Duration(0)
+	if blobStore.appliesTo(operation) {
+		latency = blobStore.config.Latency
+	}
+	blobStore.mutex.Unlock()
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if blobStore.roll(operation, func(config *FaultConfig) float64 { return config.ErrorRate }) {
+		return errors.Wrapf(ErrInjectedFault, "%s %s", operation, what)
+	}
+	return nil
+}
+
+func (blobClient *faultyBlobClient) NewObject(path string) (BlobObject, error) {
+	const fname = "faultyBlobClient.NewObject"
+	object, err := blobClient.client.NewObject(path)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return &faultyBlobObject{object: object, path: path, client: blobClient}, nil
+}
+
+func (blobClient *faultyBlobClient) GetObjects(pathPrefix string) ([]BlobProperties, error) {
+	const fname = "faultyBlobClient.GetObjects"
+	err := blobClient.store.before(context.Background(), FaultList, blobClient.String())
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return blobClient.client.GetObjects(pathPrefix)
+}
+
+func (blobClient *faultyBlobClient) WalkObjects(pathPrefix string, walkFn BlobWalkFunc) error {
+	const fname = "faultyBlobClient.WalkObjects"
+	err := blobClient.store.before(context.Background(), FaultList, blobClient.String())
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	return blobClient.client.WalkObjects(pathPrefix, walkFn)
+}
+
+func (blobClient *faultyBlobClient) SupportsLocking() bool {
+	return blobClient.client.SupportsLocking()
+}
+
+func (blobClient *faultyBlobClient) ClassifyError(err error) BlobErrorClass {
+	return ClassifyBlobError(blobClient.client, err)
+}
+
+func (blobClient *faultyBlobClient) String() string {
+	return blobClient.client.String()
+}
+
+func (blobClient *faultyBlobClient) Close() {
+	blobClient.client.Close()
+}
+
+func (blobObject *faultyBlobObject) store() *FaultyBlobStore {
+	return blobObject.client.store
+}
+
+// staleRead returns the content from before the last write if a stale read is injected
+func (blobObject *faultyBlobObject) staleRead() ([]byte, bool, error) {
+	store := blobObject.store()
+	store.mutex.Lock()
+	data, known := store.previous[blobObject.path]
+	store.mutex.Unlock()
+	if !known || !store.roll(FaultRead, func(config *FaultConfig) float64 { return config.StaleReadRate }) {
+		return nil, false, nil
+	}
+	if data == nil {
+		return nil, true, errors.Wrapf(os.ErrNotExist, "%s does not exist", blobObject.path)
+	}
+	return data, true, nil
+}
+
+func (blobObject *faultyBlobObject) Exists(ctx context.Context) (bool, error) {
+	const fname = "faultyBlobObject.Exists"
+	err := blobObject.store().before(ctx, FaultRead, blobObject.String())
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	return blobObject.object.Exists(ctx)
+}
+
+func (blobObject *faultyBlobObject) Size(ctx context.Context) (int64, error) {
+	const fname = "faultyBlobObject.Size"
+	err := blobObject.store().before(ctx, FaultRead, blobObject.String())
+	if err != nil {
+		return 0, errors.Wrap(err, fname)
+	}
+	return getObjectSize(ctx, blobObject.client.client, blobObject.object, blobObject.path)
+}
+
+func (blobObject *faultyBlobObject) LockWriteVersion(ctx context.Context) (bool, error) {
+	const fname = "faultyBlobObject.LockWriteVersion"
+	err := blobObject.store().before(ctx, FaultLock, blobObject.String())
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	return blobObject.object.LockWriteVersion(ctx)
+}
+
+func (blobObject *faultyBlobObject) Read(ctx context.Context) ([]byte, error) {
+	const fname = "faultyBlobObject.Read"
+	err := blobObject.store().before(ctx, FaultRead, blobObject.String())
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	data, stale, err := blobObject.staleRead()
+	if stale {
+		if err != nil {
+			return nil, errors.Wrap(err, fname)
+		}
+		return data, nil
+	}
+	return blobObject.object.Read(ctx)
+}
+
+// ReadIfChanged injects the same faults as Read, a stale read returns the previous content without a version
+func (blobObject *faultyBlobObject) ReadIfChanged(ctx context.Context, version string) ([]byte, string, bool, error) {
+	const fname = "faultyBlobObject.ReadIfChanged"
+	err := blobObject.store().before(ctx, FaultRead, blobObject.String())
+	if err != nil {
+		return nil, "", false, errors.Wrap(err, fname)
+	}
+	data, stale, err := blobObject.staleRead()
+	if stale {
+		if err != nil {
+			return nil, "", false, errors.Wrap(err, fname)
+		}
+		return data, "", true, nil
+	}
+	return ReadObjectIfChanged(ctx, blobObject.object, version)
+}
+
+type partialFaultReader struct {
+	reader    io.ReadCloser
+	remaining int64
+	what      string
+}
+
+func (r *partialFaultReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, errors.Wrapf(ErrInjectedFault, "read %s", r.what)
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	return n, err
+}
+
+func (r *partialFaultReader) Close() error {
+	return r.reader.Close()
+}
+
+func (blobObject *faultyBlobObject) OpenRead(ctx context.Context) (io.ReadCloser, error) {
+	const fname = "faultyBlobObject.OpenRead"
+	store := blobObject.store()
+	err := store.before(ctx, FaultRead, blobObject.String())
+	if errors.Is(err, ErrInjectedFault) {
+		// Fail half way through the object rather than when opening it
+		data, readErr := blobObject.object.Read(ctx)
+		if readErr != nil {
+			return nil, errors.Wrap(err, fname)
+		}
+		return &partialFaultReader{reader: io.NopCloser(bytes.NewReader(data)), remaining: int64(len(data) / 2), what: blobObject.String()}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	data, stale, err := blobObject.staleRead()
+	if stale {
+		if err != nil {
+			return nil, errors.Wrap(err, fname)
+		}
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	return blobObject.object.OpenRead(ctx)
+}
+
+func (blobObject *faultyBlobObject) ReadRange(ctx context.Context, offset int64, length int64) ([]byte, error) {
+	const fname = "faultyBlobObject.ReadRange"
+	err := blobObject.store().before(ctx, FaultRead, blobObject.String())
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	data, stale, err := blobObject.staleRead()
+	if stale {
+		if err != nil {
+			return nil, errors.Wrap(err, fname)
+		}
+		return sliceRange(data, offset, length), nil
+	}
+	return blobObject.object.ReadRange(ctx, offset, length)
+}
+
+// rememberPrevious keeps the current content of the object so it can be served by stale reads
+func (blobObject *faultyBlobObject) rememberPrevious(ctx context.Context) {
+	store := blobObject.store()
+	store.mutex.Lock()
+	staleReads := store.config.StaleReadRate > 0
+	store.mutex.Unlock()
+	if !staleReads {
+		return
+	}
+	data, err := blobObject.object.Read(ctx)
+	if err != nil {
+		data = nil
+	}
+	store.mutex.Lock()
+	store.previous[blobObject.path] = data
+	store.mutex.Unlock()
+}
+
+func (blobObject *faultyBlobObject) Write(ctx context.Context, data []byte) (bool, error) {
+	const fname = "faultyBlobObject.Write"
+	store := blobObject.store()
+	err := store.before(ctx, FaultWrite, blobObject.String())
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	if store.roll(FaultWrite, func(config *FaultConfig) float64 { return config.LostWriteRate }) {
+		return true, nil
+	}
+	blobObject.rememberPrevious(ctx)
+	return blobObject.object.Write(ctx, data)
+}
+
+func (blobObject *faultyBlobObject) WriteFrom(ctx context.Context, reader io.Reader) (bool, error) {
+	const fname = "faultyBlobObject.WriteFrom"
+	store := blobObject.store()
+	err := store.before(ctx, FaultWrite, blobObject.String())
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	if store.roll(FaultWrite, func(config *FaultConfig) float64 { return config.LostWriteRate }) {
+		_, err = io.Copy(io.Discard, reader)
+		if err != nil {
+			return false, errors.Wrap(err, fname)
+		}
+		return true, nil
+	}
+	blobObject.rememberPrevious(ctx)
+	return blobObject.object.WriteFrom(ctx, reader)
+}
+
+func (blobObject *faultyBlobObject) Delete(ctx context.Context) error {
+	const fname = "faultyBlobObject.Delete"
+	err := blobObject.store().before(ctx, FaultDelete, blobObject.String())
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	blobObject.rememberPrevious(ctx)
+	return blobObject.object.Delete(ctx)
+}
+
+func (blobObject *faultyBlobObject) String() string {
+	return blobObject.object.String()
+}
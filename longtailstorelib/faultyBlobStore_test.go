@@ -0,0 +1,147 @@
+package longtailstorelib
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/pkg/errors"
+)
+
+func TestFaultyBlobStoreErrors(t *testing.T) {
+	ctx := context.Background()
+	backingStore, _ := NewMemBlobStore("", true)
+	blobStore := NewFaultyBlobStore(backingStore, FaultConfig{ErrorRate: 1, Operations: []FaultOperation{FaultWrite, FaultList}})
+	client, _ := blobStore.NewClient(ctx)
+	defer client.Close()
+
+	object, _ := client.NewObject("block.lrb")
+	ok, err := object.Write(ctx, []byte("data"))
+	assert.False(t, ok)
+	assert.True(t, errors.Is(err, ErrInjectedFault))
+	_, err = client.GetObjects("")
+	assert.True(t, errors.Is(err, ErrInjectedFault))
+	err = client.WalkObjects("", func(BlobProperties) error { return nil })
+	assert.True(t, errors.Is(err, ErrInjectedFault))
+	// Injected errors are retried like any other transient error
+	assert.Equal(t, BlobErrorTransient, ClassifyBlobError(client, err))
+
+	// Operations not listed are not affected
+	exists, err := object.LockWriteVersion(ctx)
+	assert.False(t, exists)
+	assert.NoError(t, err)
+	_, err = object.Read(ctx)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	assert.Equal(t, int64(3), blobStore.InjectedFaultCount())
+
+	blobStore.SetConfig(FaultConfig{})
+	ok, err = object.Write(ctx, []byte("data"))
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	blobStore.SetConfig(FaultConfig{ErrorRate: 1, Operations: []FaultOperation{FaultRead, FaultDelete, FaultLock}})
+	_, err = object.Read(ctx)
+	assert.True(t, errors.Is(err, ErrInjectedFault))
+	_, err = object.LockWriteVersion(ctx)
+	assert.True(t, errors.Is(err, ErrInjectedFault))
+	assert.True(t, errors.Is(object.Delete(ctx), ErrInjectedFault))
+
+	// A failing streaming read fails half way through the object
+	reader, err := object.OpenRead(ctx)
+	assert.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	assert.Equal(t, "da", string(data))
+	assert.True(t, errors.Is(err, ErrInjectedFault))
+}
+
+func TestFaultyBlobStoreLostWritesAndStaleReads(t *testing.T) {
+	ctx := context.Background()
+	backingStore, _ := NewMemBlobStore("", true)
+	blobStore := NewFaultyBlobStore(backingStore, FaultConfig{LostWriteRate: 1})
+	client, _ := blobStore.NewClient(ctx)
+	defer client.Close()
+
+	object, _ := client.NewObject("store.lsi")
+	ok, err := object.Write(ctx, []byte("lost"))
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	exists, _ := object.Exists(ctx)
+	assert.False(t, exists)
+
+	blobStore.SetConfig(FaultConfig{StaleReadRate: 1})
+	object.Write(ctx, []byte("first"))
+	_, err = object.Read(ctx)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	object.Write(ctx, []byte("second"))
+	data, err := object.Read(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", string(data))
+	data, _ = object.ReadRange(ctx, 1, 3)
+	assert.Equal(t, "irs", string(data))
+
+	blobStore.SetConfig(FaultConfig{})
+	data, _ = object.Read(ctx)
+	assert.Equal(t, "second", string(data))
+}
+
+func TestFaultyBlobStoreLatency(t *testing.T) {
+	backingStore, _ := NewMemBlobStore("", true)
+	blobStore := NewFaultyBlobStore(backingStore, FaultConfig{Latency: 50 * time.Millisecond})
+	client, _ := blobStore.NewClient(context.Background())
+	defer client.Close()
+	object, _ := client.NewObject("slow")
+
+	start := time.Now()
+	object.Exists(context.Background())
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := object.Write(ctx, []byte("data"))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestFaultyBlobStoreURI(t *testing.T) {
+	defer DeleteNamedMemBlobStore("faulty")
+	SeedMemBlobs("mem://faulty/store", map[string][]byte{"a.lrb": []byte("a")})
+
+	u, scheme, ok := LookupBlobStoreScheme("fault+mem://faulty/store?error-rate=1&ops=read")
+	assert.True(t, ok)
+	assert.Equal(t, "fault+mem", scheme.Name)
+	blobStore, err := scheme.NewBlobStore(u)
+	assert.NoError(t, err)
+	client, _ := blobStore.NewClient(context.Background())
+	defer client.Close()
+	objects, err := client.GetObjects("")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(objects))
+	object, _ := client.NewObject("a.lrb")
+	_, err = object.Read(context.Background())
+	assert.True(t, errors.Is(err, ErrInjectedFault))
+
+	_, err = CreateBlobStoreForURI("fault+mem://faulty/store?error-rate=2")
+	assert.Error(t, err)
+	_, err = CreateBlobStoreForURI("fault+mem://faulty/store?ops=rename")
+	assert.Error(t, err)
+	_, _, ok = LookupBlobStoreScheme("fault+nope://faulty/store")
+	assert.False(t, ok)
+}
+
+func TestParseFaultConfig(t *testing.T) {
+	query, _ := url.ParseQuery("ops=write,lock&error-rate=0.25&lost-write-rate=0.1&stale-read-rate=0.5&latency=20ms&seed=7")
+	config, err := ParseFaultConfig(query)
+	assert.NoError(t, err)
+	assert.Equal(t, FaultConfig{
+		Operations:    []FaultOperation{FaultWrite, FaultLock},
+		ErrorRate:     0.25,
+		Latency:       20 * time.Millisecond,
+		LostWriteRate: 0.1,
+		StaleReadRate: 0.5,
+		Seed:          7,
+	}, config)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"io/fs"
 	"io/ioutil"
 	"os"
@@ -28,6 +29,7 @@ type fsBlobObject struct {
 	client         *fsBlobClient
 	path           string
 	metageneration int64
+	lockedExists   bool
 }
 
 const UNCPrefix = "\\\\?\\"
@@ -69,9 +71,23 @@ func (blobClient *fsBlobClient) NewObject(filepath string) (BlobObject, error) {
 
 func (blobClient *fsBlobClient) GetObjects(pathPrefix string) ([]BlobProperties, error) {
 	const fname = "fsBlobClient.GetObjects"
+	objects := make([]BlobProperties, 0)
+	err := blobClient.WalkObjects(pathPrefix, func(properties BlobProperties) error {
+		objects = append(objects, properties)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+
+	return objects, nil
+}
+
+func (blobClient *fsBlobClient) WalkObjects(pathPrefix string, walkFn BlobWalkFunc) error {
+	const fname = "fsBlobClient.WalkObjects"
 	searchPath := blobClient.store.prefix
 
-	objects := make([]BlobProperties, 0)
+	var walkErr error
 	err := filepath.Walk(searchPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return nil
@@ -79,7 +95,7 @@ func (blobClient *fsBlobClient) GetObjects(pathPrefix string) ([]BlobProperties,
 		if info.IsDir() {
 			return nil
 		}
-		if strings.HasSuffix(path, "._lck") {
+		if strings.HasSuffix(path, "._lck") || isFSGenerationFile(path) {
 			return nil
 		}
 		leafPath := NormalizeFileSystemPath(path[len(searchPath)+1:])
@@ -87,16 +103,35 @@ func (blobClient *fsBlobClient) GetObjects(pathPrefix string) ([]BlobProperties,
 			return nil
 		}
 		if leafPath[:len(pathPrefix)] == pathPrefix {
-			props := BlobProperties{Size: info.Size(), Name: leafPath}
-			objects = append(objects, props)
+			props := BlobProperties{Size: info.Size(), Name: leafPath, Version: fsObjectVersion(info)}
+			walkErr = walkFn(props)
+			return walkErr
 		}
 		return nil
 	})
+	if walkErr != nil {
+		return walkErr
+	}
 	if err != nil {
-		return nil, errors.Wrap(err, fname)
+		return errors.Wrap(err, fname)
 	}
+	return nil
+}
 
-	return objects, nil
+// fsObjectVersion returns the version of a file, files are written in place so the modification
+// time and size is all there is to go by
+func fsObjectVersion(info os.FileInfo) string {
+	return fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size())
+}
+
+// isFSGenerationFile returns true if path is the generation file of an object, objects that
+// happen to end in .gen are still listed as long as there is no object without the suffix
+func isFSGenerationFile(path string) bool {
+	if !strings.HasSuffix(path, ".gen") {
+		return false
+	}
+	info, err := os.Stat(strings.TrimSuffix(path, ".gen"))
+	return err == nil && !info.IsDir()
 }
 
 func (blobClient *fsBlobClient) SupportsLocking() bool {
@@ -110,7 +145,7 @@ func (blobClient *fsBlobClient) String() string {
 	return blobClient.store.String()
 }
 
-func (blobObject *fsBlobObject) Exists() (bool, error) {
+func (blobObject *fsBlobObject) Exists(ctx context.Context) (bool, error) {
 	const fname = "fsBlobObject.Exists"
 	_, err := os.Stat(blobObject.path)
 	if longtaillib.IsNotExist(err) {
@@ -122,8 +157,20 @@ func (blobObject *fsBlobObject) Exists() (bool, error) {
 	return true, nil
 }
 
-func (blobObject *fsBlobObject) Read() ([]byte, error) {
+func (blobObject *fsBlobObject) Size(ctx context.Context) (int64, error) {
+	const fname = "fsBlobObject.Size"
+	info, err := os.Stat(blobObject.path)
+	if err != nil {
+		return 0, errors.Wrap(err, fname)
+	}
+	return info.Size(), nil
+}
+
+func (blobObject *fsBlobObject) Read(ctx context.Context) ([]byte, error) {
 	const fname = "fsBlobObject.Read"
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
 
 	if blobObject.client.store.enableLocking {
 		filelock, err := blobObject.lockFile()
@@ -145,6 +192,120 @@ func (blobObject *fsBlobObject) Read() ([]byte, error) {
 	return nil, errors.Wrap(err, fname)
 }
 
+func (blobObject *fsBlobObject) ReadIfChanged(ctx context.Context, version string) ([]byte, string, bool, error) {
+	const fname = "fsBlobObject.ReadIfChanged"
+	if err := ctx.Err(); err != nil {
+		return nil, "", false, errors.Wrap(err, fname)
+	}
+
+	if blobObject.client.store.enableLocking {
+		filelock, err := blobObject.lockFile()
+		if err != nil {
+			return nil, "", false, errors.Wrap(err, fname)
+		}
+		defer filelock.Unlock()
+	}
+
+	info, err := os.Stat(blobObject.path)
+	if os.IsNotExist(err) {
+		err = errors.Wrapf(os.ErrNotExist, "%v", err)
+		return nil, "", false, errors.Wrap(err, fname)
+	}
+	if err != nil {
+		return nil, "", false, errors.Wrap(err, fname)
+	}
+	currentVersion := fsObjectVersion(info)
+	if currentVersion == version {
+		return nil, version, false, nil
+	}
+	data, err := ioutil.ReadFile(blobObject.path)
+	if err != nil {
+		var perr *fs.PathError
+		if errors.As(err, &perr) {
+			err = errors.Wrapf(os.ErrNotExist, "%v", err)
+		}
+		return nil, "", false, errors.Wrap(err, fname)
+	}
+	return data, currentVersion, true, nil
+}
+
+// fsBlobReader keeps the file lock until the reader is closed so we don't read a partially written file
+type fsBlobReader struct {
+	file     *os.File
+	filelock *Lock
+}
+
+func (reader *fsBlobReader) Read(p []byte) (int, error) {
+	return reader.file.Read(p)
+}
+
+func (reader *fsBlobReader) Close() error {
+	err := reader.file.Close()
+	if reader.filelock != nil {
+		reader.filelock.Unlock()
+	}
+	return err
+}
+
+func (blobObject *fsBlobObject) OpenRead(ctx context.Context) (io.ReadCloser, error) {
+	const fname = "fsBlobObject.OpenRead"
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+
+	var filelock *Lock
+	if blobObject.client.store.enableLocking {
+		var err error
+		filelock, err = blobObject.lockFile()
+		if err != nil {
+			return nil, errors.Wrap(err, fname)
+		}
+	}
+
+	file, err := os.Open(blobObject.path)
+	if err != nil {
+		if filelock != nil {
+			filelock.Unlock()
+		}
+		var perr *fs.PathError
+		if errors.As(err, &perr) {
+			err = errors.Wrapf(os.ErrNotExist, "%v", err)
+		}
+		return nil, errors.Wrap(err, fname)
+	}
+	return &fsBlobReader{file: file, filelock: filelock}, nil
+}
+
+func (blobObject *fsBlobObject) ReadRange(ctx context.Context, offset int64, length int64) ([]byte, error) {
+	const fname = "fsBlobObject.ReadRange"
+	reader, err := blobObject.OpenRead(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	defer reader.Close()
+
+	file := reader.(*fsBlobReader).file
+	info, err := file.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= info.Size() || length <= 0 {
+		return []byte{}, nil
+	}
+	if offset+length > info.Size() {
+		length = info.Size() - offset
+	}
+	data := make([]byte, length)
+	n, err := file.ReadAt(data, offset)
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, fname)
+	}
+	return data[:n], nil
+}
+
 func (blobObject *fsBlobObject) getMetaGeneration() (int64, error) {
 	const fname = "fsBlobObject.getMetaGeneration"
 	metapath := blobObject.path + ".gen"
@@ -186,6 +347,17 @@ func (blobObject *fsBlobObject) deleteGeneration() error {
 	return nil
 }
 
+// isLockedVersion returns true if the object is unchanged since LockWriteVersion
+func (blobObject *fsBlobObject) isLockedVersion(exists bool, currentMetaGeneration int64) bool {
+	if exists != blobObject.lockedExists {
+		return false
+	}
+	if !exists {
+		return true
+	}
+	return currentMetaGeneration == blobObject.metageneration
+}
+
 func (blobObject *fsBlobObject) lockFile() (*Lock, error) {
 	const fname = "fsBlobObject.lockFile"
 
@@ -204,7 +376,7 @@ func (blobObject *fsBlobObject) lockFile() (*Lock, error) {
 	return filelock, nil
 }
 
-func (blobObject *fsBlobObject) LockWriteVersion() (bool, error) {
+func (blobObject *fsBlobObject) LockWriteVersion(ctx context.Context) (bool, error) {
 	const fname = "fsBlobObject.LockWriteVersion"
 
 	if !blobObject.client.store.enableLocking {
@@ -218,7 +390,7 @@ func (blobObject *fsBlobObject) LockWriteVersion() (bool, error) {
 	}
 	defer filelock.Unlock()
 
-	exists, err := blobObject.Exists()
+	exists, err := blobObject.Exists(ctx)
 	if err != nil {
 		return false, err
 	}
@@ -231,12 +403,53 @@ func (blobObject *fsBlobObject) LockWriteVersion() (bool, error) {
 	} else {
 		blobObject.metageneration = 0
 	}
+	blobObject.lockedExists = exists
 
 	return exists, err
 }
 
-func (blobObject *fsBlobObject) Write(data []byte) (bool, error) {
+func (blobObject *fsBlobObject) Write(ctx context.Context, data []byte) (bool, error) {
 	const fname = "fsBlobObject.Write"
+	if err := ctx.Err(); err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+
+	ok, err := blobObject.writeContent(func() error {
+		return ioutil.WriteFile(blobObject.path, data, 0644)
+	})
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	return ok, nil
+}
+
+func (blobObject *fsBlobObject) WriteFrom(ctx context.Context, reader io.Reader) (bool, error) {
+	const fname = "fsBlobObject.WriteFrom"
+	if err := ctx.Err(); err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+
+	ok, err := blobObject.writeContent(func() error {
+		file, err := os.OpenFile(blobObject.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, reader)
+		closeErr := file.Close()
+		if err != nil {
+			return err
+		}
+		return closeErr
+	})
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	return ok, nil
+}
+
+// writeContent checks the write condition and updates the meta generation around writeFunc
+func (blobObject *fsBlobObject) writeContent(writeFunc func() error) (bool, error) {
+	const fname = "fsBlobObject.writeContent"
 
 	if blobObject.client.store.enableLocking {
 		filelock, err := blobObject.lockFile()
@@ -251,35 +464,42 @@ func (blobObject *fsBlobObject) Write(data []byte) (bool, error) {
 		return false, errors.Wrap(err, fname)
 	}
 
+	exists := false
+	currentMetaGeneration := int64(0)
 	if blobObject.client.store.enableLocking {
-		if blobObject.metageneration != -1 {
-			currentMetaGeneration, err := blobObject.getMetaGeneration()
-			if err != nil {
-				return false, errors.Wrap(err, fname)
-			}
-			if currentMetaGeneration != blobObject.metageneration {
-				return false, nil
-			}
+		exists, err = blobObject.Exists(context.Background())
+		if err != nil {
+			return false, errors.Wrap(err, fname)
+		}
+		currentMetaGeneration, err = blobObject.getMetaGeneration()
+		if err != nil {
+			return false, errors.Wrap(err, fname)
+		}
+		if blobObject.metageneration != -1 && !blobObject.isLockedVersion(exists, currentMetaGeneration) {
+			return false, nil
 		}
 	}
 
-	err = ioutil.WriteFile(blobObject.path, data, 0644)
+	err = writeFunc()
 	if err != nil {
 		return false, errors.Wrap(err, fname)
 	}
-	if blobObject.client.store.enableLocking {
-		if blobObject.metageneration != -1 {
-			err = blobObject.setMetaGeneration(blobObject.metageneration + 1)
-			if err != nil {
-				return false, errors.Wrap(err, fname)
-			}
+	// Overwrites bump the generation even without a lock so locked writers notice the change,
+	// new objects written without a lock don't need a generation file which keeps blocks cheap
+	if blobObject.client.store.enableLocking && (blobObject.metageneration != -1 || exists) {
+		err = blobObject.setMetaGeneration(currentMetaGeneration + 1)
+		if err != nil {
+			return false, errors.Wrap(err, fname)
 		}
 	}
 	return true, nil
 }
 
-func (blobObject *fsBlobObject) Delete() error {
+func (blobObject *fsBlobObject) Delete(ctx context.Context) error {
 	const fname = "fsBlobObject.Delete"
+	if err := ctx.Err(); err != nil {
+		return errors.Wrap(err, fname)
+	}
 
 	if blobObject.client.store.enableLocking {
 		filelock, err := blobObject.lockFile()
@@ -289,17 +509,26 @@ func (blobObject *fsBlobObject) Delete() error {
 		defer filelock.Unlock()
 
 		if blobObject.metageneration != -1 {
+			exists, err := blobObject.Exists(ctx)
+			if err != nil {
+				return errors.Wrap(err, fname)
+			}
 			currentMetaGeneration, err := blobObject.getMetaGeneration()
 			if err != nil {
 				return errors.Wrap(err, fname)
 			}
-			if currentMetaGeneration != blobObject.metageneration {
-				err = fmt.Errorf(fmt.Sprintf("Failed to delete `%s`, meta generation mismatch", blobObject.path))
+			// Deleting an object that is already gone is fine even if it was deleted after the lock
+			if exists && !blobObject.isLockedVersion(exists, currentMetaGeneration) {
+				err = errors.Wrapf(ErrBlobVersionChanged, "Failed to delete `%s`, meta generation mismatch", blobObject.path)
 				return errors.Wrap(err, fname)
 			}
 		}
 	}
 	err := os.Remove(blobObject.path)
+	if longtaillib.IsNotExist(err) {
+		// Already deleted, just make sure there is no generation file left behind
+		return blobObject.deleteGeneration()
+	}
 	if err != nil {
 		return errors.Wrap(err, fname)
 	}
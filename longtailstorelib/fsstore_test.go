@@ -3,6 +3,7 @@ package longtailstorelib
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"sync"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/DanEngelbrecht/golongtail/longtaillib"
 	"github.com/alecthomas/assert/v2"
+	"github.com/pkg/errors"
 )
 
 func TestFSBlobStore(t *testing.T) {
@@ -24,7 +26,7 @@ func TestFSBlobStore(t *testing.T) {
 	object, err := client.NewObject("test.txt")
 	assert.NoError(t, err)
 	assert.NotEqual(t, object, nil)
-	ok, err := object.Write([]byte("apa"))
+	ok, err := object.Write(context.Background(), []byte("apa"))
 	assert.True(t, ok)
 	assert.NoError(t, err)
 }
@@ -44,7 +46,7 @@ func TestListObjectsInEmptyFSStore(t *testing.T) {
 	obj, err := client.NewObject("should-not-exist")
 	assert.NoError(t, err)
 	assert.NotEqual(t, obj, nil)
-	data, err := obj.Read()
+	data, err := obj.Read(context.Background())
 	assert.True(t, longtaillib.IsNotExist(err))
 	assert.Equal(t, data, nil)
 }
@@ -61,30 +63,30 @@ func TestFSBlobStoreVersioning(t *testing.T) {
 	object, err := client.NewObject("test.txt")
 	assert.NoError(t, err)
 	assert.NotEqual(t, object, nil)
-	object.Delete()
-	exists, err := object.LockWriteVersion()
+	object.Delete(context.Background())
+	exists, err := object.LockWriteVersion(context.Background())
 	assert.NoError(t, err)
 	assert.False(t, exists)
-	ok, err := object.Write([]byte("apa"))
+	ok, err := object.Write(context.Background(), []byte("apa"))
 	assert.True(t, ok)
 	assert.NoError(t, err)
-	ok, err = object.Write([]byte("skapa"))
+	ok, err = object.Write(context.Background(), []byte("skapa"))
 	assert.False(t, ok)
 	assert.NoError(t, err)
-	exists, err = object.LockWriteVersion()
+	exists, err = object.LockWriteVersion(context.Background())
 	assert.NoError(t, err)
 	assert.True(t, exists)
-	ok, err = object.Write([]byte("skapa"))
+	ok, err = object.Write(context.Background(), []byte("skapa"))
 	assert.True(t, ok)
 	assert.NoError(t, err)
-	_, err = object.Read()
+	_, err = object.Read(context.Background())
 	assert.NoError(t, err)
-	err = object.Delete()
-	assert.Error(t, err)
-	exists, err = object.LockWriteVersion()
+	err = object.Delete(context.Background())
+	assert.True(t, errors.Is(err, ErrBlobVersionChanged))
+	exists, err = object.LockWriteVersion(context.Background())
 	assert.True(t, exists)
 	assert.NoError(t, err)
-	err = object.Delete()
+	err = object.Delete(context.Background())
 	assert.NoError(t, err)
 }
 
@@ -118,7 +120,7 @@ func TestFSBlobStoreVersioningStressTest(t *testing.T) {
 	object, err := client.NewObject("test.txt")
 	assert.NoError(t, err)
 	assert.NotEqual(t, object, nil)
-	data, err := object.Read()
+	data, err := object.Read(context.Background())
 	assert.NoError(t, err)
 	sliceData := strings.Split(string(data), "\n")
 	assert.Equal(t, len(sliceData), 5*5)
@@ -128,6 +130,43 @@ func TestFSBlobStoreVersioningStressTest(t *testing.T) {
 	}
 }
 
+func TestFSBlobStoreReadRangeAndStreaming(t *testing.T) {
+	storePath, _ := os.MkdirTemp("", "test")
+	blobStore, err := NewFSBlobStore(storePath, true)
+	assert.NoError(t, err)
+	client, err := blobStore.NewClient(context.Background())
+	assert.NoError(t, err)
+	defer client.Close()
+	object, err := client.NewObject("test.txt")
+	assert.NoError(t, err)
+	exists, err := object.LockWriteVersion(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	ok, err := object.WriteFrom(context.Background(), strings.NewReader("0123456789"))
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	ok, err = object.WriteFrom(context.Background(), strings.NewReader("stale"))
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	data, err := object.ReadRange(context.Background(), 2, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "2345", string(data))
+	data, err = object.ReadRange(context.Background(), 8, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "89", string(data))
+	data, err = object.ReadRange(context.Background(), 20, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(data))
+
+	reader, err := object.OpenRead(context.Background())
+	assert.NoError(t, err)
+	data, err = io.ReadAll(reader)
+	reader.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, "0123456789", string(data))
+}
+
 func TestFSGetObjects(t *testing.T) {
 	storePath, _ := os.MkdirTemp("", "test")
 	blobStore, err := NewFSBlobStore(storePath, false)
@@ -144,7 +183,7 @@ func TestFSGetObjects(t *testing.T) {
 		if err != nil {
 			t.Errorf("client.NewObject() err == %s", err)
 		}
-		object.Write([]byte(name))
+		object.Write(context.Background(), []byte(name))
 	}
 
 	blobs, err := client.GetObjects("")
@@ -154,4 +193,42 @@ func TestFSGetObjects(t *testing.T) {
 	nestedBlobs, err := client.GetObjects("nest")
 	assert.NoError(t, err)
 	assert.Equal(t, len(nestedBlobs), 2)
+
+	var walkedBlobs []BlobProperties
+	err = client.WalkObjects("nest", func(properties BlobProperties) error {
+		walkedBlobs = append(walkedBlobs, properties)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, nestedBlobs, walkedBlobs)
+}
+
+func TestFSGetObjectsWithGenSuffix(t *testing.T) {
+	blobStore, err := NewFSBlobStore(t.TempDir(), true)
+	assert.NoError(t, err)
+	client, err := blobStore.NewClient(context.Background())
+	assert.NoError(t, err)
+	defer client.Close()
+
+	// The generation file of locked.txt is hidden, an object that only ends in .gen is not
+	locked, _ := client.NewObject("locked.txt")
+	_, err = locked.LockWriteVersion(context.Background())
+	assert.NoError(t, err)
+	_, err = locked.Write(context.Background(), []byte("locked"))
+	assert.NoError(t, err)
+	_, err = locked.LockWriteVersion(context.Background())
+	assert.NoError(t, err)
+	_, err = locked.Write(context.Background(), []byte("locked again"))
+	assert.NoError(t, err)
+	object, _ := client.NewObject("data.gen")
+	_, err = object.Write(context.Background(), []byte("data"))
+	assert.NoError(t, err)
+
+	blobs, err := client.GetObjects("")
+	assert.NoError(t, err)
+	names := []string{}
+	for _, blob := range blobs {
+		names = append(names, blob.Name)
+	}
+	assert.Equal(t, []string{"data.gen", "locked.txt"}, names)
 }
@@ -31,18 +31,18 @@ func TestGCSBlobStore(t *testing.T) {
 	{
 		// Clean up any old test data
 		object, _ := client.NewObject("test.txt")
-		object.Delete()
+		object.Delete(context.Background())
 		object, _ = client.NewObject("path/first.txt")
-		object.Delete()
+		object.Delete(context.Background())
 		object, _ = client.NewObject("path/second.txt")
-		object.Delete()
+		object.Delete(context.Background())
 	}
 	object, err := client.NewObject("test.txt")
 	if err != nil {
 		t.Errorf("client.NewObject() err == %s", err)
 	}
 
-	exists, err := object.Exists()
+	exists, err := object.Exists(context.Background())
 	if err != nil {
 		t.Error("object.Exists() err != nil")
 	}
@@ -50,13 +50,13 @@ func TestGCSBlobStore(t *testing.T) {
 		t.Error("object.Exists() true != false")
 	}
 
-	data, err := object.Read()
+	data, err := object.Read(context.Background())
 	if data != nil && err != nil {
 		t.Errorf("object.Read() nil != %v", err)
 	}
 
 	testData := []byte("apa")
-	ok, err := object.Write(testData)
+	ok, err := object.Write(context.Background(), testData)
 	if !ok {
 		t.Errorf("object.Write() ok != true")
 	}
@@ -64,7 +64,7 @@ func TestGCSBlobStore(t *testing.T) {
 		t.Errorf("object.Write() err == %s", err)
 	}
 
-	exists, err = object.Exists()
+	exists, err = object.Exists(context.Background())
 	if err != nil {
 		t.Error("object.Exists() err != nil")
 	}
@@ -79,7 +79,7 @@ func TestGCSBlobStore(t *testing.T) {
 	if blobs[0].Name != "test.txt" {
 		t.Errorf("blobs[0].Name %s != %s", blobs[0].Name, "test.txt")
 	}
-	data, err = object.Read()
+	data, err = object.Read(context.Background())
 	if len(data) != 3 {
 		t.Errorf("len(data) %d != %d", len(data), 3)
 	}
@@ -90,11 +90,11 @@ func TestGCSBlobStore(t *testing.T) {
 	}
 
 	object, _ = client.NewObject("path/first.txt")
-	object.Delete()
-	_, _ = object.Write([]byte("dog"))
+	object.Delete(context.Background())
+	_, _ = object.Write(context.Background(), []byte("dog"))
 	object, _ = client.NewObject("path/second.txt")
-	object.Delete()
-	_, _ = object.Write([]byte("cat"))
+	object.Delete(context.Background())
+	_, _ = object.Write(context.Background(), []byte("cat"))
 
 	objects, _ := client.GetObjects("")
 	if len(objects) != 3 {
@@ -129,7 +129,7 @@ func TestListObjectsInEmptyGCSStore(t *testing.T) {
 		t.Errorf("TestListObjectsInEmptyGCSStore() client.GetObjects(\"\")) %d != %d", len(objects), 0)
 	}
 	obj, _ := client.NewObject("should-not-exist")
-	data, err := obj.Read()
+	data, err := obj.Read(context.Background())
 	if !longtaillib.IsNotExist(err) {
 		t.Errorf("TestListObjectsInEmptyGCSStore() obj.Read()) %s", err)
 	}
@@ -157,71 +157,71 @@ func TestGCSBlobStoreVersioning(t *testing.T) {
 	{
 		// Clean up any old test data
 		object, _ := client.NewObject("test.txt")
-		object.Delete()
+		object.Delete(context.Background())
 	}
 	object, err := client.NewObject("test.txt")
 	if err != nil {
 		t.Errorf("client.NewObject() err == %s", err)
 	}
-	exists, err := object.LockWriteVersion()
+	exists, err := object.LockWriteVersion(context.Background())
 	if err != nil {
 		t.Errorf("object.LockWriteVersion() err == %s", err)
 	}
 	if exists {
 		t.Errorf("object.LockWriteVersion() exists != false")
 	}
-	ok, err := object.Write([]byte("apa"))
+	ok, err := object.Write(context.Background(), []byte("apa"))
 	if !ok {
 		t.Errorf("object.Write() ok != true")
 	}
 	if err != nil {
 		t.Errorf("object.Write() err == %s", err)
 	}
-	exists, err = object.Exists()
+	exists, err = object.Exists(context.Background())
 	if err != nil {
 		t.Error("object.Exists() err != nil")
 	}
 	if !exists {
 		t.Error("object.Exists() false != true")
 	}
-	ok, err = object.Write([]byte("skapa"))
+	ok, err = object.Write(context.Background(), []byte("skapa"))
 	if ok {
 		t.Errorf("object.Write() ok != false")
 	}
 	if err != nil {
 		t.Errorf("object.Write() err == %s", err)
 	}
-	exists, err = object.LockWriteVersion()
+	exists, err = object.LockWriteVersion(context.Background())
 	if err != nil {
 		t.Errorf("object.LockWriteVersion() err == %s", err)
 	}
 	if !exists {
 		t.Errorf("object.LockWriteVersion() exists == false")
 	}
-	ok, err = object.Write([]byte("skapa"))
+	ok, err = object.Write(context.Background(), []byte("skapa"))
 	if !ok {
 		t.Errorf("object.Write() ok == false")
 	}
 	if err != nil {
 		t.Errorf("object.Write() err == %s", err)
 	}
-	_, err = object.Read()
+	_, err = object.Read(context.Background())
 	if err != nil {
 		t.Errorf("object.Read() err == %s", err)
 	}
-	err = object.Delete()
+	err = object.Delete(context.Background())
 	if err == nil {
 		t.Error("object.Delete() err != nil")
 	}
-	exists, err = object.LockWriteVersion()
+	exists, err = object.LockWriteVersion(context.Background())
 	if err != nil {
 		t.Errorf("object.LockWriteVersion() err == %s", err)
 	}
-	err = object.Delete()
+	err = object.Delete(context.Background())
 	if err != nil {
 		t.Errorf("object.Delete() err == %s", err)
 	}
-	exists, err = object.Exists()
+	exists, err = object.Exists(context.Background())
 	if err != nil {
 		t.Error("object.Exists() err != nil")
 	}
@@ -246,7 +246,7 @@ func TestGCSBlobStoreVersioningStressTest(t *testing.T) {
 	{
 		// Clean up any old test data
 		object, _ := client.NewObject("test.txt")
-		object.Delete()
+		object.Delete(context.Background())
 	}
 
 	var wg sync.WaitGroup
@@ -274,7 +274,7 @@ func TestGCSBlobStoreVersioningStressTest(t *testing.T) {
 	if err != nil {
 		t.Errorf("client.NewObject() err == %s", err)
 	}
-	data, err := object.Read()
+	data, err := object.Read(context.Background())
 	if err != nil {
 		t.Errorf("object.Read() err == %s", err)
 	}
@@ -1,11 +1,14 @@
 package longtailstorelib
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/url"
 	"os"
+	"strconv"
 
 	"cloud.google.com/go/storage"
 	"github.com/pkg/errors"
@@ -28,7 +31,6 @@ type gcsBlobClient struct {
 
 type gcsBlobObject struct {
 	objHandle      *storage.ObjectHandle
-	ctx            context.Context
 	path           string
 	writeCondition *storage.Conditions
 	client         *gcsBlobClient
@@ -39,6 +41,7 @@ const (
 	metadataForObjectChanged = 409
 	writeConditionFailed     = 412
 	rateLimitExceeded        = 429
+	rangeNotSatisfiable      = 416
 )
 
 // NewGCSBlobStore ...
@@ -82,7 +85,6 @@ func (blobClient *gcsBlobClient) NewObject(path string) (BlobObject, error) {
 	objHandle := blobClient.bucket.Object(gcsPath)
 	return &gcsBlobObject{
 			objHandle:      objHandle,
-			ctx:            blobClient.ctx,
 			path:           gcsPath,
 			writeCondition: nil,
 			client:         blobClient},
@@ -92,6 +94,18 @@ func (blobClient *gcsBlobClient) NewObject(path string) (BlobObject, error) {
 func (blobClient *gcsBlobClient) GetObjects(pathPrefix string) ([]BlobProperties, error) {
 	const fname = "gcsBlobClient.GetObjects"
 	var items []BlobProperties
+	err := blobClient.WalkObjects(pathPrefix, func(properties BlobProperties) error {
+		items = append(items, properties)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return items, nil
+}
+
+func (blobClient *gcsBlobClient) WalkObjects(pathPrefix string, walkFn BlobWalkFunc) error {
+	const fname = "gcsBlobClient.WalkObjects"
 	it := blobClient.bucket.Objects(blobClient.ctx, &storage.Query{
 		Prefix: blobClient.store.prefix + pathPrefix,
 	})
@@ -102,12 +116,15 @@ func (blobClient *gcsBlobClient) GetObjects(pathPrefix string) ([]BlobProperties
 			break
 		}
 		if err != nil {
-			return nil, errors.Wrap(err, fname)
+			return errors.Wrap(err, fname)
 		}
 		itemName := attrs.Name[len(blobClient.store.prefix):]
-		items = append(items, BlobProperties{Size: attrs.Size, Name: itemName})
+		err = walkFn(BlobProperties{Size: attrs.Size, Name: itemName, Version: strconv.FormatInt(attrs.Generation, 10)})
+		if err != nil {
+			return err
+		}
 	}
-	return items, nil
+	return nil
 }
 
 func (blobClient *gcsBlobClient) SupportsLocking() bool {
@@ -122,9 +139,22 @@ func (blobClient *gcsBlobClient) String() string {
 	return blobClient.store.String()
 }
 
-func (blobObject *gcsBlobObject) Read() ([]byte, error) {
+func (blobClient *gcsBlobClient) ClassifyError(err error) BlobErrorClass {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return classifyHTTPStatus(apiErr.Code)
+	}
+	if class, ok := classifyNetworkError(err); ok {
+		return class
+	}
+	return BlobErrorPermanent
+}
+
+func (blobObject *gcsBlobObject) Read(ctx context.Context) ([]byte, error) {
 	const fname = "gcsBlobObject.Read"
-	reader, err := blobObject.objHandle.NewReader(blobObject.ctx)
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	reader, err := blobObject.objHandle.NewReader(ctx)
 	if errors.Is(err, storage.ErrObjectNotExist) {
 		err = errors.Wrapf(os.ErrNotExist, "%v", err)
 		return nil, errors.Wrap(err, fname)
@@ -144,9 +174,87 @@ func (blobObject *gcsBlobObject) Read() ([]byte, error) {
 	return data, nil
 }
 
-func (blobObject *gcsBlobObject) LockWriteVersion() (bool, error) {
+func (blobObject *gcsBlobObject) ReadIfChanged(ctx context.Context, version string) ([]byte, string, bool, error) {
+	const fname = "gcsBlobObject.ReadIfChanged"
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	if version != "" {
+		attrs, err := blobObject.objHandle.Attrs(ctx)
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			err = errors.Wrapf(os.ErrNotExist, "%v", err)
+			return nil, "", false, errors.Wrap(err, fname)
+		}
+		if err != nil {
+			return nil, "", false, errors.Wrap(err, fname)
+		}
+		if strconv.FormatInt(attrs.Generation, 10) == version {
+			return nil, version, false, nil
+		}
+	}
+	reader, err := blobObject.objHandle.NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		err = errors.Wrapf(os.ErrNotExist, "%v", err)
+		return nil, "", false, errors.Wrap(err, fname)
+	}
+	if err != nil {
+		return nil, "", false, errors.Wrap(err, fname)
+	}
+	data, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return nil, "", false, errors.Wrap(err, fname)
+	}
+	// The generation of the reader is the generation of the data read, the object may have been replaced after Attrs
+	return data, strconv.FormatInt(reader.Attrs.Generation, 10), true, nil
+}
+
+func (blobObject *gcsBlobObject) OpenRead(ctx context.Context) (io.ReadCloser, error) {
+	const fname = "gcsBlobObject.OpenRead"
+	reader, err := blobObject.objHandle.NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		err = errors.Wrapf(os.ErrNotExist, "%v", err)
+		return nil, errors.Wrap(err, fname)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return reader, nil
+}
+
+func (blobObject *gcsBlobObject) ReadRange(ctx context.Context, offset int64, length int64) ([]byte, error) {
+	const fname = "gcsBlobObject.ReadRange"
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	if length <= 0 {
+		return []byte{}, nil
+	}
+	reader, err := blobObject.objHandle.NewRangeReader(ctx, offset, length)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		err = errors.Wrapf(os.ErrNotExist, "%v", err)
+		return nil, errors.Wrap(err, fname)
+	}
+	if e, ok := err.(*googleapi.Error); ok && e.Code == rangeNotSatisfiable {
+		return []byte{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	data, err := ioutil.ReadAll(reader)
+	err2 := reader.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	if err2 != nil {
+		return nil, errors.Wrap(err2, fname)
+	}
+	return data, nil
+}
+
+func (blobObject *gcsBlobObject) LockWriteVersion(ctx context.Context) (bool, error) {
 	const fname = "gcsBlobObject.LockWriteVersion"
-	objAttrs, err := blobObject.objHandle.Attrs(blobObject.ctx)
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	objAttrs, err := blobObject.objHandle.Attrs(ctx)
 	if errors.Is(err, storage.ErrObjectNotExist) {
 		blobObject.writeCondition = &storage.Conditions{DoesNotExist: true}
 		return false, nil
@@ -159,9 +267,11 @@ func (blobObject *gcsBlobObject) LockWriteVersion() (bool, error) {
 	return true, nil
 }
 
-func (blobObject *gcsBlobObject) Exists() (bool, error) {
+func (blobObject *gcsBlobObject) Exists(ctx context.Context) (bool, error) {
 	const fname = "gcsBlobObject.Exists"
-	_, err := blobObject.objHandle.Attrs(blobObject.ctx)
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	_, err := blobObject.objHandle.Attrs(ctx)
 	if errors.Is(err, storage.ErrObjectNotExist) {
 		return false, nil
 	}
@@ -171,16 +281,42 @@ func (blobObject *gcsBlobObject) Exists() (bool, error) {
 	return true, nil
 }
 
-func (blobObject *gcsBlobObject) Write(data []byte) (bool, error) {
+func (blobObject *gcsBlobObject) Size(ctx context.Context) (int64, error) {
+	const fname = "gcsBlobObject.Size"
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	attrs, err := blobObject.objHandle.Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		err = errors.Wrapf(os.ErrNotExist, "%s does not exist", blobObject.String())
+		return 0, errors.Wrap(err, fname)
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, fname)
+	}
+	return attrs.Size, nil
+}
+
+func (blobObject *gcsBlobObject) Write(ctx context.Context, data []byte) (bool, error) {
 	const fname = "gcsBlobObject.Write"
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	ok, err := blobObject.WriteFrom(ctx, bytes.NewReader(data))
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	return ok, nil
+}
+
+func (blobObject *gcsBlobObject) WriteFrom(ctx context.Context, reader io.Reader) (bool, error) {
+	const fname = "gcsBlobObject.WriteFrom"
 	var writer *storage.Writer
 	if blobObject.writeCondition == nil {
-		writer = blobObject.objHandle.NewWriter(blobObject.ctx)
+		writer = blobObject.objHandle.NewWriter(ctx)
 	} else {
-		writer = blobObject.objHandle.If(*blobObject.writeCondition).NewWriter(blobObject.ctx)
+		writer = blobObject.objHandle.If(*blobObject.writeCondition).NewWriter(ctx)
 	}
 
-	_, err := writer.Write(data)
+	_, err := io.Copy(writer, reader)
 	err2 := writer.Close()
 	if err != nil {
 		return false, errors.Wrap(err, fname)
@@ -189,32 +325,36 @@ func (blobObject *gcsBlobObject) Write(data []byte) (bool, error) {
 		if e.Code == writeConditionFailed || e.Code == metadataForObjectChanged || e.Code == rateLimitExceeded {
 			return false, nil
 		}
-		return false, errors.Wrap(err, fname)
+		return false, errors.Wrap(err2, fname)
 	} else if err2 != nil {
-		return false, errors.Wrap(err, fname)
+		return false, errors.Wrap(err2, fname)
 	}
 
-	_, err = blobObject.objHandle.Update(blobObject.ctx, storage.ObjectAttrsToUpdate{ContentType: "application/octet-stream"})
+	_, err = blobObject.objHandle.Update(ctx, storage.ObjectAttrsToUpdate{ContentType: "application/octet-stream"})
 	if err != nil {
 		return true, errors.Wrap(err, fname)
 	}
 	return true, nil
 }
 
-func (blobObject *gcsBlobObject) Delete() error {
+func (blobObject *gcsBlobObject) Delete(ctx context.Context) error {
 	const fname = "gcsBlobObject.Delete"
-	_, err := blobObject.objHandle.Attrs(blobObject.ctx)
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	var err error
+	if blobObject.writeCondition == nil {
+		err = blobObject.objHandle.Delete(ctx)
+	} else {
+		err = blobObject.objHandle.If(*blobObject.writeCondition).Delete(ctx)
+	}
 	if errors.Is(err, storage.ErrObjectNotExist) {
 		return nil
 	}
-	if err != nil {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && (apiErr.Code == writeConditionFailed || apiErr.Code == metadataForObjectChanged) {
+		err = errors.Wrapf(ErrBlobVersionChanged, "%s: %v", blobObject.String(), err)
 		return errors.Wrap(err, fname)
 	}
-	if blobObject.writeCondition == nil {
-		err = blobObject.objHandle.Delete(blobObject.ctx)
-	} else {
-		err = blobObject.objHandle.If(*blobObject.writeCondition).Delete(blobObject.ctx)
-	}
 	if err != nil {
 		return errors.Wrap(err, fname)
 	}
@@ -0,0 +1,368 @@
+package longtailstorelib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// HTTPManifestName is the name of the optional listing file for http(s) stores.
+// HTTP has no way of listing content so GetObjects() reads the manifest instead,
+// each line in the manifest is `<size> <name>` with names relative to the store root.
+// Without a manifest only the `store.lsi` store index can be discovered.
+const HTTPManifestName = "longtail.manifest"
+
+var errHTTPRangeNotSatisfiable = errors.New("range not satisfiable")
+
+type httpStatusError struct {
+	method     string
+	url        string
+	status     string
+	statusCode int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.method, e.url, e.status)
+}
+
+type httpBlobStore struct {
+	baseURL string
+}
+
+type httpBlobClient struct {
+	ctx    context.Context
+	store  *httpBlobStore
+	client *http.Client
+}
+
+type httpBlobObject struct {
+	client *httpBlobClient
+	path   string
+}
+
+// NewHTTPBlobStore ...
+func NewHTTPBlobStore(u *url.URL) (BlobStore, error) {
+	const fname = "NewHTTPBlobStore"
+	if u.Scheme != "http" && u.Scheme != "https" {
+		err := fmt.Errorf("invalid scheme '%s', expected 'http' or 'https'", u.Scheme)
+		return nil, errors.Wrap(err, fname)
+	}
+	baseURL := *u
+	baseURL.RawQuery = ""
+	baseURL.Fragment = ""
+	s := &httpBlobStore{baseURL: strings.TrimSuffix(baseURL.String(), "/") + "/"}
+	return s, nil
+}
+
+func (blobStore *httpBlobStore) NewClient(ctx context.Context) (BlobClient, error) {
+	return &httpBlobClient{ctx: ctx, store: blobStore, client: &http.Client{}}, nil
+}
+
+func (blobStore *httpBlobStore) String() string {
+	return blobStore.baseURL
+}
+
+func (blobClient *httpBlobClient) NewObject(path string) (BlobObject, error) {
+	return &httpBlobObject{
+			client: blobClient,
+			path:   path},
+		nil
+}
+
+func (blobClient *httpBlobClient) GetObjects(pathPrefix string) ([]BlobProperties, error) {
+	const fname = "httpBlobClient.GetObjects"
+	manifest, err := blobClient.NewObject(HTTPManifestName)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	data, err := manifest.Read(blobClient.ctx)
+	if errors.Is(err, os.ErrNotExist) {
+		return blobClient.getWellKnownObjects(pathPrefix)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	items, err := ParseHTTPManifest(data)
+	if err != nil {
+		err = errors.Wrapf(err, "Failed parsing `%s`", manifest.String())
+		return nil, errors.Wrap(err, fname)
+	}
+	var filteredItems []BlobProperties
+	for _, item := range items {
+		if strings.HasPrefix(item.Name, pathPrefix) {
+			filteredItems = append(filteredItems, item)
+		}
+	}
+	return filteredItems, nil
+}
+
+func (blobClient *httpBlobClient) WalkObjects(pathPrefix string, walkFn BlobWalkFunc) error {
+	const fname = "httpBlobClient.WalkObjects"
+	// The manifest is a single file so there is nothing to gain by streaming it
+	items, err := blobClient.GetObjects(pathPrefix)
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	for _, item := range items {
+		err = walkFn(item)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (blobClient *httpBlobClient) getWellKnownObjects(pathPrefix string) ([]BlobProperties, error) {
+	const fname = "httpBlobClient.getWellKnownObjects"
+	var items []BlobProperties
+	if !strings.HasPrefix("store.lsi", pathPrefix) {
+		return items, nil
+	}
+	object := &httpBlobObject{client: blobClient, path: "store.lsi"}
+	size, err := object.head(blobClient.ctx)
+	if errors.Is(err, os.ErrNotExist) {
+		return items, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	items = append(items, BlobProperties{Size: size, Name: "store.lsi"})
+	return items, nil
+}
+
+func (blobClient *httpBlobClient) SupportsLocking() bool {
+	return false
+}
+
+func (blobClient *httpBlobClient) Close() {
+	blobClient.client.CloseIdleConnections()
+}
+
+func (blobClient *httpBlobClient) ClassifyError(err error) BlobErrorClass {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return classifyHTTPStatus(statusErr.statusCode)
+	}
+	if class, ok := classifyNetworkError(err); ok {
+		return class
+	}
+	return BlobErrorPermanent
+}
+
+func (blobClient *httpBlobClient) String() string {
+	return blobClient.store.String()
+}
+
+func (blobObject *httpBlobObject) url() string {
+	return blobObject.client.store.baseURL + blobObject.path
+}
+
+func (blobObject *httpBlobObject) do(ctx context.Context, method string) (*http.Response, error) {
+	return blobObject.doWithHeader(ctx, method, nil)
+}
+
+func (blobObject *httpBlobObject) doWithHeader(ctx context.Context, method string, header http.Header) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, blobObject.url(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	response, err := blobObject.client.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode == http.StatusNotFound || response.StatusCode == http.StatusGone {
+		response.Body.Close()
+		err = fmt.Errorf("%s %s: %s", method, blobObject.url(), response.Status)
+		return nil, errors.Wrapf(os.ErrNotExist, "%v", err)
+	}
+	if response.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		response.Body.Close()
+		return nil, errHTTPRangeNotSatisfiable
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		response.Body.Close()
+		return nil, &httpStatusError{method: method, url: blobObject.url(), status: response.Status, statusCode: response.StatusCode}
+	}
+	return response, nil
+}
+
+func (blobObject *httpBlobObject) head(ctx context.Context) (int64, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	response, err := blobObject.do(ctx, http.MethodHead)
+	if err != nil {
+		return 0, err
+	}
+	response.Body.Close()
+	return response.ContentLength, nil
+}
+
+func (blobObject *httpBlobObject) Read(ctx context.Context) ([]byte, error) {
+	const fname = "httpBlobObject.Read"
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	response, err := blobObject.do(ctx, http.MethodGet)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	data, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return data, nil
+}
+
+func (blobObject *httpBlobObject) OpenRead(ctx context.Context) (io.ReadCloser, error) {
+	const fname = "httpBlobObject.OpenRead"
+	response, err := blobObject.do(ctx, http.MethodGet)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return response.Body, nil
+}
+
+func (blobObject *httpBlobObject) ReadRange(ctx context.Context, offset int64, length int64) ([]byte, error) {
+	const fname = "httpBlobObject.ReadRange"
+	if length <= 0 {
+		return []byte{}, nil
+	}
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	response, err := blobObject.doWithHeader(ctx, http.MethodGet, header)
+	if err == errHTTPRangeNotSatisfiable {
+		return []byte{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusPartialContent {
+		data, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			return nil, errors.Wrap(err, fname)
+		}
+		return data, nil
+	}
+	// The server ignored the Range header, skip to the range we want in the full response
+	_, err = io.CopyN(ioutil.Discard, response.Body, offset)
+	if err == io.EOF {
+		return []byte{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(response.Body, length))
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return data, nil
+}
+
+func (blobObject *httpBlobObject) LockWriteVersion(ctx context.Context) (bool, error) {
+	const fname = "httpBlobObject.LockWriteVersion"
+	err := fmt.Errorf("can't lock `%s`, http stores are read only", blobObject.String())
+	return false, errors.Wrap(err, fname)
+}
+
+func (blobObject *httpBlobObject) Exists(ctx context.Context) (bool, error) {
+	const fname = "httpBlobObject.Exists"
+	_, err := blobObject.head(ctx)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	return true, nil
+}
+
+func (blobObject *httpBlobObject) Size(ctx context.Context) (int64, error) {
+	const fname = "httpBlobObject.Size"
+	size, err := blobObject.head(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, fname)
+	}
+	if size < 0 {
+		err = fmt.Errorf("server did not report the size of `%s`", blobObject.String())
+		return 0, errors.Wrap(err, fname)
+	}
+	return size, nil
+}
+
+func (blobObject *httpBlobObject) Write(ctx context.Context, data []byte) (bool, error) {
+	const fname = "httpBlobObject.Write"
+	err := fmt.Errorf("can't write `%s`, http stores are read only", blobObject.String())
+	return false, errors.Wrap(err, fname)
+}
+
+func (blobObject *httpBlobObject) WriteFrom(ctx context.Context, reader io.Reader) (bool, error) {
+	const fname = "httpBlobObject.WriteFrom"
+	err := fmt.Errorf("can't write `%s`, http stores are read only", blobObject.String())
+	return false, errors.Wrap(err, fname)
+}
+
+func (blobObject *httpBlobObject) Delete(ctx context.Context) error {
+	const fname = "httpBlobObject.Delete"
+	err := fmt.Errorf("can't delete `%s`, http stores are read only", blobObject.String())
+	return errors.Wrap(err, fname)
+}
+
+func (blobObject *httpBlobObject) String() string {
+	return blobObject.url()
+}
+
+// CreateHTTPManifest builds the content of a HTTPManifestName file from a list of objects
+func CreateHTTPManifest(objects []BlobProperties) []byte {
+	sortedObjects := make([]BlobProperties, 0, len(objects))
+	for _, object := range objects {
+		if object.Name == HTTPManifestName {
+			continue
+		}
+		sortedObjects = append(sortedObjects, object)
+	}
+	sort.Slice(sortedObjects, func(i, j int) bool { return sortedObjects[i].Name < sortedObjects[j].Name })
+	var buffer bytes.Buffer
+	for _, object := range sortedObjects {
+		fmt.Fprintf(&buffer, "%d %s\n", object.Size, object.Name)
+	}
+	return buffer.Bytes()
+}
+
+// ParseHTTPManifest reads the content of a HTTPManifestName file
+func ParseHTTPManifest(data []byte) ([]BlobProperties, error) {
+	var items []BlobProperties
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("malformed manifest line `%s`", line)
+		}
+		size, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "malformed manifest line `%s`", line)
+		}
+		items = append(items, BlobProperties{Size: size, Name: parts[1]})
+	}
+	return items, scanner.Err()
+}
@@ -0,0 +1,138 @@
+package longtailstorelib
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DanEngelbrecht/golongtail/longtaillib"
+	"github.com/alecthomas/assert/v2"
+)
+
+func createTestHTTPServer(content map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, exists := content[r.URL.Path[1:]]
+		if !exists {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, time.Time{}, strings.NewReader(data))
+	}))
+}
+
+func TestHTTPBlobStore(t *testing.T) {
+	server := createTestHTTPServer(map[string]string{
+		"store/test.txt": "apa",
+	})
+	defer server.Close()
+
+	blobStore, err := CreateBlobStoreForURI(server.URL + "/store")
+	assert.NoError(t, err)
+	client, err := blobStore.NewClient(context.Background())
+	assert.NoError(t, err)
+	defer client.Close()
+	assert.False(t, client.SupportsLocking())
+
+	object, _ := client.NewObject("test.txt")
+	exists, err := object.Exists(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	data, err := object.Read(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "apa", string(data))
+
+	_, err = object.Write(context.Background(), []byte("skapa"))
+	assert.Error(t, err)
+	err = object.Delete(context.Background())
+	assert.Error(t, err)
+	_, err = object.LockWriteVersion(context.Background())
+	assert.Error(t, err)
+
+	object, _ = client.NewObject("missing.txt")
+	exists, err = object.Exists(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	_, err = object.Read(context.Background())
+	assert.True(t, longtaillib.IsNotExist(err))
+}
+
+func TestHTTPBlobStoreReadRange(t *testing.T) {
+	server := createTestHTTPServer(map[string]string{
+		"store/test.txt": "0123456789",
+	})
+	defer server.Close()
+
+	blobStore, err := CreateBlobStoreForURI(server.URL + "/store")
+	assert.NoError(t, err)
+	client, err := blobStore.NewClient(context.Background())
+	assert.NoError(t, err)
+	defer client.Close()
+	object, err := client.NewObject("test.txt")
+	assert.NoError(t, err)
+	data, err := object.ReadRange(context.Background(), 2, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "2345", string(data))
+	data, err = object.ReadRange(context.Background(), 8, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "89", string(data))
+	data, err = object.ReadRange(context.Background(), 20, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(data))
+	reader, err := object.OpenRead(context.Background())
+	assert.NoError(t, err)
+	data, err = ioutil.ReadAll(reader)
+	reader.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, "0123456789", string(data))
+	_, err = object.WriteFrom(context.Background(), strings.NewReader("apa"))
+	assert.Error(t, err)
+}
+
+func TestHTTPBlobStoreGetObjects(t *testing.T) {
+	objects := []BlobProperties{
+		{Size: 3, Name: "store.lsi"},
+		{Size: 5, Name: "chunks/0000/0x0000000000000001.lsb"},
+		{Size: 7, Name: "store/store_1234.lsi"},
+	}
+	server := createTestHTTPServer(map[string]string{
+		HTTPManifestName: string(CreateHTTPManifest(objects)),
+		"store.lsi":      "abc",
+	})
+	defer server.Close()
+
+	blobStore, _ := CreateBlobStoreForURI(server.URL)
+	client, _ := blobStore.NewClient(context.Background())
+	defer client.Close()
+
+	items, err := client.GetObjects("")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(items))
+	items, err = client.GetObjects("store")
+	assert.NoError(t, err)
+	assert.Equal(t, []BlobProperties{{Size: 3, Name: "store.lsi"}, {Size: 7, Name: "store/store_1234.lsi"}}, items)
+	items, err = client.GetObjects("chunks/")
+	assert.NoError(t, err)
+	assert.Equal(t, []BlobProperties{{Size: 5, Name: "chunks/0000/0x0000000000000001.lsb"}}, items)
+}
+
+func TestHTTPBlobStoreGetObjectsWithoutManifest(t *testing.T) {
+	server := createTestHTTPServer(map[string]string{
+		"store.lsi": "abc",
+	})
+	defer server.Close()
+
+	blobStore, _ := CreateBlobStoreForURI(server.URL)
+	client, _ := blobStore.NewClient(context.Background())
+	defer client.Close()
+
+	items, err := client.GetObjects("store")
+	assert.NoError(t, err)
+	assert.Equal(t, []BlobProperties{{Size: 3, Name: "store.lsi"}}, items)
+	items, err = client.GetObjects("chunks/")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(items))
+}
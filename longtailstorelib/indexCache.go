@@ -0,0 +1,242 @@
+package longtailstorelib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultIndexCacheMaxSize is a size cap that fits the indexes of most stores
+const DefaultIndexCacheMaxSize = 256 * 1024 * 1024
+
+const indexCacheEntrySuffix = ".lic"
+
+// IndexCache is a local persistent cache for store and version indexes
+// Entries are stored together with the version of the remote object they were read from, see
+// BlobProperties.Version, so an entry is revalidated with a conditional read of the remote object,
+// see VersionedBlobObject, instead of downloading it again. Entries with another version are stale
+// and replaced on the next Put
+type IndexCache struct {
+	path    string
+	maxSize int64
+
+	mutex  sync.Mutex
+	hits   uint64
+	misses uint64
+}
+
+// IndexCachePath returns the location of the index cache used together with the block cache at cachePath
+func IndexCachePath(cachePath string) string {
+	return filepath.Clean(NormalizeFileSystemPath(cachePath)) + "-index"
+}
+
+// NewIndexCache creates a cache in the folder path, the folder is created on the first Put
+// The least recently used entries are removed when the total size goes above maxSize
+func NewIndexCache(path string, maxSize int64) *IndexCache {
+	return &IndexCache{path: path, maxSize: maxSize}
+}
+
+// IndexCacheKey returns the cache key for the object name read with client
+func IndexCacheKey(client BlobClient, name string) string {
+	return strings.TrimSuffix(client.String(), "/") + "/" + name
+}
+
+func (cache *IndexCache) entryPath(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return filepath.Join(cache.path, hex.EncodeToString(hash[:])+indexCacheEntrySuffix)
+}
+
+// cachedVersion returns the version key is cached with, empty if key is not cached
+func (cache *IndexCache) cachedVersion(key string) string {
+	file, err := os.Open(cache.entryPath(key))
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	header, err := bufio.NewReader(file).ReadString('\n')
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(header, "\n")
+}
+
+// Read returns the data of object, object is read with a conditional read, see VersionedBlobObject, and
+// the data cached for key is used if object still has the version it was cached with
+// The data read from object is added to the cache, failing to update the cache does not fail the read
+// Returns the data, its version, empty if the store does not report versions, and true if the data was read from the cache
+func (cache *IndexCache) Read(ctx context.Context, key string, object BlobObject) ([]byte, string, bool, error) {
+	const fname = "IndexCache.Read"
+	cachedVersion := cache.cachedVersion(key)
+	missed := false
+	for {
+		data, version, changed, err := ReadObjectIfChanged(ctx, object, cachedVersion)
+		if err != nil {
+			return nil, "", false, errors.Wrap(err, fname)
+		}
+		if !changed {
+			if data, ok := cache.Get(key, version); ok {
+				return data, version, true, nil
+			}
+			// The entry was replaced or evicted after its version was looked up, Get counted the miss
+			missed = true
+			cachedVersion = ""
+			continue
+		}
+		if !missed {
+			atomic.AddUint64(&cache.misses, 1)
+		}
+		cache.Put(key, version, data)
+		return data, version, false, nil
+	}
+}
+
+// Get returns the data cached for key if it was cached with version
+func (cache *IndexCache) Get(key string, version string) ([]byte, bool) {
+	if version == "" {
+		atomic.AddUint64(&cache.misses, 1)
+		return nil, false
+	}
+	path := cache.entryPath(key)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		atomic.AddUint64(&cache.misses, 1)
+		return nil, false
+	}
+	// An entry is the version on the first line followed by the data
+	header, data, found := bytes.Cut(content, []byte{'\n'})
+	if !found || string(header) != version {
+		atomic.AddUint64(&cache.misses, 1)
+		return nil, false
+	}
+	// The modification time tracks when the entry was last used
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	atomic.AddUint64(&cache.hits, 1)
+	return data, true
+}
+
+// Put caches data for key with version, replacing the entry cached for any other version
+// Data without a version or larger than the size cap is not cached
+func (cache *IndexCache) Put(key string, version string, data []byte) error {
+	const fname = "IndexCache.Put"
+	if version == "" || strings.Contains(version, "\n") || int64(len(version)+1+len(data)) > cache.maxSize {
+		return nil
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	err := os.MkdirAll(cache.path, os.ModePerm)
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	// Write to a temporary file first so readers never see a partial entry
+	file, err := os.CreateTemp(cache.path, "*.tmp")
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	_, err = file.Write(append([]byte(version+"\n"), data...))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), cache.entryPath(key))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return errors.Wrap(err, fname)
+	}
+	return cache.evict()
+}
+
+// evict removes the least recently used entries until the cache fits the size cap, the mutex must be held
+func (cache *IndexCache) evict() error {
+	const fname = "IndexCache.evict"
+	entries, err := os.ReadDir(cache.path)
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	type cacheEntry struct {
+		path string
+		size int64
+		used time.Time
+	}
+	cached := []cacheEntry{}
+	totalSize := int64(0)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), indexCacheEntrySuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		cached = append(cached, cacheEntry{path: filepath.Join(cache.path, entry.Name()), size: info.Size(), used: info.ModTime()})
+		totalSize += info.Size()
+	}
+	sort.Slice(cached, func(i, j int) bool { return cached[i].used.Before(cached[j].used) })
+	for _, entry := range cached {
+		if totalSize <= cache.maxSize {
+			break
+		}
+		err := os.Remove(entry.path)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, fname)
+		}
+		totalSize -= entry.size
+	}
+	return nil
+}
+
+// Stats returns the number of lookups that were served from the cache and the number that were not
+func (cache *IndexCache) Stats() (hits uint64, misses uint64) {
+	return atomic.LoadUint64(&cache.hits), atomic.LoadUint64(&cache.misses)
+}
+
+func (cache *IndexCache) String() string {
+	hits, misses := cache.Stats()
+	return fmt.Sprintf("%s (%d hits, %d misses)", cache.path, hits, misses)
+}
+
+type IndexCacheOptions struct {
+	Cache *IndexCache
+}
+
+// WithIndexCache makes readers of store and version indexes use cache
+func WithIndexCache(cache *IndexCache) BlobStoreOption {
+	return func(options interface{}) {
+		indexCacheOptions, ok := options.(*IndexCacheOptions)
+		if !ok {
+			return
+		}
+		indexCacheOptions.Cache = cache
+	}
+}
+
+// GetIndexCacheOptions collects the index cache options from opts
+func GetIndexCacheOptions(opts ...BlobStoreOption) IndexCacheOptions {
+	options := IndexCacheOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// GetIndexCache returns the index cache set in opts, nil if there is none or if opts encrypt the store
+// since the cache would keep the decrypted indexes in plain text on the local disk
+func GetIndexCache(opts ...BlobStoreOption) *IndexCache {
+	if GetEncryptionOptions(opts...).KeyRing != nil {
+		return nil
+	}
+	return GetIndexCacheOptions(opts...).Cache
+}
@@ -0,0 +1,122 @@
+package longtailstorelib
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestIndexCache(t *testing.T) {
+	cache := NewIndexCache(filepath.Join(t.TempDir(), "index"), DefaultIndexCacheMaxSize)
+	_, ok := cache.Get("mem://store/store.lsi", "1")
+	assert.False(t, ok)
+
+	assert.NoError(t, cache.Put("mem://store/store.lsi", "1", []byte("index")))
+	data, ok := cache.Get("mem://store/store.lsi", "1")
+	assert.True(t, ok)
+	assert.Equal(t, "index", string(data))
+
+	// A changed object is a miss until it is cached again
+	_, ok = cache.Get("mem://store/store.lsi", "2")
+	assert.False(t, ok)
+	assert.NoError(t, cache.Put("mem://store/store.lsi", "2", []byte("new index")))
+	data, ok = cache.Get("mem://store/store.lsi", "2")
+	assert.True(t, ok)
+	assert.Equal(t, "new index", string(data))
+	_, ok = cache.Get("mem://store/store.lsi", "1")
+	assert.False(t, ok)
+
+	// Objects without a version are never cached
+	assert.NoError(t, cache.Put("mem://store/other.lsi", "", []byte("index")))
+	_, ok = cache.Get("mem://store/other.lsi", "")
+	assert.False(t, ok)
+
+	hits, misses := cache.Stats()
+	assert.Equal(t, uint64(2), hits)
+	assert.Equal(t, uint64(4), misses)
+}
+
+func TestIndexCacheEviction(t *testing.T) {
+	cache := NewIndexCache(filepath.Join(t.TempDir(), "index"), 32)
+	assert.NoError(t, cache.Put("a", "1", []byte("0123456789")))
+	assert.NoError(t, cache.Put("b", "1", []byte("0123456789")))
+	// Make a the most recently used entry
+	past := time.Now().Add(-time.Minute)
+	os.Chtimes(cache.entryPath("b"), past, past)
+	_, ok := cache.Get("a", "1")
+	assert.True(t, ok)
+
+	assert.NoError(t, cache.Put("c", "1", []byte("0123456789")))
+	_, ok = cache.Get("a", "1")
+	assert.True(t, ok)
+	_, ok = cache.Get("b", "1")
+	assert.False(t, ok)
+	_, ok = cache.Get("c", "1")
+	assert.True(t, ok)
+
+	// Data larger than the size cap is not cached
+	assert.NoError(t, cache.Put("d", "1", make([]byte, 64)))
+	_, ok = cache.Get("d", "1")
+	assert.False(t, ok)
+}
+
+func TestIndexCachePath(t *testing.T) {
+	assert.Equal(t, filepath.Clean("/tmp/cache")+"-index", IndexCachePath("/tmp/cache/"))
+}
+
+func TestGetIndexCache(t *testing.T) {
+	cache := NewIndexCache(filepath.Join(t.TempDir(), "index"), DefaultIndexCacheMaxSize)
+	assert.Equal(t, (*IndexCache)(nil), GetIndexCache())
+	assert.Equal(t, cache, GetIndexCache(WithIndexCache(cache)))
+
+	// Encrypted stores don't keep their indexes in the cache
+	keyRing, err := ParseEncryptionKeys("key:" + testEncryptionKey(1))
+	assert.NoError(t, err)
+	assert.Equal(t, (*IndexCache)(nil), GetIndexCache(WithIndexCache(cache), WithEncryptionKeyRing(keyRing)))
+}
+
+func TestIndexCacheRead(t *testing.T) {
+	ctx := context.Background()
+	blobStore, _ := NewMemBlobStore("", true)
+	client, _ := blobStore.NewClient(ctx)
+	defer client.Close()
+	object, _ := client.NewObject("store.lsi")
+	_, err := object.Write(ctx, []byte("index"))
+	assert.NoError(t, err)
+	cache := NewIndexCache(filepath.Join(t.TempDir(), "index"), DefaultIndexCacheMaxSize)
+
+	data, version, cached, err := cache.Read(ctx, "store.lsi", object)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	assert.Equal(t, "index", string(data))
+	assert.Equal(t, version, cache.cachedVersion("store.lsi"))
+
+	data, _, cached, err = cache.Read(ctx, "store.lsi", object)
+	assert.NoError(t, err)
+	assert.True(t, cached)
+	assert.Equal(t, "index", string(data))
+
+	// A changed object is read again and replaces the cached entry
+	_, err = object.Write(ctx, []byte("new index"))
+	assert.NoError(t, err)
+	data, newVersion, cached, err := cache.Read(ctx, "store.lsi", object)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	assert.Equal(t, "new index", string(data))
+	assert.NotEqual(t, version, newVersion)
+	hits, misses := cache.Stats()
+	assert.Equal(t, uint64(1), hits)
+	assert.Equal(t, uint64(2), misses)
+
+	// Objects without versions are read every time
+	unversioned := struct{ BlobObject }{object}
+	for i := 0; i < 2; i++ {
+		_, _, cached, err = cache.Read(ctx, "unversioned/store.lsi", unversioned)
+		assert.NoError(t, err)
+		assert.False(t, cached)
+	}
+}
@@ -1,24 +1,35 @@
 package longtailstorelib
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/pkg/errors"
 )
 
 type memBlob struct {
 	generation int
+	version    uint64
 	path       string
 	data       []byte
 }
 
+// memBlobVersions is shared by all in-memory stores so a recreated blob never repeats a version
+var memBlobVersions uint64
+
 type memBlobStore struct {
 	blobs           map[string]*memBlob
-	blobsMutex      sync.RWMutex
+	blobsMutex      *sync.RWMutex
+	name            string
 	prefix          string
 	supportsLocking bool
 }
@@ -33,36 +44,150 @@ type memBlobObject struct {
 	lockedGeneration *int
 }
 
+var (
+	namedMemBlobStoresMutex sync.Mutex
+	namedMemBlobStores      = map[string]*memBlobStore{}
+)
+
+func memBlobStorePrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return prefix
+}
+
 // NewMemBlobStore ...
 func NewMemBlobStore(prefix string, supportsLocking bool) (BlobStore, error) {
-	s := &memBlobStore{prefix: prefix, blobs: make(map[string]*memBlob), supportsLocking: supportsLocking}
+	s := &memBlobStore{prefix: memBlobStorePrefix(prefix), blobs: make(map[string]*memBlob), blobsMutex: &sync.RWMutex{}, supportsLocking: supportsLocking}
 	return s, nil
 }
 
+func getNamedMemBlobStore(name string, prefix string) *memBlobStore {
+	namedMemBlobStoresMutex.Lock()
+	defer namedMemBlobStoresMutex.Unlock()
+	root, exists := namedMemBlobStores[name]
+	if !exists {
+		root = &memBlobStore{name: name, blobs: make(map[string]*memBlob), blobsMutex: &sync.RWMutex{}, supportsLocking: true}
+		namedMemBlobStores[name] = root
+	}
+	// All stores with the same name share the blobs, the prefix only selects a part of it
+	return &memBlobStore{name: name, prefix: memBlobStorePrefix(prefix), blobs: root.blobs, blobsMutex: root.blobsMutex, supportsLocking: true}
+}
+
+// NewNamedMemBlobStore returns the process wide in-memory store called `name`, this is what
+// `mem://name/prefix` URIs open. The store is created on first use and keeps its content
+// until DeleteNamedMemBlobStore is called, so several commands in one process can share it
+func NewNamedMemBlobStore(name string, prefix string) (BlobStore, error) {
+	const fname = "NewNamedMemBlobStore"
+	if name == "" {
+		err := fmt.Errorf("in-memory store name can not be empty")
+		return nil, errors.Wrap(err, fname)
+	}
+	return getNamedMemBlobStore(name, prefix), nil
+}
+
+// DeleteNamedMemBlobStore drops the named in-memory store and all of its content
+func DeleteNamedMemBlobStore(name string) {
+	namedMemBlobStoresMutex.Lock()
+	defer namedMemBlobStoresMutex.Unlock()
+	delete(namedMemBlobStores, name)
+}
+
+func memBlobStoreForURI(uri string) (*memBlobStore, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "mem" {
+		return nil, fmt.Errorf("invalid scheme '%s', expected 'mem'", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("in-memory store name missing in `%s`", uri)
+	}
+	return getNamedMemBlobStore(u.Host, u.Path), nil
+}
+
+// SeedMemBlobs writes `blobs` to the in-memory store at the `mem://` uri, keys are paths relative to uri
+func SeedMemBlobs(uri string, blobs map[string][]byte) error {
+	const fname = "SeedMemBlobs"
+	blobStore, err := memBlobStoreForURI(uri)
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	client, _ := blobStore.NewClient(context.Background())
+	defer client.Close()
+	for path, data := range blobs {
+		object, _ := client.NewObject(path)
+		_, err = object.Write(context.Background(), data)
+		if err != nil {
+			return errors.Wrap(err, fname)
+		}
+	}
+	return nil
+}
+
+// GetMemBlobs returns a copy of the content of the in-memory store at the `mem://` uri
+// keyed by paths relative to uri
+func GetMemBlobs(uri string) (map[string][]byte, error) {
+	const fname = "GetMemBlobs"
+	blobStore, err := memBlobStoreForURI(uri)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	blobStore.blobsMutex.RLock()
+	defer blobStore.blobsMutex.RUnlock()
+	blobs := make(map[string][]byte)
+	for key, blob := range blobStore.blobs {
+		if strings.HasPrefix(key, blobStore.prefix) {
+			data := make([]byte, len(blob.data))
+			copy(data, blob.data)
+			blobs[key[len(blobStore.prefix):]] = data
+		}
+	}
+	return blobs, nil
+}
+
 func (blobStore *memBlobStore) NewClient(ctx context.Context) (BlobClient, error) {
 	return &memBlobClient{store: blobStore}, nil
 }
 
 func (blobStore *memBlobStore) String() string {
+	if blobStore.name != "" {
+		return "mem://" + blobStore.name + "/" + blobStore.prefix
+	}
 	return "memstore"
 }
 
 func (blobClient *memBlobClient) NewObject(filepath string) (BlobObject, error) {
-	return &memBlobObject{client: blobClient, path: filepath}, nil
+	return &memBlobObject{client: blobClient, path: blobClient.store.prefix + filepath}, nil
 }
 
 func (blobClient *memBlobClient) GetObjects(pathPrefix string) ([]BlobProperties, error) {
 	blobClient.store.blobsMutex.RLock()
 	defer blobClient.store.blobsMutex.RUnlock()
 	properties := make([]BlobProperties, 0)
+	prefix := blobClient.store.prefix
 	for key, blob := range blobClient.store.blobs {
-		if strings.HasPrefix(key, pathPrefix) {
-			properties = append(properties, BlobProperties{Name: key, Size: int64(len(blob.data))})
+		if strings.HasPrefix(key, prefix+pathPrefix) {
+			properties = append(properties, BlobProperties{Name: key[len(prefix):], Size: int64(len(blob.data)), Version: strconv.FormatUint(blob.version, 10)})
 		}
 	}
 	return properties, nil
 }
 
+func (blobClient *memBlobClient) WalkObjects(pathPrefix string, walkFn BlobWalkFunc) error {
+	// Don't hold the lock while calling walkFn so it can access the store
+	properties, _ := blobClient.GetObjects(pathPrefix)
+	for _, p := range properties {
+		err := walkFn(p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (blobClient *memBlobClient) SupportsLocking() bool {
 	return blobClient.store.supportsLocking
 }
@@ -74,14 +199,26 @@ func (blobClient *memBlobClient) String() string {
 	return blobClient.store.String()
 }
 
-func (blobObject *memBlobObject) Exists() (bool, error) {
+func (blobObject *memBlobObject) Exists(ctx context.Context) (bool, error) {
 	blobObject.client.store.blobsMutex.RLock()
 	defer blobObject.client.store.blobsMutex.RUnlock()
 	_, exists := blobObject.client.store.blobs[blobObject.path]
 	return exists, nil
 }
 
-func (blobObject *memBlobObject) Read() ([]byte, error) {
+func (blobObject *memBlobObject) Size(ctx context.Context) (int64, error) {
+	const fname = "memBlobObject.Size"
+	blobObject.client.store.blobsMutex.RLock()
+	defer blobObject.client.store.blobsMutex.RUnlock()
+	blob, exists := blobObject.client.store.blobs[blobObject.path]
+	if !exists {
+		err := errors.Wrapf(os.ErrNotExist, "%s does not exist", blobObject.path)
+		return 0, errors.Wrap(err, fname)
+	}
+	return int64(len(blob.data)), nil
+}
+
+func (blobObject *memBlobObject) Read(ctx context.Context) ([]byte, error) {
 	const fname = "memBlobObject.Read"
 	blobObject.client.store.blobsMutex.RLock()
 	defer blobObject.client.store.blobsMutex.RUnlock()
@@ -93,7 +230,23 @@ func (blobObject *memBlobObject) Read() ([]byte, error) {
 	return blob.data, nil
 }
 
-func (blobObject *memBlobObject) LockWriteVersion() (bool, error) {
+func (blobObject *memBlobObject) ReadIfChanged(ctx context.Context, version string) ([]byte, string, bool, error) {
+	const fname = "memBlobObject.ReadIfChanged"
+	blobObject.client.store.blobsMutex.RLock()
+	defer blobObject.client.store.blobsMutex.RUnlock()
+	blob, exists := blobObject.client.store.blobs[blobObject.path]
+	if !exists {
+		err := errors.Wrapf(os.ErrNotExist, "%s does not exist", blobObject.path)
+		return nil, "", false, errors.Wrap(err, fname)
+	}
+	currentVersion := strconv.FormatUint(blob.version, 10)
+	if currentVersion == version {
+		return nil, version, false, nil
+	}
+	return blob.data, currentVersion, true, nil
+}
+
+func (blobObject *memBlobObject) LockWriteVersion(ctx context.Context) (bool, error) {
 	blobObject.client.store.blobsMutex.RLock()
 	defer blobObject.client.store.blobsMutex.RUnlock()
 	blob, exists := blobObject.client.store.blobs[blobObject.path]
@@ -106,7 +259,7 @@ func (blobObject *memBlobObject) LockWriteVersion() (bool, error) {
 	return true, nil
 }
 
-func (blobObject *memBlobObject) Write(data []byte) (bool, error) {
+func (blobObject *memBlobObject) Write(ctx context.Context, data []byte) (bool, error) {
 	blobObject.client.store.blobsMutex.Lock()
 	defer blobObject.client.store.blobsMutex.Unlock()
 
@@ -126,17 +279,18 @@ func (blobObject *memBlobObject) Write(data []byte) (bool, error) {
 	copy(dataCopy, data)
 
 	if !exists {
-		blob = &memBlob{generation: 0, path: blobObject.path, data: dataCopy}
+		blob = &memBlob{generation: 0, version: atomic.AddUint64(&memBlobVersions, 1), path: blobObject.path, data: dataCopy}
 		blobObject.client.store.blobs[blobObject.path] = blob
 		return true, nil
 	}
 
 	blob.data = dataCopy
 	blob.generation++
+	blob.version = atomic.AddUint64(&memBlobVersions, 1)
 	return true, nil
 }
 
-func (blobObject *memBlobObject) Delete() error {
+func (blobObject *memBlobObject) Delete(ctx context.Context) error {
 	const fname = "memBlobObject.Delete"
 	blobObject.client.store.blobsMutex.Lock()
 	defer blobObject.client.store.blobsMutex.Unlock()
@@ -147,7 +301,7 @@ func (blobObject *memBlobObject) Delete() error {
 			return nil
 		}
 		if blob.generation != *blobObject.lockedGeneration {
-			err := fmt.Errorf("memBlobObject: generation lock mismatch %s", blobObject.path)
+			err := errors.Wrapf(ErrBlobVersionChanged, "memBlobObject: generation lock mismatch %s", blobObject.path)
 			return errors.Wrap(err, fname)
 		}
 	}
@@ -156,5 +310,35 @@ func (blobObject *memBlobObject) Delete() error {
 }
 
 func (blobObject *memBlobObject) String() string {
+	if blobObject.client.store.name != "" {
+		return fmt.Sprintf("mem://%s/%s", blobObject.client.store.name, blobObject.path)
+	}
 	return fmt.Sprintf("%s/%s", blobObject.client.String(), blobObject.path)
 }
+
+func (blobObject *memBlobObject) OpenRead(ctx context.Context) (io.ReadCloser, error) {
+	const fname = "memBlobObject.OpenRead"
+	data, err := blobObject.Read(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (blobObject *memBlobObject) ReadRange(ctx context.Context, offset int64, length int64) ([]byte, error) {
+	const fname = "memBlobObject.ReadRange"
+	data, err := blobObject.Read(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return sliceRange(data, offset, length), nil
+}
+
+func (blobObject *memBlobObject) WriteFrom(ctx context.Context, reader io.Reader) (bool, error) {
+	const fname = "memBlobObject.WriteFrom"
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	return blobObject.Write(ctx, data)
+}
@@ -0,0 +1,907 @@
+package longtailstorelib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MirrorWriteMode selects which targets of a mirrored store are written to
+type MirrorWriteMode string
+
+const (
+	// MirrorWritePrimary writes to the first target only, the other targets are
+	// expected to be replicated from it by other means
+	MirrorWritePrimary MirrorWriteMode = "primary"
+	// MirrorWriteAll writes to the first target and then to all other targets, failing
+	// writes to the other targets are recorded in the stats but do not fail the write
+	MirrorWriteAll MirrorWriteMode = "all"
+)
+
+// DefaultMirrorCooldown is how long a failing target is skipped before it is tried again
+const DefaultMirrorCooldown = 30 * time.Second
+
+// MirrorOptions controls how a MirroredBlobStore uses its targets
+type MirrorOptions struct {
+	// If a read has not completed after HedgeDelay the same read is started on the next
+	// target and the first successful result is used, zero disables hedging
+	HedgeDelay time.Duration
+	// Targets written to, MirrorWritePrimary if empty
+	WriteMode MirrorWriteMode
+	// How long a target is skipped by reads after failing, DefaultMirrorCooldown if zero
+	Cooldown time.Duration
+}
+
+// ParseMirrorOptions reads MirrorOptions from URI query parameters: `hedge` and
+// `cooldown` are durations such as `200ms` and `write` is `primary` or `all`
+func ParseMirrorOptions(query url.Values) (MirrorOptions, error) {
+	const fname = "ParseMirrorOptions"
+	options := MirrorOptions{WriteMode: MirrorWritePrimary, Cooldown: DefaultMirrorCooldown}
+	durations := map[string]*time.Duration{
+		"hedge":    &options.HedgeDelay,
+		"cooldown": &options.Cooldown,
+	}
+	for name, duration := range durations {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			return MirrorOptions{}, errors.Wrap(fmt.Errorf("%s must be a positive duration, got `%s`", name, value), fname)
+		}
+		*duration = parsed
+	}
+	if value := query.Get("write"); value != "" {
+		switch MirrorWriteMode(strings.ToLower(value)) {
+		case MirrorWritePrimary, MirrorWriteAll:
+			options.WriteMode = MirrorWriteMode(strings.ToLower(value))
+		default:
+			return MirrorOptions{}, errors.Wrap(fmt.Errorf("write must be `primary` or `all`, got `%s`", value), fname)
+		}
+	}
+	return options, nil
+}
+
+// MirrorTargetStats holds the activity and health of one target of a mirrored store
+type MirrorTargetStats struct {
+	URI         string
+	Healthy     bool
+	LastError   string
+	Reads       uint64
+	ReadBytes   uint64
+	Writes      uint64
+	WriteBytes  uint64
+	NotFound    uint64
+	Errors      uint64
+	HedgedReads uint64
+}
+
+// MirrorStatsCollector keeps track of the mirrored stores opened with WithMirrorStatsCollector
+// so their stats can be reported once the stores are no longer reachable by the caller
+type MirrorStatsCollector struct {
+	mutex  sync.Mutex
+	stores []*MirroredBlobStore
+}
+
+// NewMirrorStatsCollector creates an empty collector
+func NewMirrorStatsCollector() *MirrorStatsCollector {
+	return &MirrorStatsCollector{}
+}
+
+// Add makes the collector report the stats of store
+func (collector *MirrorStatsCollector) Add(store *MirroredBlobStore) {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	collector.stores = append(collector.stores, store)
+}
+
+// Stores returns the stores added to the collector in the order they were added
+func (collector *MirrorStatsCollector) Stores() []*MirroredBlobStore {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	return append([]*MirroredBlobStore{}, collector.stores...)
+}
+
+type MirrorStatsOptions struct {
+	Collector *MirrorStatsCollector
+}
+
+// WithMirrorStatsCollector adds all mirrored stores created with the option to collector
+func WithMirrorStatsCollector(collector *MirrorStatsCollector) BlobStoreOption {
+	return func(options interface{}) {
+		mirrorStatsOptions, ok := options.(*MirrorStatsOptions)
+		if !ok {
+			return
+		}
+		mirrorStatsOptions.Collector = collector
+	}
+}
+
+// GetMirrorStatsOptions collects the mirror stats options from opts
+func GetMirrorStatsOptions(opts ...BlobStoreOption) MirrorStatsOptions {
+	options := MirrorStatsOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// newMirroredBlobStoreForURI handles `mirror:<uri>,<uri>[,...][?options]` URIs, the targets
+// are opened without decorations since those are applied on top of the mirrored store, plain
+// path targets are opened with the fsblob scheme
+// Options are parsed with ParseMirrorOptions, the targets can't have query parameters of their own
+func newMirroredBlobStoreForURI(u *url.URL, opts ...BlobStoreOption) (BlobStore, error) {
+	const fname = "newMirroredBlobStoreForURI"
+	options, err := ParseMirrorOptions(u.Query())
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	targets := u.Opaque
+	if targets == "" {
+		targets = u.Host + u.Path
+	}
+	if targets == "" {
+		err := fmt.Errorf("`%s` does not list any targets, use `mirror:<uri>,<uri>`", u.String())
+		return nil, errors.Wrap(err, fname)
+	}
+	stores := []BlobStore{}
+	for i, target := range strings.Split(targets, ",") {
+		target = strings.TrimSpace(target)
+		targetURL, targetScheme, ok := LookupBlobStoreScheme(target)
+		if !ok {
+			// Plain paths are opened as fsblob:// stores so they lock objects like other local stores
+			targetURL, targetScheme, _ = LookupBlobStoreScheme("fsblob://" + target)
+		}
+		if targetScheme.Name == "mirror" {
+			err := fmt.Errorf("mirrored stores can't be nested, `%s`", target)
+			return nil, errors.Wrap(err, fname)
+		}
+		if targetScheme.Defaults.ReadOnly && (i == 0 || options.WriteMode == MirrorWriteAll) {
+			err := fmt.Errorf("%s stores are read only, `%s` can't be written to by the mirror", targetScheme.Name, target)
+			return nil, errors.Wrap(err, fname)
+		}
+		blobStore, err := targetScheme.Factory(targetURL, opts...)
+		if err != nil {
+			return nil, errors.Wrap(err, fname)
+		}
+		stores = append(stores, blobStore)
+	}
+	mirroredStore, err := NewMirroredBlobStore(stores, options)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	if collector := GetMirrorStatsOptions(opts...).Collector; collector != nil {
+		collector.Add(mirroredStore)
+	}
+	return mirroredStore, nil
+}
+
+type mirrorTarget struct {
+	store BlobStore
+
+	mutex          sync.Mutex
+	unhealthyUntil time.Time
+	lastError      error
+
+	reads       uint64
+	readBytes   uint64
+	writes      uint64
+	writeBytes  uint64
+	notFound    uint64
+	errors      uint64
+	hedgedReads uint64
+}
+
+// MirroredBlobStore combines an ordered list of stores holding the same content
+// Reads use the first healthy target and fail over to the next target if it fails or
+// does not have the object, writes go to the first target or to all targets depending
+// on MirrorOptions.WriteMode. The first target decides the outcome of version locked writes
+type MirroredBlobStore struct {
+	targets []*mirrorTarget
+	options MirrorOptions
+}
+
+type mirroredBlobClient struct {
+	ctx     context.Context
+	store   *MirroredBlobStore
+	clients []BlobClient
+	errors  []error
+}
+
+type mirroredBlobObject struct {
+	client  *mirroredBlobClient
+	path    string
+	objects []BlobObject
+	errors  []error
+	// Set by LockWriteVersion, a locked object is only read from the primary
+	locked bool
+	// Replicas that failed to lock are not written until they are locked again
+	lockFailed []bool
+}
+
+// NewMirroredBlobStore creates a mirrored store with stores as targets, the first store is the primary
+func NewMirroredBlobStore(stores []BlobStore, options MirrorOptions) (*MirroredBlobStore, error) {
+	const fname = "NewMirroredBlobStore"
+	if len(stores) == 0 {
+		return nil, errors.Wrap(fmt.Errorf("a mirrored store needs at least one target"), fname)
+	}
+	if options.WriteMode == "" {
+		options.WriteMode = MirrorWritePrimary
+	}
+	if options.Cooldown == 0 {
+		options.Cooldown = DefaultMirrorCooldown
+	}
+	blobStore := &MirroredBlobStore{options: options}
+	for _, store := range stores {
+		blobStore.targets = append(blobStore.targets, &mirrorTarget{store: store})
+	}
+	return blobStore, nil
+}
+
+// Stats returns the stats of each target in target order
+func (blobStore *MirroredBlobStore) Stats() []MirrorTargetStats {
+	stats := []MirrorTargetStats{}
+	now := time.Now()
+	for _, target := range blobStore.targets {
+		target.mutex.Lock()
+		s := MirrorTargetStats{
+			URI:         target.store.String(),
+			Healthy:     !now.Before(target.unhealthyUntil),
+			Reads:       atomic.LoadUint64(&target.reads),
+			ReadBytes:   atomic.LoadUint64(&target.readBytes),
+			Writes:      atomic.LoadUint64(&target.writes),
+			WriteBytes:  atomic.LoadUint64(&target.writeBytes),
+			NotFound:    atomic.LoadUint64(&target.notFound),
+			Errors:      atomic.LoadUint64(&target.errors),
+			HedgedReads: atomic.LoadUint64(&target.hedgedReads),
+		}
+		if target.lastError != nil {
+			s.LastError = target.lastError.Error()
+		}
+		target.mutex.Unlock()
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+func (blobStore *MirroredBlobStore) NewClient(ctx context.Context) (BlobClient, error) {
+	const fname = "MirroredBlobStore.NewClient"
+	client := &mirroredBlobClient{
+		ctx:     ctx,
+		store:   blobStore,
+		clients: make([]BlobClient, len(blobStore.targets)),
+		errors:  make([]error, len(blobStore.targets)),
+	}
+	connected := false
+	for i, target := range blobStore.targets {
+		targetClient, err := target.store.NewClient(ctx)
+		if err != nil {
+			// The target is skipped by this client but the others can still be used
+			client.errors[i] = err
+			target.failed(err, blobStore.options.Cooldown)
+			continue
+		}
+		client.clients[i] = targetClient
+		connected = true
+	}
+	if !connected {
+		return nil, errors.Wrap(client.errors[0], fname)
+	}
+	return client, nil
+}
+
+func (blobStore *MirroredBlobStore) String() string {
+	targets := []string{}
+	for _, target := range blobStore.targets {
+		targets = append(targets, target.store.String())
+	}
+	return "mirror:" + strings.Join(targets, ",")
+}
+
+func (target *mirrorTarget) healthy(now time.Time) bool {
+	target.mutex.Lock()
+	defer target.mutex.Unlock()
+	return !now.Before(target.unhealthyUntil)
+}
+
+func (target *mirrorTarget) failed(err error, cooldown time.Duration) {
+	atomic.AddUint64(&target.errors, 1)
+	target.mutex.Lock()
+	defer target.mutex.Unlock()
+	target.unhealthyUntil = time.Now().Add(cooldown)
+	target.lastError = err
+}
+
+func (target *mirrorTarget) succeeded() {
+	target.mutex.Lock()
+	defer target.mutex.Unlock()
+	target.unhealthyUntil = time.Time{}
+}
+
+// record updates the health and stats of target with the outcome of an operation
+// Missing objects, cancelled operations and permanent errors such as a rejected version
+// locked delete say nothing about the health of the target
+func (target *mirrorTarget) record(ctx context.Context, client BlobClient, err error, cooldown time.Duration) {
+	switch {
+	case err == nil:
+		target.succeeded()
+	case errors.Is(err, os.ErrNotExist):
+		atomic.AddUint64(&target.notFound, 1)
+	case ctx.Err() != nil:
+	case ClassifyBlobError(client, err) == BlobErrorPermanent:
+		atomic.AddUint64(&target.errors, 1)
+	default:
+		target.failed(err, cooldown)
+	}
+}
+
+// readOrder returns the targets to try for reads, healthy targets in configured order first
+func (blobClient *mirroredBlobClient) readOrder() []int {
+	now := time.Now()
+	healthy := []int{}
+	unhealthy := []int{}
+	for i, target := range blobClient.store.targets {
+		if blobClient.clients[i] == nil {
+			continue
+		}
+		if target.healthy(now) {
+			healthy = append(healthy, i)
+		} else {
+			unhealthy = append(unhealthy, i)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// writeTargets returns the targets written to, the primary first
+func (blobClient *mirroredBlobClient) writeTargets() []int {
+	if blobClient.store.options.WriteMode != MirrorWriteAll {
+		return []int{0}
+	}
+	targets := []int{}
+	for i := range blobClient.store.targets {
+		targets = append(targets, i)
+	}
+	return targets
+}
+
+// mirrorReadError picks the error to return when no target could serve a read, a missing
+// object is only reported if no target failed with another error
+func mirrorReadError(errs []error) error {
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return errors.Wrap(os.ErrNotExist, "no target available")
+}
+
+// read runs operation on the targets in read order until one succeeds, with hedging
+// enabled a slow target gets company from the next target after the hedge delay
+// operation must only store its result for the target it is called with
+func (blobClient *mirroredBlobClient) read(ctx context.Context, operation func(ctx context.Context, index int) error) (int, error) {
+	return blobClient.readFrom(ctx, blobClient.readOrder(), operation)
+}
+
+// readFrom is read with the targets to try given by order
+func (blobClient *mirroredBlobClient) readFrom(ctx context.Context, order []int, operation func(ctx context.Context, index int) error) (int, error) {
+	store := blobClient.store
+	errs := make([]error, len(store.targets))
+	run := func(ctx context.Context, index int) error {
+		target := store.targets[index]
+		atomic.AddUint64(&target.reads, 1)
+		err := operation(ctx, index)
+		target.record(ctx, blobClient.clients[index], err, store.options.Cooldown)
+		return err
+	}
+
+	if store.options.HedgeDelay <= 0 {
+		for _, index := range order {
+			err := run(ctx, index)
+			if err == nil {
+				return index, nil
+			}
+			errs[index] = err
+			if ctx.Err() != nil {
+				return -1, ctx.Err()
+			}
+		}
+		return -1, mirrorReadError(errs)
+	}
+
+	hedgeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		index int
+		err   error
+	}
+	results := make(chan result, len(order))
+	next := 0
+	pending := 0
+	launch := func() {
+		index := order[next]
+		next++
+		pending++
+		go func() {
+			results <- result{index: index, err: run(hedgeCtx, index)}
+		}()
+	}
+	if len(order) > 0 {
+		launch()
+	}
+	hedgeTimer := time.NewTimer(store.options.HedgeDelay)
+	defer hedgeTimer.Stop()
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				return r.index, nil
+			}
+			errs[r.index] = r.err
+			if pending == 0 && next < len(order) && ctx.Err() == nil {
+				launch()
+				hedgeTimer.Reset(store.options.HedgeDelay)
+			}
+		case <-hedgeTimer.C:
+			if next < len(order) {
+				atomic.AddUint64(&store.targets[order[next]].hedgedReads, 1)
+				launch()
+				hedgeTimer.Reset(store.options.HedgeDelay)
+			}
+		case <-ctx.Done():
+			return -1, ctx.Err()
+		}
+	}
+	if ctx.Err() != nil {
+		return -1, ctx.Err()
+	}
+	return -1, mirrorReadError(errs)
+}
+
+func (blobClient *mirroredBlobClient) NewObject(path string) (BlobObject, error) {
+	const fname = "mirroredBlobClient.NewObject"
+	object := &mirroredBlobObject{
+		client:     blobClient,
+		path:       path,
+		objects:    make([]BlobObject, len(blobClient.clients)),
+		errors:     make([]error, len(blobClient.clients)),
+		lockFailed: make([]bool, len(blobClient.clients)),
+	}
+	created := false
+	for i, client := range blobClient.clients {
+		if client == nil {
+			object.errors[i] = blobClient.errors[i]
+			continue
+		}
+		targetObject, err := client.NewObject(path)
+		if err != nil {
+			object.errors[i] = err
+			continue
+		}
+		object.objects[i] = targetObject
+		created = true
+	}
+	if !created {
+		return nil, errors.Wrap(mirrorReadError(object.errors), fname)
+	}
+	return object, nil
+}
+
+func (blobClient *mirroredBlobClient) GetObjects(pathPrefix string) ([]BlobProperties, error) {
+	const fname = "mirroredBlobClient.GetObjects"
+	results := make([][]BlobProperties, len(blobClient.clients))
+	index, err := blobClient.read(blobClient.ctx, func(ctx context.Context, index int) error {
+		objects, err := blobClient.clients[index].GetObjects(pathPrefix)
+		results[index] = objects
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return results[index], nil
+}
+
+func (blobClient *mirroredBlobClient) WalkObjects(pathPrefix string, walkFn BlobWalkFunc) error {
+	const fname = "mirroredBlobClient.WalkObjects"
+	store := blobClient.store
+	var errs []error
+	for _, index := range blobClient.readOrder() {
+		target := store.targets[index]
+		atomic.AddUint64(&target.reads, 1)
+		walked := false
+		err := blobClient.clients[index].WalkObjects(pathPrefix, func(properties BlobProperties) error {
+			walked = true
+			return walkFn(properties)
+		})
+		target.record(blobClient.ctx, blobClient.clients[index], err, store.options.Cooldown)
+		// Once objects have been passed to walkFn the walk can't continue on another target
+		if err == nil || walked {
+			return err
+		}
+		errs = append(errs, err)
+		if blobClient.ctx.Err() != nil {
+			return errors.Wrap(blobClient.ctx.Err(), fname)
+		}
+	}
+	return errors.Wrap(mirrorReadError(errs), fname)
+}
+
+func (blobClient *mirroredBlobClient) SupportsLocking() bool {
+	for _, index := range blobClient.writeTargets() {
+		if blobClient.clients[index] == nil || !blobClient.clients[index].SupportsLocking() {
+			return false
+		}
+	}
+	return true
+}
+
+func (blobClient *mirroredBlobClient) ClassifyError(err error) BlobErrorClass {
+	for _, client := range blobClient.clients {
+		if client != nil {
+			return ClassifyBlobError(client, err)
+		}
+	}
+	return BlobErrorPermanent
+}
+
+func (blobClient *mirroredBlobClient) String() string {
+	return blobClient.store.String()
+}
+
+func (blobClient *mirroredBlobClient) Close() {
+	for _, client := range blobClient.clients {
+		if client != nil {
+			client.Close()
+		}
+	}
+}
+
+// readOrder returns the targets to read the object from, once the object is locked for a write the
+// primary is the only target so a stale replica copy is never written back to the primary
+func (blobObject *mirroredBlobObject) readOrder() []int {
+	if blobObject.locked {
+		return []int{0}
+	}
+	return blobObject.client.readOrder()
+}
+
+// readObject runs operation on the object of each target until one succeeds
+func (blobObject *mirroredBlobObject) readObject(ctx context.Context, operation func(ctx context.Context, object BlobObject, index int) error) (int, error) {
+	return blobObject.client.readFrom(ctx, blobObject.readOrder(), func(ctx context.Context, index int) error {
+		object := blobObject.objects[index]
+		if object == nil {
+			return blobObject.errors[index]
+		}
+		return operation(ctx, object, index)
+	})
+}
+
+// Exists tells if the primary has the object, callers use it to skip writes that are already done
+// so it does not fail over to a replica that may have a copy the primary is missing
+func (blobObject *mirroredBlobObject) Exists(ctx context.Context) (bool, error) {
+	const fname = "mirroredBlobObject.Exists"
+	_, err := blobObject.client.readFrom(ctx, []int{0}, func(ctx context.Context, index int) error {
+		object := blobObject.objects[index]
+		if object == nil {
+			return blobObject.errors[index]
+		}
+		exists, err := object.Exists(ctx)
+		if err == nil && !exists {
+			return errors.Wrapf(os.ErrNotExist, "%s does not exist", object.String())
+		}
+		return err
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	return true, nil
+}
+
+// Size returns the size of the object in the primary, like Exists it does not fail over to the replicas
+func (blobObject *mirroredBlobObject) Size(ctx context.Context) (int64, error) {
+	const fname = "mirroredBlobObject.Size"
+	var size int64
+	_, err := blobObject.client.readFrom(ctx, []int{0}, func(ctx context.Context, index int) error {
+		object := blobObject.objects[index]
+		if object == nil {
+			return blobObject.errors[index]
+		}
+		var err error
+		size, err = getObjectSize(ctx, blobObject.client.clients[index], object, blobObject.path)
+		return err
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, fname)
+	}
+	return size, nil
+}
+
+func (blobObject *mirroredBlobObject) LockWriteVersion(ctx context.Context) (bool, error) {
+	const fname = "mirroredBlobObject.LockWriteVersion"
+	store := blobObject.client.store
+	primaryExists := false
+	for _, index := range blobObject.client.writeTargets() {
+		object := blobObject.objects[index]
+		if object == nil {
+			if index == 0 {
+				return false, errors.Wrap(blobObject.errors[0], fname)
+			}
+			continue
+		}
+		exists, err := object.LockWriteVersion(ctx)
+		if index == 0 {
+			if err != nil {
+				return false, errors.Wrap(err, fname)
+			}
+			primaryExists = exists
+			continue
+		}
+		// A replica that can't be locked would be written without its write condition, skip it instead
+		blobObject.lockFailed[index] = err != nil
+		if err != nil {
+			store.targets[index].record(ctx, blobObject.client.clients[index], err, store.options.Cooldown)
+		}
+	}
+	blobObject.locked = true
+	return primaryExists, nil
+}
+
+func (blobObject *mirroredBlobObject) Read(ctx context.Context) ([]byte, error) {
+	const fname = "mirroredBlobObject.Read"
+	results := make([][]byte, len(blobObject.objects))
+	index, err := blobObject.readObject(ctx, func(ctx context.Context, object BlobObject, index int) error {
+		data, err := object.Read(ctx)
+		results[index] = data
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	atomic.AddUint64(&blobObject.client.store.targets[index].readBytes, uint64(len(results[index])))
+	return results[index], nil
+}
+
+// ReadIfChanged fails over like Read, the version returned is prefixed with the index of the target that
+// served the read so a version reported by one target is never compared with the object of another target
+func (blobObject *mirroredBlobObject) ReadIfChanged(ctx context.Context, version string) ([]byte, string, bool, error) {
+	const fname = "mirroredBlobObject.ReadIfChanged"
+	versionTarget := -1
+	targetVersion := ""
+	if prefix, rest, found := strings.Cut(version, ":"); found {
+		if index, err := strconv.Atoi(prefix); err == nil {
+			versionTarget = index
+			targetVersion = rest
+		}
+	}
+	results := make([][]byte, len(blobObject.objects))
+	versions := make([]string, len(blobObject.objects))
+	changed := make([]bool, len(blobObject.objects))
+	index, err := blobObject.readObject(ctx, func(ctx context.Context, object BlobObject, index int) error {
+		readVersion := ""
+		if index == versionTarget {
+			readVersion = targetVersion
+		}
+		data, currentVersion, objectChanged, err := ReadObjectIfChanged(ctx, object, readVersion)
+		results[index] = data
+		versions[index] = currentVersion
+		changed[index] = objectChanged
+		return err
+	})
+	if err != nil {
+		return nil, "", false, errors.Wrap(err, fname)
+	}
+	if !changed[index] {
+		return nil, version, false, nil
+	}
+	atomic.AddUint64(&blobObject.client.store.targets[index].readBytes, uint64(len(results[index])))
+	if versions[index] == "" {
+		return results[index], "", true, nil
+	}
+	return results[index], fmt.Sprintf("%d:%s", index, versions[index]), true, nil
+}
+
+func (blobObject *mirroredBlobObject) SourceCount() int {
+	return len(blobObject.objects)
+}
+
+// ReadSource reads the copy of the target at index source, in target order, without failing over
+func (blobObject *mirroredBlobObject) ReadSource(ctx context.Context, source int) ([]byte, error) {
+	const fname = "mirroredBlobObject.ReadSource"
+	object := blobObject.objects[source]
+	if object == nil {
+		return nil, errors.Wrap(blobObject.errors[source], fname)
+	}
+	store := blobObject.client.store
+	target := store.targets[source]
+	atomic.AddUint64(&target.reads, 1)
+	data, err := object.Read(ctx)
+	target.record(ctx, blobObject.client.clients[source], err, store.options.Cooldown)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	atomic.AddUint64(&target.readBytes, uint64(len(data)))
+	return data, nil
+}
+
+type mirrorCountingReader struct {
+	reader io.ReadCloser
+	target *mirrorTarget
+}
+
+func (r *mirrorCountingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	atomic.AddUint64(&r.target.readBytes, uint64(n))
+	return n, err
+}
+
+func (r *mirrorCountingReader) Close() error {
+	return r.reader.Close()
+}
+
+func (blobObject *mirroredBlobObject) OpenRead(ctx context.Context) (io.ReadCloser, error) {
+	const fname = "mirroredBlobObject.OpenRead"
+	// Streams are not hedged, only opening the stream fails over to the next target
+	store := blobObject.client.store
+	errs := make([]error, len(blobObject.objects))
+	for _, index := range blobObject.readOrder() {
+		object := blobObject.objects[index]
+		if object == nil {
+			errs[index] = blobObject.errors[index]
+			continue
+		}
+		target := store.targets[index]
+		atomic.AddUint64(&target.reads, 1)
+		reader, err := object.OpenRead(ctx)
+		target.record(ctx, blobObject.client.clients[index], err, store.options.Cooldown)
+		if err == nil {
+			return &mirrorCountingReader{reader: reader, target: target}, nil
+		}
+		errs[index] = err
+		if ctx.Err() != nil {
+			return nil, errors.Wrap(ctx.Err(), fname)
+		}
+	}
+	return nil, errors.Wrap(mirrorReadError(errs), fname)
+}
+
+func (blobObject *mirroredBlobObject) ReadRange(ctx context.Context, offset int64, length int64) ([]byte, error) {
+	const fname = "mirroredBlobObject.ReadRange"
+	results := make([][]byte, len(blobObject.objects))
+	index, err := blobObject.readObject(ctx, func(ctx context.Context, object BlobObject, index int) error {
+		data, err := object.ReadRange(ctx, offset, length)
+		results[index] = data
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	atomic.AddUint64(&blobObject.client.store.targets[index].readBytes, uint64(len(results[index])))
+	return results[index], nil
+}
+
+// write runs operation on the primary and, in MirrorWriteAll mode, on the other targets once
+// the primary accepted the write. Failures of the other targets only affect their health and stats
+func (blobObject *mirroredBlobObject) write(ctx context.Context, size int, operation func(ctx context.Context, object BlobObject) (bool, error)) (bool, error) {
+	store := blobObject.client.store
+	primary := blobObject.objects[0]
+	if primary == nil {
+		return false, blobObject.errors[0]
+	}
+	ok, err := operation(ctx, primary)
+	store.targets[0].record(ctx, blobObject.client.clients[0], err, store.options.Cooldown)
+	if err != nil || !ok {
+		return ok, err
+	}
+	atomic.AddUint64(&store.targets[0].writes, 1)
+	atomic.AddUint64(&store.targets[0].writeBytes, uint64(size))
+
+	var wg sync.WaitGroup
+	for _, index := range blobObject.client.writeTargets()[1:] {
+		object := blobObject.objects[index]
+		if object == nil || blobObject.lockFailed[index] {
+			continue
+		}
+		wg.Add(1)
+		go func(target *mirrorTarget, client BlobClient, object BlobObject) {
+			defer wg.Done()
+			ok, err := operation(ctx, object)
+			if err == nil && !ok {
+				// A newer locked write has already replaced the object on this target
+				return
+			}
+			target.record(ctx, client, err, store.options.Cooldown)
+			if err == nil {
+				atomic.AddUint64(&target.writes, 1)
+				atomic.AddUint64(&target.writeBytes, uint64(size))
+			}
+		}(store.targets[index], blobObject.client.clients[index], object)
+	}
+	wg.Wait()
+	return true, nil
+}
+
+func (blobObject *mirroredBlobObject) Write(ctx context.Context, data []byte) (bool, error) {
+	const fname = "mirroredBlobObject.Write"
+	ok, err := blobObject.write(ctx, len(data), func(ctx context.Context, object BlobObject) (bool, error) {
+		return object.Write(ctx, data)
+	})
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	return ok, nil
+}
+
+func (blobObject *mirroredBlobObject) WriteFrom(ctx context.Context, reader io.Reader) (bool, error) {
+	const fname = "mirroredBlobObject.WriteFrom"
+	if len(blobObject.client.writeTargets()) == 1 {
+		ok, err := blobObject.write(ctx, 0, func(ctx context.Context, object BlobObject) (bool, error) {
+			counter := &mirrorWriteCounter{reader: reader}
+			ok, err := object.WriteFrom(ctx, counter)
+			atomic.AddUint64(&blobObject.client.store.targets[0].writeBytes, uint64(counter.count))
+			return ok, err
+		})
+		if err != nil {
+			return false, errors.Wrap(err, fname)
+		}
+		return ok, nil
+	}
+	// The content is needed once for each target
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	ok, err := blobObject.write(ctx, len(data), func(ctx context.Context, object BlobObject) (bool, error) {
+		return object.WriteFrom(ctx, bytes.NewReader(data))
+	})
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	return ok, nil
+}
+
+type mirrorWriteCounter struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *mirrorWriteCounter) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
+
+func (blobObject *mirroredBlobObject) Delete(ctx context.Context) error {
+	const fname = "mirroredBlobObject.Delete"
+	_, err := blobObject.write(ctx, 0, func(ctx context.Context, object BlobObject) (bool, error) {
+		err := object.Delete(ctx)
+		return err == nil, err
+	})
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	return nil
+}
+
+func (blobObject *mirroredBlobObject) String() string {
+	return blobObject.client.String() + "/" + blobObject.path
+}
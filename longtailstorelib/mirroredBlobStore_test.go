@@ -0,0 +1,353 @@
+package longtailstorelib
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/pkg/errors"
+)
+
+func createMirrorTestStores(t *testing.T, count int) []*FaultyBlobStore {
+	stores := []*FaultyBlobStore{}
+	for i := 0; i < count; i++ {
+		backingStore, _ := NewMemBlobStore("", true)
+		stores = append(stores, NewFaultyBlobStore(backingStore, FaultConfig{}))
+	}
+	return stores
+}
+
+func writeMirrorTestObject(t *testing.T, store BlobStore, path string, data string) {
+	client, _ := store.NewClient(context.Background())
+	defer client.Close()
+	object, _ := client.NewObject(path)
+	ok, err := object.Write(context.Background(), []byte(data))
+	assert.True(t, ok)
+	assert.NoError(t, err)
+}
+
+func readMirrorTestObject(store BlobStore, path string) ([]byte, error) {
+	client, _ := store.NewClient(context.Background())
+	defer client.Close()
+	object, _ := client.NewObject(path)
+	return object.Read(context.Background())
+}
+
+func TestMirroredBlobStoreFailover(t *testing.T) {
+	ctx := context.Background()
+	targets := createMirrorTestStores(t, 2)
+	writeMirrorTestObject(t, targets[0], "block.lrb", "primary")
+	writeMirrorTestObject(t, targets[1], "block.lrb", "replica")
+	writeMirrorTestObject(t, targets[1], "replicated-only.lrb", "replica")
+	blobStore, err := NewMirroredBlobStore([]BlobStore{targets[0], targets[1]}, MirrorOptions{})
+	assert.NoError(t, err)
+	client, _ := blobStore.NewClient(ctx)
+	defer client.Close()
+	object, _ := client.NewObject("block.lrb")
+
+	data, err := object.Read(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "primary", string(data))
+
+	// Objects missing on the primary are read from the replica but only exist if the primary has them
+	replicatedObject, _ := client.NewObject("replicated-only.lrb")
+	exists, err := replicatedObject.Exists(ctx)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	data, err = replicatedObject.ReadRange(ctx, 1, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, "epl", string(data))
+	missingObject, _ := client.NewObject("missing.lrb")
+	_, err = missingObject.Read(ctx)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+
+	// A failing primary is skipped until the cooldown has passed
+	targets[0].SetConfig(FaultConfig{ErrorRate: 1})
+	data, err = object.Read(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "replica", string(data))
+	reader, err := object.OpenRead(ctx)
+	assert.NoError(t, err)
+	data, _ = io.ReadAll(reader)
+	reader.Close()
+	assert.Equal(t, "replica", string(data))
+	objects, err := client.GetObjects("")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(objects))
+	assert.Equal(t, int64(1), targets[0].InjectedFaultCount())
+
+	stats := blobStore.Stats()
+	assert.False(t, stats[0].Healthy)
+	assert.Equal(t, uint64(1), stats[0].Errors)
+	assert.Contains(t, stats[0].LastError, ErrInjectedFault.Error())
+	assert.True(t, stats[1].Healthy)
+	assert.Equal(t, uint64(len("epl")+len("replica")*2), stats[1].ReadBytes)
+
+	// With all targets failing the error is returned
+	targets[1].SetConfig(FaultConfig{ErrorRate: 1})
+	_, err = object.Read(ctx)
+	assert.True(t, errors.Is(err, ErrInjectedFault))
+}
+
+func TestMirroredBlobStoreHedging(t *testing.T) {
+	ctx := context.Background()
+	targets := createMirrorTestStores(t, 2)
+	writeMirrorTestObject(t, targets[0], "block.lrb", "slow")
+	writeMirrorTestObject(t, targets[1], "block.lrb", "fast")
+	targets[0].SetConfig(FaultConfig{Latency: time.Second})
+	blobStore, _ := NewMirroredBlobStore([]BlobStore{targets[0], targets[1]}, MirrorOptions{HedgeDelay: 20 * time.Millisecond})
+	client, _ := blobStore.NewClient(ctx)
+	defer client.Close()
+	object, _ := client.NewObject("block.lrb")
+
+	start := time.Now()
+	data, err := object.Read(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "fast", string(data))
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+	stats := blobStore.Stats()
+	assert.Equal(t, uint64(1), stats[1].HedgedReads)
+	// Losing a hedged race does not make a target unhealthy
+	assert.True(t, stats[0].Healthy)
+	assert.Equal(t, uint64(0), stats[0].Errors)
+}
+
+func TestMirroredBlobStoreWrites(t *testing.T) {
+	ctx := context.Background()
+	targets := createMirrorTestStores(t, 2)
+	blobStore, _ := NewMirroredBlobStore([]BlobStore{targets[0], targets[1]}, MirrorOptions{})
+	writeMirrorTestObject(t, blobStore, "primary-only.lrb", "data")
+	_, err := readMirrorTestObject(targets[1], "primary-only.lrb")
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+
+	blobStore, _ = NewMirroredBlobStore([]BlobStore{targets[0], targets[1]}, MirrorOptions{WriteMode: MirrorWriteAll})
+	client, _ := blobStore.NewClient(ctx)
+	defer client.Close()
+	object, _ := client.NewObject("all.lrb")
+	ok, err := object.Write(ctx, []byte("data"))
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	for _, target := range targets {
+		_, err := readMirrorTestObject(target, "all.lrb")
+		assert.NoError(t, err)
+	}
+
+	// A failing replica does not fail the write, a failing primary does
+	targets[1].SetConfig(FaultConfig{ErrorRate: 1, Operations: []FaultOperation{FaultWrite}})
+	ok, err = object.Write(ctx, []byte("new data"))
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	assert.False(t, blobStore.Stats()[1].Healthy)
+	targets[0].SetConfig(FaultConfig{ErrorRate: 1, Operations: []FaultOperation{FaultWrite}})
+	_, err = object.Write(ctx, []byte("newer data"))
+	assert.True(t, errors.Is(err, ErrInjectedFault))
+
+	// The primary decides version locked writes
+	targets[0].SetConfig(FaultConfig{})
+	targets[1].SetConfig(FaultConfig{})
+	exists, err := object.LockWriteVersion(ctx)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	writeMirrorTestObject(t, targets[0], "all.lrb", "changed")
+	ok, err = object.Write(ctx, []byte("locked"))
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	assert.Error(t, object.Delete(ctx))
+
+	object, _ = client.NewObject("all.lrb")
+	assert.NoError(t, object.Delete(ctx))
+	for _, target := range targets {
+		_, err := readMirrorTestObject(target, "all.lrb")
+		assert.True(t, errors.Is(err, os.ErrNotExist))
+	}
+	exists, _ = object.Exists(ctx)
+	assert.False(t, exists)
+}
+
+func TestMirroredBlobStoreLockedReads(t *testing.T) {
+	ctx := context.Background()
+	targets := createMirrorTestStores(t, 2)
+	writeMirrorTestObject(t, targets[1], "store.lsi", "stale")
+	blobStore, _ := NewMirroredBlobStore([]BlobStore{targets[0], targets[1]}, MirrorOptions{WriteMode: MirrorWriteAll})
+	client, _ := blobStore.NewClient(ctx)
+	defer client.Close()
+	object, _ := client.NewObject("store.lsi")
+
+	data, err := object.Read(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "stale", string(data))
+
+	// Once locked the object is only read from the primary, the replica copy must not be merged into a write
+	exists, err := object.LockWriteVersion(ctx)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	_, err = object.Read(ctx)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	_, err = object.ReadRange(ctx, 0, 2)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	_, err = object.OpenRead(ctx)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+
+	// A replica that fails to lock is not written without its write condition
+	targets[1].SetConfig(FaultConfig{ErrorRate: 1, Operations: []FaultOperation{FaultLock}})
+	exists, err = object.LockWriteVersion(ctx)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	assert.False(t, blobStore.Stats()[1].Healthy)
+	ok, err := object.Write(ctx, []byte("fresh"))
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	data, err = readMirrorTestObject(targets[0], "store.lsi")
+	assert.NoError(t, err)
+	assert.Equal(t, "fresh", string(data))
+	data, err = readMirrorTestObject(targets[1], "store.lsi")
+	assert.NoError(t, err)
+	assert.Equal(t, "stale", string(data))
+}
+
+func TestMirroredBlobStoreURI(t *testing.T) {
+	defer DeleteNamedMemBlobStore("mirror-primary")
+	defer DeleteNamedMemBlobStore("mirror-replica")
+	SeedMemBlobs("mem://mirror-replica/store", map[string][]byte{"a.lrb": []byte("a")})
+
+	collector := NewMirrorStatsCollector()
+	blobStore, err := CreateBlobStoreForURI("mirror:mem://mirror-primary/store,mem://mirror-replica/store?hedge=100ms&write=all", WithMirrorStatsCollector(collector))
+	assert.NoError(t, err)
+	assert.Equal(t, "mirror:mem://mirror-primary/store/,mem://mirror-replica/store/", blobStore.String())
+	assert.Equal(t, 1, len(collector.Stores()))
+	mirroredStore := collector.Stores()[0]
+	assert.Equal(t, MirrorOptions{HedgeDelay: 100 * time.Millisecond, WriteMode: MirrorWriteAll, Cooldown: DefaultMirrorCooldown}, mirroredStore.options)
+
+	client, _ := blobStore.NewClient(context.Background())
+	defer client.Close()
+	object, _ := client.NewObject("a.lrb")
+	data, err := object.Read(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "a", string(data))
+	stats := mirroredStore.Stats()
+	assert.Equal(t, 2, len(stats))
+	assert.Equal(t, uint64(1), stats[0].NotFound)
+	assert.Equal(t, uint64(1), stats[1].Reads)
+
+	_, err = CreateBlobStoreForURI("mirror:")
+	assert.Error(t, err)
+	_, err = CreateBlobStoreForURI("mirror:mem://a/b?write=some")
+	assert.Error(t, err)
+	_, err = CreateBlobStoreForURI("mirror:https://example.com/store,mem://a/b")
+	assert.Error(t, err)
+	_, err = CreateBlobStoreForURI("mirror:mem://a/b,https://example.com/store")
+	assert.NoError(t, err)
+
+	// Plain path targets lock objects like fsblob:// stores
+	primaryPath := t.TempDir()
+	replicaPath := t.TempDir()
+	blobStore, err = CreateBlobStoreForURI("mirror:" + primaryPath + "," + replicaPath + "?write=all")
+	assert.NoError(t, err)
+	assert.Equal(t, "mirror:fsblob://"+primaryPath+",fsblob://"+replicaPath, blobStore.String())
+	pathClient, _ := blobStore.NewClient(context.Background())
+	defer pathClient.Close()
+	assert.True(t, pathClient.SupportsLocking())
+}
+
+func TestMirroredBlobStoreClientContext(t *testing.T) {
+	targets := createMirrorTestStores(t, 2)
+	writeMirrorTestObject(t, targets[1], "a.lrb", "a")
+	blobStore, err := NewMirroredBlobStore([]BlobStore{targets[0], targets[1]}, MirrorOptions{})
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	client, _ := blobStore.NewClient(ctx)
+	defer client.Close()
+
+	// Listing with the context of the client, a cancelled listing does not mark the primary as unhealthy
+	targets[0].SetConfig(FaultConfig{ErrorRate: 1})
+	cancel()
+	_, err = client.GetObjects("")
+	assert.Error(t, err)
+	err = client.WalkObjects("", func(properties BlobProperties) error { return nil })
+	assert.Error(t, err)
+	stats := blobStore.Stats()
+	assert.True(t, stats[0].Healthy)
+	assert.Equal(t, uint64(0), stats[0].Errors)
+}
+
+func TestParseMirrorOptions(t *testing.T) {
+	query, _ := url.ParseQuery("hedge=250ms&cooldown=1m&write=ALL")
+	options, err := ParseMirrorOptions(query)
+	assert.NoError(t, err)
+	assert.Equal(t, MirrorOptions{HedgeDelay: 250 * time.Millisecond, WriteMode: MirrorWriteAll, Cooldown: time.Minute}, options)
+	query, _ = url.ParseQuery("hedge=soon")
+	_, err = ParseMirrorOptions(query)
+	assert.Error(t, err)
+}
+
+func TestMirroredBlobStoreReadSource(t *testing.T) {
+	defer DeleteNamedMemBlobStore("source-primary")
+	defer DeleteNamedMemBlobStore("source-replica")
+	keyRing, err := ParseEncryptionKeys("key1:" + testEncryptionKey(1))
+	assert.NoError(t, err)
+	blobStore, err := CreateBlobStoreForURI("mirror:mem://source-primary/store,mem://source-replica/store?write=all", WithEncryptionKeyRing(keyRing))
+	assert.NoError(t, err)
+	writeMirrorTestObject(t, blobStore, "block.lrb", "data")
+	// Damage the copy on the primary behind the back of the mirror
+	primary, _ := CreateBlobStoreForURI("mem://source-primary/store")
+	writeMirrorTestObject(t, primary, "block.lrb", "garbage")
+
+	client, _ := blobStore.NewClient(context.Background())
+	defer client.Close()
+	object, _ := client.NewObject("block.lrb")
+	sourced, ok := object.(SourcedBlobObject)
+	assert.True(t, ok)
+	assert.Equal(t, 2, sourced.SourceCount())
+	_, err = sourced.ReadSource(context.Background(), 0)
+	assert.Error(t, err)
+	data, err := sourced.ReadSource(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+}
+
+func TestMirroredBlobStoreReadIfChanged(t *testing.T) {
+	ctx := context.Background()
+	targets := createMirrorTestStores(t, 2)
+	writeMirrorTestObject(t, targets[0], "store.lsi", "primary")
+	writeMirrorTestObject(t, targets[1], "store.lsi", "replica")
+	blobStore, _ := NewMirroredBlobStore([]BlobStore{targets[0], targets[1]}, MirrorOptions{})
+	client, _ := blobStore.NewClient(ctx)
+	defer client.Close()
+	object, _ := client.NewObject("store.lsi")
+	versioned, ok := object.(VersionedBlobObject)
+	assert.True(t, ok)
+
+	data, primaryVersion, changed, err := versioned.ReadIfChanged(ctx, "")
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "primary", string(data))
+	_, version, changed, err := versioned.ReadIfChanged(ctx, primaryVersion)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, primaryVersion, version)
+
+	// The replica serves the read while the primary fails, the version of the primary says nothing about the replica
+	targets[0].SetConfig(FaultConfig{Operations: []FaultOperation{FaultRead}, ErrorRate: 1})
+	data, replicaVersion, changed, err := versioned.ReadIfChanged(ctx, primaryVersion)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "replica", string(data))
+	assert.NotEqual(t, primaryVersion, replicaVersion)
+
+	// Once the primary is back the version of the replica is not used for it
+	targets[0].SetConfig(FaultConfig{})
+	blobStore, _ = NewMirroredBlobStore([]BlobStore{targets[0], targets[1]}, MirrorOptions{})
+	client, _ = blobStore.NewClient(ctx)
+	defer client.Close()
+	object, _ = client.NewObject("store.lsi")
+	data, version, changed, err = object.(VersionedBlobObject).ReadIfChanged(ctx, replicaVersion)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "primary", string(data))
+	assert.Equal(t, primaryVersion, version)
+}
@@ -0,0 +1,547 @@
+package longtailstorelib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Transfers are charged to the rate limiters in chunks of at most this size so that
+// concurrent transfers take turns instead of one large transfer blocking the others
+const rateLimiterChunkSize = 64 * 1024
+
+// RateLimiter limits the number of bytes per second transferred by everyone sharing it
+// Waiting callers are served in the order they arrive so the bandwidth is shared fairly
+type RateLimiter struct {
+	rate  int64
+	clock rateLimiterClock
+
+	mutex     sync.Mutex
+	available float64
+	last      time.Time
+}
+
+// rateLimiterClock is the time source of a RateLimiter, tests replace it so they don't depend on the wall clock
+type rateLimiterClock interface {
+	Now() time.Time
+	// Sleep waits for delay or until ctx is done
+	Sleep(ctx context.Context, delay time.Duration) error
+}
+
+type wallClock struct{}
+
+func (wallClock) Now() time.Time {
+	return time.Now()
+}
+
+func (wallClock) Sleep(ctx context.Context, delay time.Duration) error {
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// NewRateLimiter creates a limiter for bytesPerSecond, zero or less means unlimited
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	return &RateLimiter{rate: bytesPerSecond, clock: wallClock{}, last: time.Now()}
+}
+
+// SetRate changes the limit, it can be called while transfers are in progress
+func (limiter *RateLimiter) SetRate(bytesPerSecond int64) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	limiter.refill(limiter.clock.Now())
+	atomic.StoreInt64(&limiter.rate, bytesPerSecond)
+	// Drop any debt built up under the old rate so a raised limit applies right away
+	if limiter.available < 0 || bytesPerSecond <= 0 {
+		limiter.available = 0
+	}
+}
+
+// Rate returns the current limit in bytes per second, zero or less means unlimited
+func (limiter *RateLimiter) Rate() int64 {
+	return atomic.LoadInt64(&limiter.rate)
+}
+
+func (limiter *RateLimiter) refill(now time.Time) {
+	rate := atomic.LoadInt64(&limiter.rate)
+	if rate > 0 {
+		limiter.available += now.Sub(limiter.last).Seconds() * float64(rate)
+		// Allow at most one second worth of burst
+		if limiter.available > float64(rate) {
+			limiter.available = float64(rate)
+		}
+	}
+	limiter.last = now
+}
+
+// reserve takes count bytes from the limiter and returns how long the caller must wait before using them
+func (limiter *RateLimiter) reserve(count int) time.Duration {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	rate := atomic.LoadInt64(&limiter.rate)
+	if rate <= 0 {
+		return 0
+	}
+	limiter.refill(limiter.clock.Now())
+	limiter.available -= float64(count)
+	if limiter.available >= 0 {
+		return 0
+	}
+	return time.Duration(-limiter.available / float64(rate) * float64(time.Second))
+}
+
+// WaitN blocks until count bytes may be transferred or ctx is done
+func (limiter *RateLimiter) WaitN(ctx context.Context, count int) error {
+	for count > 0 {
+		chunk := count
+		if chunk > rateLimiterChunkSize {
+			chunk = rateLimiterChunkSize
+		}
+		count -= chunk
+		delay := limiter.reserve(chunk)
+		if delay <= 0 {
+			continue
+		}
+		if err := limiter.clock.Sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BandwidthLimits holds the rate limiters applied by rate limited blob stores, a nil
+// limiter means the direction is not limited. Total applies to uploads and downloads combined
+type BandwidthLimits struct {
+	Total    *RateLimiter
+	Download *RateLimiter
+	Upload   *RateLimiter
+}
+
+func (limits *BandwidthLimits) wait(ctx context.Context, directional *RateLimiter, count int) error {
+	for count > 0 {
+		chunk := count
+		if chunk > rateLimiterChunkSize {
+			chunk = rateLimiterChunkSize
+		}
+		count -= chunk
+		if directional != nil {
+			if err := directional.WaitN(ctx, chunk); err != nil {
+				return err
+			}
+		}
+		if limits.Total != nil {
+			if err := limits.Total.WaitN(ctx, chunk); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// NewBandwidthLimits creates limits for the given rates in bytes per second, zero means unlimited
+// All limiters are created even if unlimited so they can be changed with SetRate or ApplyControl later
+func NewBandwidthLimits(totalRate int64, downloadRate int64, uploadRate int64) *BandwidthLimits {
+	return &BandwidthLimits{
+		Total:    NewRateLimiter(totalRate),
+		Download: NewRateLimiter(downloadRate),
+		Upload:   NewRateLimiter(uploadRate),
+	}
+}
+
+// WaitDownload blocks until count downloaded bytes fit within the download and total limits
+func (limits *BandwidthLimits) WaitDownload(ctx context.Context, count int) error {
+	return limits.wait(ctx, limits.Download, count)
+}
+
+// WaitUpload blocks until count uploaded bytes fit within the upload and total limits
+func (limits *BandwidthLimits) WaitUpload(ctx context.Context, count int) error {
+	return limits.wait(ctx, limits.Upload, count)
+}
+
+// ParseByteRate parses a rate in bytes per second such as "500K", "10MB", "1.5MiB/s" or "0"
+// K, M and G are decimal units while Ki, Mi and Gi are binary units, zero or "unlimited" means no limit
+func ParseByteRate(s string) (int64, error) {
+	const fname = "ParseByteRate"
+	value := strings.ToLower(strings.TrimSpace(s))
+	if value == "" || value == "unlimited" {
+		return 0, nil
+	}
+	rate, err := ParseByteSize(strings.TrimSuffix(value, "/s"))
+	if err != nil {
+		return 0, errors.Wrap(fmt.Errorf("invalid rate `%s`", s), fname)
+	}
+	return rate, nil
+}
+
+// ParseByteSize parses a size in bytes such as "500K", "10MB" or "1.5GiB" with the same units as ParseByteRate
+func ParseByteSize(s string) (int64, error) {
+	const fname = "ParseByteSize"
+	value := strings.ToLower(strings.TrimSpace(s))
+	value = strings.TrimSuffix(value, "b")
+	multiplier := 1.0
+	units := []struct {
+		suffix     string
+		multiplier float64
+	}{
+		{"ki", 1 << 10}, {"mi", 1 << 20}, {"gi", 1 << 30},
+		{"k", 1e3}, {"m", 1e6}, {"g", 1e9},
+	}
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSuffix(value, unit.suffix)
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || number < 0 || math.IsInf(number, 0) {
+		return 0, errors.Wrap(fmt.Errorf("invalid size `%s`", s), fname)
+	}
+	return int64(number * multiplier), nil
+}
+
+// ApplyControl updates the limits from text with one `<total|download|upload>=<rate>` per line
+// Lines starting with # are comments and limits that are not mentioned keep their current rate
+func (limits *BandwidthLimits) ApplyControl(text string) error {
+	const fname = "BandwidthLimits.ApplyControl"
+	rates := map[*RateLimiter]int64{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return errors.Wrap(fmt.Errorf("expected `<direction>=<rate>`, got `%s`", line), fname)
+		}
+		var limiter *RateLimiter
+		switch strings.ToLower(strings.TrimSpace(parts[0])) {
+		case "total":
+			limiter = limits.Total
+		case "download":
+			limiter = limits.Download
+		case "upload":
+			limiter = limits.Upload
+		default:
+			return errors.Wrap(fmt.Errorf("unknown direction `%s`", parts[0]), fname)
+		}
+		rate, err := ParseByteRate(parts[1])
+		if err != nil {
+			return errors.Wrap(err, fname)
+		}
+		if limiter == nil {
+			return errors.Wrap(fmt.Errorf("no `%s` limiter to update", parts[0]), fname)
+		}
+		rates[limiter] = rate
+	}
+	// Only apply the rates once the whole file is known to be valid
+	for limiter, rate := range rates {
+		limiter.SetRate(rate)
+	}
+	return nil
+}
+
+// ReadBandwidthControlFile applies the rates in the file at path using ApplyControl
+func (limits *BandwidthLimits) ReadBandwidthControlFile(path string) error {
+	const fname = "BandwidthLimits.ReadBandwidthControlFile"
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	err = limits.ApplyControl(string(data))
+	if err != nil {
+		return errors.Wrap(errors.Wrap(err, path), fname)
+	}
+	return nil
+}
+
+// WatchBandwidthControlFile re-reads the control file at path whenever it is modified, checking
+// every interval, or when a value is received on reload until ctx is done. onError is called with
+// errors reading the file, a missing file is not an error and leaves the limits unchanged
+func (limits *BandwidthLimits) WatchBandwidthControlFile(ctx context.Context, path string, interval time.Duration, reload <-chan os.Signal, onError func(err error)) {
+	var lastModTime time.Time
+	check := func(force bool) {
+		info, err := os.Stat(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				onError(err)
+			}
+			return
+		}
+		if !force && info.ModTime().Equal(lastModTime) {
+			return
+		}
+		lastModTime = info.ModTime()
+		err = limits.ReadBandwidthControlFile(path)
+		if err != nil {
+			onError(err)
+		}
+	}
+	check(true)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			check(false)
+		case <-reload:
+			check(true)
+		}
+	}
+}
+
+// BandwidthOptions configures the rate limiting applied by CreateBlobStoreForURI
+type BandwidthOptions struct {
+	Limits *BandwidthLimits
+}
+
+// WithBandwidthLimits makes the created blob store share limits with all other stores using them
+func WithBandwidthLimits(limits *BandwidthLimits) BlobStoreOption {
+	return func(options interface{}) {
+		bandwidthOptions, ok := options.(*BandwidthOptions)
+		if !ok {
+			return
+		}
+		bandwidthOptions.Limits = limits
+	}
+}
+
+// GetBandwidthOptions collects the bandwidth options from opts
+func GetBandwidthOptions(opts ...BlobStoreOption) BandwidthOptions {
+	options := BandwidthOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+type rateLimitedBlobStore struct {
+	store  BlobStore
+	limits *BandwidthLimits
+}
+
+type rateLimitedBlobClient struct {
+	client BlobClient
+	store  *rateLimitedBlobStore
+}
+
+type rateLimitedBlobObject struct {
+	object BlobObject
+	path   string
+	client *rateLimitedBlobClient
+}
+
+type rateLimitedReader struct {
+	ctx    context.Context
+	reader io.Reader
+	wait   func(ctx context.Context, count int) error
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) > rateLimiterChunkSize {
+		p = p[:rateLimiterChunkSize]
+	}
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		if waitErr := r.wait(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+type rateLimitedReadCloser struct {
+	rateLimitedReader
+	closer io.Closer
+}
+
+func (r *rateLimitedReadCloser) Close() error {
+	return r.closer.Close()
+}
+
+// NewRateLimitedBlobStore wraps store so the payload of reads and writes is limited by limits
+// Uploads are charged before the data is sent and downloads after the data is received so
+// the rate averaged over several operations stays within the limits
+func NewRateLimitedBlobStore(store BlobStore, limits *BandwidthLimits) BlobStore {
+	return &rateLimitedBlobStore{store: store, limits: limits}
+}
+
+func (blobStore *rateLimitedBlobStore) NewClient(ctx context.Context) (BlobClient, error) {
+	const fname = "rateLimitedBlobStore.NewClient"
+	client, err := blobStore.store.NewClient(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return &rateLimitedBlobClient{client: client, store: blobStore}, nil
+}
+
+func (blobStore *rateLimitedBlobStore) String() string {
+	return blobStore.store.String()
+}
+
+func (blobClient *rateLimitedBlobClient) NewObject(path string) (BlobObject, error) {
+	const fname = "rateLimitedBlobClient.NewObject"
+	object, err := blobClient.client.NewObject(path)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return &rateLimitedBlobObject{object: object, path: path, client: blobClient}, nil
+}
+
+func (blobClient *rateLimitedBlobClient) GetObjects(pathPrefix string) ([]BlobProperties, error) {
+	return blobClient.client.GetObjects(pathPrefix)
+}
+
+func (blobClient *rateLimitedBlobClient) WalkObjects(pathPrefix string, walkFn BlobWalkFunc) error {
+	return blobClient.client.WalkObjects(pathPrefix, walkFn)
+}
+
+func (blobClient *rateLimitedBlobClient) SupportsLocking() bool {
+	return blobClient.client.SupportsLocking()
+}
+
+func (blobClient *rateLimitedBlobClient) ClassifyError(err error) BlobErrorClass {
+	return ClassifyBlobError(blobClient.client, err)
+}
+
+func (blobClient *rateLimitedBlobClient) String() string {
+	return blobClient.client.String()
+}
+
+func (blobClient *rateLimitedBlobClient) Close() {
+	blobClient.client.Close()
+}
+
+func (blobObject *rateLimitedBlobObject) limits() *BandwidthLimits {
+	return blobObject.client.store.limits
+}
+
+func (blobObject *rateLimitedBlobObject) Exists(ctx context.Context) (bool, error) {
+	return blobObject.object.Exists(ctx)
+}
+
+func (blobObject *rateLimitedBlobObject) Size(ctx context.Context) (int64, error) {
+	return getObjectSize(ctx, blobObject.client.client, blobObject.object, blobObject.path)
+}
+
+func (blobObject *rateLimitedBlobObject) LockWriteVersion(ctx context.Context) (bool, error) {
+	return blobObject.object.LockWriteVersion(ctx)
+}
+
+func (blobObject *rateLimitedBlobObject) Read(ctx context.Context) ([]byte, error) {
+	const fname = "rateLimitedBlobObject.Read"
+	data, err := blobObject.object.Read(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	err = blobObject.limits().WaitDownload(ctx, len(data))
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return data, nil
+}
+
+func (blobObject *rateLimitedBlobObject) ReadIfChanged(ctx context.Context, version string) ([]byte, string, bool, error) {
+	const fname = "rateLimitedBlobObject.ReadIfChanged"
+	data, currentVersion, changed, err := ReadObjectIfChanged(ctx, blobObject.object, version)
+	if err != nil {
+		return nil, "", false, errors.Wrap(err, fname)
+	}
+	err = blobObject.limits().WaitDownload(ctx, len(data))
+	if err != nil {
+		return nil, "", false, errors.Wrap(err, fname)
+	}
+	return data, currentVersion, changed, nil
+}
+
+func (blobObject *rateLimitedBlobObject) SourceCount() int {
+	if sourced, ok := blobObject.object.(SourcedBlobObject); ok {
+		return sourced.SourceCount()
+	}
+	return 1
+}
+
+func (blobObject *rateLimitedBlobObject) ReadSource(ctx context.Context, source int) ([]byte, error) {
+	const fname = "rateLimitedBlobObject.ReadSource"
+	sourced, ok := blobObject.object.(SourcedBlobObject)
+	if !ok {
+		return blobObject.Read(ctx)
+	}
+	data, err := sourced.ReadSource(ctx, source)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	err = blobObject.limits().WaitDownload(ctx, len(data))
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return data, nil
+}
+
+func (blobObject *rateLimitedBlobObject) OpenRead(ctx context.Context) (io.ReadCloser, error) {
+	const fname = "rateLimitedBlobObject.OpenRead"
+	reader, err := blobObject.object.OpenRead(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return &rateLimitedReadCloser{
+		rateLimitedReader: rateLimitedReader{ctx: ctx, reader: reader, wait: blobObject.limits().WaitDownload},
+		closer:            reader}, nil
+}
+
+func (blobObject *rateLimitedBlobObject) ReadRange(ctx context.Context, offset int64, length int64) ([]byte, error) {
+	const fname = "rateLimitedBlobObject.ReadRange"
+	data, err := blobObject.object.ReadRange(ctx, offset, length)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	err = blobObject.limits().WaitDownload(ctx, len(data))
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return data, nil
+}
+
+func (blobObject *rateLimitedBlobObject) Write(ctx context.Context, data []byte) (bool, error) {
+	const fname = "rateLimitedBlobObject.Write"
+	err := blobObject.limits().WaitUpload(ctx, len(data))
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	ok, err := blobObject.object.Write(ctx, data)
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	return ok, nil
+}
+
+func (blobObject *rateLimitedBlobObject) WriteFrom(ctx context.Context, reader io.Reader) (bool, error) {
+	const fname = "rateLimitedBlobObject.WriteFrom"
+	ok, err := blobObject.object.WriteFrom(ctx, &rateLimitedReader{ctx: ctx, reader: reader, wait: blobObject.limits().WaitUpload})
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	return ok, nil
+}
+
+func (blobObject *rateLimitedBlobObject) Delete(ctx context.Context) error {
+	return blobObject.object.Delete(ctx)
+}
+
+func (blobObject *rateLimitedBlobObject) String() string {
+	return blobObject.object.String()
+}
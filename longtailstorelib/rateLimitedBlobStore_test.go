@@ -0,0 +1,254 @@
+package longtailstorelib
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+type testClockKey struct{}
+
+// testClock is a rateLimiterClock where sleeping moves the time forward right away. A frozen clock keeps the
+// time still and records the last delay slept for the testClockKey value of each context instead
+type testClock struct {
+	mutex     sync.Mutex
+	now       time.Time
+	slept     time.Duration
+	frozen    bool
+	lastDelay map[interface{}]time.Duration
+}
+
+func newTestClock(frozen bool) *testClock {
+	return &testClock{now: time.Now(), frozen: frozen, lastDelay: map[interface{}]time.Duration{}}
+}
+
+func (clock *testClock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.now
+}
+
+func (clock *testClock) Sleep(ctx context.Context, delay time.Duration) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	clock.slept += delay
+	if clock.frozen {
+		clock.lastDelay[ctx.Value(testClockKey{})] = delay
+	} else {
+		clock.now = clock.now.Add(delay)
+	}
+	return nil
+}
+
+func (clock *testClock) Advance(d time.Duration) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	clock.now = clock.now.Add(d)
+}
+
+// Slept returns the time spent sleeping so far
+func (clock *testClock) Slept() time.Duration {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.slept
+}
+
+func useTestClock(clock *testClock, limiters ...*RateLimiter) {
+	for _, limiter := range limiters {
+		limiter.mutex.Lock()
+		limiter.clock = clock
+		limiter.last = clock.Now()
+		limiter.mutex.Unlock()
+	}
+}
+
+// assertDuration allows for the rounding of the float byte counts of the limiter
+func assertDuration(t *testing.T, expected time.Duration, actual time.Duration) {
+	t.Helper()
+	assert.True(t, actual > expected-time.Microsecond && actual < expected+time.Microsecond, "expected %s, got %s", expected, actual)
+}
+
+func TestRateLimiter(t *testing.T) {
+	ctx := context.Background()
+	clock := newTestClock(false)
+	limiter := NewRateLimiter(0)
+	useTestClock(clock, limiter)
+	assert.NoError(t, limiter.WaitN(ctx, 100*1024*1024))
+	assert.Equal(t, time.Duration(0), clock.Slept())
+
+	// A new limiter has no burst available so 100KB takes 100ms
+	limiter = NewRateLimiter(1000 * 1000)
+	useTestClock(clock, limiter)
+	assert.NoError(t, limiter.WaitN(ctx, 100*1000))
+	assertDuration(t, 100*time.Millisecond, clock.Slept())
+
+	// An idle limiter builds up at most one second worth of burst
+	clock.Advance(10 * time.Second)
+	before := clock.Slept()
+	assert.NoError(t, limiter.WaitN(ctx, 1000*1000))
+	assert.Equal(t, before, clock.Slept())
+	assert.NoError(t, limiter.WaitN(ctx, 1000))
+	assertDuration(t, time.Millisecond, clock.Slept()-before)
+
+	// Raising the rate drops the debt so waiting stops right away
+	limiter = NewRateLimiter(1)
+	useTestClock(clock, limiter)
+	limiter.reserve(1000)
+	limiter.SetRate(0)
+	before = clock.Slept()
+	assert.NoError(t, limiter.WaitN(ctx, 1000))
+	assert.Equal(t, before, clock.Slept())
+	assert.Equal(t, int64(0), limiter.Rate())
+
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	limiter = NewRateLimiter(1)
+	assert.Error(t, limiter.WaitN(cancelCtx, 1000))
+}
+
+func TestRateLimiterShared(t *testing.T) {
+	// The clock stands still so each transfer waits for the bytes reserved before it by all transfers
+	clock := newTestClock(true)
+	limiter := NewRateLimiter(2 * 1000 * 1000)
+	useTestClock(clock, limiter)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			limiter.WaitN(context.WithValue(context.Background(), testClockKey{}, i), 100*1000)
+		}(i)
+	}
+	wg.Wait()
+	// 400KB at 2MB/s is 200ms in total, the transfer that finishes last waits for all of it
+	// and no transfer waits less than for its own 100KB
+	latest := time.Duration(0)
+	for i := 0; i < 4; i++ {
+		delay := clock.lastDelay[i]
+		assert.True(t, delay > 50*time.Millisecond-time.Microsecond, "transfer %d waited %s", i, delay)
+		if delay > latest {
+			latest = delay
+		}
+	}
+	assertDuration(t, 200*time.Millisecond, latest)
+}
+
+func TestParseByteRate(t *testing.T) {
+	valid := map[string]int64{
+		"":          0,
+		"0":         0,
+		"unlimited": 0,
+		"1024":      1024,
+		"500K":      500 * 1000,
+		"10MB":      10 * 1000 * 1000,
+		"1.5MiB/s":  3 * 512 * 1024,
+		"2G":        2 * 1000 * 1000 * 1000,
+		" 4 kib ":   4096,
+	}
+	for s, expected := range valid {
+		rate, err := ParseByteRate(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, expected, rate, s)
+	}
+	for _, s := range []string{"fast", "-1M", "10X"} {
+		_, err := ParseByteRate(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestBandwidthLimitsControl(t *testing.T) {
+	limits := NewBandwidthLimits(0, 1000, 2000)
+	assert.NoError(t, limits.ApplyControl("# office hours\ndownload=10MB\n\nupload = 0\n"))
+	assert.Equal(t, int64(0), limits.Total.Rate())
+	assert.Equal(t, int64(10*1000*1000), limits.Download.Rate())
+	assert.Equal(t, int64(0), limits.Upload.Rate())
+
+	// Invalid control text leaves all limits unchanged
+	assert.Error(t, limits.ApplyControl("total=1M\nsideways=1M"))
+	assert.Error(t, limits.ApplyControl("total=1M\ndownload=fast"))
+	assert.Equal(t, int64(0), limits.Total.Rate())
+
+	controlFile := filepath.Join(t.TempDir(), "bandwidth.txt")
+	ctx, cancel := context.WithCancel(context.Background())
+	reload := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	go func() {
+		limits.WatchBandwidthControlFile(ctx, controlFile, time.Hour, reload, func(err error) { t.Error(err) })
+		close(done)
+	}()
+	os.WriteFile(controlFile, []byte("total=5M"), 0644)
+	reload <- os.Interrupt
+	for i := 0; i < 100 && limits.Total.Rate() != 5*1000*1000; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, int64(5*1000*1000), limits.Total.Rate())
+	cancel()
+	<-done
+}
+
+func TestRateLimitedBlobStore(t *testing.T) {
+	defer DeleteNamedMemBlobStore("ratelimited")
+	limits := NewBandwidthLimits(0, 0, 0)
+	ctx := WithBlobStoreOptions(context.Background(), WithBandwidthLimits(limits))
+	blobStore, err := CreateBlobStoreForURI("mem://ratelimited/store", GetBlobStoreOptions(ctx)...)
+	assert.NoError(t, err)
+	_, ok := blobStore.(*rateLimitedBlobStore)
+	assert.True(t, ok)
+	client, _ := blobStore.NewClient(ctx)
+	defer client.Close()
+	clock := newTestClock(false)
+	useTestClock(clock, limits.Total, limits.Download, limits.Upload)
+
+	data := bytes.Repeat([]byte{1, 2, 3, 4}, 64*1024)
+	object, _ := client.NewObject("block.lrb")
+	ok, err = object.Write(ctx, data)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	ok, err = object.WriteFrom(ctx, bytes.NewReader(data))
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	assert.Equal(t, time.Duration(0), clock.Slept())
+
+	// Lower the download rate so reading the 256KB object has to wait
+	limits.Download.SetRate(2 * 1000 * 1000)
+	read, err := object.Read(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, data, read)
+	assertDuration(t, 131072*time.Microsecond, clock.Slept())
+
+	limits.Download.SetRate(0)
+	reader, err := object.OpenRead(ctx)
+	assert.NoError(t, err)
+	read, err = io.ReadAll(reader)
+	reader.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, data, read)
+
+	// The upload limit does not apply to downloads but the total limit does
+	limits.Upload.SetRate(1)
+	limits.Upload.reserve(1000)
+	before := clock.Slept()
+	_, err = object.ReadRange(ctx, 0, 16)
+	assert.NoError(t, err)
+	assert.Equal(t, before, clock.Slept())
+	limits.Total.SetRate(1)
+	limits.Total.reserve(1000)
+	_, err = object.ReadRange(ctx, 0, 16)
+	assert.NoError(t, err)
+	assert.True(t, clock.Slept()-before >= 1000*time.Second)
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = object.ReadRange(cancelCtx, 0, 16)
+	assert.Error(t, err)
+}
@@ -0,0 +1,233 @@
+package longtailstorelib
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// BlobErrorClass tells RetryBlobOperation how to react to a failed operation
+type BlobErrorClass int
+
+const (
+	// BlobErrorPermanent errors are returned without retrying, such as a missing object or access denied
+	BlobErrorPermanent BlobErrorClass = iota
+	// BlobErrorTransient errors are retried with exponential backoff
+	BlobErrorTransient
+	// BlobErrorThrottled errors are retried and make every user of the same BlobThrottle back off
+	BlobErrorThrottled
+)
+
+// BlobErrorClassifier is implemented by blob clients which can tell transient and
+// throttling errors from their backend apart from permanent failures
+type BlobErrorClassifier interface {
+	ClassifyError(err error) BlobErrorClass
+}
+
+// ErrBlobThrottled can be returned from an operation passed to RetryBlobOperation
+// when the store signals throttling without an error, such as a rejected write
+var ErrBlobThrottled = errors.New("blob store is throttling requests")
+
+// ClassifyBlobError classifies err using the backend specific classification of client
+// Missing objects, cancelled operations and version locked writes or deletes of a changed object are
+// permanent. Network errors, expired operation timeouts and faults injected by a FaultyBlobStore are
+// transient. Errors that are not recognized here or by client are permanent so they are reported at once
+func ClassifyBlobError(client BlobClient, err error) BlobErrorClass {
+	switch {
+	case errors.Is(err, os.ErrNotExist), errors.Is(err, context.Canceled), errors.Is(err, ErrBlobVersionChanged):
+		return BlobErrorPermanent
+	case errors.Is(err, ErrBlobThrottled):
+		return BlobErrorThrottled
+	case errors.Is(err, ErrInjectedFault):
+		return BlobErrorTransient
+	}
+	if classifier, ok := client.(BlobErrorClassifier); ok {
+		return classifier.ClassifyError(err)
+	}
+	if class, ok := classifyNetworkError(err); ok {
+		return class
+	}
+	return BlobErrorPermanent
+}
+
+// classifyHTTPStatus is the shared classification for backends that report http status codes
+// Throttling, request timeouts and server errors are retried, anything else is permanent
+func classifyHTTPStatus(statusCode int) BlobErrorClass {
+	switch {
+	case statusCode == 429 || statusCode == 503:
+		return BlobErrorThrottled
+	case statusCode == 408 || statusCode >= 500:
+		return BlobErrorTransient
+	}
+	return BlobErrorPermanent
+}
+
+// classifyNetworkError returns true and the class if err is a network level error
+func classifyNetworkError(err error) (BlobErrorClass, bool) {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return BlobErrorTransient, true
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		// The operation timeout set with WithOperationTimeout expired, try again
+		return BlobErrorTransient, true
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		// The connection was dropped while reading the response body
+		return BlobErrorTransient, true
+	}
+	return BlobErrorPermanent, false
+}
+
+// RetryPolicy controls how RetryBlobOperation retries failed operations
+type RetryPolicy struct {
+	// Number of retries after the first attempt, zero disables retries
+	MaxRetries int
+	// Delay before the first retry, doubled for each following retry
+	InitialDelay time.Duration
+	// Upper limit for the delay between retries
+	MaxDelay time.Duration
+	// Minimum time all users of a BlobThrottle back off after a throttling error
+	ThrottleDelay time.Duration
+	// Fraction of each delay that is randomized, 0.5 gives delays between 50% and 100% of the nominal delay
+	Jitter float64
+}
+
+// DefaultRetryPolicy is used for contexts without a policy set with WithRetryPolicy
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries:    6,
+		InitialDelay:  100 * time.Millisecond,
+		MaxDelay:      5 * time.Second,
+		ThrottleDelay: 1 * time.Second,
+		Jitter:        0.5,
+	}
+}
+
+// Delay returns the time to wait before retry number retry, counting from zero
+func (policy RetryPolicy) Delay(retry int, class BlobErrorClass) time.Duration {
+	delay := policy.InitialDelay
+	for i := 0; i < retry && delay < policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if class == BlobErrorThrottled && delay < policy.ThrottleDelay {
+		delay = policy.ThrottleDelay
+	}
+	if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	if policy.Jitter > 0 && delay > 0 {
+		jitter := policy.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		delay -= time.Duration(rand.Float64() * jitter * float64(delay))
+	}
+	return delay
+}
+
+type retryPolicyKey struct{}
+
+// WithRetryPolicy returns a copy of ctx which makes RetryBlobOperation use policy
+func WithRetryPolicy(ctx context.Context, policy RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, policy)
+}
+
+// GetRetryPolicy returns the policy set on ctx with WithRetryPolicy or the DefaultRetryPolicy
+func GetRetryPolicy(ctx context.Context) RetryPolicy {
+	policy, ok := ctx.Value(retryPolicyKey{}).(RetryPolicy)
+	if !ok {
+		return DefaultRetryPolicy()
+	}
+	return policy
+}
+
+// BlobThrottle is shared by everything talking to the same store so that a throttling
+// response seen by one of them makes all of them back off together
+type BlobThrottle struct {
+	mutex         sync.Mutex
+	until         time.Time
+	throttleCount uint64
+}
+
+// NewBlobThrottle ...
+func NewBlobThrottle() *BlobThrottle {
+	return &BlobThrottle{}
+}
+
+// Signal makes all users of the throttle wait at least delay before their next operation
+func (throttle *BlobThrottle) Signal(delay time.Duration) {
+	atomic.AddUint64(&throttle.throttleCount, 1)
+	until := time.Now().Add(delay)
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+	if until.After(throttle.until) {
+		throttle.until = until
+	}
+}
+
+// Wait blocks until the current back off period has passed or ctx is done
+func (throttle *BlobThrottle) Wait(ctx context.Context) error {
+	throttle.mutex.Lock()
+	delay := time.Until(throttle.until)
+	throttle.mutex.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ThrottleCount returns the number of throttling errors signalled
+func (throttle *BlobThrottle) ThrottleCount() uint64 {
+	return atomic.LoadUint64(&throttle.throttleCount)
+}
+
+// RetryBlobOperation calls operation until it succeeds, fails with a permanent error,
+// runs out of retries as given by the RetryPolicy of ctx or ctx is done.
+// throttle is optional, if set operations wait for any ongoing back off before starting
+// and throttling errors make everyone using the throttle back off.
+// Returns the number of retries made together with the error of the last attempt
+func RetryBlobOperation(ctx context.Context, client BlobClient, throttle *BlobThrottle, operation func() error) (int, error) {
+	policy := GetRetryPolicy(ctx)
+	retryCount := 0
+	for {
+		if throttle != nil {
+			if err := throttle.Wait(ctx); err != nil {
+				return retryCount, err
+			}
+		}
+		err := operation()
+		if err == nil {
+			return retryCount, nil
+		}
+		if ctx.Err() != nil {
+			return retryCount, errors.Wrap(ctx.Err(), err.Error())
+		}
+		class := ClassifyBlobError(client, err)
+		if class == BlobErrorPermanent || retryCount >= policy.MaxRetries {
+			return retryCount, err
+		}
+		delay := policy.Delay(retryCount, class)
+		if class == BlobErrorThrottled && throttle != nil {
+			throttle.Signal(delay)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return retryCount, errors.Wrap(ctx.Err(), err.Error())
+		}
+		retryCount++
+	}
+}
@@ -0,0 +1,161 @@
+package longtailstorelib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/aws/smithy-go"
+	"github.com/pkg/errors"
+	"google.golang.org/api/googleapi"
+)
+
+func testRetryContext(maxRetries int) context.Context {
+	return WithRetryPolicy(context.Background(), RetryPolicy{
+		MaxRetries:    maxRetries,
+		InitialDelay:  time.Millisecond,
+		MaxDelay:      10 * time.Millisecond,
+		ThrottleDelay: 20 * time.Millisecond,
+	})
+}
+
+func TestRetryBlobOperation(t *testing.T) {
+	ctx := testRetryContext(3)
+	blobStore, _ := NewMemBlobStore("", true)
+	client, _ := blobStore.NewClient(ctx)
+	defer client.Close()
+
+	attempts := 0
+	retryCount, err := RetryBlobOperation(ctx, client, nil, func() error {
+		attempts++
+		if attempts < 3 {
+			return errors.Wrap(ErrInjectedFault, "transient failure")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, retryCount)
+
+	attempts = 0
+	retryCount, err = RetryBlobOperation(ctx, client, nil, func() error {
+		attempts++
+		return errors.Wrap(ErrInjectedFault, "keeps failing")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 3, retryCount)
+	assert.Equal(t, 4, attempts)
+
+	attempts = 0
+	retryCount, err = RetryBlobOperation(ctx, client, nil, func() error {
+		attempts++
+		return errors.Wrap(os.ErrNotExist, "missing")
+	})
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	assert.Equal(t, 0, retryCount)
+	assert.Equal(t, 1, attempts)
+
+	// Errors that are not known to be transient are not retried
+	attempts = 0
+	retryCount, err = RetryBlobOperation(ctx, client, nil, func() error {
+		attempts++
+		return fmt.Errorf("unknown failure")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 0, retryCount)
+	assert.Equal(t, 1, attempts)
+
+	retryCount, err = RetryBlobOperation(testRetryContext(0), client, nil, func() error {
+		return errors.Wrap(ErrInjectedFault, "no retries")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 0, retryCount)
+}
+
+func TestRetryBlobOperationCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(WithRetryPolicy(context.Background(), RetryPolicy{MaxRetries: 5, InitialDelay: time.Hour}))
+	blobStore, _ := NewMemBlobStore("", true)
+	client, _ := blobStore.NewClient(ctx)
+	defer client.Close()
+
+	retryCount, err := RetryBlobOperation(ctx, client, nil, func() error {
+		cancel()
+		return fmt.Errorf("failure")
+	})
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, 0, retryCount)
+}
+
+func TestBlobThrottle(t *testing.T) {
+	ctx := testRetryContext(3)
+	blobStore, _ := NewMemBlobStore("", true)
+	client, _ := blobStore.NewClient(ctx)
+	defer client.Close()
+
+	throttle := NewBlobThrottle()
+	attempts := 0
+	retryCount, err := RetryBlobOperation(ctx, client, throttle, func() error {
+		attempts++
+		if attempts == 1 {
+			return ErrBlobThrottled
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, retryCount)
+	assert.Equal(t, uint64(1), throttle.ThrottleCount())
+
+	// A throttle signalled by one user delays everyone sharing it
+	throttle.Signal(50 * time.Millisecond)
+	start := time.Now()
+	_, err = RetryBlobOperation(ctx, client, throttle, func() error { return nil })
+	assert.NoError(t, err)
+	assert.True(t, time.Since(start) >= 40*time.Millisecond)
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{InitialDelay: 100 * time.Millisecond, MaxDelay: time.Second, ThrottleDelay: 500 * time.Millisecond}
+	assert.Equal(t, 100*time.Millisecond, policy.Delay(0, BlobErrorTransient))
+	assert.Equal(t, 400*time.Millisecond, policy.Delay(2, BlobErrorTransient))
+	assert.Equal(t, time.Second, policy.Delay(10, BlobErrorTransient))
+	assert.Equal(t, 500*time.Millisecond, policy.Delay(0, BlobErrorThrottled))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay := policy.Delay(1, BlobErrorTransient)
+		assert.True(t, delay >= 100*time.Millisecond && delay <= 200*time.Millisecond)
+	}
+}
+
+func TestClassifyBlobError(t *testing.T) {
+	memStore, _ := NewMemBlobStore("", true)
+	memClient, _ := memStore.NewClient(context.Background())
+	assert.Equal(t, BlobErrorPermanent, ClassifyBlobError(memClient, fmt.Errorf("unknown")))
+	assert.Equal(t, BlobErrorPermanent, ClassifyBlobError(memClient, errors.Wrap(ErrBlobVersionChanged, "locked delete")))
+	assert.Equal(t, BlobErrorTransient, ClassifyBlobError(memClient, errors.Wrap(ErrInjectedFault, "fault")))
+	assert.Equal(t, BlobErrorTransient, ClassifyBlobError(memClient, errors.Wrap(io.ErrUnexpectedEOF, "read body")))
+	assert.Equal(t, BlobErrorTransient, ClassifyBlobError(memClient, errors.Wrap(context.DeadlineExceeded, "timeout")))
+	assert.Equal(t, BlobErrorPermanent, ClassifyBlobError(memClient, errors.Wrap(os.ErrNotExist, "missing")))
+	assert.Equal(t, BlobErrorPermanent, ClassifyBlobError(memClient, errors.Wrap(context.Canceled, "cancelled")))
+	assert.Equal(t, BlobErrorThrottled, ClassifyBlobError(memClient, errors.Wrap(ErrBlobThrottled, "rejected")))
+
+	s3Client := &s3BlobClient{}
+	assert.Equal(t, BlobErrorThrottled, ClassifyBlobError(s3Client, &smithy.GenericAPIError{Code: "SlowDown"}))
+	assert.Equal(t, BlobErrorTransient, ClassifyBlobError(s3Client, &smithy.GenericAPIError{Code: "InternalError"}))
+	assert.Equal(t, BlobErrorPermanent, ClassifyBlobError(s3Client, &smithy.GenericAPIError{Code: "AccessDenied"}))
+	assert.Equal(t, BlobErrorPermanent, ClassifyBlobError(s3Client, fmt.Errorf("unknown")))
+
+	gcsClient := &gcsBlobClient{}
+	assert.Equal(t, BlobErrorThrottled, ClassifyBlobError(gcsClient, errors.Wrap(&googleapi.Error{Code: 429}, "write")))
+	assert.Equal(t, BlobErrorTransient, ClassifyBlobError(gcsClient, &googleapi.Error{Code: 502}))
+	assert.Equal(t, BlobErrorPermanent, ClassifyBlobError(gcsClient, &googleapi.Error{Code: 403}))
+	assert.Equal(t, BlobErrorPermanent, ClassifyBlobError(gcsClient, errors.Wrap(ErrBlobVersionChanged, "locked delete")))
+
+	httpClient := &httpBlobClient{}
+	assert.Equal(t, BlobErrorThrottled, ClassifyBlobError(httpClient, &httpStatusError{statusCode: 503}))
+	assert.Equal(t, BlobErrorPermanent, ClassifyBlobError(httpClient, &httpStatusError{statusCode: 401}))
+	assert.Equal(t, BlobErrorTransient, ClassifyBlobError(httpClient, errors.Wrap(context.DeadlineExceeded, "timeout")))
+}
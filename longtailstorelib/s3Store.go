@@ -4,19 +4,29 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/url"
 	"os"
+	"strconv"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 	"github.com/pkg/errors"
 )
 
 type S3Options struct {
 	EndpointResolverURI string
+	// Use path style addressing (endpoint/bucket/key), needed for MinIO and similar
+	UsePathStyle bool
+	// Lock objects with conditional writes (If-Match/If-None-Match). Set with `?locking=true` on the store
+	// URI, it is off by default since S3 compatible stores that ignore the conditions would silently
+	// lose concurrent store index updates
+	EnableLocking bool
 }
 
 type s3BlobStore struct {
@@ -31,12 +41,28 @@ type s3BlobClient struct {
 	client *s3.Client
 }
 
+type s3WriteCondition struct {
+	ifMatch     *string
+	ifNoneMatch *string
+}
+
 type s3BlobObject struct {
-	ctx    context.Context
-	client *s3BlobClient
-	path   string
+	client         *s3BlobClient
+	path           string
+	writeCondition *s3WriteCondition
 }
 
+const (
+	// If the object changed between our lock and write/delete S3 responds with 412,
+	// or 409 if a concurrent conditional write to the same object is in progress
+	s3WriteConditionFailed       = 412
+	s3ConditionalRequestConflict = 409
+	s3InvalidRange               = 416
+	s3NotFound                   = 404
+	// A conditional read of an object that still has the requested ETag responds with 304
+	s3NotModified = 304
+)
+
 // NewS3BlobStore ...
 func NewS3BlobStore(u *url.URL, opts ...BlobStoreOption) (BlobStore, error) {
 	const fname = "NewS3BlobStore"
@@ -53,6 +79,14 @@ func NewS3BlobStore(u *url.URL, opts ...BlobStoreOption) (BlobStore, error) {
 		prefix += "/"
 	}
 	s := &s3BlobStore{bucketName: u.Host, prefix: prefix}
+	if locking := u.Query().Get("locking"); locking != "" {
+		enableLocking, err := strconv.ParseBool(locking)
+		if err != nil {
+			err = errors.Wrapf(err, "invalid locking option '%s' in '%s'", locking, u.String())
+			return nil, errors.Wrap(err, fname)
+		}
+		s.options.EnableLocking = enableLocking
+	}
 	for _, opt := range opts {
 		opt(&s.options)
 	}
@@ -69,6 +103,7 @@ func (blobStore *s3BlobStore) NewClient(ctx context.Context) (BlobClient, error)
 		if blobStore.options.EndpointResolverURI != "" {
 			o.EndpointResolver = s3.EndpointResolverFromURL(blobStore.options.EndpointResolverURI)
 		}
+		o.UsePathStyle = blobStore.options.UsePathStyle
 	})
 	return &s3BlobClient{store: blobStore, ctx: ctx, client: client}, nil
 }
@@ -80,31 +115,49 @@ func (blobStore *s3BlobStore) String() string {
 func (blobClient *s3BlobClient) NewObject(path string) (BlobObject, error) {
 	s3Path := blobClient.store.prefix + path
 	return &s3BlobObject{
-			ctx:    blobClient.ctx,
-			client: blobClient,
-			path:   s3Path},
+			client:         blobClient,
+			path:           s3Path,
+			writeCondition: nil},
 		nil
 }
 
 func (blobClient *s3BlobClient) GetObjects(pathPrefix string) ([]BlobProperties, error) {
 	const fname = "s3BlobClient.GetObjects"
 	var items []BlobProperties
-	output, err := blobClient.client.ListObjectsV2(blobClient.ctx, &s3.ListObjectsV2Input{
-		Bucket: aws.String(blobClient.store.bucketName),
-		Prefix: aws.String(blobClient.store.prefix + pathPrefix),
+	err := blobClient.WalkObjects(pathPrefix, func(properties BlobProperties) error {
+		items = append(items, properties)
+		return nil
 	})
 	if err != nil {
 		return nil, errors.Wrap(err, fname)
 	}
-	for _, object := range output.Contents {
-		itemName := aws.ToString(object.Key)[len(blobClient.store.prefix):]
-		items = append(items, BlobProperties{Size: *object.Size, Name: itemName})
-	}
 	return items, nil
 }
 
+func (blobClient *s3BlobClient) WalkObjects(pathPrefix string, walkFn BlobWalkFunc) error {
+	const fname = "s3BlobClient.WalkObjects"
+	paginator := s3.NewListObjectsV2Paginator(blobClient.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(blobClient.store.bucketName),
+		Prefix: aws.String(blobClient.store.prefix + pathPrefix),
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(blobClient.ctx)
+		if err != nil {
+			return errors.Wrap(err, fname)
+		}
+		for _, object := range output.Contents {
+			itemName := aws.ToString(object.Key)[len(blobClient.store.prefix):]
+			err = walkFn(BlobProperties{Size: aws.ToInt64(object.Size), Name: itemName, Version: aws.ToString(object.ETag)})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (blobClient *s3BlobClient) SupportsLocking() bool {
-	return false
+	return blobClient.store.options.EnableLocking
 }
 
 func (blobClient *s3BlobClient) Close() {
@@ -115,13 +168,35 @@ func (blobClient *s3BlobClient) String() string {
 	return blobClient.store.String()
 }
 
-func (blobObject *s3BlobObject) Read() ([]byte, error) {
+func (blobClient *s3BlobClient) ClassifyError(err error) BlobErrorClass {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "SlowDown", "Throttling", "ThrottlingException", "RequestLimitExceeded", "RequestThrottled", "TooManyRequestsException", "ServiceUnavailable":
+			return BlobErrorThrottled
+		case "InternalError", "RequestTimeout":
+			return BlobErrorTransient
+		}
+	}
+	var responseErr *awshttp.ResponseError
+	if errors.As(err, &responseErr) {
+		return classifyHTTPStatus(responseErr.HTTPStatusCode())
+	}
+	if class, ok := classifyNetworkError(err); ok {
+		return class
+	}
+	return BlobErrorPermanent
+}
+
+func (blobObject *s3BlobObject) Read(ctx context.Context) ([]byte, error) {
 	const fname = "s3BlobObject.Read()"
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
 	input := &s3.GetObjectInput{
 		Bucket: aws.String(blobObject.client.store.bucketName),
 		Key:    aws.String(blobObject.path),
 	}
-	result, err := blobObject.client.client.GetObject(blobObject.client.ctx, input)
+	result, err := blobObject.client.client.GetObject(ctx, input)
 	if err != nil {
 		var nsk *types.NoSuchKey
 		if errors.As(err, &nsk) {
@@ -138,17 +213,128 @@ func (blobObject *s3BlobObject) Read() ([]byte, error) {
 	return data, nil
 }
 
-func (blobObject *s3BlobObject) LockWriteVersion() (bool, error) {
-	return false, nil
+func (blobObject *s3BlobObject) ReadIfChanged(ctx context.Context, version string) ([]byte, string, bool, error) {
+	const fname = "s3BlobObject.ReadIfChanged()"
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(blobObject.client.store.bucketName),
+		Key:    aws.String(blobObject.path),
+	}
+	if version != "" {
+		input.IfNoneMatch = aws.String(version)
+	}
+	result, err := blobObject.client.client.GetObject(ctx, input)
+	if err != nil {
+		var nsk *types.NoSuchKey
+		if errors.As(err, &nsk) {
+			err = errors.Wrapf(os.ErrNotExist, "%v", err)
+			return nil, "", false, errors.Wrap(err, fname)
+		}
+		var responseErr *awshttp.ResponseError
+		if errors.As(err, &responseErr) && responseErr.HTTPStatusCode() == s3NotModified {
+			return nil, version, false, nil
+		}
+		return nil, "", false, errors.Wrap(err, fname)
+	}
+	data, err := ioutil.ReadAll(result.Body)
+	result.Body.Close()
+	if err != nil {
+		return nil, "", false, errors.Wrap(err, fname)
+	}
+	return data, aws.ToString(result.ETag), true, nil
+}
+
+func (blobObject *s3BlobObject) OpenRead(ctx context.Context) (io.ReadCloser, error) {
+	const fname = "s3BlobObject.OpenRead()"
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(blobObject.client.store.bucketName),
+		Key:    aws.String(blobObject.path),
+	}
+	result, err := blobObject.client.client.GetObject(ctx, input)
+	if err != nil {
+		var nsk *types.NoSuchKey
+		if errors.As(err, &nsk) {
+			err = errors.Wrapf(os.ErrNotExist, "%v", err)
+		}
+		return nil, errors.Wrap(err, fname)
+	}
+	return result.Body, nil
 }
 
-func (blobObject *s3BlobObject) Exists() (bool, error) {
+func (blobObject *s3BlobObject) ReadRange(ctx context.Context, offset int64, length int64) ([]byte, error) {
+	const fname = "s3BlobObject.ReadRange()"
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	if length <= 0 {
+		return []byte{}, nil
+	}
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(blobObject.client.store.bucketName),
+		Key:    aws.String(blobObject.path),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	}
+	result, err := blobObject.client.client.GetObject(ctx, input)
+	if err != nil {
+		var nsk *types.NoSuchKey
+		if errors.As(err, &nsk) {
+			err = errors.Wrapf(os.ErrNotExist, "%v", err)
+			return nil, errors.Wrap(err, fname)
+		}
+		var responseErr *awshttp.ResponseError
+		if errors.As(err, &responseErr) && responseErr.HTTPStatusCode() == s3InvalidRange {
+			return []byte{}, nil
+		}
+		return nil, errors.Wrap(err, fname)
+	}
+	data, err := ioutil.ReadAll(result.Body)
+	result.Body.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return data, nil
+}
+
+func isS3WriteConditionFailure(err error) bool {
+	var responseErr *awshttp.ResponseError
+	if errors.As(err, &responseErr) {
+		statusCode := responseErr.HTTPStatusCode()
+		return statusCode == s3WriteConditionFailed || statusCode == s3ConditionalRequestConflict
+	}
+	return false
+}
+
+func (blobObject *s3BlobObject) LockWriteVersion(ctx context.Context) (bool, error) {
+	const fname = "s3BlobObject.LockWriteVersion()"
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(blobObject.client.store.bucketName),
+		Key:    aws.String(blobObject.path),
+	}
+	output, err := blobObject.client.client.HeadObject(ctx, input)
+	if err != nil {
+		var notFoundErr *types.NotFound
+		if errors.As(err, &notFoundErr) {
+			blobObject.writeCondition = &s3WriteCondition{ifNoneMatch: aws.String("*")}
+			return false, nil
+		}
+		err = errors.Wrapf(err, "Cant lock %s", blobObject.path)
+		return false, errors.Wrap(err, fname)
+	}
+	blobObject.writeCondition = &s3WriteCondition{ifMatch: output.ETag}
+	return true, nil
+}
+
+func (blobObject *s3BlobObject) Exists(ctx context.Context) (bool, error) {
 	const fname = "s3BlobObject.Exists()"
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
 	input := &s3.HeadObjectInput{
 		Bucket: aws.String(blobObject.client.store.bucketName),
 		Key:    aws.String(blobObject.path),
 	}
-	_, err := blobObject.client.client.HeadObject(blobObject.client.ctx, input)
+	_, err := blobObject.client.client.HeadObject(ctx, input)
 	if err != nil {
 		var notFoundErr *types.NotFound
 		if errors.As(err, &notFoundErr) {
@@ -159,28 +345,126 @@ func (blobObject *s3BlobObject) Exists() (bool, error) {
 	return true, nil
 }
 
-func (blobObject *s3BlobObject) Write(data []byte) (bool, error) {
+func (blobObject *s3BlobObject) Size(ctx context.Context) (int64, error) {
+	const fname = "s3BlobObject.Size()"
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(blobObject.client.store.bucketName),
+		Key:    aws.String(blobObject.path),
+	}
+	output, err := blobObject.client.client.HeadObject(ctx, input)
+	if err != nil {
+		var notFoundErr *types.NotFound
+		if errors.As(err, &notFoundErr) {
+			err = errors.Wrapf(os.ErrNotExist, "%s does not exist", blobObject.String())
+		}
+		return 0, errors.Wrap(err, fname)
+	}
+	return aws.ToInt64(output.ContentLength), nil
+}
+
+func (blobObject *s3BlobObject) Write(ctx context.Context, data []byte) (bool, error) {
 	const fname = "s3BlobObject.Write()"
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	ok, err := blobObject.put(ctx, bytes.NewReader(data))
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	return ok, nil
+}
+
+func (blobObject *s3BlobObject) WriteFrom(ctx context.Context, reader io.Reader) (bool, error) {
+	const fname = "s3BlobObject.WriteFrom()"
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		ok, err := blobObject.put(ctx, seeker)
+		if err != nil {
+			return false, errors.Wrap(err, fname)
+		}
+		return ok, nil
+	}
+	// PutObject needs to know the content length up front, spool the data to disk
+	// rather than keeping all of it in memory
+	tmpFile, err := ioutil.TempFile("", "longtail-s3-upload-")
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+	_, err = io.Copy(tmpFile, reader)
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	_, err = tmpFile.Seek(0, io.SeekStart)
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	ok, err := blobObject.put(ctx, tmpFile)
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	return ok, nil
+}
+
+func (blobObject *s3BlobObject) put(ctx context.Context, body io.ReadSeeker) (bool, error) {
+	const fname = "s3BlobObject.put()"
 	input := &s3.PutObjectInput{
 		Bucket: aws.String(blobObject.client.store.bucketName),
 		Key:    aws.String(blobObject.path),
-		Body:   bytes.NewReader(data),
+		Body:   body,
+	}
+	if blobObject.writeCondition != nil {
+		input.IfMatch = blobObject.writeCondition.ifMatch
+		input.IfNoneMatch = blobObject.writeCondition.ifNoneMatch
 	}
-	_, err := blobObject.client.client.PutObject(blobObject.client.ctx, input)
+	_, err := blobObject.client.client.PutObject(ctx, input)
 	if err != nil {
-		return true, errors.Wrap(err, fname)
+		if blobObject.writeCondition != nil && isS3WriteConditionFailure(err) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, fname)
 	}
 	return true, nil
 }
 
-func (blobObject *s3BlobObject) Delete() error {
+func (blobObject *s3BlobObject) Delete(ctx context.Context) error {
 	const fname = "s3BlobObject.Delete()"
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
 	input := &s3.DeleteObjectInput{
 		Bucket: aws.String(blobObject.client.store.bucketName),
 		Key:    aws.String(blobObject.path),
 	}
-	_, err := blobObject.client.client.DeleteObject(blobObject.client.ctx, input)
+	if blobObject.writeCondition != nil {
+		if blobObject.writeCondition.ifMatch == nil {
+			// The object did not exist when it was locked so there is nothing of ours to delete,
+			// nothing is deleted either way but report an object created since then as a change
+			exists, err := blobObject.Exists(ctx)
+			if err != nil {
+				return errors.Wrap(err, fname)
+			}
+			if exists {
+				err = errors.Wrapf(ErrBlobVersionChanged, "%s was created after it was locked", blobObject.String())
+				return errors.Wrap(err, fname)
+			}
+			return nil
+		}
+		input.IfMatch = blobObject.writeCondition.ifMatch
+	}
+	_, err := blobObject.client.client.DeleteObject(ctx, input)
 	if err != nil {
+		var responseErr *awshttp.ResponseError
+		if errors.As(err, &responseErr) {
+			switch responseErr.HTTPStatusCode() {
+			case s3NotFound:
+				return nil
+			case s3WriteConditionFailed, s3ConditionalRequestConflict:
+				if blobObject.writeCondition != nil {
+					err = errors.Wrapf(ErrBlobVersionChanged, "%s: %v", blobObject.String(), err)
+				}
+			}
+		}
 		return errors.Wrap(err, fname)
 	}
 	return nil
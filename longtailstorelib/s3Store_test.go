@@ -3,9 +3,13 @@ package longtailstorelib
 import (
 	"context"
 	"net/url"
+	"os"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/DanEngelbrecht/golongtail/longtaillib"
+	"github.com/pkg/errors"
 )
 
 func TestS3BlobStore(t *testing.T) {
@@ -28,18 +32,18 @@ func TestS3BlobStore(t *testing.T) {
 	{
 		// Clean up any old test data
 		object, _ := client.NewObject("test.txt")
-		object.Delete()
+		object.Delete(context.Background())
 		object, _ = client.NewObject("path/first.txt")
-		object.Delete()
+		object.Delete(context.Background())
 		object, _ = client.NewObject("path/second.txt")
-		object.Delete()
+		object.Delete(context.Background())
 	}
 	object, err := client.NewObject("test.txt")
 	if err != nil {
 		t.Errorf("client.NewObject() err == %s", err)
 	}
 
-	exists, err := object.Exists()
+	exists, err := object.Exists(context.Background())
 	if err != nil {
 		t.Error("object.Exists() err != nil")
 	}
@@ -47,13 +51,13 @@ func TestS3BlobStore(t *testing.T) {
 		t.Error("object.Exists() true != false")
 	}
 
-	data, err := object.Read()
+	data, err := object.Read(context.Background())
 	if data != nil && err != nil {
 		t.Errorf("object.Read() nil != %v", err)
 	}
 
 	testData := []byte("apa")
-	ok, err := object.Write(testData)
+	ok, err := object.Write(context.Background(), testData)
 	if !ok {
 		t.Errorf("object.Write() ok != true")
 	}
@@ -61,7 +65,7 @@ func TestS3BlobStore(t *testing.T) {
 		t.Errorf("object.Write() err == %s", err)
 	}
 
-	exists, err = object.Exists()
+	exists, err = object.Exists(context.Background())
 	if err != nil {
 		t.Error("object.Exists() err != nil")
 	}
@@ -76,7 +80,7 @@ func TestS3BlobStore(t *testing.T) {
 	if blobs[0].Name != "test.txt" {
 		t.Errorf("blobs[0].Name %s != %s", blobs[0].Name, "test.txt")
 	}
-	data, err = object.Read()
+	data, err = object.Read(context.Background())
 	if len(data) != 3 {
 		t.Errorf("len(data) %d != %d", len(data), 3)
 	}
@@ -87,11 +91,11 @@ func TestS3BlobStore(t *testing.T) {
 	}
 
 	object, _ = client.NewObject("path/first.txt")
-	object.Delete()
-	_, _ = object.Write([]byte("dog"))
+	object.Delete(context.Background())
+	_, _ = object.Write(context.Background(), []byte("dog"))
 	object, _ = client.NewObject("path/second.txt")
-	object.Delete()
-	_, _ = object.Write([]byte("cat"))
+	object.Delete(context.Background())
+	_, _ = object.Write(context.Background(), []byte("cat"))
 
 	objects, _ := client.GetObjects("")
 	if len(objects) != 3 {
@@ -126,7 +130,7 @@ func TestListObjectsInEmptyS3Store(t *testing.T) {
 		t.Errorf("TestListObjectsInEmptyS3Store() client.GetObjects(\"\")) %d != %d", len(objects), 0)
 	}
 	obj, _ := client.NewObject("should-not-exist")
-	data, err := obj.Read()
+	data, err := obj.Read(context.Background())
 	if !longtaillib.IsNotExist(err) {
 		t.Errorf("TestListObjectsInEmptyS3Store() obj.Read()) %s", err)
 	}
@@ -134,3 +138,180 @@ func TestListObjectsInEmptyS3Store(t *testing.T) {
 		t.Errorf("TestListObjectsInEmptyS3Store() obj.Read()) %v != %v", nil, data)
 	}
 }
+
+func TestS3BlobStoreLockingOption(t *testing.T) {
+	for _, test := range []struct {
+		uri             string
+		supportsLocking bool
+	}{
+		{"s3://bucket/store", false},
+		{"s3://bucket/store?locking=true", true},
+		{"s3://bucket/store?locking=false", false},
+	} {
+		u, _ := url.Parse(test.uri)
+		blobStore, err := NewS3BlobStore(u)
+		if err != nil {
+			t.Fatalf("NewS3BlobStore(%s) err == %s", test.uri, err)
+		}
+		if blobStore.String() != "s3://bucket/store/" {
+			t.Errorf("blobStore.String() %s != %s", blobStore.String(), "s3://bucket/store/")
+		}
+		client, err := blobStore.NewClient(context.Background())
+		if err != nil {
+			t.Fatalf("blobStore.NewClient() err == %s", err)
+		}
+		if client.SupportsLocking() != test.supportsLocking {
+			t.Errorf("%s client.SupportsLocking() %t != %t", test.uri, client.SupportsLocking(), test.supportsLocking)
+		}
+		client.Close()
+	}
+	u, _ := url.Parse("s3://bucket/store?locking=maybe")
+	_, err := NewS3BlobStore(u)
+	if err == nil {
+		t.Error("NewS3BlobStore() err == nil for invalid locking option")
+	}
+}
+
+// To run against a local MinIO set
+// LONGTAIL_TEST_S3_URI=s3://<bucket>/<path>
+// LONGTAIL_TEST_S3_ENDPOINT=http://127.0.0.1:9000
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_REGION
+func createS3TestStore(t *testing.T) BlobStore {
+	storeURI := os.Getenv("LONGTAIL_TEST_S3_URI")
+	if storeURI == "" {
+		t.Skip()
+	}
+	u, err := url.Parse(storeURI)
+	if err != nil {
+		t.Fatalf("url.Parse() err == %s", err)
+	}
+	endpoint := os.Getenv("LONGTAIL_TEST_S3_ENDPOINT")
+	blobStore, err := NewS3BlobStore(u, func(options interface{}) {
+		s3Options, ok := options.(*S3Options)
+		if !ok {
+			return
+		}
+		s3Options.EnableLocking = true
+		if endpoint != "" {
+			s3Options.EndpointResolverURI = endpoint
+			s3Options.UsePathStyle = true
+		}
+	})
+	if err != nil {
+		t.Fatalf("NewS3BlobStore() err == %s", err)
+	}
+	return blobStore
+}
+
+func TestS3BlobStoreVersioning(t *testing.T) {
+	blobStore := createS3TestStore(t)
+	client, err := blobStore.NewClient(context.Background())
+	if err != nil {
+		t.Errorf("blobStore.NewClient() err == %s", err)
+	}
+	defer client.Close()
+	if !client.SupportsLocking() {
+		t.Error("client.SupportsLocking() false != true")
+	}
+	{
+		// Clean up any old test data
+		object, _ := client.NewObject("test.txt")
+		object.Delete(context.Background())
+	}
+	object, err := client.NewObject("test.txt")
+	if err != nil {
+		t.Errorf("client.NewObject() err == %s", err)
+	}
+	exists, err := object.LockWriteVersion(context.Background())
+	if err != nil {
+		t.Errorf("object.LockWriteVersion() err == %s", err)
+	}
+	if exists {
+		t.Errorf("object.LockWriteVersion() exists != false")
+	}
+	ok, err := object.Write(context.Background(), []byte("apa"))
+	if !ok {
+		t.Errorf("object.Write() ok != true")
+	}
+	if err != nil {
+		t.Errorf("object.Write() err == %s", err)
+	}
+	ok, err = object.Write(context.Background(), []byte("skapa"))
+	if ok {
+		t.Errorf("object.Write() ok != false")
+	}
+	if err != nil {
+		t.Errorf("object.Write() err == %s", err)
+	}
+	exists, err = object.LockWriteVersion(context.Background())
+	if err != nil {
+		t.Errorf("object.LockWriteVersion() err == %s", err)
+	}
+	if !exists {
+		t.Errorf("object.LockWriteVersion() exists == false")
+	}
+	ok, err = object.Write(context.Background(), []byte("skapa"))
+	if !ok {
+		t.Errorf("object.Write() ok == false")
+	}
+	if err != nil {
+		t.Errorf("object.Write() err == %s", err)
+	}
+	err = object.Delete(context.Background())
+	if !errors.Is(err, ErrBlobVersionChanged) {
+		t.Errorf("object.Delete() err == %v, expected ErrBlobVersionChanged", err)
+	}
+	_, err = object.LockWriteVersion(context.Background())
+	if err != nil {
+		t.Errorf("object.LockWriteVersion() err == %s", err)
+	}
+	err = object.Delete(context.Background())
+	if err != nil {
+		t.Errorf("object.Delete() err == %s", err)
+	}
+	exists, err = object.Exists(context.Background())
+	if err != nil {
+		t.Error("object.Exists() err != nil")
+	}
+	if exists {
+		t.Error("object.Exists() true != false")
+	}
+}
+
+func TestS3BlobStoreVersioningStressTest(t *testing.T) {
+	blobStore := createS3TestStore(t)
+	client, _ := blobStore.NewClient(context.Background())
+	{
+		// Clean up any old test data
+		object, _ := client.NewObject("test.txt")
+		object.Delete(context.Background())
+	}
+	client.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(5)
+		for n := 0; n < 5; n++ {
+			go func(number int, blobStore BlobStore) {
+				err := writeANumberWithRetry(number, blobStore)
+				if err != nil {
+					t.Errorf("writeANumberWithRetry() err == %s", err)
+				}
+				wg.Done()
+			}(i*5+n+1, blobStore)
+		}
+		wg.Wait()
+	}
+
+	client, _ = blobStore.NewClient(context.Background())
+	defer client.Close()
+	object, _ := client.NewObject("test.txt")
+	data, err := object.Read(context.Background())
+	if err != nil {
+		t.Errorf("object.Read() err == %s", err)
+	}
+	sliceData := strings.Split(string(data), "\n")
+	if len(sliceData) != 3*5 {
+		t.Errorf("len(sliceData) %d != %d", len(sliceData), 3*5)
+	}
+}
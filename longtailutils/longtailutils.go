@@ -3,9 +3,9 @@ package longtailutils
 import (
 	"context"
 	"fmt"
+	"io"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/DanEngelbrecht/golongtail/longtaillib"
 	"github.com/DanEngelbrecht/golongtail/longtailstorelib"
@@ -309,7 +309,9 @@ func splitURI(uri string) (string, string) {
 }
 
 // ReadFromURI ...
-func ReadFromURI(uri string, opts ...longtailstorelib.BlobStoreOption) ([]byte, error) {
+// With an index cache in opts or ctx, see longtailstorelib.WithIndexCache, the object is only
+// downloaded if it changed since it was cached. Encrypted stores don't use the cache
+func ReadFromURI(ctx context.Context, uri string, opts ...longtailstorelib.BlobStoreOption) ([]byte, error) {
 	const fname = "ReadFromURI"
 	log := logrus.WithFields(logrus.Fields{
 		"fname": fname,
@@ -317,29 +319,93 @@ func ReadFromURI(uri string, opts ...longtailstorelib.BlobStoreOption) ([]byte,
 	})
 	log.Debug(fname)
 	uriParent, uriName := splitURI(uri)
+	opts = append(longtailstorelib.GetBlobStoreOptions(ctx), opts...)
 	blobStore, err := longtailstorelib.CreateBlobStoreForURI(uriParent, opts...)
 	if err != nil {
 		return nil, errors.Wrap(err, fname)
 	}
-	client, err := blobStore.NewClient(context.Background())
+	client, err := blobStore.NewClient(ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, fname)
 	}
 	defer client.Close()
+	cache := longtailstorelib.GetIndexCache(opts...)
+	if _, scheme, ok := longtailstorelib.LookupBlobStoreScheme(uriParent); !ok || scheme.Name == "file" || scheme.Name == "fsblob" {
+		// Local files are as fast to read as the cache
+		cache = nil
+	}
+	if cache == nil {
+		object, err := client.NewObject(uriName)
+		if err != nil {
+			return nil, errors.Wrap(err, fname)
+		}
+		vbuffer, err := object.Read(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, fname)
+		}
+		log.Infof("read %d bytes", len(vbuffer))
+		return vbuffer, nil
+	}
 	object, err := client.NewObject(uriName)
 	if err != nil {
 		return nil, errors.Wrap(err, fname)
 	}
-	vbuffer, err := object.Read()
+	vbuffer, _, cached, err := cache.Read(ctx, longtailstorelib.IndexCacheKey(client, uriName), object)
 	if err != nil {
 		return nil, errors.Wrap(err, fname)
 	}
-	log.Infof("read %d bytes", len(vbuffer))
+	if cached {
+		log.Infof("read %d bytes from index cache", len(vbuffer))
+	} else {
+		log.Infof("read %d bytes", len(vbuffer))
+	}
 	return vbuffer, nil
 }
 
+type uriReadCloser struct {
+	io.ReadCloser
+	client longtailstorelib.BlobClient
+}
+
+func (r *uriReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	r.client.Close()
+	return err
+}
+
+// OpenReadFromURI opens a streaming reader for uri, the caller must Close() it
+func OpenReadFromURI(ctx context.Context, uri string, opts ...longtailstorelib.BlobStoreOption) (io.ReadCloser, error) {
+	const fname = "OpenReadFromURI"
+	log := logrus.WithFields(logrus.Fields{
+		"fname": fname,
+		"uri":   uri,
+	})
+	log.Debug(fname)
+	uriParent, uriName := splitURI(uri)
+	opts = append(longtailstorelib.GetBlobStoreOptions(ctx), opts...)
+	blobStore, err := longtailstorelib.CreateBlobStoreForURI(uriParent, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	client, err := blobStore.NewClient(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	object, err := client.NewObject(uriName)
+	if err != nil {
+		client.Close()
+		return nil, errors.Wrap(err, fname)
+	}
+	reader, err := object.OpenRead(ctx)
+	if err != nil {
+		client.Close()
+		return nil, errors.Wrap(err, fname)
+	}
+	return &uriReadCloser{ReadCloser: reader, client: client}, nil
+}
+
 // WriteToURI ...
-func WriteToURI(uri string, data []byte, opts ...longtailstorelib.BlobStoreOption) error {
+func WriteToURI(ctx context.Context, uri string, data []byte, opts ...longtailstorelib.BlobStoreOption) error {
 	const fname = "WriteToURI"
 	log := logrus.WithFields(logrus.Fields{
 		"fname": fname,
@@ -347,11 +413,12 @@ func WriteToURI(uri string, data []byte, opts ...longtailstorelib.BlobStoreOptio
 	})
 	log.Debug(fname)
 	uriParent, uriName := splitURI(uri)
+	opts = append(longtailstorelib.GetBlobStoreOptions(ctx), opts...)
 	blobStore, err := longtailstorelib.CreateBlobStoreForURI(uriParent, opts...)
 	if err != nil {
 		return errors.Wrap(err, fname)
 	}
-	client, err := blobStore.NewClient(context.Background())
+	client, err := blobStore.NewClient(ctx)
 	if err != nil {
 		return errors.Wrap(err, fname)
 	}
@@ -360,7 +427,7 @@ func WriteToURI(uri string, data []byte, opts ...longtailstorelib.BlobStoreOptio
 	if err != nil {
 		return errors.Wrap(err, fname)
 	}
-	_, err = object.Write(data)
+	_, err = object.Write(ctx, data)
 	if err != nil {
 		return errors.Wrap(err, fname)
 	}
@@ -369,7 +436,7 @@ func WriteToURI(uri string, data []byte, opts ...longtailstorelib.BlobStoreOptio
 }
 
 // DeleteByURI ...
-func DeleteByURI(uri string, opts ...longtailstorelib.BlobStoreOption) error {
+func DeleteByURI(ctx context.Context, uri string, opts ...longtailstorelib.BlobStoreOption) error {
 	const fname = "DeleteByURI"
 	log := logrus.WithFields(logrus.Fields{
 		"fname": fname,
@@ -377,11 +444,12 @@ func DeleteByURI(uri string, opts ...longtailstorelib.BlobStoreOption) error {
 	})
 	log.Debug(fname)
 	uriParent, uriName := splitURI(uri)
+	opts = append(longtailstorelib.GetBlobStoreOptions(ctx), opts...)
 	blobStore, err := longtailstorelib.CreateBlobStoreForURI(uriParent, opts...)
 	if err != nil {
 		return errors.Wrap(err, fname)
 	}
-	client, err := blobStore.NewClient(context.Background())
+	client, err := blobStore.NewClient(ctx)
 	if err != nil {
 		return errors.Wrap(err, fname)
 	}
@@ -390,7 +458,7 @@ func DeleteByURI(uri string, opts ...longtailstorelib.BlobStoreOption) error {
 	if err != nil {
 		return errors.Wrap(err, fname)
 	}
-	err = object.Delete()
+	err = object.Delete(ctx)
 	if err != nil && !longtaillib.IsNotExist(err) {
 		return errors.Wrap(err, fname)
 	}
@@ -398,9 +466,13 @@ func DeleteByURI(uri string, opts ...longtailstorelib.BlobStoreOption) error {
 	return nil
 }
 
+// ReadBlobWithRetry reads key using the retry policy of ctx, throttle is optional and
+// makes all readers sharing it back off together when the store is throttling requests
+// Returns the data and the number of retries made
 func ReadBlobWithRetry(
 	ctx context.Context,
 	client longtailstorelib.BlobClient,
+	throttle *longtailstorelib.BlobThrottle,
 	key string) ([]byte, int, error) {
 	const fname = "ReadBlobWithRetry"
 	log := logrus.WithFields(logrus.Fields{
@@ -410,36 +482,41 @@ func ReadBlobWithRetry(
 	})
 	log.Debug(fname)
 
-	retryCount := 0
 	objHandle, err := client.NewObject(key)
 	if err != nil {
-		return nil, retryCount, errors.Wrap(err, fname)
-	}
-	exists, err := objHandle.Exists()
-	if err != nil {
-		return nil, retryCount, errors.Wrap(err, fname)
+		return nil, 0, errors.Wrap(err, fname)
 	}
-	if !exists {
+	exists := false
+	retryCount, err := longtailstorelib.RetryBlobOperation(ctx, client, throttle, func() error {
+		var err error
+		exists, err = objHandle.Exists(ctx)
+		return err
+	})
+	if err == nil && !exists {
 		err = errors.Wrap(longtaillib.NotExistErr(), fmt.Sprintf("%s/%s does not exist", client.String(), key))
 		return nil, retryCount, errors.Wrap(err, fname)
 	}
-	retryDelay := []time.Duration{0, 100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond, 1 * time.Second, 2 * time.Second}
-	blobData, err := objHandle.Read()
-	for err != nil {
+	var blobData []byte
+	if err == nil {
+		var readRetryCount int
+		readRetryCount, err = longtailstorelib.RetryBlobOperation(ctx, client, throttle, func() error {
+			var err error
+			blobData, err = objHandle.Read(ctx)
+			return err
+		})
+		retryCount += readRetryCount
+	}
+	if err != nil {
 		if longtaillib.IsNotExist(err) {
 			return nil, retryCount, errors.Wrap(err, fname)
 		}
-		if retryCount == len(retryDelay) {
-			err = errors.Wrapf(err, "Failed getBlob %s in store %s", key, client.String())
-			log.Error(err)
+		if ctx.Err() != nil {
+			err = errors.Wrap(longtaillib.CancelledErr(), err.Error())
 			return nil, retryCount, errors.Wrap(err, fname)
 		}
-		err = errors.Wrapf(err, "Retrying getBlob %s in store %s with %s delay", key, client.String(), retryDelay[retryCount])
-		log.Info(err)
-
-		time.Sleep(retryDelay[retryCount])
-		retryCount++
-		blobData, err = objHandle.Read()
+		err = errors.Wrapf(err, "Failed getBlob %s in store %s after %d retries", key, client.String(), retryCount)
+		log.Error(err)
+		return nil, retryCount, errors.Wrap(err, fname)
 	}
 	log.Infof("read %d bytes", len(blobData))
 	return blobData, retryCount, nil
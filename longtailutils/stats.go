@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/DanEngelbrecht/golongtail/longtaillib"
+	"github.com/DanEngelbrecht/golongtail/longtailstorelib"
 	"github.com/sirupsen/logrus"
 )
 
@@ -154,3 +155,41 @@ func PrintStats(name string, stats longtaillib.BlockStoreStats, showStats bool)
 		"GetStats_Count":                ByteCountDecimal(stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_GetStats_Count]),
 	}).Printf("store stats")
 }
+
+func PrintMirrorStats(name string, stats []longtailstorelib.MirrorTargetStats, showStats bool) {
+	for i, s := range stats {
+		health := "healthy"
+		if !s.Healthy {
+			health = "unhealthy"
+		}
+		if showStats {
+			fmt.Printf("%s target %d: %s\n", name, i, s.URI)
+			fmt.Printf("------------------\n")
+			fmt.Printf("Health:                        %s\n", health)
+			if s.LastError != "" {
+				fmt.Printf("LastError:                     %s\n", s.LastError)
+			}
+			fmt.Printf("Read_Count:                    %s\n", ByteCountDecimal(s.Reads))
+			fmt.Printf("Read_Byte_Count:               %s\n", ByteCountBinary(s.ReadBytes))
+			fmt.Printf("Write_Count:                   %s\n", ByteCountDecimal(s.Writes))
+			fmt.Printf("Write_Byte_Count:              %s\n", ByteCountBinary(s.WriteBytes))
+			fmt.Printf("NotFound_Count:                %s\n", ByteCountDecimal(s.NotFound))
+			fmt.Printf("Error_Count:                   %s\n", ByteCountDecimal(s.Errors))
+			fmt.Printf("HedgedRead_Count:              %s\n", ByteCountDecimal(s.HedgedReads))
+			fmt.Printf("------------------\n")
+		}
+		logrus.WithFields(logrus.Fields{
+			"Store":            name,
+			"Target":           s.URI,
+			"Health":           health,
+			"LastError":        s.LastError,
+			"Read_Count":       ByteCountDecimal(s.Reads),
+			"Read_Byte_Count":  ByteCountBinary(s.ReadBytes),
+			"Write_Count":      ByteCountDecimal(s.Writes),
+			"Write_Byte_Count": ByteCountBinary(s.WriteBytes),
+			"NotFound_Count":   ByteCountDecimal(s.NotFound),
+			"Error_Count":      ByteCountDecimal(s.Errors),
+			"HedgedRead_Count": ByteCountDecimal(s.HedgedReads),
+		}).Printf("mirror store stats")
+	}
+}
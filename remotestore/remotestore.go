@@ -3,11 +3,14 @@ package remotestore
 import (
 	"context"
 	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -46,6 +49,7 @@ type deleteBlockMessage struct {
 	blockHash      uint64
 	completeSignal *sync.WaitGroup
 	successCounter *uint32
+	failureCounter *uint32
 }
 
 type prefetchBlockMessage struct {
@@ -85,6 +89,7 @@ type remoteStore struct {
 	defaultClient    longtailstorelib.BlobClient
 
 	workerCount int
+	accessType  AccessType
 
 	putBlockChan           chan putBlockMessage
 	getBlockChan           chan getBlockMessage
@@ -102,9 +107,27 @@ type remoteStore struct {
 	prefetchMemory         int64
 	maxPrefetchMemory      int64
 
+	// Shared by all workers so they back off together when the store is throttling requests
+	throttle *longtailstorelib.BlobThrottle
+
 	fetchedBlocksSync sync.Mutex
 	prefetchBlocks    map[uint64]*pendingPrefetchedBlock
 
+	// Fetched blocks are verified against the chunk layout of the store index
+	blockVerifierSync sync.RWMutex
+	blockVerifier     *BlockVerifier
+	// Set by WithBlockContentVerification, also hash the chunks of each fetched block
+	verifyBlockContent bool
+
+	quarantineSync    sync.Mutex
+	quarantinedBlocks map[uint64]bool
+
+	// Prune candidates reused by this store are rescued so the next prune sweep keeps them
+	pruneCandidatesSync    sync.Mutex
+	pruneCandidates        map[uint64]bool
+	pruneCandidatesVersion string
+	rescuedBlocks          map[uint64]bool
+
 	stats longtaillib.BlockStoreStats
 }
 
@@ -129,6 +152,10 @@ func putStoredBlock(
 
 	atomic.AddUint64(&s.stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_PutStoredBlock_Count], 1)
 
+	if ctx.Err() != nil {
+		return errors.Wrap(cancelledError(ctx), fname)
+	}
+
 	blockIndex := storedBlock.GetBlockIndex()
 	blockHash := blockIndex.GetBlockHash()
 	key := getBlockPath("chunks", blockHash)
@@ -142,44 +169,49 @@ func putStoredBlock(
 	if err != nil {
 		return errors.Wrap(err, fname)
 	}
-	if exists, err := objHandle.Exists(); err == nil && !exists {
+	exists := false
+	retryCount, err := longtailstorelib.RetryBlobOperation(ctx, blobClient, s.throttle, func() error {
+		var err error
+		exists, err = objHandle.Exists(ctx)
+		return err
+	})
+	atomic.AddUint64(&s.stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_PutStoredBlock_RetryCount], uint64(retryCount))
+	if err != nil {
+		atomic.AddUint64(&s.stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_PutStoredBlock_FailCount], 1)
+		if ctx.Err() != nil {
+			return errors.Wrap(cancelledError(ctx), fname)
+		}
+		err := errors.Wrap(err, fmt.Sprintf("failed to check for stored block at `%s` in `%s`", key, s))
+		err = errors.Wrap(err, fname)
+		log.Error(err)
+		return err
+	}
+	if !exists {
 		blob, err := longtaillib.WriteStoredBlockToBuffer(storedBlock)
 		if err != nil {
 			return errors.Wrap(err, fname)
 		}
 		defer blob.Dispose()
 
-		var sleepTimes = [...]time.Duration{100 * time.Millisecond, 500 * time.Millisecond, 2 * time.Second}
-		ok, err := objHandle.Write(blob.ToBuffer())
+		retryCount, err := longtailstorelib.RetryBlobOperation(ctx, blobClient, s.throttle, func() error {
+			ok, err := objHandle.Write(ctx, blob.ToBuffer())
+			if err == nil && !ok {
+				// No write condition is set so the store rejected the write due to rate limiting
+				return longtailstorelib.ErrBlobThrottled
+			}
+			return err
+		})
+		atomic.AddUint64(&s.stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_PutStoredBlock_RetryCount], uint64(retryCount))
 		if err != nil {
-			err := errors.Wrap(err, fmt.Sprintf("failed to put stored block at `%s` in `%s`", key, s))
+			atomic.AddUint64(&s.stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_PutStoredBlock_FailCount], 1)
+			if ctx.Err() != nil {
+				return errors.Wrap(cancelledError(ctx), fname)
+			}
+			err := errors.Wrap(err, fmt.Sprintf("failed to put stored block at `%s` in `%s` after %d retries", key, s, retryCount))
 			err = errors.Wrap(err, fname)
 			log.Error(err)
 			return err
 		}
-		if !ok {
-			for _, sleepTime := range sleepTimes {
-				atomic.AddUint64(&s.stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_PutStoredBlock_RetryCount], 1)
-				log.Warningf("retrying putBlob (sleeping %s)", sleepTime)
-				time.Sleep(sleepTime)
-				ok, err = objHandle.Write(blob.ToBuffer())
-				if err != nil {
-					err = errors.Wrap(err, fmt.Sprintf("failed to put stored block at `%s` in `%s`", key, s))
-					err = errors.Wrap(err, fname)
-					log.Error(err)
-					return err
-				}
-				if ok {
-					break
-				}
-			}
-			if !ok {
-				err = fmt.Errorf("failed to put stored block at `%s` in `%s` even after retries", key, s)
-				err = errors.Wrap(err, fname)
-				log.Error(err)
-				return err
-			}
-		}
 
 		atomic.AddUint64(&s.stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_PutStoredBlock_Byte_Count], (uint64)(blob.Size()))
 		atomic.AddUint64(&s.stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_PutStoredBlock_Chunk_Count], (uint64)(blockIndex.GetChunkCount()))
@@ -191,6 +223,12 @@ func putStoredBlock(
 		}).Info("wrote block")
 	}
 
+	err = s.rescuePruneCandidates(ctx, blobClient, []uint64{blockHash})
+	if err != nil {
+		atomic.AddUint64(&s.stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_PutStoredBlock_FailCount], 1)
+		return errors.Wrap(err, fname)
+	}
+
 	blockIndexCopy, err := blockIndex.Copy()
 	if err != nil {
 		return errors.Wrap(err, fname)
@@ -199,212 +237,944 @@ func putStoredBlock(
 	return nil
 }
 
-func getStoredBlock(
-	ctx context.Context,
-	s *remoteStore,
-	blobClient longtailstorelib.BlobClient,
-	blockHash uint64) (longtaillib.Longtail_StoredBlock, error) {
-	const fname = "getStoredBlock"
-	log := logrus.WithFields(logrus.Fields{
-		"fname":      fname,
-		"s":          s,
-		"blobClient": blobClient.String(),
-		"blockHash":  blockHash,
-	})
-	log.Debug(fname)
+// QuarantinePath is the folder of a store where clients record blocks that failed verification
+const QuarantinePath = "quarantine"
 
-	atomic.AddUint64(&s.stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_GetStoredBlock_Count], 1)
+// QuarantineRecord is written to QuarantinePath when a block fetched from the store is damaged
+type QuarantineRecord struct {
+	BlockHash string    `json:"blockHash"`
+	Path      string    `json:"path"`
+	Reason    string    `json:"reason"`
+	Recovered bool      `json:"recovered"`
+	Time      time.Time `json:"time"`
+}
 
-	key := getBlockPath("chunks", blockHash)
+// GetQuarantineRecordPath returns the path of the quarantine record for blockHash
+func GetQuarantineRecordPath(blockHash uint64) string {
+	return fmt.Sprintf("%s/0x%016x.json", QuarantinePath, blockHash)
+}
 
-	storedBlockData, retryCount, err := longtailutils.ReadBlobWithRetry(ctx, blobClient, key)
-	atomic.AddUint64(&s.stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_GetStoredBlock_RetryCount], uint64(retryCount))
+// PruneCandidatesPath is where a prune with a grace period records the blocks it marked for deletion
+const PruneCandidatesPath = "prune/candidates.json"
 
-	if err != nil || storedBlockData == nil {
-		atomic.AddUint64(&s.stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_GetStoredBlock_FailCount], 1)
-		return longtaillib.Longtail_StoredBlock{}, errors.Wrap(err, fname)
+// PruneRescuedPath is the folder of a store where clients record the prune candidates they reuse
+const PruneRescuedPath = "prune/rescued"
+
+// PruneCandidates is written to PruneCandidatesPath, blocks are keyed by their hash formatted as 0x%016x
+type PruneCandidates struct {
+	// The time each block was first marked, it is removed from the store index once the grace period has passed unless it is rescued
+	Blocks map[string]time.Time `json:"blocks"`
+	// The time each block was removed from the store index, it is deleted once the grace period has passed unless it is rescued
+	Unindexed map[string]time.Time `json:"unindexed,omitempty"`
+}
+
+// PruneRescueRecord is written to PruneRescuedPath when an upload reuses a prune candidate
+type PruneRescueRecord struct {
+	BlockHash string    `json:"blockHash"`
+	Time      time.Time `json:"time"`
+}
+
+// GetPruneRescueRecordPath returns the path of the rescue record for blockHash
+func GetPruneRescueRecordPath(blockHash uint64) string {
+	return fmt.Sprintf("%s/0x%016x.json", PruneRescuedPath, blockHash)
+}
+
+// readPruneCandidates returns the marked blocks and the time they were marked and the blocks that were removed
+// from the store index and the time they were removed, nil if nothing is marked
+func readPruneCandidates(
+	ctx context.Context,
+	client longtailstorelib.BlobClient) (map[uint64]time.Time, map[uint64]time.Time, error) {
+	const fname = "readPruneCandidates"
+	data, _, err := longtailutils.ReadBlobWithRetry(ctx, client, nil, PruneCandidatesPath)
+	if longtaillib.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, fname)
+	}
+	blocks, unindexed, err := parsePruneCandidates(client, data)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, fname)
 	}
+	return blocks, unindexed, nil
+}
 
-	storedBlock, err := longtaillib.ReadStoredBlockFromBuffer(storedBlockData)
-	storedBlockData = nil
+func parsePruneCandidates(
+	client longtailstorelib.BlobClient,
+	data []byte) (map[uint64]time.Time, map[uint64]time.Time, error) {
+	const fname = "parsePruneCandidates"
+	candidates := PruneCandidates{}
+	err := json.Unmarshal(data, &candidates)
 	if err != nil {
-		atomic.AddUint64(&s.stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_GetStoredBlock_FailCount], 1)
-		err = errors.Wrap(err, fmt.Sprintf("Failed to parse stored block `%s`", key))
-		return longtaillib.Longtail_StoredBlock{}, errors.Wrap(err, fname)
+		err = errors.Wrapf(err, "Cant parse prune candidates from `%s/%s`", client.String(), PruneCandidatesPath)
+		return nil, nil, errors.Wrap(err, fname)
+	}
+	blocks, err := parsePruneCandidateBlocks(candidates.Blocks)
+	if err != nil {
+		err = errors.Wrapf(err, "Invalid prune candidates in `%s/%s`", client.String(), PruneCandidatesPath)
+		return nil, nil, errors.Wrap(err, fname)
 	}
+	unindexed, err := parsePruneCandidateBlocks(candidates.Unindexed)
+	if err != nil {
+		err = errors.Wrapf(err, "Invalid prune candidates in `%s/%s`", client.String(), PruneCandidatesPath)
+		return nil, nil, errors.Wrap(err, fname)
+	}
+	return blocks, unindexed, nil
+}
 
-	atomic.AddUint64(&s.stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_GetStoredBlock_Byte_Count], (uint64)(len(storedBlockData)))
-	blockIndex := storedBlock.GetBlockIndex()
-	if blockIndex.GetBlockHash() != blockHash {
-		atomic.AddUint64(&s.stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_GetStoredBlock_FailCount], 1)
-		err = errors.Wrap(longtaillib.BadFormatErr(), "Block hash does not match path")
-		return longtaillib.Longtail_StoredBlock{}, errors.Wrap(err, fname)
+func parsePruneCandidateBlocks(blocks map[string]time.Time) (map[uint64]time.Time, error) {
+	result := make(map[uint64]time.Time, len(blocks))
+	for blockHashString, blockTime := range blocks {
+		blockHash, err := strconv.ParseUint(blockHashString, 0, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid block hash `%s`", blockHashString)
+		}
+		result[blockHash] = blockTime
 	}
-	atomic.AddUint64(&s.stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_GetStoredBlock_Chunk_Count], (uint64)(blockIndex.GetChunkCount()))
-	log.WithFields(logrus.Fields{
-		"chunks": blockIndex.GetChunkCount(),
-		"bytes":  len(storedBlockData),
-		"path":   fmt.Sprintf("%s/%s", blobClient.String(), key)}).Info("read block")
-	return storedBlock, nil
+	return result, nil
 }
 
-func fetchBlock(
+func formatPruneCandidateBlocks(blocks map[uint64]time.Time) map[string]time.Time {
+	result := make(map[string]time.Time, len(blocks))
+	for blockHash, blockTime := range blocks {
+		result[fmt.Sprintf("0x%016x", blockHash)] = blockTime
+	}
+	return result
+}
+
+// writePruneCandidates replaces the prune candidates of the store, the candidates are removed if both blocks and unindexed are empty
+func writePruneCandidates(
 	ctx context.Context,
-	s *remoteStore,
 	client longtailstorelib.BlobClient,
-	getMsg getBlockMessage) {
-	const fname = "fetchBlock"
-	log := logrus.WithFields(logrus.Fields{
-		"fname":  fname,
-		"s":      s,
-		"client": client.String(),
-		"getMsg": getMsg,
-	})
-	log.Debug(fname)
-	s.fetchedBlocksSync.Lock()
-	prefetchedBlock := s.prefetchBlocks[getMsg.blockHash]
-	if prefetchedBlock != nil {
-		storedBlock := prefetchedBlock.storedBlock
-		err := prefetchedBlock.err
-		if storedBlock.IsValid() {
-			blockSize := -int64(storedBlock.GetBlockSize())
-			atomic.AddInt64(&s.prefetchMemory, blockSize)
-		}
-		if storedBlock.IsValid() || err != nil {
-			s.prefetchBlocks[getMsg.blockHash] = nil
-			s.fetchedBlocksSync.Unlock()
-			getMsg.asyncCompleteAPI.OnComplete(storedBlock, err)
-			return
+	blocks map[uint64]time.Time,
+	unindexed map[uint64]time.Time) error {
+	const fname = "writePruneCandidates"
+	objHandle, err := client.NewObject(PruneCandidatesPath)
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	if len(blocks) == 0 && len(unindexed) == 0 {
+		_, err = longtailstorelib.RetryBlobOperation(ctx, client, nil, func() error {
+			return objHandle.Delete(ctx)
+		})
+		if longtaillib.IsNotExist(err) {
+			return nil
 		}
-		prefetchedBlock.completeCallbacks = append(prefetchedBlock.completeCallbacks, getMsg.asyncCompleteAPI)
-		s.fetchedBlocksSync.Unlock()
-		return
+		return errors.Wrap(err, fname)
 	}
-	prefetchedBlock = &pendingPrefetchedBlock{storedBlock: longtaillib.Longtail_StoredBlock{}}
-	s.prefetchBlocks[getMsg.blockHash] = prefetchedBlock
-	s.fetchedBlocksSync.Unlock()
-	storedBlock, getStoredBlockErr := getStoredBlock(ctx, s, client, getMsg.blockHash)
-	s.fetchedBlocksSync.Lock()
-	prefetchedBlock, exists := s.prefetchBlocks[getMsg.blockHash]
-	if exists && prefetchedBlock == nil {
-		fmt.Printf("Prefetch in background while fetching block, dropping duplicate\n")
-		storedBlock.Dispose()
-		s.fetchedBlocksSync.Unlock()
-		return
+	candidates := PruneCandidates{Blocks: formatPruneCandidateBlocks(blocks)}
+	if len(unindexed) > 0 {
+		candidates.Unindexed = formatPruneCandidateBlocks(unindexed)
 	}
-	completeCallbacks := prefetchedBlock.completeCallbacks
-	s.prefetchBlocks[getMsg.blockHash] = nil
-	s.fetchedBlocksSync.Unlock()
-	for _, c := range completeCallbacks {
-		if getStoredBlockErr != nil {
-			c.OnComplete(longtaillib.Longtail_StoredBlock{}, errors.Wrap(getStoredBlockErr, fname))
-			continue
-		}
-		buf, err := longtaillib.WriteStoredBlockToBuffer(storedBlock)
-		if err != nil {
-			c.OnComplete(longtaillib.Longtail_StoredBlock{}, errors.Wrap(err, fname))
-			continue
-		}
-		blockCopy, err := longtaillib.ReadStoredBlockFromBuffer(buf.ToBuffer())
-		fmt.Printf("Making block copy due to multiple waiters\n")
-		buf.Dispose()
+	data, err := json.Marshal(candidates)
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	_, err = longtailstorelib.RetryBlobOperation(ctx, client, nil, func() error {
+		_, err := objHandle.Write(ctx, data)
+		return err
+	})
+	return errors.Wrap(err, fname)
+}
+
+// listPruneRescueRecords returns the path of the rescue record of each rescued block
+func listPruneRescueRecords(
+	ctx context.Context,
+	client longtailstorelib.BlobClient) (map[uint64]string, error) {
+	const fname = "listPruneRescueRecords"
+	var blobs []longtailstorelib.BlobProperties
+	_, err := longtailstorelib.RetryBlobOperation(ctx, client, nil, func() error {
+		var err error
+		blobs, err = client.GetObjects(PruneRescuedPath + "/")
+		return err
+	})
+	if err != nil && !longtaillib.IsNotExist(err) {
+		return nil, errors.Wrap(err, fname)
+	}
+	records := map[uint64]string{}
+	for _, blob := range blobs {
+		name := strings.TrimSuffix(blob.Name[strings.LastIndex(blob.Name, "/")+1:], ".json")
+		blockHash, err := strconv.ParseUint(name, 0, 64)
 		if err != nil {
-			c.OnComplete(longtaillib.Longtail_StoredBlock{}, errors.Wrap(err, fname))
 			continue
 		}
-		c.OnComplete(blockCopy, nil)
+		records[blockHash] = blob.Name
 	}
-	getMsg.asyncCompleteAPI.OnComplete(storedBlock, errors.Wrap(getStoredBlockErr, fname))
+	return records, nil
 }
 
-func deleteBlock(
+// pruneRescueRecordExists returns true if the rescue record at recordPath exists
+func pruneRescueRecordExists(
 	ctx context.Context,
-	s *remoteStore,
 	client longtailstorelib.BlobClient,
-	blockHash uint64) error {
-	const fname = "deleteBlock"
-	log := logrus.WithFields(logrus.Fields{
-		"fname":     fname,
-		"s":         s,
-		"client":    client.String(),
-		"blockHash": blockHash,
+	recordPath string) (bool, error) {
+	const fname = "pruneRescueRecordExists"
+	objHandle, err := client.NewObject(recordPath)
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	exists := false
+	_, err = longtailstorelib.RetryBlobOperation(ctx, client, nil, func() error {
+		var err error
+		exists, err = objHandle.Exists(ctx)
+		return err
 	})
-	log.Debug(fname)
-	s.fetchedBlocksSync.Lock()
-	defer s.fetchedBlocksSync.Unlock()
-	key := getBlockPath("chunks", blockHash)
-	objHandle, err := client.NewObject(key)
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	return exists, nil
+}
+
+func (s *remoteStore) setPruneCandidates(candidates map[uint64]time.Time, unindexed map[uint64]time.Time, version string) {
+	pruneCandidates := make(map[uint64]bool, len(candidates)+len(unindexed))
+	for blockHash := range candidates {
+		pruneCandidates[blockHash] = true
+	}
+	for blockHash := range unindexed {
+		pruneCandidates[blockHash] = true
+	}
+	s.pruneCandidatesSync.Lock()
+	s.pruneCandidates = pruneCandidates
+	s.pruneCandidatesVersion = version
+	s.pruneCandidatesSync.Unlock()
+}
+
+// refreshPruneCandidates reads the prune candidates again if they changed since they were last read so blocks
+// marked by a prune that ran after the store index was loaded are rescued too. The candidates are revalidated
+// with a conditional read, see longtailstorelib.VersionedBlobObject, so an unchanged candidate list is not downloaded
+func (s *remoteStore) refreshPruneCandidates(
+	ctx context.Context,
+	blobClient longtailstorelib.BlobClient) error {
+	const fname = "refreshPruneCandidates"
+	objHandle, err := blobClient.NewObject(PruneCandidatesPath)
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	s.pruneCandidatesSync.Lock()
+	version := s.pruneCandidatesVersion
+	s.pruneCandidatesSync.Unlock()
+
+	var data []byte
+	var currentVersion string
+	changed := false
+	_, err = longtailstorelib.RetryBlobOperation(ctx, blobClient, s.throttle, func() error {
+		var err error
+		data, currentVersion, changed, err = longtailstorelib.ReadObjectIfChanged(ctx, objHandle, version)
+		return err
+	})
+	if longtaillib.IsNotExist(err) {
+		s.setPruneCandidates(nil, nil, "")
+		return nil
+	}
 	if err != nil {
 		return errors.Wrap(err, fname)
 	}
-	err = objHandle.Delete()
+	if !changed {
+		return nil
+	}
+	candidates, unindexed, err := parsePruneCandidates(blobClient, data)
 	if err != nil {
 		return errors.Wrap(err, fname)
 	}
+	s.setPruneCandidates(candidates, unindexed, currentVersion)
 	return nil
 }
 
-func prefetchBlock(
+// rescuePruneCandidates writes a rescue record for each of blockHashes that is a prune candidate so the
+// next sweep keeps it, a block is only rescued once per block store instance
+// The prune candidates are revalidated first so a block marked after the store index was loaded is rescued
+// Read only stores never rescue, they don't add to the store and may not have write access to it
+func (s *remoteStore) rescuePruneCandidates(
 	ctx context.Context,
-	s *remoteStore,
-	client longtailstorelib.BlobClient,
-	prefetchMsg prefetchBlockMessage) {
-	const fname = "prefetchBlock"
-	log := logrus.WithFields(logrus.Fields{
-		"fname":       fname,
-		"s":           s,
-		"client":      client.String(),
-		"prefetchMsg": prefetchMsg,
-	})
-	log.Debug(fname)
-	s.fetchedBlocksSync.Lock()
-	_, exists := s.prefetchBlocks[prefetchMsg.blockHash]
-	if exists {
-		// Already pre-fetched
-		s.fetchedBlocksSync.Unlock()
-		return
+	blobClient longtailstorelib.BlobClient,
+	blockHashes []uint64) error {
+	const fname = "rescuePruneCandidates"
+	if s.accessType == ReadOnly || len(blockHashes) == 0 {
+		return nil
 	}
-	prefetchedBlock := &pendingPrefetchedBlock{storedBlock: longtaillib.Longtail_StoredBlock{}}
-	s.prefetchBlocks[prefetchMsg.blockHash] = prefetchedBlock
-	s.fetchedBlocksSync.Unlock()
-
-	storedBlock, getErr := getStoredBlock(ctx, s, client, prefetchMsg.blockHash)
-
-	s.fetchedBlocksSync.Lock()
-
-	prefetchedBlock, exists = s.prefetchBlocks[prefetchMsg.blockHash]
-	if prefetchedBlock == nil {
-		fmt.Printf("Dropping prefetched block due to background prefetch\n")
-		storedBlock.Dispose()
-		s.fetchedBlocksSync.Unlock()
-		return
+	err := s.refreshPruneCandidates(ctx, blobClient)
+	if err != nil {
+		return errors.Wrap(err, fname)
 	}
-
-	completeCallbacks := prefetchedBlock.completeCallbacks
-	if len(completeCallbacks) == 0 {
-		// Nobody is actively waiting for the block
-		if storedBlock.IsValid() {
-			blockSize := int64(storedBlock.GetBlockSize())
-			prefetchedBlock.storedBlock = storedBlock
-			atomic.AddInt64(&s.prefetchMemory, blockSize)
+	s.pruneCandidatesSync.Lock()
+	rescueBlockHashes := []uint64{}
+	for _, blockHash := range blockHashes {
+		if s.pruneCandidates[blockHash] && !s.rescuedBlocks[blockHash] {
+			rescueBlockHashes = append(rescueBlockHashes, blockHash)
 		}
-		prefetchedBlock.err = getErr
-		s.fetchedBlocksSync.Unlock()
-		return
 	}
-	s.prefetchBlocks[prefetchMsg.blockHash] = nil
-	s.fetchedBlocksSync.Unlock()
+	s.pruneCandidatesSync.Unlock()
 
-	for i := 1; i < len(completeCallbacks)-1; i++ {
-		c := completeCallbacks[i]
-		if getErr != nil {
-			c.OnComplete(longtaillib.Longtail_StoredBlock{}, errors.Wrap(getErr, fname))
-			continue
-		}
-		buf, err := longtaillib.WriteStoredBlockToBuffer(storedBlock)
+	for _, blockHash := range rescueBlockHashes {
+		record, err := json.Marshal(PruneRescueRecord{
+			BlockHash: fmt.Sprintf("0x%016x", blockHash),
+			Time:      time.Now().UTC(),
+		})
 		if err != nil {
-			c.OnComplete(longtaillib.Longtail_StoredBlock{}, errors.Wrap(err, fname))
-			continue
+			return errors.Wrap(err, fname)
+		}
+		_, err = longtailstorelib.RetryBlobOperation(ctx, blobClient, s.throttle, func() error {
+			objHandle, err := blobClient.NewObject(GetPruneRescueRecordPath(blockHash))
+			if err != nil {
+				return err
+			}
+			_, err = objHandle.Write(ctx, record)
+			return err
+		})
+		if err != nil {
+			err = errors.Wrapf(err, "Failed rescuing prune candidate 0x%016x in `%s`", blockHash, s)
+			return errors.Wrap(err, fname)
+		}
+		logrus.WithFields(logrus.Fields{"fname": fname, "blockHash": blockHash}).Info("rescued prune candidate")
+		s.pruneCandidatesSync.Lock()
+		s.rescuedBlocks[blockHash] = true
+		s.pruneCandidatesSync.Unlock()
+	}
+	return nil
+}
+
+// Number of times a block that fails verification is read again before trying other copies
+const blockVerifyAttempts = 3
+
+type blockLayout struct {
+	chunkOffset uint32
+	chunkCount  uint32
+	tag         uint32
+}
+
+// BlockVerifier checks stored blocks against a copy of the chunk layout of each block in a store index
+type BlockVerifier struct {
+	blocks      map[uint64]blockLayout
+	chunkHashes []uint64
+	chunkSizes  []uint32
+}
+
+// NewBlockVerifier creates a verifier for the blocks in storeIndex, storeIndex is not used after the call
+func NewBlockVerifier(storeIndex longtaillib.Longtail_StoreIndex) *BlockVerifier {
+	blockHashes := storeIndex.GetBlockHashes()
+	chunkOffsets := storeIndex.GetBlockChunksOffsets()
+	chunkCounts := storeIndex.GetBlockChunkCounts()
+	tags := storeIndex.GetBlockTags()
+	verifier := &BlockVerifier{
+		blocks:      make(map[uint64]blockLayout, len(blockHashes)),
+		chunkHashes: append([]uint64{}, storeIndex.GetChunkHashes()...),
+		chunkSizes:  append([]uint32{}, storeIndex.GetChunkSizes()...),
+	}
+	for i, blockHash := range blockHashes {
+		verifier.blocks[blockHash] = blockLayout{chunkOffset: chunkOffsets[i], chunkCount: chunkCounts[i], tag: tags[i]}
+	}
+	return verifier
+}
+
+func (s *remoteStore) setBlockVerifier(storeIndex longtaillib.Longtail_StoreIndex) {
+	verifier := NewBlockVerifier(storeIndex)
+	s.blockVerifierSync.Lock()
+	s.blockVerifier = verifier
+	s.blockVerifierSync.Unlock()
+}
+
+func (s *remoteStore) getBlockVerifier() *BlockVerifier {
+	s.blockVerifierSync.RLock()
+	defer s.blockVerifierSync.RUnlock()
+	return s.blockVerifier
+}
+
+// VerifyStoredBlockData checks that storedBlockData is a readable stored block for blockHash that matches the
+// store index and that its chunk data decompresses and hashes to the chunk hashes of the block
+func (verifier *BlockVerifier) VerifyStoredBlockData(blockHash uint64, storedBlockData []byte) error {
+	const fname = "BlockVerifier.VerifyStoredBlockData"
+	storedBlock, err := readVerifiedStoredBlock(verifier, blockHash, storedBlockData, true)
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	storedBlock.Dispose()
+	return nil
+}
+
+type BlockVerifyOptions struct {
+	VerifyContent bool
+}
+
+// WithBlockContentVerification makes block stores decompress each fetched block and check the hash of every
+// chunk, by default only the block index and the size of the chunk data are checked against the store index
+func WithBlockContentVerification() longtailstorelib.BlobStoreOption {
+	return func(options interface{}) {
+		blockVerifyOptions, ok := options.(*BlockVerifyOptions)
+		if !ok {
+			return
+		}
+		blockVerifyOptions.VerifyContent = true
+	}
+}
+
+// GetBlockVerifyOptions collects the block verification options from opts
+func GetBlockVerifyOptions(opts ...longtailstorelib.BlobStoreOption) BlockVerifyOptions {
+	options := BlockVerifyOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+var verifyRegistries struct {
+	once                sync.Once
+	hashRegistry        longtaillib.Longtail_HashRegistryAPI
+	compressionRegistry longtaillib.Longtail_CompressionRegistryAPI
+}
+
+// getVerifyRegistries returns the hash and compression registries used to check block content,
+// they are created on first use and kept for the life of the process
+func getVerifyRegistries() (longtaillib.Longtail_HashRegistryAPI, longtaillib.Longtail_CompressionRegistryAPI) {
+	verifyRegistries.once.Do(func() {
+		verifyRegistries.hashRegistry = longtaillib.CreateFullHashRegistry()
+		verifyRegistries.compressionRegistry = longtaillib.CreateFullCompressionRegistry()
+	})
+	return verifyRegistries.hashRegistry, verifyRegistries.compressionRegistry
+}
+
+// storedBlockSource is a block store that serves a single stored block, it lets the compress block store
+// decompress a block we have already read
+type storedBlockSource struct {
+	storedBlock longtaillib.Longtail_StoredBlock
+}
+
+func (source *storedBlockSource) PutStoredBlock(storedBlock longtaillib.Longtail_StoredBlock, asyncCompleteAPI longtaillib.Longtail_AsyncPutStoredBlockAPI) error {
+	return longtaillib.AccessViolationErr()
+}
+
+func (source *storedBlockSource) PreflightGet(blockHashes []uint64, asyncCompleteAPI longtaillib.Longtail_AsyncPreflightStartedAPI) error {
+	asyncCompleteAPI.OnComplete([]uint64{}, nil)
+	return nil
+}
+
+func (source *storedBlockSource) GetStoredBlock(blockHash uint64, asyncCompleteAPI longtaillib.Longtail_AsyncGetStoredBlockAPI) error {
+	if !source.storedBlock.IsValid() || source.storedBlock.GetBlockHash() != blockHash {
+		return longtaillib.NotExistErr()
+	}
+	storedBlock := source.storedBlock
+	source.storedBlock = longtaillib.Longtail_StoredBlock{}
+	asyncCompleteAPI.OnComplete(storedBlock, nil)
+	return nil
+}
+
+func (source *storedBlockSource) GetExistingContent(chunkHashes []uint64, minBlockUsagePercent uint32, asyncCompleteAPI longtaillib.Longtail_AsyncGetExistingContentAPI) error {
+	return longtaillib.AccessViolationErr()
+}
+
+func (source *storedBlockSource) PruneBlocks(blockHashes []uint64, asyncCompleteAPI longtaillib.Longtail_AsyncPruneBlocksAPI) error {
+	return longtaillib.AccessViolationErr()
+}
+
+func (source *storedBlockSource) GetStats() (longtaillib.BlockStoreStats, error) {
+	return longtaillib.BlockStoreStats{}, nil
+}
+
+func (source *storedBlockSource) Flush(asyncCompleteAPI longtaillib.Longtail_AsyncFlushAPI) error {
+	asyncCompleteAPI.OnComplete(nil)
+	return nil
+}
+
+func (source *storedBlockSource) Close() {
+	if source.storedBlock.IsValid() {
+		source.storedBlock.Dispose()
+		source.storedBlock = longtaillib.Longtail_StoredBlock{}
+	}
+}
+
+// verifyStoredBlockContent decompresses the chunk data in storedBlockData through the compress block store and
+// checks the size and hash of each chunk against the block index
+func verifyStoredBlockContent(storedBlockData []byte) error {
+	const fname = "verifyStoredBlockContent"
+	storedBlock, err := longtaillib.ReadStoredBlockFromBuffer(storedBlockData)
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	blockHash := storedBlock.GetBlockHash()
+	hashRegistry, compressionRegistry := getVerifyRegistries()
+	sourceStore := longtaillib.CreateBlockStoreAPI(&storedBlockSource{storedBlock: storedBlock})
+	defer sourceStore.Dispose()
+	decompressStore := longtaillib.CreateCompressBlockStore(sourceStore, compressionRegistry)
+	defer decompressStore.Dispose()
+
+	getStoredBlockComplete := &longtailutils.GetStoredBlockCompletionAPI{}
+	getStoredBlockComplete.Wg.Add(1)
+	err = decompressStore.GetStoredBlock(blockHash, longtaillib.CreateAsyncGetStoredBlockAPI(getStoredBlockComplete))
+	if err != nil {
+		getStoredBlockComplete.Wg.Done()
+	}
+	getStoredBlockComplete.Wg.Wait()
+	if err == nil {
+		err = getStoredBlockComplete.Err
+	}
+	if err != nil {
+		err = errors.Wrap(err, "Failed to decompress block")
+		return errors.Wrap(err, fname)
+	}
+	decompressedBlock := getStoredBlockComplete.StoredBlock
+	defer decompressedBlock.Dispose()
+
+	blockIndex := decompressedBlock.GetBlockIndex()
+	hashAPI, err := hashRegistry.GetHashAPI(blockIndex.GetHashIdentifier())
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	chunksData := decompressedBlock.GetChunksBlockData()
+	chunkHashes := blockIndex.GetChunkHashes()
+	chunkOffset := uint64(0)
+	for i, chunkSize := range blockIndex.GetChunkSizes() {
+		if chunkOffset+uint64(chunkSize) > uint64(len(chunksData)) {
+			err = errors.Wrap(longtaillib.BadFormatErr(), fmt.Sprintf("Chunk %d of block is truncated", i))
+			return errors.Wrap(err, fname)
+		}
+		chunkHash, err := hashAPI.HashBuffer(chunksData[chunkOffset : chunkOffset+uint64(chunkSize)])
+		if err != nil {
+			return errors.Wrap(err, fname)
+		}
+		if chunkHash != chunkHashes[i] {
+			err = errors.Wrap(longtaillib.BadFormatErr(), fmt.Sprintf("Chunk %d of block does not match its chunk hash", i))
+			return errors.Wrap(err, fname)
+		}
+		chunkOffset += uint64(chunkSize)
+	}
+	if chunkOffset != uint64(len(chunksData)) {
+		err = errors.Wrap(longtaillib.BadFormatErr(), fmt.Sprintf("Block data is %d bytes but the chunks add up to %d bytes", len(chunksData), chunkOffset))
+		return errors.Wrap(err, fname)
+	}
+	return nil
+}
+
+// verifyStoredBlock checks that storedBlock is the block with blockHash, that the size of its chunk data is
+// plausible and that its chunks match the store index, blocks that are not in the store index, or a nil
+// verifier, only check the block against itself
+func (verifier *BlockVerifier) verifyStoredBlock(blockHash uint64, storedBlock longtaillib.Longtail_StoredBlock) error {
+	blockIndex := storedBlock.GetBlockIndex()
+	if blockIndex.GetBlockHash() != blockHash {
+		return errors.Wrap(longtaillib.BadFormatErr(), fmt.Sprintf("Block hash 0x%016x does not match path", blockIndex.GetBlockHash()))
+	}
+	err := verifyChunksDataSize(blockIndex, int64(len(storedBlock.GetChunksBlockData())))
+	if err != nil {
+		return err
+	}
+	return verifier.verifyBlockIndex(blockHash, blockIndex)
+}
+
+// verifyChunksDataSize checks the size of the chunk data of a block, uncompressed blocks hold the chunks back
+// to back so their size is known, compressed blocks must at least hold some data
+func verifyChunksDataSize(blockIndex longtaillib.Longtail_BlockIndex, chunksSize int64) error {
+	if blockIndex.GetTag() != 0 {
+		if chunksSize <= 0 {
+			return errors.Wrap(longtaillib.BadFormatErr(), "Block has no chunk data")
+		}
+		return nil
+	}
+	expectedSize := int64(0)
+	for _, chunkSize := range blockIndex.GetChunkSizes() {
+		expectedSize += int64(chunkSize)
+	}
+	if chunksSize != expectedSize {
+		return errors.Wrap(longtaillib.BadFormatErr(), fmt.Sprintf("Block data is %d bytes but the chunks add up to %d bytes", chunksSize, expectedSize))
+	}
+	return nil
+}
+
+// verifyBlockIndex checks that the chunks of blockIndex match the store index, blocks that are not in the
+// store index, or a nil verifier, are not checked
+func (verifier *BlockVerifier) verifyBlockIndex(blockHash uint64, blockIndex longtaillib.Longtail_BlockIndex) error {
+	if verifier == nil {
+		return nil
+	}
+	layout, exists := verifier.blocks[blockHash]
+	if !exists {
+		return nil
+	}
+	chunkHashes := blockIndex.GetChunkHashes()
+	chunkSizes := blockIndex.GetChunkSizes()
+	if layout.tag != blockIndex.GetTag() || int(layout.chunkCount) != len(chunkHashes) {
+		return errors.Wrap(longtaillib.BadFormatErr(), fmt.Sprintf("Block has tag %d and %d chunks, store index has tag %d and %d chunks", blockIndex.GetTag(), len(chunkHashes), layout.tag, layout.chunkCount))
+	}
+	for i := range chunkHashes {
+		chunkIndex := layout.chunkOffset + uint32(i)
+		if chunkHashes[i] != verifier.chunkHashes[chunkIndex] || chunkSizes[i] != verifier.chunkSizes[chunkIndex] {
+			return errors.Wrap(longtaillib.BadFormatErr(), fmt.Sprintf("Chunk %d of block does not match store index", i))
+		}
+	}
+	return nil
+}
+
+// BlockIndexDataSize returns the size of the block index at the start of the stored block blockHash,
+// false if the block is not in the store index
+func (verifier *BlockVerifier) BlockIndexDataSize(blockHash uint64) (int64, bool) {
+	layout, exists := verifier.blocks[blockHash]
+	if !exists {
+		return 0, false
+	}
+	return int64(longtaillib.GetBlockIndexDataSize(layout.chunkCount)), true
+}
+
+// VerifyStoredBlockHeader checks the block index read from the start of the stored block blockHash and the size
+// of the stored block against the store index without reading the chunk data of the block. A negative storedSize
+// skips the size check, for stores that don't list the size of the stored block such as encrypted stores
+func (verifier *BlockVerifier) VerifyStoredBlockHeader(blockHash uint64, blockIndexData []byte, storedSize int64) error {
+	const fname = "BlockVerifier.VerifyStoredBlockHeader"
+	blockIndex, err := longtaillib.ReadBlockIndexFromBuffer(blockIndexData)
+	if err != nil {
+		err = errors.Wrap(err, "Failed to parse block index")
+		return errors.Wrap(err, fname)
+	}
+	defer blockIndex.Dispose()
+	if blockIndex.GetBlockHash() != blockHash {
+		err = errors.Wrap(longtaillib.BadFormatErr(), fmt.Sprintf("Block hash 0x%016x does not match path", blockIndex.GetBlockHash()))
+		return errors.Wrap(err, fname)
+	}
+	err = verifier.verifyBlockIndex(blockHash, blockIndex)
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	if storedSize < 0 {
+		return nil
+	}
+	err = verifyChunksDataSize(blockIndex, storedSize-int64(len(blockIndexData)))
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	return nil
+}
+
+// readVerifiedStoredBlock parses storedBlockData and verifies it, verifyContent also decompresses the chunk data
+// and checks the hash of every chunk which costs as much as decompressing the block again
+func readVerifiedStoredBlock(
+	verifier *BlockVerifier,
+	blockHash uint64,
+	storedBlockData []byte,
+	verifyContent bool) (longtaillib.Longtail_StoredBlock, error) {
+	const fname = "readVerifiedStoredBlock"
+	storedBlock, err := longtaillib.ReadStoredBlockFromBuffer(storedBlockData)
+	if err != nil {
+		err = errors.Wrap(err, "Failed to parse stored block")
+		return longtaillib.Longtail_StoredBlock{}, errors.Wrap(err, fname)
+	}
+	err = verifier.verifyStoredBlock(blockHash, storedBlock)
+	if err == nil && verifyContent {
+		err = verifyStoredBlockContent(storedBlockData)
+	}
+	if err != nil {
+		storedBlock.Dispose()
+		return longtaillib.Longtail_StoredBlock{}, errors.Wrap(err, fname)
+	}
+	return storedBlock, nil
+}
+
+// readStoredBlockFromSources reads each copy of a block from stores that keep more than one copy,
+// such as mirrored stores, and returns the first copy that passes verification
+func readStoredBlockFromSources(
+	ctx context.Context,
+	s *remoteStore,
+	blobClient longtailstorelib.BlobClient,
+	blockHash uint64,
+	key string) (longtaillib.Longtail_StoredBlock, error) {
+	const fname = "readStoredBlockFromSources"
+	objHandle, err := blobClient.NewObject(key)
+	if err != nil {
+		return longtaillib.Longtail_StoredBlock{}, errors.Wrap(err, fname)
+	}
+	sourced, ok := objHandle.(longtailstorelib.SourcedBlobObject)
+	if !ok || sourced.SourceCount() < 2 {
+		return longtaillib.Longtail_StoredBlock{}, errors.Wrap(longtaillib.BadFormatErr(), "No other copies of the block")
+	}
+	for source := 0; source < sourced.SourceCount(); source++ {
+		storedBlockData, err := sourced.ReadSource(ctx, source)
+		if err != nil {
+			continue
+		}
+		storedBlock, err := readVerifiedStoredBlock(s.getBlockVerifier(), blockHash, storedBlockData, s.verifyBlockContent)
+		if err == nil {
+			logrus.WithFields(logrus.Fields{"fname": fname, "path": objHandle.String(), "source": source}).Info("read block from other copy")
+			return storedBlock, nil
+		}
+	}
+	return longtaillib.Longtail_StoredBlock{}, errors.Wrap(longtaillib.BadFormatErr(), "No copy of the block passed verification")
+}
+
+// quarantineBlock records a damaged block in the store so operators can find it, it is only recorded
+// once per block store instance and failing to record it does not fail the fetch
+func quarantineBlock(
+	ctx context.Context,
+	s *remoteStore,
+	blobClient longtailstorelib.BlobClient,
+	blockHash uint64,
+	key string,
+	reason error,
+	recovered bool) {
+	const fname = "quarantineBlock"
+	log := logrus.WithFields(logrus.Fields{
+		"fname":     fname,
+		"blockHash": blockHash,
+		"path":      key,
+		"recovered": recovered,
+	})
+	log.WithError(reason).Error("block failed verification")
+
+	s.quarantineSync.Lock()
+	if s.quarantinedBlocks[blockHash] {
+		s.quarantineSync.Unlock()
+		return
+	}
+	if s.quarantinedBlocks == nil {
+		s.quarantinedBlocks = map[uint64]bool{}
+	}
+	s.quarantinedBlocks[blockHash] = true
+	s.quarantineSync.Unlock()
+
+	record, err := json.Marshal(QuarantineRecord{
+		BlockHash: fmt.Sprintf("0x%016x", blockHash),
+		Path:      key,
+		Reason:    reason.Error(),
+		Recovered: recovered,
+		Time:      time.Now().UTC(),
+	})
+	if err == nil {
+		_, err = longtailstorelib.RetryBlobOperation(ctx, blobClient, s.throttle, func() error {
+			objHandle, err := blobClient.NewObject(GetQuarantineRecordPath(blockHash))
+			if err != nil {
+				return err
+			}
+			_, err = objHandle.Write(ctx, record)
+			return err
+		})
+	}
+	if err != nil {
+		log.WithError(err).Warn("Failed writing quarantine record")
+	}
+}
+
+func getStoredBlock(
+	ctx context.Context,
+	s *remoteStore,
+	blobClient longtailstorelib.BlobClient,
+	blockHash uint64) (longtaillib.Longtail_StoredBlock, error) {
+	const fname = "getStoredBlock"
+	log := logrus.WithFields(logrus.Fields{
+		"fname":      fname,
+		"s":          s,
+		"blobClient": blobClient.String(),
+		"blockHash":  blockHash,
+	})
+	log.Debug(fname)
+
+	atomic.AddUint64(&s.stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_GetStoredBlock_Count], 1)
+
+	if ctx.Err() != nil {
+		atomic.AddUint64(&s.stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_GetStoredBlock_FailCount], 1)
+		return longtaillib.Longtail_StoredBlock{}, errors.Wrap(cancelledError(ctx), fname)
+	}
+
+	key := getBlockPath("chunks", blockHash)
+
+	var verifyErr error
+	for attempt := 0; attempt < blockVerifyAttempts; attempt++ {
+		storedBlockData, retryCount, err := longtailutils.ReadBlobWithRetry(ctx, blobClient, s.throttle, key)
+		atomic.AddUint64(&s.stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_GetStoredBlock_RetryCount], uint64(retryCount))
+
+		if err != nil || storedBlockData == nil {
+			atomic.AddUint64(&s.stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_GetStoredBlock_FailCount], 1)
+			return longtaillib.Longtail_StoredBlock{}, errors.Wrap(err, fname)
+		}
+
+		storedBlock, err := readVerifiedStoredBlock(s.getBlockVerifier(), blockHash, storedBlockData, s.verifyBlockContent)
+		if err == nil {
+			atomic.AddUint64(&s.stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_GetStoredBlock_Byte_Count], (uint64)(len(storedBlockData)))
+			blockIndex := storedBlock.GetBlockIndex()
+			atomic.AddUint64(&s.stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_GetStoredBlock_Chunk_Count], (uint64)(blockIndex.GetChunkCount()))
+			log.WithFields(logrus.Fields{
+				"chunks": blockIndex.GetChunkCount(),
+				"bytes":  len(storedBlockData),
+				"path":   fmt.Sprintf("%s/%s", blobClient.String(), key)}).Info("read block")
+			return storedBlock, nil
+		}
+		verifyErr = errors.Wrap(err, fmt.Sprintf("Stored block `%s` failed verification", key))
+		log.WithError(verifyErr).Warnf("Retrying reading block, attempt %d of %d", attempt+1, blockVerifyAttempts)
+		if ctx.Err() != nil {
+			atomic.AddUint64(&s.stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_GetStoredBlock_FailCount], 1)
+			return longtaillib.Longtail_StoredBlock{}, errors.Wrap(cancelledError(ctx), fname)
+		}
+	}
+
+	// The stored copy is damaged, other copies of the block may still be good
+	storedBlock, err := readStoredBlockFromSources(ctx, s, blobClient, blockHash, key)
+	quarantineBlock(ctx, s, blobClient, blockHash, key, verifyErr, err == nil)
+	if err == nil {
+		return storedBlock, nil
+	}
+	atomic.AddUint64(&s.stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_GetStoredBlock_FailCount], 1)
+	return longtaillib.Longtail_StoredBlock{}, errors.Wrap(verifyErr, fname)
+}
+
+func fetchBlock(
+	ctx context.Context,
+	s *remoteStore,
+	client longtailstorelib.BlobClient,
+	getMsg getBlockMessage) {
+	const fname = "fetchBlock"
+	log := logrus.WithFields(logrus.Fields{
+		"fname":  fname,
+		"s":      s,
+		"client": client.String(),
+		"getMsg": getMsg,
+	})
+	log.Debug(fname)
+	s.fetchedBlocksSync.Lock()
+	prefetchedBlock := s.prefetchBlocks[getMsg.blockHash]
+	if prefetchedBlock != nil {
+		storedBlock := prefetchedBlock.storedBlock
+		err := prefetchedBlock.err
+		if storedBlock.IsValid() {
+			blockSize := -int64(storedBlock.GetBlockSize())
+			atomic.AddInt64(&s.prefetchMemory, blockSize)
+		}
+		if storedBlock.IsValid() || err != nil {
+			s.prefetchBlocks[getMsg.blockHash] = nil
+			s.fetchedBlocksSync.Unlock()
+			getMsg.asyncCompleteAPI.OnComplete(storedBlock, err)
+			return
+		}
+		prefetchedBlock.completeCallbacks = append(prefetchedBlock.completeCallbacks, getMsg.asyncCompleteAPI)
+		s.fetchedBlocksSync.Unlock()
+		return
+	}
+	prefetchedBlock = &pendingPrefetchedBlock{storedBlock: longtaillib.Longtail_StoredBlock{}}
+	s.prefetchBlocks[getMsg.blockHash] = prefetchedBlock
+	s.fetchedBlocksSync.Unlock()
+	storedBlock, getStoredBlockErr := getStoredBlock(ctx, s, client, getMsg.blockHash)
+	s.fetchedBlocksSync.Lock()
+	prefetchedBlock, exists := s.prefetchBlocks[getMsg.blockHash]
+	if exists && prefetchedBlock == nil {
+		fmt.Printf("Prefetch in background while fetching block, dropping duplicate\n")
+		storedBlock.Dispose()
+		s.fetchedBlocksSync.Unlock()
+		return
+	}
+	completeCallbacks := prefetchedBlock.completeCallbacks
+	s.prefetchBlocks[getMsg.blockHash] = nil
+	s.fetchedBlocksSync.Unlock()
+	for _, c := range completeCallbacks {
+		if getStoredBlockErr != nil {
+			c.OnComplete(longtaillib.Longtail_StoredBlock{}, errors.Wrap(getStoredBlockErr, fname))
+			continue
+		}
+		buf, err := longtaillib.WriteStoredBlockToBuffer(storedBlock)
+		if err != nil {
+			c.OnComplete(longtaillib.Longtail_StoredBlock{}, errors.Wrap(err, fname))
+			continue
+		}
+		blockCopy, err := longtaillib.ReadStoredBlockFromBuffer(buf.ToBuffer())
+		fmt.Printf("Making block copy due to multiple waiters\n")
+		buf.Dispose()
+		if err != nil {
+			c.OnComplete(longtaillib.Longtail_StoredBlock{}, errors.Wrap(err, fname))
+			continue
+		}
+		c.OnComplete(blockCopy, nil)
+	}
+	getMsg.asyncCompleteAPI.OnComplete(storedBlock, errors.Wrap(getStoredBlockErr, fname))
+}
+
+func deleteBlock(
+	ctx context.Context,
+	s *remoteStore,
+	client longtailstorelib.BlobClient,
+	blockHash uint64) error {
+	const fname = "deleteBlock"
+	log := logrus.WithFields(logrus.Fields{
+		"fname":     fname,
+		"s":         s,
+		"client":    client.String(),
+		"blockHash": blockHash,
+	})
+	log.Debug(fname)
+	if ctx.Err() != nil {
+		return errors.Wrap(cancelledError(ctx), fname)
+	}
+	s.fetchedBlocksSync.Lock()
+	defer s.fetchedBlocksSync.Unlock()
+	key := getBlockPath("chunks", blockHash)
+	objHandle, err := client.NewObject(key)
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	retryCount, err := longtailstorelib.RetryBlobOperation(ctx, client, s.throttle, func() error {
+		return objHandle.Delete(ctx)
+	})
+	atomic.AddUint64(&s.stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_PruneBlocks_RetryCount], uint64(retryCount))
+	if err != nil {
+		if ctx.Err() != nil {
+			return errors.Wrap(cancelledError(ctx), fname)
+		}
+		return errors.Wrap(err, fname)
+	}
+	return nil
+}
+
+func prefetchBlock(
+	ctx context.Context,
+	s *remoteStore,
+	client longtailstorelib.BlobClient,
+	prefetchMsg prefetchBlockMessage) {
+	const fname = "prefetchBlock"
+	log := logrus.WithFields(logrus.Fields{
+		"fname":       fname,
+		"s":           s,
+		"client":      client.String(),
+		"prefetchMsg": prefetchMsg,
+	})
+	log.Debug(fname)
+	s.fetchedBlocksSync.Lock()
+	_, exists := s.prefetchBlocks[prefetchMsg.blockHash]
+	if exists {
+		// Already pre-fetched
+		s.fetchedBlocksSync.Unlock()
+		return
+	}
+	prefetchedBlock := &pendingPrefetchedBlock{storedBlock: longtaillib.Longtail_StoredBlock{}}
+	s.prefetchBlocks[prefetchMsg.blockHash] = prefetchedBlock
+	s.fetchedBlocksSync.Unlock()
+
+	storedBlock, getErr := getStoredBlock(ctx, s, client, prefetchMsg.blockHash)
+
+	s.fetchedBlocksSync.Lock()
+
+	prefetchedBlock, exists = s.prefetchBlocks[prefetchMsg.blockHash]
+	if prefetchedBlock == nil {
+		fmt.Printf("Dropping prefetched block due to background prefetch\n")
+		storedBlock.Dispose()
+		s.fetchedBlocksSync.Unlock()
+		return
+	}
+
+	completeCallbacks := prefetchedBlock.completeCallbacks
+	if len(completeCallbacks) == 0 {
+		// Nobody is actively waiting for the block
+		if storedBlock.IsValid() {
+			blockSize := int64(storedBlock.GetBlockSize())
+			prefetchedBlock.storedBlock = storedBlock
+			atomic.AddInt64(&s.prefetchMemory, blockSize)
+		}
+		prefetchedBlock.err = getErr
+		s.fetchedBlocksSync.Unlock()
+		return
+	}
+	s.prefetchBlocks[prefetchMsg.blockHash] = nil
+	s.fetchedBlocksSync.Unlock()
+
+	for i := 1; i < len(completeCallbacks)-1; i++ {
+		c := completeCallbacks[i]
+		if getErr != nil {
+			c.OnComplete(longtaillib.Longtail_StoredBlock{}, errors.Wrap(getErr, fname))
+			continue
+		}
+		buf, err := longtaillib.WriteStoredBlockToBuffer(storedBlock)
+		if err != nil {
+			c.OnComplete(longtaillib.Longtail_StoredBlock{}, errors.Wrap(err, fname))
+			continue
 		}
 		blockCopy, err := longtaillib.ReadStoredBlockFromBuffer(buf.ToBuffer())
 		buf.Dispose()
@@ -509,6 +1279,9 @@ func remoteWorker(
 			err := deleteBlock(ctx, s, client, deleteMsg.blockHash)
 			if err == nil {
 				atomic.AddUint32(deleteMsg.successCounter, 1)
+			} else if !longtaillib.IsNotExist(err) {
+				log.WithError(err).WithField("blockHash", deleteMsg.blockHash).Warn("Failed to delete pruned block")
+				atomic.AddUint32(deleteMsg.failureCounter, 1)
 			}
 			deleteMsg.completeSignal.Done()
 		default:
@@ -617,7 +1390,9 @@ func onPreflighMessage(
 }
 
 func onGetExistingContentMessage(
+	ctx context.Context,
 	s *remoteStore,
+	client longtailstorelib.BlobClient,
 	storeIndex longtaillib.Longtail_StoreIndex,
 	message getExistingContentMessage) {
 	const fname = "onGetExistingContentMessage"
@@ -633,6 +1408,13 @@ func onGetExistingContentMessage(
 		message.asyncCompleteAPI.OnComplete(longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname))
 		return
 	}
+	// The caller reuses these blocks so they must survive a prune that has marked them
+	err = s.rescuePruneCandidates(ctx, client, existingStoreIndex.GetBlockHashes())
+	if err != nil {
+		existingStoreIndex.Dispose()
+		message.asyncCompleteAPI.OnComplete(longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname))
+		return
+	}
 	message.asyncCompleteAPI.OnComplete(existingStoreIndex, nil)
 }
 
@@ -671,15 +1453,22 @@ func onPruneBlocksMessage(
 	var wg sync.WaitGroup
 
 	prunedCount := uint32(0)
+	failedCount := uint32(0)
 	existingBlockHashes := storeIndex.GetBlockHashes()
 	for _, blockHash := range existingBlockHashes {
 		if _, exists := keptBlocksMap[blockHash]; exists {
 			continue
 		}
 		wg.Add(1)
-		s.deleteBlockChan <- deleteBlockMessage{blockHash: blockHash, completeSignal: &wg, successCounter: &prunedCount}
+		s.deleteBlockChan <- deleteBlockMessage{blockHash: blockHash, completeSignal: &wg, successCounter: &prunedCount, failureCounter: &failedCount}
 	}
 	wg.Wait()
+	if failedCount > 0 {
+		// The pruned store index is already written so the blocks that could not be deleted are only left
+		// behind as unreferenced blocks, the caller still gets the pruned store index
+		err = fmt.Errorf("failed to delete %d pruned blocks, they are no longer in the store index", failedCount)
+		return prunedCount, prunedIndex, errors.Wrap(err, fname)
+	}
 	return prunedCount, prunedIndex, nil
 }
 
@@ -703,10 +1492,17 @@ func getCurrentStoreIndex(
 	log.Debug(fname)
 	var err error = nil
 	if !storeIndex.IsValid() {
-		storeIndex, err = readRemoteStoreIndex(ctx, optionalStoreIndexPaths, s.blobStore, client, accessType, s.workerCount)
+		storeIndex, err = readRemoteStoreIndex(ctx, optionalStoreIndexPaths, s.blobStore, client, accessType, s.workerCount, s.blobStoreOptions...)
 		if err != nil {
 			return storeIndex, longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
 		}
+		s.setBlockVerifier(storeIndex)
+		if accessType != ReadOnly {
+			err = s.refreshPruneCandidates(ctx, client)
+			if err != nil {
+				return storeIndex, longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+			}
+		}
 	}
 	if len(addedBlockIndexes) == 0 {
 		return storeIndex, longtaillib.Longtail_StoreIndex{}, nil
@@ -801,13 +1597,13 @@ func contentIndexWorker(
 				getExistingContentMessage.asyncCompleteAPI.OnComplete(longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname))
 			} else if accessType == ReadOnly {
 				go func() {
-					onGetExistingContentMessage(s, storeIndex, getExistingContentMessage)
+					onGetExistingContentMessage(ctx, s, client, storeIndex, getExistingContentMessage)
 				}()
 			} else if updatedStoreIndex.IsValid() {
-				onGetExistingContentMessage(s, updatedStoreIndex, getExistingContentMessage)
+				onGetExistingContentMessage(ctx, s, client, updatedStoreIndex, getExistingContentMessage)
 				updatedStoreIndex.Dispose()
 			} else {
-				onGetExistingContentMessage(s, storeIndex, getExistingContentMessage)
+				onGetExistingContentMessage(ctx, s, client, storeIndex, getExistingContentMessage)
 			}
 		case pruneBlocksMessage := <-pruneBlocksMessages:
 			if accessType == ReadOnly {
@@ -886,13 +1682,13 @@ func contentIndexWorker(
 				getExistingContentMessage.asyncCompleteAPI.OnComplete(longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname))
 			} else if accessType == ReadOnly {
 				go func() {
-					onGetExistingContentMessage(s, storeIndex, getExistingContentMessage)
+					onGetExistingContentMessage(ctx, s, client, storeIndex, getExistingContentMessage)
 				}()
 			} else if updatedStoreIndex.IsValid() {
-				onGetExistingContentMessage(s, updatedStoreIndex, getExistingContentMessage)
+				onGetExistingContentMessage(ctx, s, client, updatedStoreIndex, getExistingContentMessage)
 				updatedStoreIndex.Dispose()
 			} else {
-				onGetExistingContentMessage(s, storeIndex, getExistingContentMessage)
+				onGetExistingContentMessage(ctx, s, client, storeIndex, getExistingContentMessage)
 			}
 		case pruneBlocksMessage := <-pruneBlocksMessages:
 			if accessType == ReadOnly {
@@ -943,7 +1739,10 @@ func contentIndexWorker(
 }
 
 // NewRemoteBlockStore ...
+// All blob operations of the store are bound to ctx, cancelling it makes pending and
+// in-flight requests complete with a cancelled error
 func NewRemoteBlockStore(
+	ctx context.Context,
 	jobAPI longtaillib.Longtail_JobAPI,
 	blobStore longtailstorelib.BlobStore,
 	optionalStoreIndexPaths []string,
@@ -960,7 +1759,6 @@ func NewRemoteBlockStore(
 		"opts":                    opts,
 	})
 	log.Debug(fname)
-	ctx := context.Background()
 	defaultClient, err := blobStore.NewClient(ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, fname)
@@ -973,6 +1771,9 @@ func NewRemoteBlockStore(
 		defaultClient:    defaultClient}
 
 	s.workerCount = workerCount
+	s.accessType = accessType
+	s.verifyBlockContent = GetBlockVerifyOptions(opts...).VerifyContent
+	s.throttle = longtailstorelib.NewBlobThrottle()
 	s.putBlockChan = make(chan putBlockMessage, 16+s.workerCount*8)
 	s.getBlockChan = make(chan getBlockMessage, 32+s.workerCount*4)
 	s.prefetchBlockChan = make(chan prefetchBlockMessage, 16+s.workerCount*2048)
@@ -991,6 +1792,7 @@ func NewRemoteBlockStore(
 	s.maxPrefetchMemory = 512 * 1024 * 1024
 
 	s.prefetchBlocks = map[uint64]*pendingPrefetchedBlock{}
+	s.rescuedBlocks = map[uint64]bool{}
 
 	go func() {
 		err := contentIndexWorker(
@@ -1091,120 +1893,350 @@ func (s *remoteStore) Flush(asyncCompleteAPI longtaillib.Longtail_AsyncFlushAPI)
 	return nil
 }
 
-// Close ...
-func (s *remoteStore) Close() {
-	close(s.putBlockChan)
-	for i := 0; i < s.workerCount; i++ {
-		err := <-s.workerErrorChan
+// Close ...
+func (s *remoteStore) Close() {
+	close(s.putBlockChan)
+	for i := 0; i < s.workerCount; i++ {
+		err := <-s.workerErrorChan
+		if err != nil {
+			logrus.Error(err)
+		}
+	}
+	close(s.blockIndexChan)
+	err := <-s.workerErrorChan
+	if err != nil {
+		logrus.Error(err)
+	}
+
+	if throttleCount := s.throttle.ThrottleCount(); throttleCount > 0 {
+		logrus.Warnf("%s throttled requests %d times", s, throttleCount)
+	}
+
+	s.defaultClient.Close()
+}
+
+func tryAddRemoteStoreIndexWithLocking(
+	ctx context.Context,
+	addStoreIndex longtaillib.Longtail_StoreIndex,
+	blobClient longtailstorelib.BlobClient) (bool, longtaillib.Longtail_StoreIndex, error) {
+	const fname = "tryAddRemoteStoreIndexWithLocking"
+	log := logrus.WithFields(logrus.Fields{
+		"fname":      fname,
+		"blobClient": blobClient,
+	})
+	log.Debug(fname)
+
+	key := "store.lsi"
+	objHandle, err := blobClient.NewObject(key)
+	if err != nil {
+		return false, longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+	}
+
+	exists, err := objHandle.LockWriteVersion(ctx)
+	if err != nil {
+		return false, longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+	}
+	if exists {
+		blob, err := objHandle.Read(ctx)
+		if err != nil {
+			return false, longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+		}
+		if longtaillib.IsNotExist(err) {
+			return false, longtaillib.Longtail_StoreIndex{}, nil
+		}
+
+		remoteStoreIndex, err := longtaillib.ReadStoreIndexFromBuffer(blob)
+		if err != nil {
+			err = errors.Wrap(err, fmt.Sprintf("Cant parse store index from `%s/%s`", blobClient.String(), key))
+			return false, longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+		}
+		defer remoteStoreIndex.Dispose()
+		log.WithFields(logrus.Fields{"path": objHandle.String(), "bytes": len(blob)}).Info("read store index")
+
+		newStoreIndex, err := longtaillib.MergeStoreIndex(remoteStoreIndex, addStoreIndex)
+		if err != nil {
+			err = errors.Wrap(err, fmt.Sprintf("Failed merging store index for `%s/%s`", blobClient.String(), key))
+			return false, longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+		}
+
+		storeBlob, err := longtaillib.WriteStoreIndexToBuffer(newStoreIndex)
+		if err != nil {
+			newStoreIndex.Dispose()
+			err = errors.Wrap(err, fmt.Sprintf("Failed serializing store index for `%s/%s`", blobClient.String(), key))
+			return false, longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+		}
+		defer storeBlob.Dispose()
+
+		ok, err := objHandle.Write(ctx, storeBlob.ToBuffer())
+		if err != nil {
+			newStoreIndex.Dispose()
+			return false, longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+		}
+		if !ok {
+			newStoreIndex.Dispose()
+			return false, longtaillib.Longtail_StoreIndex{}, nil
+		}
+		log.WithFields(logrus.Fields{"path": objHandle.String(), "bytes": storeBlob.Size()}).Info("wrote store index")
+		return ok, newStoreIndex, nil
+	}
+	storeBlob, err := longtaillib.WriteStoreIndexToBuffer(addStoreIndex)
+	if err != nil {
+		err = errors.Wrap(err, fmt.Sprintf("Failed serializing store index for `%s/%s`", blobClient.String(), key))
+		return false, longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+	}
+	defer storeBlob.Dispose()
+
+	ok, err := objHandle.Write(ctx, storeBlob.ToBuffer())
+	if err != nil {
+		return false, longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+	}
+	if ok {
+		log.WithFields(logrus.Fields{"path": objHandle.String(), "bytes": storeBlob.Size()}).Info("wrote store index")
+	}
+	return ok, longtaillib.Longtail_StoreIndex{}, nil
+}
+
+// getLegacyStoreIndexItems returns the store index items other than the locked index key, they are
+// written by clients that update the store index without locking
+func getLegacyStoreIndexItems(
+	ctx context.Context,
+	client longtailstorelib.BlobClient,
+	key string) ([]string, error) {
+	const fname = "getLegacyStoreIndexItems"
+	items, err := getStoreStoreIndexes(ctx, client)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	legacyItems := []string{}
+	for _, item := range items {
+		if item != key {
+			legacyItems = append(legacyItems, item)
+		}
+	}
+	return legacyItems, nil
+}
+
+// deleteLegacyStoreIndexItems deletes items once they are part of the locked index, an item that is
+// left behind is merged again by the next update
+func deleteLegacyStoreIndexItems(
+	ctx context.Context,
+	client longtailstorelib.BlobClient,
+	items []string) {
+	for _, item := range items {
+		objHandle, err := client.NewObject(item)
+		if err == nil {
+			err = objHandle.Delete(ctx)
+		}
+		if err != nil && !longtaillib.IsNotExist(err) {
+			logrus.WithFields(logrus.Fields{"fname": "deleteLegacyStoreIndexItems", "item": item}).Warnf("Failed deleting legacy store index item: %s", err)
+		}
+	}
+}
+
+func tryWriteRemoteStoreIndex(
+	ctx context.Context,
+	storeIndex longtaillib.Longtail_StoreIndex,
+	existingIndexItems []string,
+	blobClient longtailstorelib.BlobClient) (bool, error) {
+	const fname = "tryWriteRemoteStoreIndex"
+	log := logrus.WithFields(logrus.Fields{
+		"fname":      fname,
+		"blobClient": blobClient,
+	})
+	log.Debug(fname)
+
+	storeBlob, err := longtaillib.WriteStoreIndexToBuffer(storeIndex)
+	if err != nil {
+		err := errors.Wrap(err, "Failed serializing store index")
+		return false, errors.Wrap(err, fname)
+	}
+	defer storeBlob.Dispose()
+
+	sha256 := sha256.Sum256(storeBlob.ToBuffer())
+	key := fmt.Sprintf("store_%x.lsi", sha256)
+
+	for _, item := range existingIndexItems {
+		if item == key {
+			return true, nil
+		}
+	}
+
+	objHandle, err := blobClient.NewObject(key)
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+
+	exists, err := objHandle.Exists(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	if exists {
+		return false, nil
+	}
+
+	ok, err := objHandle.Write(ctx, storeBlob.ToBuffer())
+	if !ok || err != nil {
+		return ok, errors.Wrap(err, fname)
+	}
+
+	log.WithFields(logrus.Fields{"path": objHandle.String(), "bytes": storeBlob.Size()}).Info("wrote store index")
+
+	for _, item := range existingIndexItems {
+		objHandle, err := blobClient.NewObject(item)
 		if err != nil {
-			logrus.Error(err)
+			continue
+		}
+		err = objHandle.Delete(ctx)
+		if err != nil {
+			continue
 		}
-	}
-	close(s.blockIndexChan)
-	err := <-s.workerErrorChan
-	if err != nil {
-		logrus.Error(err)
 	}
 
-	s.defaultClient.Close()
+	return true, nil
 }
 
-func tryAddRemoteStoreIndexWithLocking(
+func tryAddRemoteStoreIndex(
 	ctx context.Context,
 	addStoreIndex longtaillib.Longtail_StoreIndex,
 	blobClient longtailstorelib.BlobClient) (bool, longtaillib.Longtail_StoreIndex, error) {
-	const fname = "tryAddRemoteStoreIndexWithLocking"
+	const fname = "tryAddRemoteStoreIndex"
 	log := logrus.WithFields(logrus.Fields{
 		"fname":      fname,
-		"blobClient": blobClient,
+		"blobClient": blobClient.String(),
 	})
 	log.Debug(fname)
 
-	key := "store.lsi"
-	objHandle, err := blobClient.NewObject(key)
+	if blobClient.SupportsLocking() {
+		return tryAddRemoteStoreIndexWithLocking(ctx, addStoreIndex, blobClient)
+	}
+
+	storeIndex, items, err := readStoreStoreIndexWithItems(ctx, blobClient)
 	if err != nil {
 		return false, longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
 	}
 
-	exists, err := objHandle.LockWriteVersion()
+	if !storeIndex.IsValid() {
+		ok, err := tryWriteRemoteStoreIndex(ctx, addStoreIndex, items, blobClient)
+		return ok, longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+	}
+
+	mergedStoreIndex, err := longtaillib.MergeStoreIndex(storeIndex, addStoreIndex)
+	storeIndex.Dispose()
 	if err != nil {
+		err = errors.Wrap(err, "Failed merging store index")
 		return false, longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
 	}
-	if exists {
-		blob, err := objHandle.Read()
-		if err != nil {
-			return false, longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
-		}
-		if longtaillib.IsNotExist(err) {
-			return false, longtaillib.Longtail_StoreIndex{}, nil
-		}
 
-		remoteStoreIndex, err := longtaillib.ReadStoreIndexFromBuffer(blob)
-		if err != nil {
-			err = errors.Wrap(err, fmt.Sprintf("Cant parse store index from `%s/%s`", blobClient.String(), key))
-			return false, longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
-		}
-		defer remoteStoreIndex.Dispose()
-		log.WithFields(logrus.Fields{"path": objHandle.String(), "bytes": len(blob)}).Info("read store index")
+	ok, err := tryWriteRemoteStoreIndex(ctx, mergedStoreIndex, items, blobClient)
+	if err != nil {
+		return false, longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+	}
+	return ok, mergedStoreIndex, nil
+}
 
-		newStoreIndex, err := longtaillib.MergeStoreIndex(remoteStoreIndex, addStoreIndex)
-		if err != nil {
-			err = errors.Wrap(err, fmt.Sprintf("Failed merging store index for `%s/%s`", blobClient.String(), key))
-			return false, longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
-		}
+func addToRemoteStoreIndex(
+	ctx context.Context,
+	blobClient longtailstorelib.BlobClient,
+	addStoreIndex longtaillib.Longtail_StoreIndex) (longtaillib.Longtail_StoreIndex, error) {
+	const fname = "addToRemoteStoreIndex"
+	log := logrus.WithFields(logrus.Fields{
+		"fname":      fname,
+		"blobClient": blobClient,
+	})
+	log.Debug(fname)
 
-		storeBlob, err := longtaillib.WriteStoreIndexToBuffer(newStoreIndex)
-		if err != nil {
-			newStoreIndex.Dispose()
-			err = errors.Wrap(err, fmt.Sprintf("Failed serializing store index for `%s/%s`", blobClient.String(), key))
-			return false, longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+	errorRetries := 0
+	for {
+		ok, newStoreIndex, err := tryAddRemoteStoreIndex(
+			ctx,
+			addStoreIndex,
+			blobClient)
+		if ok {
+			return newStoreIndex, nil
 		}
-		defer storeBlob.Dispose()
-
-		ok, err := objHandle.Write(storeBlob.ToBuffer())
 		if err != nil {
-			newStoreIndex.Dispose()
-			return false, longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
-		}
-		if !ok {
-			newStoreIndex.Dispose()
-			return false, longtaillib.Longtail_StoreIndex{}, nil
+			errorRetries++
+			if errorRetries == 3 {
+				log.Errorf("Failed updating remote store after %d tryAddRemoteStoreIndex: %s", 3, err)
+				return longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+			} else {
+				log.Warnf("Error from tryAddRemoteStoreIndex %s", err)
+			}
 		}
-		log.WithFields(logrus.Fields{"path": objHandle.String(), "bytes": storeBlob.Size()}).Info("wrote store index")
-		return ok, newStoreIndex, nil
+		log.Debug("Retrying updating remote store index")
 	}
-	storeBlob, err := longtaillib.WriteStoreIndexToBuffer(addStoreIndex)
+	return longtaillib.Longtail_StoreIndex{}, nil
+}
+
+func tryOverwriteRemoteStoreIndexWithLocking(
+	ctx context.Context,
+	storeIndex longtaillib.Longtail_StoreIndex,
+	client longtailstorelib.BlobClient) (bool, error) {
+	const fname = "tryOverwriteRemoteStoreIndexWithLocking"
+	log := logrus.WithFields(logrus.Fields{
+		"fname":  fname,
+		"client": client,
+	})
+	log.Debug(fname)
+
+	storeBlob, err := longtaillib.WriteStoreIndexToBuffer(storeIndex)
 	if err != nil {
-		err = errors.Wrap(err, fmt.Sprintf("Failed serializing store index for `%s/%s`", blobClient.String(), key))
-		return false, longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+		err := errors.Wrap(err, "Failed serializing store index")
+		return false, errors.Wrap(err, fname)
 	}
 	defer storeBlob.Dispose()
 
-	ok, err := objHandle.Write(storeBlob.ToBuffer())
+	key := "store.lsi"
+	objHandle, err := client.NewObject(key)
 	if err != nil {
-		return false, longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+		return false, errors.Wrap(err, fname)
+	}
+
+	_, err = objHandle.LockWriteVersion(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+
+	// The overwriting index replaces the items written before the store supported locking, merging
+	// them would bring back the blocks removed from the index
+	legacyItems, err := getLegacyStoreIndexItems(ctx, client, key)
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+
+	ok, err := objHandle.Write(ctx, storeBlob.ToBuffer())
+	if err != nil {
+		return false, errors.Wrap(err, fname)
 	}
 	if ok {
-		log.WithFields(logrus.Fields{"path": objHandle.String(), "bytes": storeBlob.Size()}).Info("wrote store index")
+		deleteLegacyStoreIndexItems(ctx, client, legacyItems)
 	}
-	return ok, longtaillib.Longtail_StoreIndex{}, nil
+	return ok, nil
 }
 
-func tryWriteRemoteStoreIndex(
+func tryOverwriteRemoteStoreIndexWithoutLocking(
 	ctx context.Context,
 	storeIndex longtaillib.Longtail_StoreIndex,
-	existingIndexItems []string,
-	blobClient longtailstorelib.BlobClient) (bool, error) {
-	const fname = "tryWriteRemoteStoreIndex"
+	client longtailstorelib.BlobClient) (bool, error) {
+	const fname = "tryOverwriteRemoteStoreIndexWithoutLocking"
 	log := logrus.WithFields(logrus.Fields{
-		"fname":      fname,
-		"blobClient": blobClient,
+		"fname":  fname,
+		"client": client.String(),
 	})
 	log.Debug(fname)
 
+	items, err := getStoreStoreIndexes(ctx, client)
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+
 	storeBlob, err := longtaillib.WriteStoreIndexToBuffer(storeIndex)
 	if err != nil {
-		err := errors.Wrap(err, "Failed serializing store index")
+		err = errors.Wrap(err, "Failed serializing store index")
 		return false, errors.Wrap(err, fname)
 	}
 	defer storeBlob.Dispose()
@@ -1212,269 +2244,587 @@ func tryWriteRemoteStoreIndex(
 	sha256 := sha256.Sum256(storeBlob.ToBuffer())
 	key := fmt.Sprintf("store_%x.lsi", sha256)
 
-	for _, item := range existingIndexItems {
-		if item == key {
-			return true, nil
-		}
+	objHandle, err := client.NewObject(key)
+	if err != nil {
+		return false, errors.Wrap(err, fname)
 	}
 
-	objHandle, err := blobClient.NewObject(key)
 	if err != nil {
 		return false, errors.Wrap(err, fname)
 	}
 
+	exists, err := objHandle.Exists(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, fname)
+	}
+	if !exists {
+		ok, err := objHandle.Write(ctx, storeBlob.ToBuffer())
+		if !ok || err != nil {
+			return ok, errors.Wrap(err, fname)
+		}
+	}
+
+	for _, item := range items {
+		if item == key {
+			continue
+		}
+		objHandle, err := client.NewObject(item)
+		if err != nil {
+			continue
+		}
+		err = objHandle.Delete(ctx)
+		if err != nil {
+			continue
+		}
+	}
+
+	return true, nil
+}
+
+func tryOverwriteRemoteStoreIndex(
+	ctx context.Context,
+	storeIndex longtaillib.Longtail_StoreIndex,
+	blobClient longtailstorelib.BlobClient) (bool, error) {
+	const fname = "tryOverwriteRemoteStoreIndex"
+	log := logrus.WithFields(logrus.Fields{
+		"fname":      fname,
+		"blobClient": blobClient,
+	})
+	log.Debug(fname)
+
+	if blobClient.SupportsLocking() {
+		return tryOverwriteRemoteStoreIndexWithLocking(ctx, storeIndex, blobClient)
+	}
+	return tryOverwriteRemoteStoreIndexWithoutLocking(ctx, storeIndex, blobClient)
+}
+
+func tryOverwriteStoreIndexWithRetry(
+	ctx context.Context,
+	storeIndex longtaillib.Longtail_StoreIndex,
+	blobClient longtailstorelib.BlobClient) error {
+	const fname = "tryOverwriteStoreIndexWithRetry"
+	log := logrus.WithFields(logrus.Fields{
+		"fname":      fname,
+		"blobClient": blobClient,
+	})
+	log.Debug(fname)
+
+	errorRetries := 0
+	for {
+		ok, err := tryOverwriteRemoteStoreIndex(
+			ctx,
+			storeIndex,
+			blobClient)
+		if ok {
+			return nil
+		}
+		if err != nil {
+			errorRetries++
+			if errorRetries == 3 {
+				log.Errorf("Failed updating remote store after %d tryAddRemoteStoreIndex: %s", 3, err)
+				return errors.Wrapf(err, fname)
+			} else {
+				log.Warnf("Error from tryOverwriteStoreIndexWithRetry %s", err)
+			}
+		}
+		log.Debug("Retrying updating remote store index")
+	}
+}
+
+// tryCompactRemoteStoreIndex merges all store index items into one item and deletes the items it replaces
+// The merged item is written before any item is deleted so readers always find every block in the listed items
+func tryCompactRemoteStoreIndex(
+	ctx context.Context,
+	client longtailstorelib.BlobClient,
+	minItems int) (bool, int, error) {
+	const fname = "tryCompactRemoteStoreIndex"
+	log := logrus.WithFields(logrus.Fields{
+		"fname":    fname,
+		"client":   client.String(),
+		"minItems": minItems,
+	})
+	log.Debug(fname)
+
+	listedItems, err := getStoreStoreIndexes(ctx, client)
 	if err != nil {
-		return false, errors.Wrap(err, fname)
+		return false, 0, errors.Wrap(err, fname)
+	}
+	if len(listedItems) < 2 || len(listedItems) < minItems {
+		log.WithField("items", len(listedItems)).Info("store index does not need compaction")
+		return true, 0, nil
 	}
 
-	exists, err := objHandle.Exists()
+	storeIndex, items, err := readStoreStoreIndexWithItems(ctx, client)
 	if err != nil {
-		return false, errors.Wrap(err, fname)
+		return false, 0, errors.Wrap(err, fname)
 	}
-	if exists {
-		return false, nil
+	defer storeIndex.Dispose()
+	if len(items) < 2 {
+		return true, 0, nil
 	}
 
-	ok, err := objHandle.Write(storeBlob.ToBuffer())
-	if !ok || err != nil {
-		return ok, errors.Wrap(err, fname)
+	keepItem := "store.lsi"
+	if client.SupportsLocking() {
+		ok, newStoreIndex, err := tryAddRemoteStoreIndexWithLocking(ctx, storeIndex, client)
+		newStoreIndex.Dispose()
+		if !ok || err != nil {
+			return false, 0, errors.Wrap(err, fname)
+		}
+	} else {
+		keepItem, err = writeContentAddressedStoreIndex(ctx, client, storeIndex)
+		if err != nil {
+			return false, 0, errors.Wrap(err, fname)
+		}
 	}
 
-	log.WithFields(logrus.Fields{"path": objHandle.String(), "bytes": storeBlob.Size()}).Info("wrote store index")
-
-	for _, item := range existingIndexItems {
-		objHandle, err := blobClient.NewObject(item)
-		if err != nil {
+	for _, item := range items {
+		if item == keepItem {
 			continue
 		}
-		err = objHandle.Delete()
+		objHandle, err := client.NewObject(item)
+		if err == nil {
+			err = objHandle.Delete(ctx)
+		}
 		if err != nil {
-			continue
+			// A remaining item only holds blocks that are also in the merged item, it is merged again next time
+			log.WithError(err).Warnf("Failed deleting compacted store index item `%s`", item)
 		}
 	}
+	log.WithFields(logrus.Fields{"items": len(items), "blocks": len(storeIndex.GetBlockHashes())}).Info("compacted store index")
+	return true, len(items), nil
+}
 
-	return true, nil
+// writeContentAddressedStoreIndex writes storeIndex as a store index item named after the hash of its content
+// and returns the name of the item, the item is not written again if it already exists
+func writeContentAddressedStoreIndex(
+	ctx context.Context,
+	client longtailstorelib.BlobClient,
+	storeIndex longtaillib.Longtail_StoreIndex) (string, error) {
+	const fname = "writeContentAddressedStoreIndex"
+	storeBlob, err := longtaillib.WriteStoreIndexToBuffer(storeIndex)
+	if err != nil {
+		err = errors.Wrap(err, "Failed serializing store index")
+		return "", errors.Wrap(err, fname)
+	}
+	defer storeBlob.Dispose()
+	key := fmt.Sprintf("store_%x.lsi", sha256.Sum256(storeBlob.ToBuffer()))
+	objHandle, err := client.NewObject(key)
+	if err != nil {
+		return "", errors.Wrap(err, fname)
+	}
+	exists, err := objHandle.Exists(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, fname)
+	}
+	if !exists {
+		ok, err := objHandle.Write(ctx, storeBlob.ToBuffer())
+		if err != nil {
+			return "", errors.Wrap(err, fname)
+		}
+		if !ok {
+			return "", errors.Wrap(longtailstorelib.ErrBlobThrottled, fname)
+		}
+		logrus.WithFields(logrus.Fields{"fname": fname, "path": objHandle.String(), "bytes": storeBlob.Size()}).Info("wrote store index")
+	}
+	return key, nil
 }
 
-func tryAddRemoteStoreIndex(
+// keepBlocksExcept returns the blocks of storeIndex that are not in removeBlocks and how many blocks were left out
+func keepBlocksExcept(storeIndex longtaillib.Longtail_StoreIndex, removeBlocks map[uint64]bool) ([]uint64, int) {
+	blockHashes := storeIndex.GetBlockHashes()
+	keepBlockHashes := make([]uint64, 0, len(blockHashes))
+	for _, blockHash := range blockHashes {
+		if !removeBlocks[blockHash] {
+			keepBlockHashes = append(keepBlockHashes, blockHash)
+		}
+	}
+	return keepBlockHashes, len(blockHashes) - len(keepBlockHashes)
+}
+
+// tryRemoveBlocksFromRemoteStoreIndex rewrites the store index without removeBlocks following the same protocol
+// as uploads, so blocks that concurrent uploads add to the store index are kept
+func tryRemoveBlocksFromRemoteStoreIndex(
 	ctx context.Context,
-	addStoreIndex longtaillib.Longtail_StoreIndex,
-	blobClient longtailstorelib.BlobClient) (bool, longtaillib.Longtail_StoreIndex, error) {
-	const fname = "tryAddRemoteStoreIndex"
+	client longtailstorelib.BlobClient,
+	removeBlocks map[uint64]bool) (bool, error) {
+	const fname = "tryRemoveBlocksFromRemoteStoreIndex"
 	log := logrus.WithFields(logrus.Fields{
-		"fname":      fname,
-		"blobClient": blobClient.String(),
+		"fname":             fname,
+		"client":            client.String(),
+		"len(removeBlocks)": len(removeBlocks),
 	})
 	log.Debug(fname)
 
-	if blobClient.SupportsLocking() {
-		return tryAddRemoteStoreIndexWithLocking(ctx, addStoreIndex, blobClient)
+	if client.SupportsLocking() {
+		// Uploads to a locking store only update store.lsi, so merge any other items into it first
+		_, err := compactRemoteStoreIndex(ctx, client, 2)
+		if err != nil {
+			return false, errors.Wrap(err, fname)
+		}
+		key := "store.lsi"
+		objHandle, err := client.NewObject(key)
+		if err != nil {
+			return false, errors.Wrap(err, fname)
+		}
+		exists, err := objHandle.LockWriteVersion(ctx)
+		if err != nil {
+			return false, errors.Wrap(err, fname)
+		}
+		if !exists {
+			return true, nil
+		}
+		blob, err := objHandle.Read(ctx)
+		if err != nil {
+			return false, errors.Wrap(err, fname)
+		}
+		storeIndex, err := longtaillib.ReadStoreIndexFromBuffer(blob)
+		if err != nil {
+			err = errors.Wrap(err, fmt.Sprintf("Cant parse store index from `%s/%s`", client.String(), key))
+			return false, errors.Wrap(err, fname)
+		}
+		defer storeIndex.Dispose()
+		keepBlockHashes, removedCount := keepBlocksExcept(storeIndex, removeBlocks)
+		if removedCount == 0 {
+			return true, nil
+		}
+		prunedIndex, err := longtaillib.PruneStoreIndex(storeIndex, keepBlockHashes)
+		if err != nil {
+			return false, errors.Wrap(err, fname)
+		}
+		defer prunedIndex.Dispose()
+		storeBlob, err := longtaillib.WriteStoreIndexToBuffer(prunedIndex)
+		if err != nil {
+			err = errors.Wrap(err, "Failed serializing store index")
+			return false, errors.Wrap(err, fname)
+		}
+		defer storeBlob.Dispose()
+		ok, err := objHandle.Write(ctx, storeBlob.ToBuffer())
+		if !ok || err != nil {
+			return false, errors.Wrap(err, fname)
+		}
+		log.WithFields(logrus.Fields{"removed": removedCount, "path": objHandle.String()}).Info("removed blocks from store index")
+		return true, nil
 	}
 
-	storeIndex, items, err := readStoreStoreIndexWithItems(ctx, blobClient)
+	storeIndex, items, err := readStoreStoreIndexWithItems(ctx, client)
 	if err != nil {
-		return false, longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+		return false, errors.Wrap(err, fname)
 	}
-
-	if !storeIndex.IsValid() {
-		ok, err := tryWriteRemoteStoreIndex(ctx, addStoreIndex, items, blobClient)
-		return ok, longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+	defer storeIndex.Dispose()
+	keepBlockHashes, removedCount := keepBlocksExcept(storeIndex, removeBlocks)
+	if removedCount == 0 {
+		return true, nil
 	}
-
-	mergedStoreIndex, err := longtaillib.MergeStoreIndex(storeIndex, addStoreIndex)
-	storeIndex.Dispose()
+	prunedIndex, err := longtaillib.PruneStoreIndex(storeIndex, keepBlockHashes)
 	if err != nil {
-		err = errors.Wrap(err, "Failed merging store index")
-		return false, longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+		return false, errors.Wrap(err, fname)
 	}
-
-	ok, err := tryWriteRemoteStoreIndex(ctx, mergedStoreIndex, items, blobClient)
+	defer prunedIndex.Dispose()
+	keepItem, err := writeContentAddressedStoreIndex(ctx, client, prunedIndex)
 	if err != nil {
-		return false, longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+		return false, errors.Wrap(err, fname)
 	}
-	return ok, mergedStoreIndex, nil
+	for _, item := range items {
+		if item == keepItem {
+			continue
+		}
+		// Unlike compaction a remaining item still lists removed blocks, so it must be deleted before the blocks are
+		objHandle, err := client.NewObject(item)
+		if err == nil {
+			err = objHandle.Delete(ctx)
+		}
+		if err != nil {
+			return false, errors.Wrap(err, fname)
+		}
+	}
+	log.WithFields(logrus.Fields{"removed": removedCount, "items": len(items)}).Info("removed blocks from store index")
+	return true, nil
 }
 
-func addToRemoteStoreIndex(
+func removeBlocksFromRemoteStoreIndex(
 	ctx context.Context,
-	blobClient longtailstorelib.BlobClient,
-	addStoreIndex longtaillib.Longtail_StoreIndex) (longtaillib.Longtail_StoreIndex, error) {
-	const fname = "addToRemoteStoreIndex"
+	client longtailstorelib.BlobClient,
+	removeBlocks map[uint64]bool) error {
+	const fname = "removeBlocksFromRemoteStoreIndex"
 	log := logrus.WithFields(logrus.Fields{
-		"fname":      fname,
-		"blobClient": blobClient,
+		"fname":             fname,
+		"client":            client.String(),
+		"len(removeBlocks)": len(removeBlocks),
 	})
 	log.Debug(fname)
 
 	errorRetries := 0
 	for {
-		ok, newStoreIndex, err := tryAddRemoteStoreIndex(
-			ctx,
-			addStoreIndex,
-			blobClient)
+		ok, err := tryRemoveBlocksFromRemoteStoreIndex(ctx, client, removeBlocks)
 		if ok {
-			return newStoreIndex, nil
+			return nil
 		}
 		if err != nil {
 			errorRetries++
 			if errorRetries == 3 {
-				log.Errorf("Failed updating remote store after %d tryAddRemoteStoreIndex: %s", 3, err)
-				return longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+				log.Errorf("Failed updating remote store index after %d tryRemoveBlocksFromRemoteStoreIndex: %s", 3, err)
+				return errors.Wrap(err, fname)
 			} else {
-				log.Warnf("Error from tryAddRemoteStoreIndex %s", err)
+				log.Warnf("Error from tryRemoveBlocksFromRemoteStoreIndex %s", err)
 			}
 		}
+		if ctx.Err() != nil {
+			return errors.Wrap(cancelledError(ctx), fname)
+		}
 		log.Debug("Retrying updating remote store index")
 	}
-	return longtaillib.Longtail_StoreIndex{}, nil
 }
 
-func tryOverwriteRemoteStoreIndexWithLocking(
+// markPruneCandidates replaces the prune candidates with blockHashes, blocks that were already marked keep their time
+// Blocks that have already been removed from the store index stay candidates until they are swept
+func markPruneCandidates(
 	ctx context.Context,
-	storeIndex longtaillib.Longtail_StoreIndex,
-	client longtailstorelib.BlobClient) (bool, error) {
-	const fname = "tryOverwriteRemoteStoreIndexWithLocking"
-	log := logrus.WithFields(logrus.Fields{
-		"fname":  fname,
-		"client": client,
-	})
-	log.Debug(fname)
-
-	storeBlob, err := longtaillib.WriteStoreIndexToBuffer(storeIndex)
+	client longtailstorelib.BlobClient,
+	blockHashes []uint64) error {
+	const fname = "markPruneCandidates"
+	previous, unindexed, err := readPruneCandidates(ctx, client)
 	if err != nil {
-		err := errors.Wrap(err, "Failed serializing store index")
-		return false, errors.Wrap(err, fname)
+		return errors.Wrap(err, fname)
 	}
-	defer storeBlob.Dispose()
-
-	key := "store.lsi"
-	objHandle, err := client.NewObject(key)
+	now := time.Now().UTC()
+	candidates := make(map[uint64]time.Time, len(blockHashes))
+	for _, blockHash := range blockHashes {
+		if _, isUnindexed := unindexed[blockHash]; isUnindexed {
+			continue
+		}
+		markedTime, exists := previous[blockHash]
+		if !exists {
+			markedTime = now
+		}
+		candidates[blockHash] = markedTime
+	}
+	err = writePruneCandidates(ctx, client, candidates, unindexed)
 	if err != nil {
-		return false, errors.Wrap(err, fname)
+		return errors.Wrap(err, fname)
 	}
+	return nil
+}
 
-	_, err = objHandle.LockWriteVersion()
+// restorePruneCandidates adds the blocks of blockHashes that are not in the store index back to it
+func restorePruneCandidates(
+	ctx context.Context,
+	client longtailstorelib.BlobClient,
+	indexedBlocks map[uint64]bool,
+	blockHashes []uint64) (int, error) {
+	const fname = "restorePruneCandidates"
+	blockIndexes := []longtaillib.Longtail_BlockIndex{}
+	defer func() {
+		for _, blockIndex := range blockIndexes {
+			blockIndex.Dispose()
+		}
+	}()
+	for _, blockHash := range blockHashes {
+		if indexedBlocks[blockHash] {
+			continue
+		}
+		blockPath := getBlockPath("chunks", blockHash)
+		storedBlockData, _, err := longtailutils.ReadBlobWithRetry(ctx, client, nil, blockPath)
+		if longtaillib.IsNotExist(err) {
+			logrus.WithFields(logrus.Fields{"fname": fname, "blockHash": blockHash}).Warnf("Rescued block `%s` is missing", blockPath)
+			continue
+		}
+		if err != nil {
+			return 0, errors.Wrap(err, fname)
+		}
+		blockIndex, err := longtaillib.ReadBlockIndexFromBuffer(storedBlockData)
+		if err != nil {
+			err = errors.Wrapf(err, "Failed reading block index from `%s`", blockPath)
+			return 0, errors.Wrap(err, fname)
+		}
+		blockIndexes = append(blockIndexes, blockIndex)
+	}
+	if len(blockIndexes) == 0 {
+		return 0, nil
+	}
+	restoreIndex, err := longtaillib.CreateStoreIndexFromBlocks(blockIndexes)
 	if err != nil {
-		return false, errors.Wrap(err, fname)
+		return 0, errors.Wrap(err, fname)
 	}
-
-	ok, err := objHandle.Write(storeBlob.ToBuffer())
+	defer restoreIndex.Dispose()
+	newStoreIndex, err := addToRemoteStoreIndex(ctx, client, restoreIndex)
 	if err != nil {
-		return false, errors.Wrap(err, fname)
+		return 0, errors.Wrap(err, fname)
 	}
-	return ok, nil
+	newStoreIndex.Dispose()
+	return len(blockIndexes), nil
 }
 
-func tryOverwriteRemoteStoreIndexWithoutLocking(
+// sweepPruneCandidates removes the candidates that were marked before gracePeriod ago and not rescued since from
+// the store index. The blocks are deleted by a later sweep once they have been out of the store index for gracePeriod
+// so uploads that read the store index before they were removed can still rescue them
+// Rescued candidates are no longer marked, a later prune marks them again if they are still unused
+func sweepPruneCandidates(
 	ctx context.Context,
-	storeIndex longtaillib.Longtail_StoreIndex,
-	client longtailstorelib.BlobClient) (bool, error) {
-	const fname = "tryOverwriteRemoteStoreIndexWithoutLocking"
+	client longtailstorelib.BlobClient,
+	gracePeriod time.Duration) (int, int, error) {
+	const fname = "sweepPruneCandidates"
 	log := logrus.WithFields(logrus.Fields{
-		"fname":  fname,
-		"client": client.String(),
+		"fname":       fname,
+		"client":      client.String(),
+		"gracePeriod": gracePeriod,
 	})
 	log.Debug(fname)
 
-	items, err := getStoreStoreIndexes(ctx, client)
+	candidates, unindexed, err := readPruneCandidates(ctx, client)
 	if err != nil {
-		return false, errors.Wrap(err, fname)
+		return 0, 0, errors.Wrap(err, fname)
 	}
-
-	storeBlob, err := longtaillib.WriteStoreIndexToBuffer(storeIndex)
+	rescued, err := listPruneRescueRecords(ctx, client)
 	if err != nil {
-		err = errors.Wrap(err, "Failed serializing store index")
-		return false, errors.Wrap(err, fname)
+		return 0, 0, errors.Wrap(err, fname)
+	}
+
+	now := time.Now().UTC()
+	expiredBefore := now.Add(-gracePeriod)
+	rescuedCount := 0
+	remaining := map[uint64]time.Time{}
+	remainingUnindexed := map[uint64]time.Time{}
+	unindexBlocks := map[uint64]bool{}
+	for blockHash, markedTime := range candidates {
+		if _, isRescued := rescued[blockHash]; isRescued {
+			rescuedCount++
+			continue
+		}
+		if markedTime.Before(expiredBefore) {
+			unindexBlocks[blockHash] = true
+			remainingUnindexed[blockHash] = now
+			continue
+		}
+		remaining[blockHash] = markedTime
+	}
+	restoreBlockHashes := []uint64{}
+	deleteBlockHashes := []uint64{}
+	for blockHash, unindexedTime := range unindexed {
+		if _, isRescued := rescued[blockHash]; isRescued {
+			rescuedCount++
+			restoreBlockHashes = append(restoreBlockHashes, blockHash)
+			continue
+		}
+		if unindexedTime.Before(expiredBefore) {
+			deleteBlockHashes = append(deleteBlockHashes, blockHash)
+			continue
+		}
+		remainingUnindexed[blockHash] = unindexedTime
 	}
-	defer storeBlob.Dispose()
 
-	sha256 := sha256.Sum256(storeBlob.ToBuffer())
-	key := fmt.Sprintf("store_%x.lsi", sha256)
+	deletedCount := 0
+	if len(restoreBlockHashes) > 0 || len(deleteBlockHashes) > 0 {
+		storeIndex, _, err := readStoreStoreIndexWithItems(ctx, client)
+		if err != nil {
+			return 0, 0, errors.Wrap(err, fname)
+		}
+		indexedBlocks := map[uint64]bool{}
+		for _, blockHash := range storeIndex.GetBlockHashes() {
+			indexedBlocks[blockHash] = true
+		}
+		storeIndex.Dispose()
 
-	objHandle, err := client.NewObject(key)
-	if err != nil {
-		return false, errors.Wrap(err, fname)
-	}
+		restoredCount, err := restorePruneCandidates(ctx, client, indexedBlocks, restoreBlockHashes)
+		if err != nil {
+			return 0, 0, errors.Wrap(err, fname)
+		}
+		if restoredCount > 0 {
+			log.WithField("blocks", restoredCount).Info("restored rescued blocks to store index")
+		}
 
-	if err != nil {
-		return false, errors.Wrap(err, fname)
+		for _, blockHash := range deleteBlockHashes {
+			// The block was uploaded again by a writer that did not see it in the store index
+			if indexedBlocks[blockHash] {
+				continue
+			}
+			// A writer may have rescued the block after the rescue records were listed, check right before deleting it
+			recordPath := GetPruneRescueRecordPath(blockHash)
+			lateRescue, err := pruneRescueRecordExists(ctx, client, recordPath)
+			if err != nil {
+				return deletedCount, 0, errors.Wrap(err, fname)
+			}
+			if lateRescue {
+				restoredCount, err := restorePruneCandidates(ctx, client, indexedBlocks, []uint64{blockHash})
+				if err != nil {
+					return deletedCount, 0, errors.Wrap(err, fname)
+				}
+				if restoredCount > 0 {
+					log.WithField("blockHash", blockHash).Info("restored block rescued during sweep to store index")
+				}
+				rescued[blockHash] = recordPath
+				rescuedCount++
+				continue
+			}
+			objHandle, err := client.NewObject(getBlockPath("chunks", blockHash))
+			if err != nil {
+				return deletedCount, 0, errors.Wrap(err, fname)
+			}
+			_, err = longtailstorelib.RetryBlobOperation(ctx, client, nil, func() error {
+				return objHandle.Delete(ctx)
+			})
+			if err != nil && !longtaillib.IsNotExist(err) {
+				return deletedCount, 0, errors.Wrap(err, fname)
+			}
+			deletedCount++
+		}
 	}
 
-	exists, err := objHandle.Exists()
+	// Record the blocks as unindexed before removing them from the store index so they are not lost if we fail
+	err = writePruneCandidates(ctx, client, remaining, remainingUnindexed)
 	if err != nil {
-		return false, errors.Wrap(err, fname)
+		return deletedCount, rescuedCount, errors.Wrap(err, fname)
 	}
-	if !exists {
-		ok, err := objHandle.Write(storeBlob.ToBuffer())
-		if !ok || err != nil {
-			return ok, errors.Wrap(err, fname)
+	if len(unindexBlocks) > 0 {
+		err = removeBlocksFromRemoteStoreIndex(ctx, client, unindexBlocks)
+		if err != nil {
+			return deletedCount, rescuedCount, errors.Wrap(err, fname)
 		}
 	}
-
-	for _, item := range items {
-		if item == key {
-			continue
-		}
-		objHandle, err := client.NewObject(item)
-		if err != nil {
-			continue
+	for _, path := range rescued {
+		objHandle, err := client.NewObject(path)
+		if err == nil {
+			err = objHandle.Delete(ctx)
 		}
-		err = objHandle.Delete()
 		if err != nil {
-			continue
+			// A left over record only rescues the block from the next sweep
+			log.WithError(err).Warnf("Failed deleting prune rescue record `%s`", path)
 		}
 	}
-
-	return true, nil
-}
-
-func tryOverwriteRemoteStoreIndex(
-	ctx context.Context,
-	storeIndex longtaillib.Longtail_StoreIndex,
-	blobClient longtailstorelib.BlobClient) (bool, error) {
-	const fname = "tryOverwriteRemoteStoreIndex"
-	log := logrus.WithFields(logrus.Fields{
-		"fname":      fname,
-		"blobClient": blobClient,
-	})
-	log.Debug(fname)
-
-	if blobClient.SupportsLocking() {
-		return tryOverwriteRemoteStoreIndexWithLocking(ctx, storeIndex, blobClient)
-	}
-	return tryOverwriteRemoteStoreIndexWithoutLocking(ctx, storeIndex, blobClient)
+	log.WithFields(logrus.Fields{"unindexed": len(unindexBlocks), "deleted": deletedCount, "rescued": rescuedCount, "remaining": len(remaining) + len(remainingUnindexed)}).Info("swept prune candidates")
+	return deletedCount, rescuedCount, nil
 }
 
-func tryOverwriteStoreIndexWithRetry(
+func compactRemoteStoreIndex(
 	ctx context.Context,
-	storeIndex longtaillib.Longtail_StoreIndex,
-	blobClient longtailstorelib.BlobClient) error {
-	const fname = "tryOverwriteStoreIndexWithRetry"
+	client longtailstorelib.BlobClient,
+	minItems int) (int, error) {
+	const fname = "compactRemoteStoreIndex"
 	log := logrus.WithFields(logrus.Fields{
-		"fname":      fname,
-		"blobClient": blobClient,
+		"fname":    fname,
+		"client":   client.String(),
+		"minItems": minItems,
 	})
 	log.Debug(fname)
 
 	errorRetries := 0
 	for {
-		ok, err := tryOverwriteRemoteStoreIndex(
-			ctx,
-			storeIndex,
-			blobClient)
+		ok, compactedItems, err := tryCompactRemoteStoreIndex(ctx, client, minItems)
 		if ok {
-			return nil
+			return compactedItems, nil
 		}
 		if err != nil {
 			errorRetries++
 			if errorRetries == 3 {
-				log.Errorf("Failed updating remote store after %d tryAddRemoteStoreIndex: %s", 3, err)
-				return errors.Wrapf(err, fname)
+				log.Errorf("Failed compacting remote store index after %d tryCompactRemoteStoreIndex: %s", 3, err)
+				return 0, errors.Wrap(err, fname)
 			} else {
-				log.Warnf("Error from tryOverwriteStoreIndexWithRetry %s", err)
+				log.Warnf("Error from tryCompactRemoteStoreIndex %s", err)
 			}
 		}
-		log.Debug("Retrying updating remote store index")
+		if ctx.Err() != nil {
+			return 0, errors.Wrap(cancelledError(ctx), fname)
+		}
+		log.Debug("Retrying compacting remote store index")
 	}
 }
 
@@ -1540,6 +2890,7 @@ func getStoreIndexFromBlocks(
 				storedBlockData, _, err := longtailutils.ReadBlobWithRetry(
 					ctx,
 					client,
+					nil,
 					blockKey)
 
 				if err != nil {
@@ -1616,20 +2967,23 @@ func buildStoreIndexFromStoreBlocks(
 	log.Debug(fname)
 
 	var items []string
-	blobs, err := blobClient.GetObjects("")
+	_, err := longtailstorelib.RetryBlobOperation(ctx, blobClient, nil, func() error {
+		// Start over if the listing failed part way through
+		items = nil
+		return blobClient.WalkObjects("", func(blob longtailstorelib.BlobProperties) error {
+			if blob.Size == 0 {
+				return nil
+			}
+			if strings.HasSuffix(blob.Name, ".lsb") {
+				items = append(items, blob.Name)
+			}
+			return nil
+		})
+	})
 	if err != nil {
 		return longtaillib.Longtail_StoreIndex{}, errors.Wrapf(err, fname)
 	}
 
-	for _, blob := range blobs {
-		if blob.Size == 0 {
-			continue
-		}
-		if strings.HasSuffix(blob.Name, ".lsb") {
-			items = append(items, blob.Name)
-		}
-	}
-
 	return getStoreIndexFromBlocks(ctx, blobStore, blobClient, workerCount, items)
 }
 
@@ -1645,7 +2999,7 @@ func readStoreStoreIndexFromPath(
 	})
 	log.Debug(fname)
 
-	blobData, _, err := longtailutils.ReadBlobWithRetry(ctx, client, key)
+	blobData, _, err := longtailutils.ReadBlobWithRetry(ctx, client, nil, key)
 	if err != nil {
 		return longtaillib.Longtail_StoreIndex{}, errors.Wrapf(err, fname)
 	}
@@ -1665,31 +3019,42 @@ func getStoreStoreIndexes(
 	ctx context.Context,
 	client longtailstorelib.BlobClient) ([]string, error) {
 	const fname = "getStoreStoreIndexes"
+	blobs, err := listStoreStoreIndexes(ctx, client)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	var items []string
+	for _, blob := range blobs {
+		items = append(items, blob.Name)
+	}
+	return items, nil
+}
+
+// listStoreStoreIndexes returns the store index items with their versions
+func listStoreStoreIndexes(
+	ctx context.Context,
+	client longtailstorelib.BlobClient) ([]longtailstorelib.BlobProperties, error) {
+	const fname = "listStoreStoreIndexes"
 	log := logrus.WithFields(logrus.Fields{
 		"fname":  fname,
 		"client": client.String(),
 	})
 	log.Debug(fname)
 
-	var items []string
-	retryCount := 0
-	retryDelay := []time.Duration{0, 100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond, 1 * time.Second, 2 * time.Second}
-	blobs, err := client.GetObjects("store")
-	for err != nil {
-		if longtaillib.IsNotExist(err) {
-			return items, nil
-		}
-		if retryCount == len(retryDelay) {
-			err = errors.Wrapf(err, "Failed list store indexes in store %s", client.String())
-			log.Error(err)
-			return nil, errors.Wrap(err, fname)
-		}
-		err = errors.Wrapf(err, "Retrying list store indexes in store %s with %s delay", client.String(), retryDelay[retryCount])
-		log.Info(err)
-
-		time.Sleep(retryDelay[retryCount])
-		retryCount++
+	var items []longtailstorelib.BlobProperties
+	var blobs []longtailstorelib.BlobProperties
+	retryCount, err := longtailstorelib.RetryBlobOperation(ctx, client, nil, func() error {
+		var err error
 		blobs, err = client.GetObjects("store")
+		return err
+	})
+	if longtaillib.IsNotExist(err) {
+		return items, nil
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "Failed list store indexes in store %s after %d retries", client.String(), retryCount)
+		log.Error(err)
+		return nil, errors.Wrap(err, fname)
 	}
 
 	for _, blob := range blobs {
@@ -1697,7 +3062,7 @@ func getStoreStoreIndexes(
 			continue
 		}
 		if strings.HasSuffix(blob.Name, ".lsi") {
-			items = append(items, blob.Name)
+			items = append(items, blob)
 		}
 	}
 	return items, nil
@@ -1746,6 +3111,123 @@ func mergeStoreIndexItems(
 	return storeIndex, usedItems, nil
 }
 
+// readCachedStoreStoreIndex reads the store index like readStoreStoreIndexWithItems but uses cache
+// to skip downloading index items, and merging them, if they have not changed since they were cached
+// Each item is revalidated with a conditional read so the cached items of a mirrored store are only
+// used if the copy that serves the read still has the same version
+func readCachedStoreStoreIndex(
+	ctx context.Context,
+	client longtailstorelib.BlobClient,
+	cache *longtailstorelib.IndexCache) (longtaillib.Longtail_StoreIndex, error) {
+	const fname = "readCachedStoreStoreIndex"
+	log := logrus.WithFields(logrus.Fields{
+		"fname":  fname,
+		"client": client.String(),
+		"cache":  cache.String(),
+	})
+	log.Debug(fname)
+
+	for {
+		items, err := listStoreStoreIndexes(ctx, client)
+		if err != nil {
+			return longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+		}
+		if len(items) == 0 {
+			storeIndex, _, err := readStoreStoreIndexWithItems(ctx, client)
+			return storeIndex, err
+		}
+		sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+
+		// The merged index is valid for as long as the same versions of the same items are read
+		mergedHash := sha256.New()
+		versioned := true
+		itemsData := make([][]byte, 0, len(items))
+		retry := false
+		for _, item := range items {
+			object, err := client.NewObject(item.Name)
+			if err != nil {
+				return longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+			}
+			var data []byte
+			var version string
+			_, err = longtailstorelib.RetryBlobOperation(ctx, client, nil, func() error {
+				var err error
+				data, version, _, err = cache.Read(ctx, longtailstorelib.IndexCacheKey(client, item.Name), object)
+				return err
+			})
+			if err == nil && len(data) == 0 {
+				err = errors.Wrap(os.ErrNotExist, fmt.Sprintf("%s/%s contains no data", client.String(), item.Name))
+			}
+			if longtaillib.IsNotExist(err) {
+				// The item was replaced while we were reading, start over with a new listing
+				retry = true
+				break
+			}
+			if err != nil {
+				return longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+			}
+			if version == "" {
+				versioned = false
+			}
+			fmt.Fprintf(mergedHash, "%s\x00%s\n", item.Name, version)
+			itemsData = append(itemsData, data)
+		}
+		if retry {
+			log.Infof("Retrying reading remote store index")
+			continue
+		}
+
+		mergedKey := longtailstorelib.IndexCacheKey(client, "store.lsi+merged")
+		mergedVersion := hex.EncodeToString(mergedHash.Sum(nil))
+		if versioned {
+			if data, ok := cache.Get(mergedKey, mergedVersion); ok {
+				storeIndex, err := longtaillib.ReadStoreIndexFromBuffer(data)
+				if err == nil {
+					log.Infof("read store index from index cache")
+					return storeIndex, nil
+				}
+				log.WithError(err).Warn("Ignoring invalid store index in index cache")
+			}
+		} else {
+			log.Info("store does not report object versions, merged store index is not cached")
+		}
+
+		storeIndex := longtaillib.Longtail_StoreIndex{}
+		for i, data := range itemsData {
+			itemStoreIndex, err := longtaillib.ReadStoreIndexFromBuffer(data)
+			if err != nil {
+				storeIndex.Dispose()
+				err = errors.Wrap(err, fmt.Sprintf("Cant parse store index from `%s/%s`", client.String(), items[i].Name))
+				return longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+			}
+			if !storeIndex.IsValid() {
+				storeIndex = itemStoreIndex
+				continue
+			}
+			mergedStoreIndex, err := longtaillib.MergeStoreIndex(storeIndex, itemStoreIndex)
+			itemStoreIndex.Dispose()
+			storeIndex.Dispose()
+			if err != nil {
+				err := errors.Wrap(err, "longtaillib.MergeStoreIndex() failed")
+				return longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+			}
+			storeIndex = mergedStoreIndex
+		}
+
+		if versioned {
+			mergedBuffer, err := longtaillib.WriteStoreIndexToBuffer(storeIndex)
+			if err == nil {
+				err = cache.Put(mergedKey, mergedVersion, mergedBuffer.ToBuffer())
+				mergedBuffer.Dispose()
+			}
+			if err != nil {
+				log.WithError(err).Warn("Failed to update index cache")
+			}
+		}
+		return storeIndex, nil
+	}
+}
+
 func readStoreStoreIndexWithItems(
 	ctx context.Context,
 	client longtailstorelib.BlobClient) (longtaillib.Longtail_StoreIndex, []string, error) {
@@ -1829,7 +3311,7 @@ func readStoreIndexFromURI(
 		"blobStoreOptions": blobStoreOptions,
 	})
 	log.Debug(fname)
-	sbuffer, err := longtailutils.ReadFromURI(path, blobStoreOptions...)
+	sbuffer, err := longtailutils.ReadFromURI(ctx, path, blobStoreOptions...)
 	if err != nil {
 		if longtaillib.IsNotExist(err) {
 			log.WithError(err).Info("Failed reading store index")
@@ -1921,7 +3403,11 @@ func readRemoteStoreIndex(
 		return storeIndex, nil
 	}
 
-	storeIndex, _, err = readStoreStoreIndexWithItems(ctx, client)
+	if cache := longtailstorelib.GetIndexCache(blobStoreOptions...); cache != nil {
+		storeIndex, err = readCachedStoreStoreIndex(ctx, client, cache)
+	} else {
+		storeIndex, _, err = readStoreStoreIndexWithItems(ctx, client)
+	}
 	if err == nil {
 		return storeIndex, nil
 	} else if !longtaillib.IsNotExist(err) {
@@ -1937,6 +3423,11 @@ func readRemoteStoreIndex(
 	return storeIndex, nil
 }
 
+// cancelledError is returned for operations that fail or are skipped because ctx is done
+func cancelledError(ctx context.Context) error {
+	return errors.Wrap(longtaillib.CancelledErr(), ctx.Err().Error())
+}
+
 func getBlockPath(basePath string, blockHash uint64) string {
 	fileName := fmt.Sprintf("0x%016x.lsb", blockHash)
 	dir := filepath.Join(basePath, fileName[2:6])
@@ -1946,6 +3437,7 @@ func getBlockPath(basePath string, blockHash uint64) string {
 }
 
 func CreateBlockStoreForURI(
+	ctx context.Context,
 	uri string,
 	optionalStoreIndexPaths []string,
 	jobAPI longtaillib.Longtail_JobAPI,
@@ -1966,90 +3458,324 @@ func CreateBlockStoreForURI(
 	})
 	log.Debug(fname)
 
-	// Special case since filepaths may not parse nicely as a url
-	if strings.HasPrefix(uri, "fsblob://") {
-		fsBlobStore, err := longtailstorelib.NewFSBlobStore(uri[len("fsblob://"):], true)
-		if err != nil {
-			return longtaillib.Longtail_BlockStoreAPI{}, errors.Wrap(err, fname)
+	opts = append(longtailstorelib.GetBlobStoreOptions(ctx), opts...)
+
+	u, scheme, ok := longtailstorelib.LookupBlobStoreScheme(uri)
+	if !ok || scheme.Name == "file" {
+		path := uri
+		if ok {
+			path = longtailstorelib.FileSystemPathFromURL(u)
+		}
+		// Local file system stores, including UNC paths, use the native block store which is faster than going
+		// through a blob store. The native block store can't encrypt or limit its bandwidth so encrypted and rate
+		// limited stores use the file system blob store instead. It can't rescue prune candidates either so
+		// writers use the blob store while a prune has marked blocks
+		nativeStore := longtailstorelib.GetEncryptionOptions(opts...).KeyRing == nil && longtailstorelib.GetBandwidthOptions(opts...).Limits == nil
+		if nativeStore && !(accessType != ReadOnly && hasPruneCandidates(path)) {
+			return longtaillib.CreateFSBlockStore(jobAPI, longtaillib.CreateFSStorageAPI(), path, ".lsb", enableFileMapping), nil
 		}
+		u, scheme, _ = longtailstorelib.LookupBlobStoreScheme("fsblob://" + path)
+	}
+
+	if scheme.Defaults.ReadOnly && accessType != ReadOnly {
+		err := fmt.Errorf("%s stores are read only, can't open `%s` for writing", scheme.Name, uri)
+		return longtaillib.Longtail_BlockStoreAPI{}, errors.Wrap(err, fname)
+	}
+
+	blobStore, err := scheme.NewBlobStore(u, opts...)
+	if err != nil {
+		return longtaillib.Longtail_BlockStoreAPI{}, errors.Wrap(err, fname)
+	}
+
+	if numWorkerCount == 0 {
+		numWorkerCount = defaultWorkerCount(scheme)
+	}
 
-		if numWorkerCount == 0 {
-			numWorkerCount = runtime.NumCPU()
+	blockStore, err := NewRemoteBlockStore(
+		ctx,
+		jobAPI,
+		blobStore,
+		optionalStoreIndexPaths,
+		numWorkerCount,
+		accessType,
+		opts...)
+	if err != nil {
+		return longtaillib.Longtail_BlockStoreAPI{}, errors.Wrap(err, fname)
+	}
+	return longtaillib.CreateBlockStoreAPI(blockStore), nil
+}
+
+// hasPruneCandidates returns true if the local store at path has blocks marked by a prune with a grace period
+func hasPruneCandidates(path string) bool {
+	_, err := os.Stat(filepath.Join(path, PruneCandidatesPath))
+	return err == nil
+}
+
+// createBlobStoreForStoreURI opens the blob store of the block store at uri, local paths are opened
+// as file system blob stores which share the layout of the native file system block store
+func createBlobStoreForStoreURI(
+	uri string,
+	opts ...longtailstorelib.BlobStoreOption) (longtailstorelib.BlobStore, error) {
+	const fname = "createBlobStoreForStoreURI"
+	u, scheme, ok := longtailstorelib.LookupBlobStoreScheme(uri)
+	if !ok || scheme.Name == "file" {
+		path := uri
+		if ok {
+			path = longtailstorelib.FileSystemPathFromURL(u)
 		}
+		u, scheme, _ = longtailstorelib.LookupBlobStoreScheme("fsblob://" + path)
+	}
+	if scheme.Defaults.ReadOnly {
+		err := fmt.Errorf("%s stores are read only, can't open `%s` for writing", scheme.Name, uri)
+		return nil, errors.Wrap(err, fname)
+	}
+	blobStore, err := scheme.NewBlobStore(u, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return blobStore, nil
+}
 
-		fsBlockStore, err := NewRemoteBlockStore(
-			jobAPI,
-			fsBlobStore,
-			optionalStoreIndexPaths,
-			numWorkerCount,
-			accessType,
-			opts...)
+// CompactStoreIndex merges the store index items of the store at uri into a single item and deletes the
+// items it replaces, following the same locking protocol as uploads so it is safe to run next to them
+// The store index is left as is if it has fewer than minItems items. Returns the number of merged items
+func CompactStoreIndex(
+	ctx context.Context,
+	uri string,
+	minItems int,
+	opts ...longtailstorelib.BlobStoreOption) (int, error) {
+	const fname = "CompactStoreIndex"
+	log := logrus.WithFields(logrus.Fields{
+		"fname":    fname,
+		"uri":      uri,
+		"minItems": minItems,
+		"opts":     opts,
+	})
+	log.Debug(fname)
+
+	opts = append(longtailstorelib.GetBlobStoreOptions(ctx), opts...)
+	blobStore, err := createBlobStoreForStoreURI(uri, opts...)
+	if err != nil {
+		return 0, errors.Wrap(err, fname)
+	}
+	client, err := blobStore.NewClient(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, fname)
+	}
+	defer client.Close()
+	compactedItems, err := compactRemoteStoreIndex(ctx, client, minItems)
+	if err != nil {
+		return 0, errors.Wrap(err, fname)
+	}
+	return compactedItems, nil
+}
+
+// GetBlockPath returns the path of the block with blockHash relative to the root of a store
+func GetBlockPath(blockHash uint64) string {
+	return getBlockPath("chunks", blockHash)
+}
+
+// ReadStoreIndex reads and merges all the store index items of the store at uri
+// An empty store index is returned if the store has no store index
+func ReadStoreIndex(
+	ctx context.Context,
+	uri string,
+	opts ...longtailstorelib.BlobStoreOption) (longtaillib.Longtail_StoreIndex, error) {
+	const fname = "ReadStoreIndex"
+	log := logrus.WithFields(logrus.Fields{
+		"fname": fname,
+		"uri":   uri,
+		"opts":  opts,
+	})
+	log.Debug(fname)
+
+	opts = append(longtailstorelib.GetBlobStoreOptions(ctx), opts...)
+	blobStore, err := longtailstorelib.CreateBlobStoreForURI(uri, opts...)
+	if err != nil {
+		return longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+	}
+	client, err := blobStore.NewClient(ctx)
+	if err != nil {
+		return longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+	}
+	defer client.Close()
+	storeIndex, _, err := readStoreStoreIndexWithItems(ctx, client)
+	if err != nil {
+		return longtaillib.Longtail_StoreIndex{}, errors.Wrap(err, fname)
+	}
+	return storeIndex, nil
+}
+
+func defaultWorkerCount(scheme longtailstorelib.BlobStoreScheme) int {
+	workerCount := runtime.NumCPU()
+	if scheme.Defaults.MaxWorkerCount > 0 && workerCount > scheme.Defaults.MaxWorkerCount {
+		workerCount = scheme.Defaults.MaxWorkerCount
+	}
+	return workerCount
+}
+
+// DefaultWorkerCount returns the number of workers used for the store at uri when no worker count is given,
+// the number of CPUs capped by the limit of the store type
+func DefaultWorkerCount(uri string) int {
+	_, scheme, _ := longtailstorelib.LookupBlobStoreScheme(uri)
+	return defaultWorkerCount(scheme)
+}
+
+// GetStoredBlockSizes returns the stored size of the blocks in blockHashes that exist in the store at uri
+// The size of each block is read from the store in parallel, blocks missing from the store are left out
+func GetStoredBlockSizes(
+	ctx context.Context,
+	uri string,
+	blockHashes []uint64,
+	opts ...longtailstorelib.BlobStoreOption) (map[uint64]uint64, error) {
+	const fname = "GetStoredBlockSizes"
+	log := logrus.WithFields(logrus.Fields{
+		"fname":            fname,
+		"uri":              uri,
+		"len(blockHashes)": len(blockHashes),
+		"opts":             opts,
+	})
+	log.Debug(fname)
+
+	blockSizes := map[uint64]uint64{}
+	if len(blockHashes) == 0 {
+		return blockSizes, nil
+	}
+	opts = append(longtailstorelib.GetBlobStoreOptions(ctx), opts...)
+	blobStore, err := longtailstorelib.CreateBlobStoreForURI(uri, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+
+	workerCount := DefaultWorkerCount(uri)
+	if workerCount > len(blockHashes) {
+		workerCount = len(blockHashes)
+	}
+	clients := make([]longtailstorelib.BlobClient, 0, workerCount)
+	defer func() {
+		for _, client := range clients {
+			client.Close()
+		}
+	}()
+	for i := 0; i < workerCount; i++ {
+		client, err := blobStore.NewClient(ctx)
 		if err != nil {
-			return longtaillib.Longtail_BlockStoreAPI{}, errors.Wrap(err, fname)
+			return nil, errors.Wrap(err, fname)
 		}
-		return longtaillib.CreateBlockStoreAPI(fsBlockStore), nil
+		clients = append(clients, client)
 	}
 
-	blobStoreURL, err := url.Parse(uri)
-	if err == nil {
-		switch blobStoreURL.Scheme {
-		case "gs":
-			gcsBlobStore, err := longtailstorelib.NewGCSBlobStore(blobStoreURL, false)
-			if err != nil {
-				return longtaillib.Longtail_BlockStoreAPI{}, errors.Wrap(err, fname)
-			}
+	blockHashChan := make(chan uint64, len(blockHashes))
+	for _, blockHash := range blockHashes {
+		blockHashChan <- blockHash
+	}
+	close(blockHashChan)
 
-			if numWorkerCount == 0 {
-				numWorkerCount = runtime.NumCPU()
-				if numWorkerCount > 8 {
-					numWorkerCount = 8
+	var wg sync.WaitGroup
+	var blockSizesSync sync.Mutex
+	errs := make([]error, workerCount)
+	wg.Add(workerCount)
+	for i, client := range clients {
+		go func(i int, client longtailstorelib.BlobClient) {
+			defer wg.Done()
+			for blockHash := range blockHashChan {
+				if ctx.Err() != nil {
+					errs[i] = cancelledError(ctx)
+					return
+				}
+				var size int64
+				_, err := longtailstorelib.RetryBlobOperation(ctx, client, nil, func() error {
+					var err error
+					size, err = longtailstorelib.GetObjectSize(ctx, client, GetBlockPath(blockHash))
+					return err
+				})
+				if longtaillib.IsNotExist(err) {
+					continue
+				}
+				if err != nil {
+					errs[i] = err
+					return
 				}
+				blockSizesSync.Lock()
+				blockSizes[blockHash] = uint64(size)
+				blockSizesSync.Unlock()
 			}
+		}(i, client)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, errors.Wrap(err, fname)
+		}
+	}
+	return blockSizes, nil
+}
 
-			gcsBlockStore, err := NewRemoteBlockStore(
-				jobAPI,
-				gcsBlobStore,
-				optionalStoreIndexPaths,
-				numWorkerCount,
-				accessType,
-				opts...)
-			if err != nil {
-				return longtaillib.Longtail_BlockStoreAPI{}, errors.Wrap(err, fname)
-			}
-			return longtaillib.CreateBlockStoreAPI(gcsBlockStore), nil
-		case "s3":
-			s3BlobStore, err := longtailstorelib.NewS3BlobStore(blobStoreURL, opts...)
-			if err != nil {
-				return longtaillib.Longtail_BlockStoreAPI{}, errors.Wrap(err, fname)
-			}
+// MarkPruneCandidates records blockHashes as the blocks of the store at uri to delete once a grace period has
+// passed, replacing the blocks marked by earlier calls. Blocks that were already marked keep the time they were
+// first marked. Uploads and GetExistingContent calls that reuse a marked block rescue it from the next sweep
+func MarkPruneCandidates(
+	ctx context.Context,
+	uri string,
+	blockHashes []uint64,
+	opts ...longtailstorelib.BlobStoreOption) error {
+	const fname = "MarkPruneCandidates"
+	log := logrus.WithFields(logrus.Fields{
+		"fname":            fname,
+		"uri":              uri,
+		"len(blockHashes)": len(blockHashes),
+		"opts":             opts,
+	})
+	log.Debug(fname)
 
-			if numWorkerCount == 0 {
-				numWorkerCount = runtime.NumCPU()
-				if numWorkerCount > 8 {
-					numWorkerCount = 8
-				}
-			}
+	opts = append(longtailstorelib.GetBlobStoreOptions(ctx), opts...)
+	blobStore, err := createBlobStoreForStoreURI(uri, opts...)
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	client, err := blobStore.NewClient(ctx)
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	defer client.Close()
+	err = markPruneCandidates(ctx, client, blockHashes)
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	return nil
+}
 
-			s3BlockStore, err := NewRemoteBlockStore(
-				jobAPI,
-				s3BlobStore,
-				optionalStoreIndexPaths,
-				numWorkerCount,
-				accessType,
-				opts...)
-			if err != nil {
-				return longtaillib.Longtail_BlockStoreAPI{}, errors.Wrap(err, fname)
-			}
-			return longtaillib.CreateBlockStoreAPI(s3BlockStore), nil
-		case "abfs":
-			err := fmt.Errorf("azure Gen1 storage not yet implemented for path %s", uri)
-			return longtaillib.Longtail_BlockStoreAPI{}, errors.Wrap(err, fname)
-		case "abfss":
-			err := fmt.Errorf("azure Gen2 storage not yet implemented for path %s", uri)
-			return longtaillib.Longtail_BlockStoreAPI{}, errors.Wrap(err, fname)
-		case "file":
-			return longtaillib.CreateFSBlockStore(jobAPI, longtaillib.CreateFSStorageAPI(), blobStoreURL.Path[1:], ".lsb", enableFileMapping), nil
-		}
-	}
-	return longtaillib.CreateFSBlockStore(jobAPI, longtaillib.CreateFSStorageAPI(), uri, ".lsb", enableFileMapping), nil
+// SweepPruneCandidates removes the blocks marked with MarkPruneCandidates more than gracePeriod ago that have
+// not been rescued since from the store index. The blocks are deleted by a later sweep once they have been out
+// of the store index for gracePeriod without being rescued
+// Returns the number of deleted blocks and the number of rescued blocks
+func SweepPruneCandidates(
+	ctx context.Context,
+	uri string,
+	gracePeriod time.Duration,
+	opts ...longtailstorelib.BlobStoreOption) (int, int, error) {
+	const fname = "SweepPruneCandidates"
+	log := logrus.WithFields(logrus.Fields{
+		"fname":       fname,
+		"uri":         uri,
+		"gracePeriod": gracePeriod,
+		"opts":        opts,
+	})
+	log.Debug(fname)
+
+	opts = append(longtailstorelib.GetBlobStoreOptions(ctx), opts...)
+	blobStore, err := createBlobStoreForStoreURI(uri, opts...)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, fname)
+	}
+	client, err := blobStore.NewClient(ctx)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, fname)
+	}
+	defer client.Close()
+	deletedCount, rescuedCount, err := sweepPruneCandidates(ctx, client, gracePeriod)
+	if err != nil {
+		return deletedCount, rescuedCount, errors.Wrap(err, fname)
+	}
+	return deletedCount, rescuedCount, nil
 }
@@ -2,16 +2,22 @@ package remotestore
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"log"
 	"net/url"
 	"os"
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/DanEngelbrecht/golongtail/longtaillib"
 	"github.com/DanEngelbrecht/golongtail/longtailstorelib"
+	"github.com/DanEngelbrecht/golongtail/longtailutils"
 	"github.com/alecthomas/assert/v2"
 	"github.com/pkg/errors"
 )
@@ -21,6 +27,7 @@ func TestCreateRemoteBlobStore(t *testing.T) {
 	jobs := longtaillib.CreateBikeshedJobAPI(uint32(runtime.NumCPU()), 0)
 	defer jobs.Dispose()
 	remoteStore, err := NewRemoteBlockStore(
+		context.Background(),
 		jobs,
 		blobStore,
 		nil,
@@ -109,6 +116,7 @@ func TestEmptyGetExistingContent(t *testing.T) {
 	jobs := longtaillib.CreateBikeshedJobAPI(uint32(runtime.NumCPU()), 0)
 	defer jobs.Dispose()
 	remoteStore, err := NewRemoteBlockStore(
+		context.Background(),
 		jobs,
 		blobStore,
 		nil,
@@ -139,6 +147,7 @@ func TestPutGetStoredBlock(t *testing.T) {
 	jobs := longtaillib.CreateBikeshedJobAPI(uint32(runtime.NumCPU()), 0)
 	defer jobs.Dispose()
 	remoteStore, err := NewRemoteBlockStore(
+		context.Background(),
 		jobs,
 		blobStore,
 		nil,
@@ -164,6 +173,33 @@ func TestPutGetStoredBlock(t *testing.T) {
 	defer storeAPI.Dispose()
 }
 
+func TestGetStoredBlockCancelled(t *testing.T) {
+	blobStore, _ := longtailstorelib.NewMemBlobStore("the_path", true)
+	jobs := longtaillib.CreateBikeshedJobAPI(uint32(runtime.NumCPU()), 0)
+	defer jobs.Dispose()
+	ctx, cancel := context.WithCancel(context.Background())
+	remoteStore, err := NewRemoteBlockStore(
+		ctx,
+		jobs,
+		blobStore,
+		nil,
+		runtime.NumCPU(),
+		ReadWrite)
+	assert.NoError(t, err, "TestGetStoredBlockCancelled() NewRemoteBlockStore()) %s", err)
+	storeAPI := longtaillib.CreateBlockStoreAPI(remoteStore)
+	defer storeAPI.Dispose()
+
+	storedBlock, err := storeBlockFromSeed(t, storeAPI, 0)
+	assert.NoError(t, err, "TestGetStoredBlockCancelled() storeBlock(t, storeAPI, 0) %s", err)
+	blockHash := storedBlock.GetBlockHash()
+
+	cancel()
+
+	_, err = fetchBlockFromStore(t, storeAPI, blockHash)
+	assert.Error(t, err)
+	assert.True(t, longtaillib.IsCancelled(err))
+}
+
 type flushCompletionAPI struct {
 	wg  sync.WaitGroup
 	err error
@@ -179,6 +215,7 @@ func TestGetExistingContent(t *testing.T) {
 	jobs := longtaillib.CreateBikeshedJobAPI(uint32(runtime.NumCPU()), 0)
 	defer jobs.Dispose()
 	remoteStore, err := NewRemoteBlockStore(
+		context.Background(),
 		jobs,
 		blobStore,
 		nil,
@@ -228,6 +265,7 @@ func TestRestoreStore(t *testing.T) {
 	jobs := longtaillib.CreateBikeshedJobAPI(uint32(runtime.NumCPU()), 0)
 	defer jobs.Dispose()
 	remoteStore, err := NewRemoteBlockStore(
+		context.Background(),
 		jobs,
 		blobStore,
 		nil,
@@ -252,6 +290,7 @@ func TestRestoreStore(t *testing.T) {
 	storeAPI.Dispose()
 
 	remoteStore, err = NewRemoteBlockStore(
+		context.Background(),
 		jobs,
 		blobStore,
 		nil,
@@ -296,6 +335,7 @@ func TestRestoreStore(t *testing.T) {
 	storeAPI.Dispose()
 
 	remoteStore, err = NewRemoteBlockStore(
+		context.Background(),
 		jobs,
 		blobStore,
 		nil,
@@ -361,12 +401,36 @@ func storeBlock(blobClient longtailstorelib.BlobClient, storedBlock longtaillib.
 		path = parentPath + "/" + path
 	}
 	blobObject, _ := blobClient.NewObject(path)
-	blobObject.Write(bytes.ToBuffer())
+	blobObject.Write(context.Background(), bytes.ToBuffer())
 	return storedBlockHash
 }
 
+// createTestStoredBlock creates an uncompressed block with chunk hashes that match the chunk data
+func createTestStoredBlock(t *testing.T, blockHash uint64, chunkSizes []uint32, blockData []uint8) (longtaillib.Longtail_StoredBlock, error) {
+	hashAPI := longtaillib.CreateBlake3HashAPI()
+	defer hashAPI.Dispose()
+	chunkHashes := make([]uint64, len(chunkSizes))
+	chunkOffset := uint32(0)
+	for i, chunkSize := range chunkSizes {
+		chunkHash, err := hashAPI.HashBuffer(blockData[chunkOffset : chunkOffset+chunkSize])
+		if err != nil {
+			return longtaillib.Longtail_StoredBlock{}, err
+		}
+		chunkHashes[i] = chunkHash
+		chunkOffset += chunkSize
+	}
+
+	return longtaillib.CreateStoredBlock(
+		blockHash,
+		longtaillib.GetBlake3HashIdentifier(),
+		0,
+		chunkHashes,
+		chunkSizes,
+		blockData,
+		false)
+}
+
 func generateStoredBlock(t *testing.T, seed uint8) (longtaillib.Longtail_StoredBlock, error) {
-	chunkHashes := []uint64{uint64(seed) + 1, uint64(seed) + 2, uint64(seed) + 3}
 	chunkSizes := []uint32{uint32(seed) + 10, uint32(seed) + 20, uint32(seed) + 30}
 
 	blockDataLen := (int)(chunkSizes[0] + chunkSizes[1] + chunkSizes[2])
@@ -375,18 +439,10 @@ func generateStoredBlock(t *testing.T, seed uint8) (longtaillib.Longtail_StoredB
 		blockData[p] = seed
 	}
 
-	return longtaillib.CreateStoredBlock(
-		uint64(seed)+21412151,
-		997,
-		2,
-		chunkHashes,
-		chunkSizes,
-		blockData,
-		false)
+	return createTestStoredBlock(t, uint64(seed)+21412151, chunkSizes, blockData)
 }
 
 func generateUniqueStoredBlock(t *testing.T, seed uint8) (longtaillib.Longtail_StoredBlock, error) {
-	chunkHashes := []uint64{uint64(seed)<<8 + 1, uint64(seed)<<8 + 2, uint64(seed)<<8 + 3}
 	chunkSizes := []uint32{uint32(seed)<<8 + 10, uint32(seed)<<8 + 20, uint32(seed)<<8 + 30}
 
 	blockDataLen := (int)(chunkSizes[0] + chunkSizes[1] + chunkSizes[2])
@@ -395,14 +451,7 @@ func generateUniqueStoredBlock(t *testing.T, seed uint8) (longtaillib.Longtail_S
 		blockData[p] = seed
 	}
 
-	return longtaillib.CreateStoredBlock(
-		uint64(seed)<<16+21412151,
-		997,
-		2,
-		chunkHashes,
-		chunkSizes,
-		blockData,
-		false)
+	return createTestStoredBlock(t, uint64(seed)<<16+21412151, chunkSizes, blockData)
 }
 
 func storeBlockFromSeed(t *testing.T, storeAPI longtaillib.Longtail_BlockStoreAPI, seed uint8) (longtaillib.Longtail_StoredBlock, error) {
@@ -483,6 +532,7 @@ func TestBlockScanning(t *testing.T) {
 	jobs := longtaillib.CreateBikeshedJobAPI(uint32(runtime.NumCPU()), 0)
 	defer jobs.Dispose()
 	remoteStore, err := NewRemoteBlockStore(
+		context.Background(),
 		jobs,
 		blobStore,
 		nil,
@@ -533,6 +583,7 @@ func PruneStoreTest(syncStore bool, t *testing.T) {
 	jobs := longtaillib.CreateBikeshedJobAPI(uint32(runtime.NumCPU()), 0)
 	defer jobs.Dispose()
 	remoteStore, err := NewRemoteBlockStore(
+		context.Background(),
 		jobs,
 		blobStore,
 		nil,
@@ -584,6 +635,7 @@ func PruneStoreTest(syncStore bool, t *testing.T) {
 	storeAPI.Dispose()
 
 	remoteStore, err = NewRemoteBlockStore(
+		context.Background(),
 		jobs,
 		blobStore,
 		nil,
@@ -608,6 +660,7 @@ func PruneStoreTest(syncStore bool, t *testing.T) {
 	storeAPI.Dispose()
 
 	remoteStore, err = NewRemoteBlockStore(
+		context.Background(),
 		jobs,
 		blobStore,
 		nil,
@@ -810,7 +863,7 @@ func TestGCSStoreIndexSyncWithLocking(t *testing.T) {
 	client, _ := blobStore.NewClient(context.Background())
 	defer client.Close()
 	object, _ := client.NewObject("store.lsi")
-	object.Delete()
+	object.Delete(context.Background())
 
 	testStoreIndexSync(blobStore, t)
 }
@@ -827,7 +880,7 @@ func TestGCSStoreIndexSyncWithoutLocking(t *testing.T) {
 	client, _ := blobStore.NewClient(context.Background())
 	defer client.Close()
 	object, _ := client.NewObject("store.lsi")
-	object.Delete()
+	object.Delete(context.Background())
 
 	testStoreIndexSync(blobStore, t)
 }
@@ -836,10 +889,15 @@ func TestS3StoreIndexSync(t *testing.T) {
 	// This test uses hardcoded paths in S3 and is disabled
 	t.Skip()
 
-	u, err := url.Parse("s3://longtail-test/test-s3-blob-store-sync")
+	u, err := url.Parse("s3://longtail-test/test-s3-blob-store-sync?locking=true")
 	assert.NoError(t, err, "url.Parse() err == %s", err)
 
 	blobStore, _ := longtailstorelib.NewS3BlobStore(u)
+	client, _ := blobStore.NewClient(context.Background())
+	defer client.Close()
+	object, _ := client.NewObject("store.lsi")
+	object.Delete(context.Background())
+
 	testStoreIndexSync(blobStore, t)
 }
 
@@ -851,7 +909,7 @@ func TestFSStoreIndexSyncWithLocking(t *testing.T) {
 	client, _ := blobStore.NewClient(context.Background())
 	defer client.Close()
 	object, _ := client.NewObject("store.lsi")
-	object.Delete()
+	object.Delete(context.Background())
 
 	testStoreIndexSync(blobStore, t)
 }
@@ -864,7 +922,902 @@ func TestFSStoreIndexSyncWithoutLocking(t *testing.T) {
 	client, _ := blobStore.NewClient(context.Background())
 	defer client.Close()
 	object, _ := client.NewObject("store.lsi")
-	object.Delete()
+	object.Delete(context.Background())
 
 	testStoreIndexSync(blobStore, t)
 }
+
+func faultTestContext() context.Context {
+	return longtailstorelib.WithRetryPolicy(context.Background(), longtailstorelib.RetryPolicy{
+		MaxRetries:    20,
+		InitialDelay:  time.Millisecond,
+		MaxDelay:      5 * time.Millisecond,
+		ThrottleDelay: 5 * time.Millisecond,
+	})
+}
+
+func TestPutGetStoredBlockWithFaults(t *testing.T) {
+	ctx := faultTestContext()
+	backingStore, _ := longtailstorelib.NewMemBlobStore("the_path", true)
+	blobStore := longtailstorelib.NewFaultyBlobStore(backingStore, longtailstorelib.FaultConfig{ErrorRate: 0.5, Seed: 1})
+	jobs := longtaillib.CreateBikeshedJobAPI(uint32(runtime.NumCPU()), 0)
+	defer jobs.Dispose()
+	remoteStore, err := NewRemoteBlockStore(
+		ctx,
+		jobs,
+		blobStore,
+		nil,
+		runtime.NumCPU(),
+		ReadWrite)
+	assert.NoError(t, err)
+	storeAPI := longtaillib.CreateBlockStoreAPI(remoteStore)
+	defer storeAPI.Dispose()
+
+	for seed := uint8(0); seed < 50; seed += 10 {
+		_, err = storeBlockFromSeed(t, storeAPI, seed)
+		assert.NoError(t, err)
+	}
+	for seed := uint8(0); seed < 50; seed += 10 {
+		storedBlock, err := fetchBlockFromStore(t, storeAPI, uint64(seed)+21412151)
+		assert.NoError(t, err)
+		validateBlockFromSeed(t, seed, storedBlock)
+		storedBlock.Dispose()
+	}
+	assert.True(t, blobStore.InjectedFaultCount() > 0)
+
+	stats, err := storeAPI.GetStats()
+	assert.NoError(t, err)
+	assert.True(t, stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_PutStoredBlock_RetryCount]+stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_GetStoredBlock_RetryCount] > 0)
+	assert.Equal(t, uint64(0), stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_PutStoredBlock_FailCount])
+}
+
+func TestPutStoredBlockFailsCleanly(t *testing.T) {
+	ctx := longtailstorelib.WithRetryPolicy(context.Background(), longtailstorelib.RetryPolicy{MaxRetries: 2, InitialDelay: time.Millisecond})
+	backingStore, _ := longtailstorelib.NewMemBlobStore("the_path", true)
+	blobStore := longtailstorelib.NewFaultyBlobStore(backingStore, longtailstorelib.FaultConfig{
+		ErrorRate:  1,
+		Operations: []longtailstorelib.FaultOperation{longtailstorelib.FaultWrite}})
+	jobs := longtaillib.CreateBikeshedJobAPI(uint32(runtime.NumCPU()), 0)
+	defer jobs.Dispose()
+	remoteStore, err := NewRemoteBlockStore(
+		ctx,
+		jobs,
+		blobStore,
+		nil,
+		runtime.NumCPU(),
+		ReadWrite)
+	assert.NoError(t, err)
+	storeAPI := longtaillib.CreateBlockStoreAPI(remoteStore)
+	defer storeAPI.Dispose()
+
+	_, err = storeBlockFromSeed(t, storeAPI, 0)
+	assert.Error(t, err)
+	stats, _ := storeAPI.GetStats()
+	assert.Equal(t, uint64(1), stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_PutStoredBlock_FailCount])
+	assert.Equal(t, uint64(2), stats.StatU64[longtaillib.Longtail_BlockStoreAPI_StatU64_PutStoredBlock_RetryCount])
+
+	// Once the store recovers the block can be uploaded again
+	blobStore.SetConfig(longtailstorelib.FaultConfig{})
+	_, err = storeBlockFromSeed(t, storeAPI, 0)
+	assert.NoError(t, err)
+	storedBlock, err := fetchBlockFromStore(t, storeAPI, uint64(0)+21412151)
+	assert.NoError(t, err)
+	validateBlockFromSeed(t, 0, storedBlock)
+	storedBlock.Dispose()
+}
+
+func TestGetStoredBlockAfterLostWrite(t *testing.T) {
+	ctx := faultTestContext()
+	backingStore, _ := longtailstorelib.NewMemBlobStore("the_path", true)
+	blobStore := longtailstorelib.NewFaultyBlobStore(backingStore, longtailstorelib.FaultConfig{LostWriteRate: 1})
+	jobs := longtaillib.CreateBikeshedJobAPI(uint32(runtime.NumCPU()), 0)
+	defer jobs.Dispose()
+	remoteStore, err := NewRemoteBlockStore(
+		ctx,
+		jobs,
+		blobStore,
+		nil,
+		runtime.NumCPU(),
+		ReadWrite)
+	assert.NoError(t, err)
+	storeAPI := longtaillib.CreateBlockStoreAPI(remoteStore)
+	defer storeAPI.Dispose()
+
+	// The store believes the write succeeded but reading the block reports it missing instead of hanging or crashing
+	_, err = storeBlockFromSeed(t, storeAPI, 0)
+	assert.NoError(t, err)
+	_, err = fetchBlockFromStore(t, storeAPI, uint64(0)+21412151)
+	assert.Error(t, err)
+	assert.True(t, longtaillib.IsNotExist(err))
+
+	// Uploading again checks that the block is missing and writes it
+	blobStore.SetConfig(longtailstorelib.FaultConfig{})
+	_, err = storeBlockFromSeed(t, storeAPI, 0)
+	assert.NoError(t, err)
+	storedBlock, err := fetchBlockFromStore(t, storeAPI, uint64(0)+21412151)
+	assert.NoError(t, err)
+	validateBlockFromSeed(t, 0, storedBlock)
+	storedBlock.Dispose()
+}
+
+func testCompactStoreIndex(blobStore longtailstorelib.BlobStore, t *testing.T) {
+	ctx := context.Background()
+	client, _ := blobStore.NewClient(ctx)
+	defer client.Close()
+
+	// Items left behind by concurrent uploads to a store without locking
+	blockHashes := []uint64{}
+	for seed := 0; seed < 4; seed++ {
+		block, _ := generateUniqueStoredBlock(t, uint8(seed))
+		blockIndex := block.GetBlockIndex()
+		blockHashes = append(blockHashes, blockIndex.GetBlockHash())
+		itemStoreIndex, err := longtaillib.CreateStoreIndexFromBlocks([]longtaillib.Longtail_BlockIndex{blockIndex})
+		assert.NoError(t, err)
+		ok, err := tryWriteRemoteStoreIndex(ctx, itemStoreIndex, nil, client)
+		itemStoreIndex.Dispose()
+		block.Dispose()
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+	items, err := getStoreStoreIndexes(ctx, client)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(items))
+
+	compactedItems, err := compactRemoteStoreIndex(ctx, client, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, compactedItems)
+
+	compactedItems, err = compactRemoteStoreIndex(ctx, client, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, compactedItems)
+	items, err = getStoreStoreIndexes(ctx, client)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(items))
+	if client.SupportsLocking() {
+		assert.Equal(t, "store.lsi", items[0])
+	}
+
+	storeIndex, _, err := readStoreStoreIndexWithItems(ctx, client)
+	assert.NoError(t, err)
+	defer storeIndex.Dispose()
+	assert.Equal(t, len(blockHashes), len(storeIndex.GetBlockHashes()))
+	lookup := map[uint64]bool{}
+	for _, h := range storeIndex.GetBlockHashes() {
+		lookup[h] = true
+	}
+	for _, h := range blockHashes {
+		assert.True(t, lookup[h])
+	}
+
+	compactedItems, err = compactRemoteStoreIndex(ctx, client, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, compactedItems)
+}
+
+func TestCompactStoreIndexWithLocking(t *testing.T) {
+	blobStore, _ := longtailstorelib.NewMemBlobStore("the_path", true)
+	testCompactStoreIndex(blobStore, t)
+}
+
+func TestCompactStoreIndexWithoutLocking(t *testing.T) {
+	blobStore, _ := longtailstorelib.NewMemBlobStore("the_path", false)
+	testCompactStoreIndex(blobStore, t)
+}
+
+func TestLockedStoreIndexMergesLegacyItems(t *testing.T) {
+	ctx := context.Background()
+	blobStore, _ := longtailstorelib.NewMemBlobStore("the_path", true)
+	client, _ := blobStore.NewClient(ctx)
+	defer client.Close()
+
+	blockIndexes := []longtaillib.Longtail_BlockIndex{}
+	for seed := 0; seed < 3; seed++ {
+		block, _ := generateUniqueStoredBlock(t, uint8(seed))
+		defer block.Dispose()
+		blockIndexes = append(blockIndexes, block.GetBlockIndex())
+	}
+	sortedBlockHashes := func(storeIndex longtaillib.Longtail_StoreIndex) []uint64 {
+		blockHashes := storeIndex.GetBlockHashes()
+		sort.Slice(blockHashes, func(i, j int) bool { return blockHashes[i] < blockHashes[j] })
+		return blockHashes
+	}
+
+	// An item written by a client that did not lock the store index
+	legacyStoreIndex, err := longtaillib.CreateStoreIndexFromBlocks(blockIndexes[:2])
+	assert.NoError(t, err)
+	defer legacyStoreIndex.Dispose()
+	ok, err := tryWriteRemoteStoreIndex(ctx, legacyStoreIndex, nil, client)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	addStoreIndex, err := longtaillib.CreateStoreIndexFromBlocks(blockIndexes[2:])
+	assert.NoError(t, err)
+	defer addStoreIndex.Dispose()
+	newStoreIndex, err := addToRemoteStoreIndex(ctx, client, addStoreIndex)
+	assert.NoError(t, err)
+	newStoreIndex.Dispose()
+
+	// Uploads leave the legacy item in place, readers merge it with the locked index
+	items, err := getStoreStoreIndexes(ctx, client)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(items))
+	storeIndex, _, err := readStoreStoreIndexWithItems(ctx, client)
+	assert.NoError(t, err)
+	allStoreIndex, err := longtaillib.CreateStoreIndexFromBlocks(blockIndexes)
+	assert.NoError(t, err)
+	defer allStoreIndex.Dispose()
+	assert.Equal(t, sortedBlockHashes(allStoreIndex), sortedBlockHashes(storeIndex))
+	storeIndex.Dispose()
+
+	// Compaction merges the legacy item into the locked index and deletes it
+	ok, compactedItems, err := tryCompactRemoteStoreIndex(ctx, client, 0)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 2, compactedItems)
+	items, err = getStoreStoreIndexes(ctx, client)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"store.lsi"}, items)
+	storeIndex, _, err = readStoreStoreIndexWithItems(ctx, client)
+	assert.NoError(t, err)
+	assert.Equal(t, sortedBlockHashes(allStoreIndex), sortedBlockHashes(storeIndex))
+	storeIndex.Dispose()
+
+	// Overwriting the index removes legacy items instead of merging them so removed blocks stay removed
+	ok, err = tryWriteRemoteStoreIndex(ctx, legacyStoreIndex, nil, client)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, tryOverwriteStoreIndexWithRetry(ctx, addStoreIndex, client))
+
+	items, err = getStoreStoreIndexes(ctx, client)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"store.lsi"}, items)
+	storeIndex, _, err = readStoreStoreIndexWithItems(ctx, client)
+	assert.NoError(t, err)
+	defer storeIndex.Dispose()
+	assert.Equal(t, sortedBlockHashes(addStoreIndex), sortedBlockHashes(storeIndex))
+}
+
+func readQuarantineRecord(t *testing.T, blobStore longtailstorelib.BlobStore, blockHash uint64) QuarantineRecord {
+	client, _ := blobStore.NewClient(context.Background())
+	defer client.Close()
+	object, _ := client.NewObject(GetQuarantineRecordPath(blockHash))
+	data, err := object.Read(context.Background())
+	assert.NoError(t, err)
+	record := QuarantineRecord{}
+	assert.NoError(t, json.Unmarshal(data, &record))
+	return record
+}
+
+func TestGetStoredBlockCorrupted(t *testing.T) {
+	blobStore, _ := longtailstorelib.NewMemBlobStore("the_path", true)
+	blobClient, _ := blobStore.NewClient(context.Background())
+	defer blobClient.Close()
+	// Block 0 stored where block 1 is expected
+	storedBlock, _ := generateStoredBlock(t, 0)
+	corruptBlockHash := storeBlock(blobClient, storedBlock, 1, "")
+	storedBlock.Dispose()
+
+	jobs := longtaillib.CreateBikeshedJobAPI(uint32(runtime.NumCPU()), 0)
+	defer jobs.Dispose()
+	remoteStore, err := NewRemoteBlockStore(
+		context.Background(),
+		jobs,
+		blobStore,
+		nil,
+		runtime.NumCPU(),
+		ReadOnly)
+	assert.NoError(t, err)
+	storeAPI := longtaillib.CreateBlockStoreAPI(remoteStore)
+	defer storeAPI.Dispose()
+
+	_, err = fetchBlockFromStore(t, storeAPI, corruptBlockHash)
+	assert.Error(t, err)
+	assert.False(t, longtaillib.IsNotExist(err))
+
+	record := readQuarantineRecord(t, blobStore, corruptBlockHash)
+	assert.Equal(t, fmt.Sprintf("0x%016x", corruptBlockHash), record.BlockHash)
+	assert.Equal(t, getBlockPath("chunks", corruptBlockHash), record.Path)
+	assert.False(t, record.Recovered)
+}
+
+func TestGetStoredBlockCorruptedChunkData(t *testing.T) {
+	blobStore, _ := longtailstorelib.NewMemBlobStore("the_path", true)
+	blobClient, _ := blobStore.NewClient(context.Background())
+	defer blobClient.Close()
+	storedBlock, _ := generateStoredBlock(t, 0)
+	blockHash := storeBlock(blobClient, storedBlock, 0, "")
+	storedBlock.Dispose()
+
+	// Flip the last byte of the chunk data, the block header still matches the path and the store index
+	object, _ := blobClient.NewObject(getBlockPath("chunks", blockHash))
+	data, err := object.Read(context.Background())
+	assert.NoError(t, err)
+	data[len(data)-1]++
+	_, err = object.Write(context.Background(), data)
+	assert.NoError(t, err)
+
+	jobs := longtaillib.CreateBikeshedJobAPI(uint32(runtime.NumCPU()), 0)
+	defer jobs.Dispose()
+
+	// Without content verification only the block index and the size of the chunk data are checked
+	remoteStore, err := NewRemoteBlockStore(
+		context.Background(),
+		jobs,
+		blobStore,
+		nil,
+		runtime.NumCPU(),
+		ReadOnly)
+	assert.NoError(t, err)
+	storeAPI := longtaillib.CreateBlockStoreAPI(remoteStore)
+	fetchedBlock, err := fetchBlockFromStore(t, storeAPI, blockHash)
+	assert.NoError(t, err)
+	fetchedBlock.Dispose()
+	storeAPI.Dispose()
+
+	remoteStore, err = NewRemoteBlockStore(
+		context.Background(),
+		jobs,
+		blobStore,
+		nil,
+		runtime.NumCPU(),
+		ReadOnly,
+		WithBlockContentVerification())
+	assert.NoError(t, err)
+	storeAPI = longtaillib.CreateBlockStoreAPI(remoteStore)
+	defer storeAPI.Dispose()
+
+	_, err = fetchBlockFromStore(t, storeAPI, blockHash)
+	assert.Error(t, err)
+	assert.False(t, longtaillib.IsNotExist(err))
+	assert.False(t, readQuarantineRecord(t, blobStore, blockHash).Recovered)
+}
+
+func TestGetStoredBlockCorruptedInMirror(t *testing.T) {
+	primary, _ := longtailstorelib.NewMemBlobStore("the_path", true)
+	replica, _ := longtailstorelib.NewMemBlobStore("the_path", true)
+	primaryClient, _ := primary.NewClient(context.Background())
+	defer primaryClient.Close()
+	replicaClient, _ := replica.NewClient(context.Background())
+	defer replicaClient.Close()
+	badBlock, _ := generateStoredBlock(t, 0)
+	corruptBlockHash := storeBlock(primaryClient, badBlock, 1, "")
+	badBlock.Dispose()
+	goodBlock, _ := generateStoredBlock(t, 1)
+	storeBlock(replicaClient, goodBlock, 0, "")
+	goodBlock.Dispose()
+
+	blobStore, _ := longtailstorelib.NewMirroredBlobStore([]longtailstorelib.BlobStore{primary, replica}, longtailstorelib.MirrorOptions{})
+	jobs := longtaillib.CreateBikeshedJobAPI(uint32(runtime.NumCPU()), 0)
+	defer jobs.Dispose()
+	remoteStore, err := NewRemoteBlockStore(
+		context.Background(),
+		jobs,
+		blobStore,
+		nil,
+		runtime.NumCPU(),
+		ReadOnly)
+	assert.NoError(t, err)
+	storeAPI := longtaillib.CreateBlockStoreAPI(remoteStore)
+	defer storeAPI.Dispose()
+
+	// The damaged copy on the primary is found and the block is read from the replica
+	storedBlock, err := fetchBlockFromStore(t, storeAPI, corruptBlockHash)
+	assert.NoError(t, err)
+	validateBlockFromSeed(t, 1, storedBlock)
+	storedBlock.Dispose()
+	assert.True(t, readQuarantineRecord(t, primary, corruptBlockHash).Recovered)
+}
+
+// writeTestStoreIndexItem stores a block for each seed and adds a store index item with them
+func writeTestStoreIndexItem(t *testing.T, client longtailstorelib.BlobClient, seeds []uint8) {
+	blockIndexes := []longtaillib.Longtail_BlockIndex{}
+	for _, seed := range seeds {
+		block, _ := generateUniqueStoredBlock(t, seed)
+		defer block.Dispose()
+		storeBlock(client, block, 0, "")
+		blockIndexes = append(blockIndexes, block.GetBlockIndex())
+	}
+	storeIndex, err := longtaillib.CreateStoreIndexFromBlocks(blockIndexes)
+	assert.NoError(t, err)
+	defer storeIndex.Dispose()
+	ok, err := tryWriteRemoteStoreIndex(context.Background(), storeIndex, nil, client)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestReadCachedStoreStoreIndex(t *testing.T) {
+	ctx := context.Background()
+	blobStore, _ := longtailstorelib.NewNamedMemBlobStore("cached-store-index", "store")
+	defer longtailstorelib.DeleteNamedMemBlobStore("cached-store-index")
+	client, _ := blobStore.NewClient(ctx)
+	defer client.Close()
+	writeTestStoreIndexItem(t, client, []uint8{0, 1})
+	cache := longtailstorelib.NewIndexCache(t.TempDir(), longtailstorelib.DefaultIndexCacheMaxSize)
+
+	storeIndex, err := readCachedStoreStoreIndex(ctx, client, cache)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), storeIndex.GetBlockCount())
+	storeIndex.Dispose()
+	hits, _ := cache.Stats()
+	assert.Equal(t, uint64(0), hits)
+
+	// The item and the merged store index are read from the cache while the item is unchanged
+	storeIndex, err = readCachedStoreStoreIndex(ctx, client, cache)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), storeIndex.GetBlockCount())
+	storeIndex.Dispose()
+	hits, _ = cache.Stats()
+	assert.Equal(t, uint64(2), hits)
+
+	// A new item is downloaded and merged with the cached item
+	writeTestStoreIndexItem(t, client, []uint8{2})
+	storeIndex, err = readCachedStoreStoreIndex(ctx, client, cache)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(3), storeIndex.GetBlockCount())
+	storeIndex.Dispose()
+	hits, _ = cache.Stats()
+	assert.Equal(t, uint64(3), hits)
+}
+
+func TestReadCachedStoreStoreIndexInMirror(t *testing.T) {
+	ctx := context.Background()
+	primary, _ := longtailstorelib.NewNamedMemBlobStore("cached-store-index-primary", "store")
+	defer longtailstorelib.DeleteNamedMemBlobStore("cached-store-index-primary")
+	replica, _ := longtailstorelib.NewNamedMemBlobStore("cached-store-index-replica", "store")
+	defer longtailstorelib.DeleteNamedMemBlobStore("cached-store-index-replica")
+	primaryClient, _ := primary.NewClient(ctx)
+	defer primaryClient.Close()
+	replicaClient, _ := replica.NewClient(ctx)
+	defer replicaClient.Close()
+	writeTestStoreIndexItem(t, primaryClient, []uint8{0})
+	writeTestStoreIndexItem(t, replicaClient, []uint8{0, 1})
+	cache := longtailstorelib.NewIndexCache(t.TempDir(), longtailstorelib.DefaultIndexCacheMaxSize)
+
+	// Listing the primary fails so the store index is read from the replica and cached with the version of the replica
+	failingPrimary := longtailstorelib.NewFaultyBlobStore(primary, longtailstorelib.FaultConfig{
+		Operations: []longtailstorelib.FaultOperation{longtailstorelib.FaultList},
+		ErrorRate:  1})
+	mirror, _ := longtailstorelib.NewMirroredBlobStore([]longtailstorelib.BlobStore{failingPrimary, replica}, longtailstorelib.MirrorOptions{})
+	mirrorClient, _ := mirror.NewClient(ctx)
+	storeIndex, err := readCachedStoreStoreIndex(ctx, mirrorClient, cache)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), storeIndex.GetBlockCount())
+	storeIndex.Dispose()
+	storeIndex, err = readCachedStoreStoreIndex(ctx, mirrorClient, cache)
+	mirrorClient.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), storeIndex.GetBlockCount())
+	storeIndex.Dispose()
+	hits, _ := cache.Stats()
+	assert.Equal(t, uint64(2), hits)
+
+	// Once the primary is back its own store index is read, not the one cached for the replica
+	mirror, _ = longtailstorelib.NewMirroredBlobStore([]longtailstorelib.BlobStore{primary, replica}, longtailstorelib.MirrorOptions{})
+	mirrorClient, _ = mirror.NewClient(ctx)
+	defer mirrorClient.Close()
+	storeIndex, err = readCachedStoreStoreIndex(ctx, mirrorClient, cache)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), storeIndex.GetBlockCount())
+	storeIndex.Dispose()
+}
+
+func TestReadFromURIWithIndexCache(t *testing.T) {
+	ctx := context.Background()
+	defer longtailstorelib.DeleteNamedMemBlobStore("read-from-uri-primary")
+	defer longtailstorelib.DeleteNamedMemBlobStore("read-from-uri-replica")
+	assert.NoError(t, longtailstorelib.SeedMemBlobs("mem://read-from-uri-primary/index", map[string][]byte{"v1.lvi": []byte("primary v1")}))
+	assert.NoError(t, longtailstorelib.SeedMemBlobs("mem://read-from-uri-replica/index", map[string][]byte{"v1.lvi": []byte("replica v1"), "v2.lvi": []byte("replica v2")}))
+	cache := longtailstorelib.NewIndexCache(t.TempDir(), longtailstorelib.DefaultIndexCacheMaxSize)
+	withCache := longtailstorelib.WithIndexCache(cache)
+
+	data, err := longtailutils.ReadFromURI(ctx, "mem://read-from-uri-primary/index/v1.lvi", withCache)
+	assert.NoError(t, err)
+	assert.Equal(t, "primary v1", string(data))
+	data, err = longtailutils.ReadFromURI(ctx, "mem://read-from-uri-primary/index/v1.lvi", withCache)
+	assert.NoError(t, err)
+	assert.Equal(t, "primary v1", string(data))
+	hits, _ := cache.Stats()
+	assert.Equal(t, uint64(1), hits)
+
+	// A changed object is downloaded again
+	assert.NoError(t, longtailstorelib.SeedMemBlobs("mem://read-from-uri-primary/index", map[string][]byte{"v1.lvi": []byte("primary v1 changed")}))
+	data, err = longtailutils.ReadFromURI(ctx, "mem://read-from-uri-primary/index/v1.lvi", withCache)
+	assert.NoError(t, err)
+	assert.Equal(t, "primary v1 changed", string(data))
+
+	// The replica serves the object the primary is missing, once the primary has it the entry cached for the replica is not used
+	mirrorURI := "mirror:mem://read-from-uri-primary/index,mem://read-from-uri-replica/index"
+	data, err = longtailutils.ReadFromURI(ctx, mirrorURI+"/v2.lvi", withCache)
+	assert.NoError(t, err)
+	assert.Equal(t, "replica v2", string(data))
+	assert.NoError(t, longtailstorelib.SeedMemBlobs("mem://read-from-uri-primary/index", map[string][]byte{"v2.lvi": []byte("primary v2")}))
+	data, err = longtailutils.ReadFromURI(ctx, mirrorURI+"/v2.lvi", withCache)
+	assert.NoError(t, err)
+	assert.Equal(t, "primary v2", string(data))
+
+	// Encrypted objects are never cached
+	keyRing, err := longtailstorelib.ParseEncryptionKeys("key:" + base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	assert.NoError(t, err)
+	withEncryption := longtailstorelib.WithEncryptionKeyRing(keyRing)
+	assert.NoError(t, longtailutils.WriteToURI(ctx, "mem://read-from-uri-primary/index/v3.lvi", []byte("secret"), withEncryption))
+	hits, misses := cache.Stats()
+	for i := 0; i < 2; i++ {
+		data, err = longtailutils.ReadFromURI(ctx, "mem://read-from-uri-primary/index/v3.lvi", withCache, withEncryption)
+		assert.NoError(t, err)
+		assert.Equal(t, "secret", string(data))
+	}
+	encryptedHits, encryptedMisses := cache.Stats()
+	assert.Equal(t, hits, encryptedHits)
+	assert.Equal(t, misses, encryptedMisses)
+
+	// Encrypted objects written at the root of a store can be read from their own folder
+	encryptedStore, err := longtailstorelib.CreateBlobStoreForURI("mem://read-from-uri-primary", withEncryption)
+	assert.NoError(t, err)
+	encryptedClient, _ := encryptedStore.NewClient(ctx)
+	defer encryptedClient.Close()
+	encryptedObject, _ := encryptedClient.NewObject("index/store/0a1b2c3d4e5f6071.lsi")
+	_, err = encryptedObject.Write(ctx, []byte("secret store index"))
+	assert.NoError(t, err)
+	data, err = longtailutils.ReadFromURI(ctx, "mem://read-from-uri-primary/index/store/0a1b2c3d4e5f6071.lsi", withEncryption)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret store index", string(data))
+}
+
+func testPruneCandidates(blobStore longtailstorelib.BlobStore, t *testing.T) {
+	ctx := context.Background()
+	client, _ := blobStore.NewClient(ctx)
+	defer client.Close()
+
+	blockHashes := []uint64{}
+	blockIndexes := []longtaillib.Longtail_BlockIndex{}
+	rescuedChunkHashes := []uint64{}
+	for seed := 0; seed < 3; seed++ {
+		block, _ := generateUniqueStoredBlock(t, uint8(seed))
+		defer block.Dispose()
+		storeBlock(client, block, 0, "")
+		blockIndex := block.GetBlockIndex()
+		blockHashes = append(blockHashes, blockIndex.GetBlockHash())
+		blockIndexes = append(blockIndexes, blockIndex)
+		if seed == 1 {
+			rescuedChunkHashes = blockIndex.GetChunkHashes()
+		}
+	}
+	storeIndex, err := longtaillib.CreateStoreIndexFromBlocks(blockIndexes)
+	assert.NoError(t, err)
+	ok, err := tryWriteRemoteStoreIndex(ctx, storeIndex, nil, client)
+	storeIndex.Dispose()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	assert.NoError(t, markPruneCandidates(ctx, client, blockHashes))
+
+	// An upload that reuses a marked block rescues it
+	jobs := longtaillib.CreateBikeshedJobAPI(uint32(runtime.NumCPU()), 0)
+	defer jobs.Dispose()
+	remoteStore, err := NewRemoteBlockStore(ctx, jobs, blobStore, nil, runtime.NumCPU(), ReadWrite)
+	assert.NoError(t, err)
+	storeAPI := longtaillib.CreateBlockStoreAPI(remoteStore)
+	existingContent, err := getExistingContent(t, storeAPI, rescuedChunkHashes, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(existingContent.GetBlockHashes()))
+	existingContent.Dispose()
+	storeAPI.Dispose()
+
+	deletedCount, rescuedCount, err := sweepPruneCandidates(ctx, client, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, deletedCount)
+	assert.Equal(t, 1, rescuedCount)
+
+	// Expired candidates are removed from the store index but not deleted until a later sweep
+	deletedCount, rescuedCount, err = sweepPruneCandidates(ctx, client, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, deletedCount)
+	assert.Equal(t, 0, rescuedCount)
+
+	storeIndex, _, err = readStoreStoreIndexWithItems(ctx, client)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{blockHashes[1]}, storeIndex.GetBlockHashes())
+	storeIndex.Dispose()
+	for _, blockHash := range blockHashes {
+		object, _ := client.NewObject(getBlockPath("chunks", blockHash))
+		exists, err := object.Exists(ctx)
+		assert.NoError(t, err)
+		assert.True(t, exists)
+	}
+	candidates, unindexed, err := readPruneCandidates(ctx, client)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(candidates))
+	assert.Equal(t, 2, len(unindexed))
+
+	// An upload that started before the blocks left the store index can still rescue them
+	record, _ := json.Marshal(PruneRescueRecord{BlockHash: fmt.Sprintf("0x%016x", blockHashes[2]), Time: time.Now().UTC()})
+	object, _ := client.NewObject(GetPruneRescueRecordPath(blockHashes[2]))
+	_, err = object.Write(ctx, record)
+	assert.NoError(t, err)
+
+	deletedCount, rescuedCount, err = sweepPruneCandidates(ctx, client, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deletedCount)
+	assert.Equal(t, 1, rescuedCount)
+
+	storeIndex, _, err = readStoreStoreIndexWithItems(ctx, client)
+	assert.NoError(t, err)
+	defer storeIndex.Dispose()
+	indexedBlocks := storeIndex.GetBlockHashes()
+	sort.Slice(indexedBlocks, func(i, j int) bool { return indexedBlocks[i] < indexedBlocks[j] })
+	expectedBlocks := []uint64{blockHashes[1], blockHashes[2]}
+	sort.Slice(expectedBlocks, func(i, j int) bool { return expectedBlocks[i] < expectedBlocks[j] })
+	assert.Equal(t, expectedBlocks, indexedBlocks)
+	for i, blockHash := range blockHashes {
+		object, _ := client.NewObject(getBlockPath("chunks", blockHash))
+		exists, err := object.Exists(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, i != 0, exists)
+	}
+	candidates, unindexed, err = readPruneCandidates(ctx, client)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(candidates))
+	assert.Equal(t, 0, len(unindexed))
+	rescued, err := listPruneRescueRecords(ctx, client)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(rescued))
+}
+
+func TestPruneCandidatesWithLocking(t *testing.T) {
+	blobStore, _ := longtailstorelib.NewMemBlobStore("the_path", true)
+	testPruneCandidates(blobStore, t)
+}
+
+func TestPruneCandidatesWithoutLocking(t *testing.T) {
+	blobStore, _ := longtailstorelib.NewMemBlobStore("the_path", false)
+	testPruneCandidates(blobStore, t)
+}
+
+func TestPruneCandidatesReadOnly(t *testing.T) {
+	// Readers may only have read access to the store so they must not write rescue records
+	ctx := longtailstorelib.WithRetryPolicy(context.Background(), longtailstorelib.RetryPolicy{MaxRetries: 2, InitialDelay: time.Millisecond})
+	backingStore, _ := longtailstorelib.NewMemBlobStore("the_path", true)
+	client, _ := backingStore.NewClient(ctx)
+	defer client.Close()
+
+	block, _ := generateUniqueStoredBlock(t, 0)
+	defer block.Dispose()
+	storeBlock(client, block, 0, "")
+	blockIndex := block.GetBlockIndex()
+	storeIndex, err := longtaillib.CreateStoreIndexFromBlocks([]longtaillib.Longtail_BlockIndex{blockIndex})
+	assert.NoError(t, err)
+	ok, err := tryWriteRemoteStoreIndex(ctx, storeIndex, nil, client)
+	storeIndex.Dispose()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, markPruneCandidates(ctx, client, []uint64{blockIndex.GetBlockHash()}))
+
+	blobStore := longtailstorelib.NewFaultyBlobStore(backingStore, longtailstorelib.FaultConfig{
+		ErrorRate:  1,
+		Operations: []longtailstorelib.FaultOperation{longtailstorelib.FaultWrite, longtailstorelib.FaultDelete}})
+	jobs := longtaillib.CreateBikeshedJobAPI(uint32(runtime.NumCPU()), 0)
+	defer jobs.Dispose()
+	remoteStore, err := NewRemoteBlockStore(ctx, jobs, blobStore, nil, runtime.NumCPU(), ReadOnly)
+	assert.NoError(t, err)
+	storeAPI := longtaillib.CreateBlockStoreAPI(remoteStore)
+	existingContent, err := getExistingContent(t, storeAPI, blockIndex.GetChunkHashes(), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(existingContent.GetBlockHashes()))
+	existingContent.Dispose()
+	storeAPI.Dispose()
+
+	rescued, err := listPruneRescueRecords(ctx, client)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(rescued))
+	candidates, _, err := readPruneCandidates(ctx, client)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(candidates))
+}
+
+func TestPruneCandidatesMarkedAfterStoreIndexLoaded(t *testing.T) {
+	// A prune that marks blocks after an upload loaded the store index must still see them rescued
+	ctx := context.Background()
+	blobStore, _ := longtailstorelib.NewMemBlobStore("the_path", true)
+	client, _ := blobStore.NewClient(ctx)
+	defer client.Close()
+
+	block, _ := generateUniqueStoredBlock(t, 0)
+	defer block.Dispose()
+	storeBlock(client, block, 0, "")
+	blockIndex := block.GetBlockIndex()
+	storeIndex, err := longtaillib.CreateStoreIndexFromBlocks([]longtaillib.Longtail_BlockIndex{blockIndex})
+	assert.NoError(t, err)
+	ok, err := tryWriteRemoteStoreIndex(ctx, storeIndex, nil, client)
+	storeIndex.Dispose()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	jobs := longtaillib.CreateBikeshedJobAPI(uint32(runtime.NumCPU()), 0)
+	defer jobs.Dispose()
+	remoteStore, err := NewRemoteBlockStore(ctx, jobs, blobStore, nil, runtime.NumCPU(), ReadWrite)
+	assert.NoError(t, err)
+	storeAPI := longtaillib.CreateBlockStoreAPI(remoteStore)
+	defer storeAPI.Dispose()
+	existingContent, err := getExistingContent(t, storeAPI, blockIndex.GetChunkHashes(), 0)
+	assert.NoError(t, err)
+	existingContent.Dispose()
+	rescued, err := listPruneRescueRecords(ctx, client)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(rescued))
+
+	assert.NoError(t, markPruneCandidates(ctx, client, []uint64{blockIndex.GetBlockHash()}))
+	existingContent, err = getExistingContent(t, storeAPI, blockIndex.GetChunkHashes(), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(existingContent.GetBlockHashes()))
+	existingContent.Dispose()
+	rescued, err = listPruneRescueRecords(ctx, client)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(rescued))
+}
+
+// staleRescueListingBlobStore lists no prune rescue records, like a listing made before a writer rescued a block
+type staleRescueListingBlobStore struct {
+	longtailstorelib.BlobStore
+}
+
+type staleRescueListingBlobClient struct {
+	longtailstorelib.BlobClient
+}
+
+func (blobStore *staleRescueListingBlobStore) NewClient(ctx context.Context) (longtailstorelib.BlobClient, error) {
+	client, err := blobStore.BlobStore.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &staleRescueListingBlobClient{BlobClient: client}, nil
+}
+
+func (blobClient *staleRescueListingBlobClient) GetObjects(pathPrefix string) ([]longtailstorelib.BlobProperties, error) {
+	if strings.HasPrefix(pathPrefix, PruneRescuedPath) {
+		return []longtailstorelib.BlobProperties{}, nil
+	}
+	return blobClient.BlobClient.GetObjects(pathPrefix)
+}
+
+func TestSweepPruneCandidatesRescuedDuringSweep(t *testing.T) {
+	ctx := context.Background()
+	blobStore, _ := longtailstorelib.NewMemBlobStore("the_path", true)
+	client, _ := blobStore.NewClient(ctx)
+	defer client.Close()
+
+	block, _ := generateUniqueStoredBlock(t, 0)
+	defer block.Dispose()
+	storeBlock(client, block, 0, "")
+	blockIndex := block.GetBlockIndex()
+	blockHash := blockIndex.GetBlockHash()
+	storeIndex, err := longtaillib.CreateStoreIndexFromBlocks([]longtaillib.Longtail_BlockIndex{blockIndex})
+	assert.NoError(t, err)
+	ok, err := tryWriteRemoteStoreIndex(ctx, storeIndex, nil, client)
+	storeIndex.Dispose()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, markPruneCandidates(ctx, client, []uint64{blockHash}))
+	_, _, err = sweepPruneCandidates(ctx, client, 0)
+	assert.NoError(t, err)
+
+	// The block is rescued after the sweep listed the rescue records
+	record, _ := json.Marshal(PruneRescueRecord{BlockHash: fmt.Sprintf("0x%016x", blockHash), Time: time.Now().UTC()})
+	object, _ := client.NewObject(GetPruneRescueRecordPath(blockHash))
+	_, err = object.Write(ctx, record)
+	assert.NoError(t, err)
+	staleClient, _ := (&staleRescueListingBlobStore{BlobStore: blobStore}).NewClient(ctx)
+	defer staleClient.Close()
+	deletedCount, rescuedCount, err := sweepPruneCandidates(ctx, staleClient, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, deletedCount)
+	assert.Equal(t, 1, rescuedCount)
+
+	blockObject, _ := client.NewObject(getBlockPath("chunks", blockHash))
+	exists, err := blockObject.Exists(ctx)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	storeIndex, _, err = readStoreStoreIndexWithItems(ctx, client)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{blockHash}, storeIndex.GetBlockHashes())
+	storeIndex.Dispose()
+	exists, err = object.Exists(ctx)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestStoreLease(t *testing.T) {
+	ctx := context.Background()
+	blobStore, _ := longtailstorelib.NewMemBlobStore("the_path", true)
+	client, _ := blobStore.NewClient(ctx)
+	defer client.Close()
+
+	lease, err := acquireStoreLease(ctx, blobStore, "compact-store-index", time.Minute)
+	assert.NoError(t, err)
+	record, exists, err := readStoreLease(ctx, client)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, "compact-store-index", record.Operation)
+	assert.Equal(t, lease.Record().Token, record.Token)
+	assert.False(t, record.IsExpired(time.Now()))
+
+	_, err = acquireStoreLease(ctx, blobStore, "init-remote-store", time.Minute)
+	assert.True(t, errors.Is(err, ErrStoreLeaseHeld))
+
+	assert.NoError(t, lease.Release(ctx))
+	_, exists, err = readStoreLease(ctx, client)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// An expired lease is taken over
+	expired := StoreLeaseRecord{Holder: "other:1", Token: "abc", Operation: "prune-store", Expires: time.Now().Add(-time.Second)}
+	data, _ := json.Marshal(expired)
+	object, _ := client.NewObject(StoreLeasePath)
+	_, err = object.Write(ctx, data)
+	assert.NoError(t, err)
+	lease, err = acquireStoreLease(ctx, blobStore, "init-remote-store", time.Minute)
+	assert.NoError(t, err)
+
+	// Breaking the lease makes the holder lose it
+	record, exists, err = breakStoreLease(ctx, client)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, "init-remote-store", record.Operation)
+	assert.True(t, errors.Is(lease.Release(ctx), ErrStoreLeaseLost))
+	_, exists, err = breakStoreLease(ctx, client)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestStoreLeaseHeartbeat(t *testing.T) {
+	ctx := context.Background()
+	blobStore, _ := longtailstorelib.NewMemBlobStore("the_path", true)
+	client, _ := blobStore.NewClient(ctx)
+	defer client.Close()
+
+	lease, err := acquireStoreLease(ctx, blobStore, "prune-store", 300*time.Millisecond)
+	assert.NoError(t, err)
+	acquired := lease.Record()
+	time.Sleep(500 * time.Millisecond)
+	record, _, err := readStoreLease(ctx, client)
+	assert.NoError(t, err)
+	assert.True(t, record.Heartbeat.After(acquired.Heartbeat))
+	assert.False(t, record.IsExpired(time.Now()))
+	assert.NoError(t, lease.Context().Err())
+
+	_, _, err = breakStoreLease(ctx, client)
+	assert.NoError(t, err)
+	select {
+	case <-lease.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("lease context was not cancelled after the lease was broken")
+	}
+	assert.True(t, errors.Is(lease.Release(ctx), ErrStoreLeaseLost))
+}
+
+func TestStoreLeaseFailingDelete(t *testing.T) {
+	ctx := longtailstorelib.WithRetryPolicy(context.Background(), longtailstorelib.RetryPolicy{MaxRetries: 2, InitialDelay: time.Millisecond})
+	backingStore, _ := longtailstorelib.NewMemBlobStore("the_path", true)
+	blobStore := longtailstorelib.NewFaultyBlobStore(backingStore, longtailstorelib.FaultConfig{
+		ErrorRate:  1,
+		Operations: []longtailstorelib.FaultOperation{longtailstorelib.FaultDelete}})
+	client, _ := blobStore.NewClient(ctx)
+	defer client.Close()
+
+	// Deletes that keep failing are returned instead of being retried forever
+	lease, err := acquireStoreLease(ctx, blobStore, "prune-store", time.Minute)
+	assert.NoError(t, err)
+	err = lease.Release(ctx)
+	assert.True(t, errors.Is(err, longtailstorelib.ErrInjectedFault))
+
+	_, _, err = breakStoreLease(ctx, client)
+	assert.True(t, errors.Is(err, longtailstorelib.ErrInjectedFault))
+	_, exists, err := readStoreLease(ctx, client)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestStoreLeaseNotSupported(t *testing.T) {
+	blobStore, _ := longtailstorelib.NewMemBlobStore("the_path", false)
+	_, err := acquireStoreLease(context.Background(), blobStore, "prune-store", time.Minute)
+	assert.True(t, errors.Is(err, ErrStoreLeaseNotSupported))
+}
@@ -0,0 +1,443 @@
+package remotestore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/DanEngelbrecht/golongtail/longtaillib"
+	"github.com/DanEngelbrecht/golongtail/longtailstorelib"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// StoreLeasePath is the object in the root of a store that holds the maintenance lease
+const StoreLeasePath = "store.lease"
+
+// ErrStoreLeaseHeld is returned when the store lease is held by another holder
+var ErrStoreLeaseHeld = errors.New("store lease is held by another operation")
+
+// ErrStoreLeaseNotSupported is returned for stores that can't lock objects, such stores have no lease
+var ErrStoreLeaseNotSupported = errors.New("store does not support locking")
+
+// ErrStoreLeaseLost is returned when a held lease was broken or taken over after it expired
+var ErrStoreLeaseLost = errors.New("store lease was lost")
+
+// StoreLeaseRecord is the content of StoreLeasePath
+type StoreLeaseRecord struct {
+	// Host and process id of the holder
+	Holder string `json:"holder"`
+	// Random token that tells leases taken by the same holder apart
+	Token     string    `json:"token"`
+	Operation string    `json:"operation"`
+	Acquired  time.Time `json:"acquired"`
+	Heartbeat time.Time `json:"heartbeat"`
+	Expires   time.Time `json:"expires"`
+}
+
+// IsExpired returns true if the holder stopped renewing the lease before now
+func (record StoreLeaseRecord) IsExpired(now time.Time) bool {
+	return !now.Before(record.Expires)
+}
+
+func (record StoreLeaseRecord) String() string {
+	return fmt.Sprintf("`%s` held by %s since %s, expires %s", record.Operation, record.Holder, record.Acquired.Format(time.RFC3339), record.Expires.Format(time.RFC3339))
+}
+
+// StoreLease is an advisory lock on a store held by a maintenance operation
+// The lease is renewed in the background until it is released, if it can't be renewed the
+// context returned by Context() is cancelled so the operation stops
+type StoreLease struct {
+	client longtailstorelib.BlobClient
+	ttl    time.Duration
+
+	recordSync sync.Mutex
+	record     StoreLeaseRecord
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func storeLeaseHolder() string {
+	hostName, err := os.Hostname()
+	if err != nil {
+		hostName = "unknown"
+	}
+	return fmt.Sprintf("%s:%d", hostName, os.Getpid())
+}
+
+func newStoreLeaseToken() (string, error) {
+	token := make([]byte, 16)
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// lockStoreLease reads the lease after locking its version so it can be replaced or deleted with a
+// conditional write. A lease that can't be parsed is returned as an expired lease
+func lockStoreLease(
+	ctx context.Context,
+	client longtailstorelib.BlobClient) (longtailstorelib.BlobObject, StoreLeaseRecord, bool, error) {
+	const fname = "lockStoreLease"
+	objHandle, err := client.NewObject(StoreLeasePath)
+	if err != nil {
+		return nil, StoreLeaseRecord{}, false, errors.Wrap(err, fname)
+	}
+	exists, err := objHandle.LockWriteVersion(ctx)
+	if err != nil {
+		return nil, StoreLeaseRecord{}, false, errors.Wrap(err, fname)
+	}
+	if !exists {
+		return objHandle, StoreLeaseRecord{}, false, nil
+	}
+	data, err := objHandle.Read(ctx)
+	if longtaillib.IsNotExist(err) {
+		// Removed after we locked it, the conditional write or delete fails and the caller tries again
+		return objHandle, StoreLeaseRecord{}, true, nil
+	}
+	if err != nil {
+		return nil, StoreLeaseRecord{}, false, errors.Wrap(err, fname)
+	}
+	record := StoreLeaseRecord{}
+	if json.Unmarshal(data, &record) != nil {
+		logrus.WithFields(logrus.Fields{"fname": fname, "path": objHandle.String()}).Warn("Ignoring store lease that can't be parsed")
+		return objHandle, StoreLeaseRecord{}, true, nil
+	}
+	return objHandle, record, true, nil
+}
+
+func readStoreLease(
+	ctx context.Context,
+	client longtailstorelib.BlobClient) (StoreLeaseRecord, bool, error) {
+	const fname = "readStoreLease"
+	objHandle, err := client.NewObject(StoreLeasePath)
+	if err != nil {
+		return StoreLeaseRecord{}, false, errors.Wrap(err, fname)
+	}
+	var data []byte
+	_, err = longtailstorelib.RetryBlobOperation(ctx, client, nil, func() error {
+		var err error
+		data, err = objHandle.Read(ctx)
+		return err
+	})
+	if longtaillib.IsNotExist(err) {
+		return StoreLeaseRecord{}, false, nil
+	}
+	if err != nil {
+		return StoreLeaseRecord{}, false, errors.Wrap(err, fname)
+	}
+	record := StoreLeaseRecord{}
+	err = json.Unmarshal(data, &record)
+	if err != nil {
+		err = errors.Wrapf(err, "Cant parse store lease from `%s`", objHandle.String())
+		return StoreLeaseRecord{}, false, errors.Wrap(err, fname)
+	}
+	return record, true, nil
+}
+
+func acquireStoreLease(
+	ctx context.Context,
+	blobStore longtailstorelib.BlobStore,
+	operation string,
+	ttl time.Duration) (*StoreLease, error) {
+	const fname = "acquireStoreLease"
+	log := logrus.WithFields(logrus.Fields{
+		"fname":     fname,
+		"blobStore": blobStore.String(),
+		"operation": operation,
+		"ttl":       ttl,
+	})
+	log.Debug(fname)
+
+	if ttl <= 0 {
+		err := fmt.Errorf("store lease ttl must be positive, got %s", ttl)
+		return nil, errors.Wrap(err, fname)
+	}
+	client, err := blobStore.NewClient(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	if !client.SupportsLocking() {
+		client.Close()
+		err = errors.Wrapf(ErrStoreLeaseNotSupported, "Can't take the store lease of `%s`", blobStore.String())
+		return nil, errors.Wrap(err, fname)
+	}
+	token, err := newStoreLeaseToken()
+	if err != nil {
+		client.Close()
+		return nil, errors.Wrap(err, fname)
+	}
+
+	record := StoreLeaseRecord{Holder: storeLeaseHolder(), Token: token, Operation: operation}
+	for {
+		objHandle, current, exists, err := lockStoreLease(ctx, client)
+		if err != nil {
+			client.Close()
+			return nil, errors.Wrap(err, fname)
+		}
+		now := time.Now().UTC()
+		if exists && !current.IsExpired(now) {
+			client.Close()
+			err = errors.Wrapf(ErrStoreLeaseHeld, "Can't take the store lease of `%s`, %s", blobStore.String(), current)
+			return nil, errors.Wrap(err, fname)
+		}
+		if exists && current.Holder != "" {
+			log.Warnf("Taking over expired store lease %s", current)
+		}
+		record.Acquired = now
+		record.Heartbeat = now
+		record.Expires = now.Add(ttl)
+		data, err := json.Marshal(record)
+		if err != nil {
+			client.Close()
+			return nil, errors.Wrap(err, fname)
+		}
+		ok, err := objHandle.Write(ctx, data)
+		if err != nil {
+			client.Close()
+			return nil, errors.Wrap(err, fname)
+		}
+		if ok {
+			break
+		}
+		// The lease changed since we read it, check it again
+		if ctx.Err() != nil {
+			client.Close()
+			return nil, errors.Wrap(cancelledError(ctx), fname)
+		}
+	}
+	log.WithField("holder", record.Holder).Info("took store lease")
+
+	lease := &StoreLease{client: client, ttl: ttl, record: record, done: make(chan struct{})}
+	lease.ctx, lease.cancel = context.WithCancel(ctx)
+	go lease.heartbeat()
+	return lease, nil
+}
+
+// Context returns a context derived from the one passed when taking the lease that is cancelled if the lease is lost
+func (lease *StoreLease) Context() context.Context {
+	return lease.ctx
+}
+
+// Record returns the lease as it was last written by this holder
+func (lease *StoreLease) Record() StoreLeaseRecord {
+	lease.recordSync.Lock()
+	defer lease.recordSync.Unlock()
+	return lease.record
+}
+
+func (lease *StoreLease) heartbeat() {
+	const fname = "StoreLease.heartbeat"
+	defer close(lease.done)
+	ticker := time.NewTicker(lease.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-lease.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := lease.renew()
+		if err == nil {
+			continue
+		}
+		log := logrus.WithFields(logrus.Fields{"fname": fname, "lease": lease.Record().String()})
+		if errors.Is(err, ErrStoreLeaseLost) || time.Now().After(lease.Record().Expires) {
+			log.WithError(err).Error("Lost store lease, stopping operation")
+			lease.cancel()
+			return
+		}
+		// Renewing is retried on the next tick, the lease is only lost once it expires
+		log.WithError(err).Warn("Failed renewing store lease")
+	}
+}
+
+func (lease *StoreLease) renew() error {
+	const fname = "StoreLease.renew"
+	objHandle, current, exists, err := lockStoreLease(lease.ctx, lease.client)
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	record := lease.Record()
+	if !exists || current.Token != record.Token {
+		return errors.Wrap(ErrStoreLeaseLost, fname)
+	}
+	now := time.Now().UTC()
+	record.Heartbeat = now
+	record.Expires = now.Add(lease.ttl)
+	data, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	ok, err := objHandle.Write(lease.ctx, data)
+	if err != nil {
+		return errors.Wrap(err, fname)
+	}
+	if !ok {
+		err = fmt.Errorf("store lease changed while renewing it")
+		return errors.Wrap(err, fname)
+	}
+	lease.recordSync.Lock()
+	lease.record = record
+	lease.recordSync.Unlock()
+	return nil
+}
+
+// Release stops renewing the lease and removes it from the store unless it was lost
+// It returns ErrStoreLeaseLost if the lease was lost while it was held
+func (lease *StoreLease) Release(ctx context.Context) error {
+	const fname = "StoreLease.Release"
+	lostErr := lease.ctx.Err()
+	lease.cancel()
+	<-lease.done
+	defer lease.client.Close()
+	if lostErr != nil && ctx.Err() == nil {
+		return errors.Wrap(ErrStoreLeaseLost, fname)
+	}
+
+	for {
+		objHandle, current, exists, err := lockStoreLease(ctx, lease.client)
+		if err != nil {
+			return errors.Wrap(err, fname)
+		}
+		if !exists || current.Token != lease.Record().Token {
+			return errors.Wrap(ErrStoreLeaseLost, fname)
+		}
+		changed, err := deleteLockedStoreLease(ctx, lease.client, objHandle)
+		if err != nil {
+			return errors.Wrap(err, fname)
+		}
+		if !changed {
+			logrus.WithFields(logrus.Fields{"fname": fname, "holder": current.Holder}).Info("released store lease")
+			return nil
+		}
+		// The delete is rejected if the lease changed since we read it, check it again
+		logrus.WithFields(logrus.Fields{"fname": fname}).Debug("Retrying store lease release")
+	}
+}
+
+// deleteLockedStoreLease deletes a lease locked by lockStoreLease, retrying failures as the retry policy
+// of ctx allows. It returns true without an error if the lease changed after it was locked
+func deleteLockedStoreLease(
+	ctx context.Context,
+	client longtailstorelib.BlobClient,
+	objHandle longtailstorelib.BlobObject) (bool, error) {
+	const fname = "deleteLockedStoreLease"
+	_, err := longtailstorelib.RetryBlobOperation(ctx, client, nil, func() error {
+		return objHandle.Delete(ctx)
+	})
+	if err == nil {
+		return false, nil
+	}
+	if ctx.Err() != nil {
+		return false, errors.Wrap(cancelledError(ctx), fname)
+	}
+	if errors.Is(err, longtailstorelib.ErrBlobVersionChanged) {
+		return true, nil
+	}
+	return false, errors.Wrap(err, fname)
+}
+
+func breakStoreLease(
+	ctx context.Context,
+	client longtailstorelib.BlobClient) (StoreLeaseRecord, bool, error) {
+	const fname = "breakStoreLease"
+	if !client.SupportsLocking() {
+		return StoreLeaseRecord{}, false, errors.Wrap(ErrStoreLeaseNotSupported, fname)
+	}
+	for {
+		objHandle, current, exists, err := lockStoreLease(ctx, client)
+		if err != nil {
+			return StoreLeaseRecord{}, false, errors.Wrap(err, fname)
+		}
+		if !exists {
+			return StoreLeaseRecord{}, false, nil
+		}
+		changed, err := deleteLockedStoreLease(ctx, client, objHandle)
+		if err != nil {
+			return StoreLeaseRecord{}, false, errors.Wrap(err, fname)
+		}
+		if !changed {
+			logrus.WithFields(logrus.Fields{"fname": fname, "lease": current.String()}).Warn("broke store lease")
+			return current, true, nil
+		}
+	}
+}
+
+// AcquireStoreLease takes the maintenance lease of the store at uri for operation, it fails with an error wrapping
+// ErrStoreLeaseHeld if an unexpired lease is held by someone else and with ErrStoreLeaseNotSupported if the store
+// can't lock objects. The lease expires ttl after the last heartbeat, heartbeats are sent every third of ttl
+func AcquireStoreLease(
+	ctx context.Context,
+	uri string,
+	operation string,
+	ttl time.Duration,
+	opts ...longtailstorelib.BlobStoreOption) (*StoreLease, error) {
+	const fname = "AcquireStoreLease"
+	opts = append(longtailstorelib.GetBlobStoreOptions(ctx), opts...)
+	blobStore, err := createBlobStoreForStoreURI(uri, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	lease, err := acquireStoreLease(ctx, blobStore, operation, ttl)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	return lease, nil
+}
+
+// ReadStoreLease returns the maintenance lease of the store at uri and false if there is none
+// Uploads use it to check if a maintenance operation is running, the lease may be expired
+func ReadStoreLease(
+	ctx context.Context,
+	uri string,
+	opts ...longtailstorelib.BlobStoreOption) (StoreLeaseRecord, bool, error) {
+	const fname = "ReadStoreLease"
+	opts = append(longtailstorelib.GetBlobStoreOptions(ctx), opts...)
+	blobStore, err := createBlobStoreForStoreURI(uri, opts...)
+	if err != nil {
+		return StoreLeaseRecord{}, false, errors.Wrap(err, fname)
+	}
+	client, err := blobStore.NewClient(ctx)
+	if err != nil {
+		return StoreLeaseRecord{}, false, errors.Wrap(err, fname)
+	}
+	defer client.Close()
+	record, exists, err := readStoreLease(ctx, client)
+	if err != nil {
+		return StoreLeaseRecord{}, false, errors.Wrap(err, fname)
+	}
+	return record, exists, nil
+}
+
+// BreakStoreLease removes the maintenance lease of the store at uri, even if it is held and unexpired
+// Returns the removed lease and false if there was no lease
+func BreakStoreLease(
+	ctx context.Context,
+	uri string,
+	opts ...longtailstorelib.BlobStoreOption) (StoreLeaseRecord, bool, error) {
+	const fname = "BreakStoreLease"
+	opts = append(longtailstorelib.GetBlobStoreOptions(ctx), opts...)
+	blobStore, err := createBlobStoreForStoreURI(uri, opts...)
+	if err != nil {
+		return StoreLeaseRecord{}, false, errors.Wrap(err, fname)
+	}
+	client, err := blobStore.NewClient(ctx)
+	if err != nil {
+		return StoreLeaseRecord{}, false, errors.Wrap(err, fname)
+	}
+	defer client.Close()
+	record, exists, err := breakStoreLease(ctx, client)
+	if err != nil {
+		return StoreLeaseRecord{}, false, errors.Wrap(err, fname)
+	}
+	return record, exists, nil
+}